@@ -11,10 +11,16 @@ import (
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/agent"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/sandbox"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	// Sandboxed tasks re-execute the agent to finish namespace setup
+	if sandbox.IsHelper() {
+		sandbox.RunHelper()
+	}
+
 	var (
 		configFile = flag.String("config", "", "Configuration file path")
 		showVer    = flag.Bool("version", false, "Show version information")
@@ -89,14 +95,27 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
+	// Handle shutdown and reload signals
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		sig := <-sigChan
-		log.WithField("signal", sig).Info("Received shutdown signal")
-		cancel()
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				reloadConfig(agentInstance, *configFile, log)
+				continue
+			}
+
+			log.WithField("signal", sig).Info("Received shutdown signal")
+
+			// SIGTERM lets running tasks finish before shutting down
+			if sig == syscall.SIGTERM {
+				drainAgent(agentInstance, cfg.Executor.DrainTimeout, log)
+			}
+
+			cancel()
+			return
+		}
 	}()
 
 	// Start agent
@@ -121,6 +140,38 @@ func main() {
 	log.Info("Agent stopped successfully")
 }
 
+// drainAgent drains the agent, giving running tasks up to timeout to finish
+func drainAgent(agentInstance *agent.Agent, timeout time.Duration, log *logrus.Logger) {
+	log.WithField("timeout", timeout).Info("Draining agent before shutdown")
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), timeout)
+	defer drainCancel()
+
+	if err := agentInstance.Drain(drainCtx); err != nil {
+		log.WithError(err).Error("Failed to drain agent")
+	}
+}
+
+// reloadConfig re-reads the configuration file and applies it to the running agent
+func reloadConfig(agentInstance *agent.Agent, configFile string, log *logrus.Logger) {
+	log.WithField("config_file", configFile).Info("Received SIGHUP, reloading configuration")
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.WithError(err).Error("Failed to reload configuration")
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.WithError(err).Error("Invalid configuration, keeping current settings")
+		return
+	}
+
+	if err := agentInstance.Reload(cfg); err != nil {
+		log.WithError(err).Error("Failed to apply configuration")
+	}
+}
+
 // handleCLICommands processes CLI commands
 func handleCLICommands(args []string, configFile string, debug bool) {
 	// Initialize logger for CLI
@@ -5,7 +5,6 @@ import (
 	"os"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/cli"
-	"github.com/spf13/cobra"
 )
 
 var (
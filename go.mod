@@ -1,10 +1,11 @@
 module github.com/duclacloud/DUCLA-CLOUD-AGENT
 
-go 1.19
+go 1.20
 
 require (
 	github.com/google/uuid v1.3.1
 	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.17.4
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.10.1
 	golang.org/x/sys v0.15.0
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/api"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/audit"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/health"
@@ -26,6 +28,8 @@ type Agent struct {
 	fileops   *fileops.Manager
 	health    *health.Checker
 	metrics   *metrics.Collector
+	events    *events.Bus
+	audit     *audit.Logger
 	
 	// Internal state
 	mu       sync.RWMutex
@@ -46,6 +50,8 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Agent, error) {
 		config:   cfg,
 		logger:   logger,
 		services: make([]Service, 0),
+		events:   events.NewBus(cfg.Events.BufferSize, logger),
+		audit:    audit.New(cfg.Security.Audit, logger),
 	}
 
 	// Initialize transport layer (only if master URL is provided)
@@ -58,28 +64,39 @@ func New(cfg *config.Config, logger *logrus.Logger) (*Agent, error) {
 	}
 
 	// Initialize executor
-	executorInstance, err := executor.New(cfg.Executor, logger)
+	executorInstance, err := executor.New(cfg.Executor, agent.events, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create executor: %w", err)
 	}
 	agent.executor = executorInstance
 	agent.services = append(agent.services, executorInstance)
+	executorInstance.SetAuditor(agent.audit)
 
 	// Initialize file operations manager
-	fileopsManager, err := fileops.New(cfg.Storage, logger)
+	fileopsManager, err := fileops.New(cfg.Storage, agent.events, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create fileops manager: %w", err)
 	}
 	agent.fileops = fileopsManager
+	agent.services = append(agent.services, fileopsManager)
+	if streamer, ok := agent.transport.(transport.FileStreamer); ok {
+		fileopsManager.SetStreamer(transportStreamer{transport: streamer})
+	}
+	fileopsManager.SetAuditor(agent.audit)
+	executorInstance.SetPathPolicy(fileopsManager.Policy())
+	executorInstance.SetDeleter(fileopsManager)
 
 	// Initialize health checker
 	if cfg.Health.Enabled {
-		healthChecker, err := health.New(cfg.Health, logger)
+		healthChecker, err := health.New(cfg.Health, agent.events, logger)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create health checker: %w", err)
 		}
 		agent.health = healthChecker
 		agent.services = append(agent.services, healthChecker)
+
+		// Feed host load into executor admission control
+		executorInstance.SetLoadProvider(hostLoadProvider{healthChecker})
 	}
 
 	// Initialize metrics collector
@@ -137,12 +154,21 @@ func (a *Agent) Start(ctx context.Context) error {
 		a.logger.Info("Running in standalone mode (no master server)")
 	}
 
+	// Resubmit tasks handed back by a previous drain
+	a.restoreDrainedTasks()
+
 	// Start heartbeat
 	go a.heartbeatLoop(ctx)
 
 	// Start message handling
 	go a.messageLoop(ctx)
 
+	// Forward task progress and file watch events to the master
+	if a.transport != nil {
+		go a.progressLoop(ctx)
+		go a.fileEventLoop(ctx)
+	}
+
 	a.running = true
 	a.logger.Info("Ducla Cloud Agent started successfully")
 
@@ -170,6 +196,12 @@ func (a *Agent) Stop(ctx context.Context) error {
 	// Stop all services
 	a.stopServices(ctx)
 
+	// Close event bus and audit log once no service can use them anymore
+	a.events.Close()
+	if err := a.audit.Close(); err != nil {
+		a.logger.WithError(err).Error("Error closing audit log")
+	}
+
 	a.running = false
 	a.logger.Info("Ducla Cloud Agent stopped")
 
@@ -226,6 +258,11 @@ func (a *Agent) messageLoop(ctx context.Context) {
 				continue
 			}
 
+			// Chunks of a file must be written in the order they arrive
+			if message.Type == transport.MessageTypeFileChunk {
+				a.handleMessage(ctx, message)
+				continue
+			}
 			go a.handleMessage(ctx, message)
 		}
 	}
@@ -237,14 +274,20 @@ func (a *Agent) sendHeartbeat() error {
 	if a.transport == nil {
 		return nil
 	}
+
+	status := "healthy"
+	if a.executor.IsDraining() {
+		status = "draining"
+	}
 	
 	heartbeat := &transport.Message{
 		Type: transport.MessageTypeHeartbeat,
 		Data: map[string]interface{}{
 			"agent_id":   a.config.Agent.ID,
 			"timestamp":  time.Now().Unix(),
-			"status":     "healthy",
+			"status":     status,
 			"version":    "1.0.0", // TODO: Get from build info
+			"running_tasks": a.runningTaskInfo(),
 		},
 	}
 
@@ -263,6 +306,10 @@ func (a *Agent) handleMessage(ctx context.Context, message *transport.Message) {
 		a.handleTaskMessage(ctx, message)
 	case transport.MessageTypeFileOperation:
 		a.handleFileOperationMessage(ctx, message)
+	case transport.MessageTypeFileChunk:
+		a.handleFileChunkMessage(ctx, message)
+	case transport.MessageTypeFileTransferCancel:
+		a.handleFileTransferCancelMessage(ctx, message)
 	case transport.MessageTypeHealthCheck:
 		a.handleHealthCheckMessage(ctx, message)
 	case transport.MessageTypeConfig:
@@ -315,6 +362,7 @@ func (a *Agent) handleFileOperationMessage(ctx context.Context, message *transpo
 		a.sendErrorResponse(message, err)
 		return
 	}
+	operation.Actor = "master"
 
 	// Execute file operation
 	result, err := a.fileops.ExecuteOperation(ctx, operation)
@@ -397,6 +445,24 @@ func (a *Agent) sendErrorResponse(originalMessage *transport.Message, err error)
 	}
 }
 
+// Reload applies the settings of a freshly loaded configuration that can be
+// changed without a restart
+func (a *Agent) Reload(cfg *config.Config) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.logger.Info("Reloading configuration")
+
+	if err := a.executor.ResizeWorkerPool(cfg.Executor.WorkerPoolSize, cfg.Executor.MaxWorkers); err != nil {
+		return fmt.Errorf("failed to resize worker pool: %w", err)
+	}
+	a.config.Executor.WorkerPoolSize = cfg.Executor.WorkerPoolSize
+	a.config.Executor.MaxWorkers = cfg.Executor.MaxWorkers
+
+	a.logger.Info("Configuration reloaded, other changes require a restart")
+	return nil
+}
+
 // GetConfig returns the agent configuration
 func (a *Agent) GetConfig() *config.Config {
 	return a.config
@@ -422,9 +488,33 @@ func (a *Agent) GetMetrics() api.MetricsInterface {
 	return a.metrics
 }
 
+// GetEventBus returns the internal event bus
+func (a *Agent) GetEventBus() *events.Bus {
+	return a.events
+}
+
 // IsRunning returns whether the agent is currently running
 func (a *Agent) IsRunning() bool {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 	return a.running
-}
\ No newline at end of file
+}
+
+// hostLoadProvider exposes health checker readings to executor admission control
+type hostLoadProvider struct {
+	checker *health.Checker
+}
+
+// GetHostLoad implements executor.LoadProvider
+func (p hostLoadProvider) GetHostLoad() (executor.HostLoad, bool) {
+	load, ok := p.checker.GetHostLoad()
+	if !ok {
+		return executor.HostLoad{}, false
+	}
+
+	return executor.HostLoad{
+		CPULoadPerCore: load.CPULoadPerCore,
+		MemoryPercent:  load.MemoryPercent,
+		DiskPercent:    load.DiskPercent,
+	}, true
+}
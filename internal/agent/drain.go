@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
+	"github.com/sirupsen/logrus"
+)
+
+// drainedTasksFile holds tasks handed back by a drain until the next start
+const drainedTasksFile = "drained-tasks.json"
+
+// Drain stops the executor from accepting tasks and waits for running tasks
+// to finish until ctx is done. Queued tasks are persisted to the data
+// directory and resubmitted on the next start.
+func (a *Agent) Drain(ctx context.Context) error {
+	a.logger.Info("Draining agent")
+
+	handedBack, err := a.executor.Drain(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to drain executor: %w", err)
+	}
+
+	// Let the master know right away instead of waiting for the next heartbeat
+	if err := a.sendHeartbeat(); err != nil {
+		a.logger.WithError(err).Warn("Failed to send draining heartbeat")
+	}
+
+	if err := a.persistDrainedTasks(handedBack); err != nil {
+		return err
+	}
+
+	a.logger.WithField("persisted_tasks", len(handedBack)).Info("Agent drained")
+	return nil
+}
+
+// IsDraining returns whether the agent is draining
+func (a *Agent) IsDraining() bool {
+	return a.executor.IsDraining()
+}
+
+// persistDrainedTasks writes handed back tasks to the data directory
+func (a *Agent) persistDrainedTasks(tasks []*executor.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	return a.writeDrainedTasks(tasks)
+}
+
+// writeDrainedTasks replaces the drained tasks file with the given tasks
+func (a *Agent) writeDrainedTasks(tasks []*executor.Task) error {
+	data, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode drained tasks: %w", err)
+	}
+
+	path := filepath.Join(a.config.Storage.DataDir, drainedTasksFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write drained tasks: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write drained tasks: %w", err)
+	}
+
+	return nil
+}
+
+// restoreDrainedTasks resubmits tasks persisted by a previous drain. Tasks
+// that cannot be resubmitted right now are kept in the file for the next
+// start; invalid tasks are dropped.
+func (a *Agent) restoreDrainedTasks() {
+	path := filepath.Join(a.config.Storage.DataDir, drainedTasksFile)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.logger.WithError(err).Error("Failed to read drained tasks")
+		}
+		return
+	}
+
+	var tasks []*executor.Task
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		a.logger.WithError(err).Error("Failed to decode drained tasks")
+		return
+	}
+
+	var failed []*executor.Task
+	dropped := 0
+	for _, task := range tasks {
+		// Reset execution state recorded when the task was handed back
+		task.Status = executor.TaskStatusPending
+		task.Result = nil
+		task.StartedAt = time.Time{}
+		task.FinishedAt = time.Time{}
+
+		if _, err := a.executor.SubmitTask(task); err != nil {
+			// Invalid tasks would fail again on every start
+			if errors.Is(err, executor.ErrInvalidTask) {
+				a.logger.WithError(err).WithField("task_id", task.ID).Error("Dropping invalid drained task")
+				a.executor.DiscardTaskInputs(task)
+				dropped++
+				continue
+			}
+			a.logger.WithError(err).WithField("task_id", task.ID).Error("Failed to resubmit drained task")
+			failed = append(failed, task)
+		}
+	}
+
+	if len(failed) > 0 {
+		if err := a.writeDrainedTasks(failed); err != nil {
+			a.logger.WithError(err).Error("Failed to keep drained tasks that were not resubmitted")
+		}
+	} else if err := os.Remove(path); err != nil {
+		a.logger.WithError(err).Error("Failed to remove drained tasks file")
+	}
+
+	a.logger.WithFields(logrus.Fields{
+		"tasks":   len(tasks) - len(failed) - dropped,
+		"failed":  len(failed),
+		"dropped": dropped,
+	}).Info("Resubmitted tasks from previous drain")
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
+	"github.com/sirupsen/logrus"
+)
+
+// testAgent returns an agent with a running single-worker executor and a
+// temporary data directory
+func testAgent(t *testing.T, dataDir string) *Agent {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &config.Config{}
+	cfg.Storage.DataDir = dataDir
+	cfg.Executor = config.ExecutorConfig{
+		TaskTimeout:    time.Minute,
+		WorkerPoolSize: 1,
+		MaxWorkers:     1,
+		QueueSize:      10,
+		DrainTimeout:   10 * time.Second,
+		InputsDir:      filepath.Join(dataDir, "inputs"),
+	}
+
+	e, err := executor.New(cfg.Executor, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Stop(context.Background()) })
+
+	return &Agent{config: cfg, logger: logger, executor: e}
+}
+
+func sleepTask(d time.Duration) *executor.Task {
+	return &executor.Task{Type: executor.TaskTypeCommand, Command: "sleep", Args: []string{strconv.FormatFloat(d.Seconds(), 'f', -1, 64)}}
+}
+
+// workerBusy reports whether a worker is executing a task
+func workerBusy(e *executor.Executor) bool {
+	for _, worker := range e.GetWorkerStats() {
+		if worker.Busy {
+			return true
+		}
+	}
+	return false
+}
+
+// readDrainedTasks returns the IDs of the tasks in the drained tasks file
+func readDrainedTasks(t *testing.T, dataDir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dataDir, drainedTasksFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tasks []*executor.Task
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestDrainAfterDrainRequest(t *testing.T) {
+	dataDir := t.TempDir()
+	a := testAgent(t, dataDir)
+
+	running, err := a.executor.SubmitTask(sleepTask(300 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); !workerBusy(a.executor); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("task did not start")
+		}
+	}
+	queued, err := a.executor.SubmitTask(sleepTask(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A drain requested over the API, then SIGTERM
+	if _, err := a.executor.BeginDrain(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+
+	if workerBusy(a.executor) {
+		t.Errorf("Drain() returned while task %s was running", running)
+	}
+	if ids := readDrainedTasks(t, dataDir); len(ids) != 1 || ids[0] != queued {
+		t.Fatalf("persisted tasks = %v, want [%s]", ids, queued)
+	}
+
+	// The next start resubmits the persisted task
+	restarted := testAgent(t, dataDir)
+	restarted.restoreDrainedTasks()
+	if _, err := restarted.executor.GetTask(queued); err != nil {
+		t.Errorf("persisted task was not resubmitted: %v", err)
+	}
+	if ids := readDrainedTasks(t, dataDir); ids != nil {
+		t.Errorf("drained tasks file still holds %v", ids)
+	}
+}
+
+func TestRestoreDropsInvalidTasks(t *testing.T) {
+	dataDir := t.TempDir()
+	a := testAgent(t, dataDir)
+
+	valid := sleepTask(10 * time.Millisecond)
+	valid.ID = "valid"
+	invalid := sleepTask(10 * time.Millisecond)
+	invalid.ID = "invalid"
+	invalid.Inputs = []executor.TaskInput{{Name: "app.yaml", Path: filepath.Join(dataDir, "inputs", "gone")}}
+	if err := a.writeDrainedTasks([]*executor.Task{valid, invalid}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Valid tasks that cannot be submitted yet are kept for the next start
+	if _, err := a.executor.BeginDrain(); err != nil {
+		t.Fatal(err)
+	}
+	a.restoreDrainedTasks()
+	if ids := readDrainedTasks(t, dataDir); len(ids) != 1 || ids[0] != "valid" {
+		t.Fatalf("kept tasks = %v, want [valid]", ids)
+	}
+
+	a.executor.Undrain()
+	a.restoreDrainedTasks()
+	if _, err := a.executor.GetTask("valid"); err != nil {
+		t.Errorf("valid task was not resubmitted: %v", err)
+	}
+	if ids := readDrainedTasks(t, dataDir); ids != nil {
+		t.Errorf("drained tasks file still holds %v", ids)
+	}
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/transport"
+)
+
+// progressLoop forwards progress reported by running tasks to the master
+func (a *Agent) progressLoop(ctx context.Context) {
+	sub := a.events.Subscribe(0, events.TypeTaskProgress)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case envelope, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			progress, ok := envelope.Event.(events.TaskProgress)
+			if !ok || !a.transport.IsConnected() {
+				continue
+			}
+
+			message := &transport.Message{
+				Type:      transport.MessageTypeTaskProgress,
+				Timestamp: envelope.Timestamp,
+				AgentID:   a.config.Agent.ID,
+				Data: map[string]interface{}{
+					"task_id":    progress.TaskID,
+					"task_type":  progress.TaskType,
+					"name":       progress.Name,
+					"progress":   progress.Percent,
+					"message":    progress.Message,
+					"fields":     progress.Fields,
+					"updated_at": progress.UpdatedAt.Unix(),
+				},
+			}
+
+			if err := a.transport.SendMessage(message); err != nil {
+				a.logger.WithError(err).WithField("task_id", progress.TaskID).Debug("Failed to send task progress")
+			}
+		}
+	}
+}
+
+// runningTaskInfo summarizes running tasks and their progress for the master
+func (a *Agent) runningTaskInfo() []*transport.TaskInfo {
+	page, err := a.executor.QueryTasks(executor.TaskQuery{
+		Statuses: []executor.TaskStatus{executor.TaskStatusRunning, executor.TaskStatusPaused},
+		Limit:    executor.MaxQueryLimit,
+	})
+	if err != nil {
+		return nil
+	}
+
+	infos := make([]*transport.TaskInfo, 0, len(page.Tasks))
+	for _, task := range page.Tasks {
+		info := &transport.TaskInfo{
+			TaskId:    task.ID,
+			TaskType:  string(task.Type),
+			Status:    string(task.Status),
+			StartedAt: task.StartedAt.Unix(),
+		}
+		if progress := task.GetProgress(); progress != nil {
+			info.Progress = int32(progress.Percent)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/transport"
+)
+
+// transportStreamer sends files to the master over the transport's file
+// streams
+type transportStreamer struct {
+	transport transport.FileStreamer
+}
+
+func (s transportStreamer) OpenStream(ctx context.Context, transfer *fileops.Transfer) (fileops.ChunkStream, error) {
+	stream, err := s.transport.OpenFileStream(ctx, transfer.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkStream{stream: stream, filename: filepath.Base(transfer.SourcePath)}, nil
+}
+
+// chunkStream adapts a transport file stream to fileops
+type chunkStream struct {
+	stream   transport.FileStream
+	filename string
+}
+
+func (c *chunkStream) Send(chunk *fileops.Chunk) error {
+	fileChunk := &transport.FileChunk{
+		FileId:    chunk.TransferID,
+		Filename:  c.filename,
+		Offset:    chunk.Offset,
+		Data:      chunk.Data,
+		TotalSize: chunk.Size,
+		IsLast:    chunk.Last,
+	}
+	if chunk.SHA256 != "" {
+		fileChunk.Metadata = map[string]string{"sha256": chunk.SHA256}
+	}
+	return c.stream.Send(fileChunk)
+}
+
+func (c *chunkStream) Acknowledged() int64 {
+	return c.stream.Acknowledged()
+}
+
+func (c *chunkStream) Close() (*fileops.ChunkReceipt, error) {
+	response, err := c.stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	return &fileops.ChunkReceipt{
+		Received: response.BytesTransferred,
+		Checksum: response.Checksum,
+		Error:    response.Error,
+	}, nil
+}
+
+// handleFileChunkMessage writes a chunk of a file sent by the master and
+// acknowledges it with the transfer's new offset. After the last chunk the
+// outcome of the transfer is reported.
+func (a *Agent) handleFileChunkMessage(ctx context.Context, message *transport.Message) {
+	chunk, err := transport.ParseChunkMessage(message)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to parse file chunk")
+		a.sendErrorResponse(message, err)
+		return
+	}
+
+	session, transfer, err := a.fileops.ReceiveChunk(ctx, &fileops.Chunk{
+		TransferID: chunk.FileId,
+		Offset:     chunk.Offset,
+		Data:       chunk.Data,
+		Size:       chunk.TotalSize,
+		Last:       chunk.IsLast,
+		SHA256:     chunk.Metadata["sha256"],
+	})
+
+	ack := map[string]interface{}{
+		"transfer_id": chunk.FileId,
+	}
+	if session != nil {
+		ack["offset"] = session.Offset
+	}
+	var offsetErr *fileops.OffsetError
+	if errors.As(err, &offsetErr) {
+		ack["offset"] = offsetErr.Offset
+	}
+	if err != nil {
+		a.logger.WithError(err).WithField("transfer_id", chunk.FileId).Warn("Failed to receive file chunk")
+		ack["error"] = err.Error()
+	}
+	a.sendTransferMessage(transport.MessageTypeFileChunkAck, message, ack)
+
+	// A checksum mismatch discards the transfer, other failures can be retried
+	var checksumErr *fileops.ChecksumError
+	switch {
+	case transfer != nil && err == nil:
+		a.sendTransferMessage(transport.MessageTypeFileTransferResult, message, map[string]interface{}{
+			"transfer_id":       transfer.ID,
+			"status":            transfer.Status,
+			"bytes_transferred": transfer.Size,
+			"checksum":          transfer.Checksum,
+		})
+	case errors.As(err, &checksumErr):
+		a.sendTransferMessage(transport.MessageTypeFileTransferResult, message, map[string]interface{}{
+			"transfer_id": chunk.FileId,
+			"status":      fileops.TransferStatusFailed,
+			"checksum":    checksumErr.Actual,
+			"error":       err.Error(),
+		})
+	}
+}
+
+// handleFileTransferCancelMessage cancels a transfer at the master's request
+func (a *Agent) handleFileTransferCancelMessage(ctx context.Context, message *transport.Message) {
+	transferID, _ := message.Data["transfer_id"].(string)
+	if err := a.fileops.CancelTransfer(transferID); err != nil {
+		a.logger.WithError(err).WithField("transfer_id", transferID).Warn("Failed to cancel transfer")
+		a.sendErrorResponse(message, err)
+		return
+	}
+
+	a.sendTransferMessage(transport.MessageTypeFileTransferResult, message, map[string]interface{}{
+		"transfer_id": transferID,
+		"status":      fileops.TransferStatusCancelled,
+	})
+}
+
+// sendTransferMessage replies to a file transfer message
+func (a *Agent) sendTransferMessage(messageType transport.MessageType, original *transport.Message, data map[string]interface{}) {
+	response := &transport.Message{
+		Type:    messageType,
+		ReplyTo: original.ID,
+		Data:    data,
+	}
+
+	if err := a.transport.SendMessage(response); err != nil {
+		a.logger.WithError(err).WithField("type", messageType).Error("Failed to send file transfer message")
+	}
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/transport"
+)
+
+// fileEventLoop forwards changes reported by file watches to the master
+func (a *Agent) fileEventLoop(ctx context.Context) {
+	sub := a.events.Subscribe(0, events.TypeFileChanged)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case envelope, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			change, ok := envelope.Event.(events.FileChanged)
+			if !ok || !a.transport.IsConnected() {
+				continue
+			}
+
+			message := &transport.Message{
+				Type:      transport.MessageTypeFileEvent,
+				Timestamp: envelope.Timestamp,
+				AgentID:   a.config.Agent.ID,
+				Data: map[string]interface{}{
+					"watch_id": change.WatchID,
+					"op":       change.Op,
+					"path":     change.Path,
+					"old_path": change.OldPath,
+					"is_dir":   change.IsDir,
+					"message":  change.Message,
+					"sequence": envelope.Sequence,
+				},
+			}
+
+			if err := a.transport.SendMessage(message); err != nil {
+				a.logger.WithError(err).WithField("watch_id", change.WatchID).Debug("Failed to send file event")
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
+	"github.com/sirupsen/logrus"
+)
+
+// fileOpsAgent is an agent that only provides file operations
+type fileOpsAgent struct {
+	AgentInterface
+	fileOps FileOpsInterface
+}
+
+func (a *fileOpsAgent) GetFileOps() FileOpsInterface {
+	return a.fileOps
+}
+
+// fileServer returns a server with a file operations manager allowed to
+// access the returned data directory
+func fileServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data")
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	rule := config.AccessRule{Roots: []string{data}}
+	manager, err := fileops.New(config.StorageConfig{
+		DataDir:    data,
+		TempDir:    filepath.Join(dir, "tmp"),
+		UploadsDir: filepath.Join(dir, "uploads"),
+		Access:     config.AccessConfig{Read: rule, Write: rule, Delete: rule},
+	}, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := New(config.APIConfig{}, &fileOpsAgent{fileOps: manager}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, data
+}
+
+// download requests a download with the given query and headers
+func download(s *Server, query url.Values, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/files/download?"+query.Encode(), nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.handleFileDownload(w, r)
+	return w
+}
+
+func TestDownloadRangeAndConditional(t *testing.T) {
+	s, data := fileServer(t)
+	path := filepath.Join(data, "app.log")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"path": {path}}
+
+	w := download(s, query, nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" || etag == "" {
+		t.Fatalf("download = %d %q with ETag %q", w.Code, w.Body.String(), etag)
+	}
+	if got := w.Header().Get("Digest"); got != "sha-256=hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII=" {
+		t.Errorf("Digest = %q", got)
+	}
+
+	w = download(s, query, map[string]string{"Range": "bytes=2-5"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Errorf("range download = %d %q, want 206 \"2345\"", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	w = download(s, query, map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("conditional download = %d with %d bytes, want 304", w.Code, w.Body.Len())
+	}
+
+	// A resumed download of a file that changed starts over
+	w = download(s, query, map[string]string{"Range": "bytes=5-", "If-Range": `"stale"`})
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Errorf("download with a stale If-Range = %d %q, want the whole file", w.Code, w.Body.String())
+	}
+	w = download(s, query, map[string]string{"Range": "bytes=5-", "If-Range": etag})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "56789" {
+		t.Errorf("download with a current If-Range = %d %q, want the rest", w.Code, w.Body.String())
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	s, data := fileServer(t)
+	tests := map[string]struct {
+		query url.Values
+		code  int
+	}{
+		"no path":            {url.Values{}, http.StatusBadRequest},
+		"missing file":       {url.Values{"path": {filepath.Join(data, "missing")}}, http.StatusNotFound},
+		"directory":          {url.Values{"path": {data}}, http.StatusBadRequest},
+		"unknown format":     {url.Values{"path": {data}, "format": {"rar"}}, http.StatusBadRequest},
+		"archive of a file":  {url.Values{"path": {filepath.Join(data, "file")}, "format": {"tar"}}, http.StatusBadRequest},
+		"malformed patterns": {url.Values{"path": {data}, "format": {"tar"}, "include": {"[a-"}}, http.StatusBadRequest},
+	}
+	if err := os.WriteFile(filepath.Join(data, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range tests {
+		if w := download(s, tt.query, nil); w.Code != tt.code {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, tt.code)
+		}
+	}
+}
+
+func TestDownloadArchive(t *testing.T) {
+	s, data := fileServer(t)
+	root := filepath.Join(data, "site")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"index.html", "main.css", "debug.log"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := download(s, url.Values{"path": {root}, "format": {"tgz"}, "exclude": {"*.log, *.tmp"}}, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("archive download = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename=site.tar.gz` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	want := []string{"site/", "site/index.html", "site/main.css"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+}
@@ -2,12 +2,21 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -76,7 +85,155 @@ func (s *AgentService) SubmitTask(ctx context.Context, req *TaskRequest) (*TaskR
 		"command":   req.Command,
 	}).Info("SubmitTask called")
 
-	// Convert request to task data
+	// Convert to Task struct
+	task, err := convertMapToTask(taskRequestData(req))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to parse task: %v", err)
+	}
+
+	return s.submitTask(ctx, task)
+}
+
+// SubmitTaskWithInputs submits a task together with its input files
+func (s *AgentService) SubmitTaskWithInputs(stream AgentAPI_SubmitTaskWithInputsServer) error {
+	var (
+		req      *TaskRequest
+		received []*executor.TaskInput
+		current  *inputStream
+		queued   bool
+	)
+
+	// Received files are removed unless the task is queued
+	defer func() {
+		if current != nil {
+			if input, err := current.finish(); err == nil {
+				received = append(received, input)
+			}
+		}
+		if !queued {
+			executor.DiscardInputs(received)
+		}
+	}()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunk.Task != nil {
+			req = chunk.Task
+		}
+		if chunk.Name == "" {
+			if len(chunk.Data) > 0 {
+				return status.Error(codes.InvalidArgument, "input data without a name")
+			}
+			continue
+		}
+
+		// A new name starts the next input file
+		if current == nil || current.name != chunk.Name {
+			if current != nil {
+				input, err := current.finish()
+				current = nil
+				if err != nil {
+					return status.Errorf(codes.InvalidArgument, "%v", err)
+				}
+				received = append(received, input)
+			}
+			current = s.receiveInput(chunk.Name)
+		}
+
+		if _, err := current.writer.Write(chunk.Data); err != nil {
+			_, err = current.finish()
+			current = nil
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	if current != nil {
+		input, err := current.finish()
+		current = nil
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		received = append(received, input)
+	}
+
+	if req == nil {
+		return status.Error(codes.InvalidArgument, "stream did not contain a task")
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"task_type": req.Type,
+		"command":   req.Command,
+		"inputs":    len(received),
+	}).Info("SubmitTaskWithInputs called")
+
+	task, err := convertMapToTask(taskRequestData(req))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to parse task: %v", err)
+	}
+
+	if err := executor.AttachInputs(task, received); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	response, err := s.submitTask(stream.Context(), task)
+	if err != nil {
+		return err
+	}
+	queued = true
+
+	return stream.SendAndClose(response)
+}
+
+// inputStream feeds the data of one streamed input file to the executor
+type inputStream struct {
+	name   string
+	writer *io.PipeWriter
+	result chan inputResult
+}
+
+type inputResult struct {
+	input *executor.TaskInput
+	err   error
+}
+
+// receiveInput starts receiving an input file from a stream
+func (s *AgentService) receiveInput(name string) *inputStream {
+	reader, writer := io.Pipe()
+	in := &inputStream{
+		name:   name,
+		writer: writer,
+		result: make(chan inputResult, 1),
+	}
+
+	go func() {
+		input, err := s.agent.GetExecutor().ReceiveInput(name, reader)
+		if err == nil {
+			reader.Close()
+		} else {
+			reader.CloseWithError(err)
+		}
+		in.result <- inputResult{input: input, err: err}
+	}()
+
+	return in
+}
+
+// finish ends the input file and waits until it has been stored
+func (in *inputStream) finish() (*executor.TaskInput, error) {
+	in.writer.Close()
+	result := <-in.result
+	return result.input, result.err
+}
+
+// taskRequestData converts a task request to task data
+func taskRequestData(req *TaskRequest) map[string]interface{} {
 	taskData := map[string]interface{}{
 		"type":        req.Type,
 		"name":        req.Name,
@@ -88,23 +245,73 @@ func (s *AgentService) SubmitTask(ctx context.Context, req *TaskRequest) (*TaskR
 		"metadata":    req.Metadata,
 	}
 
-	// Convert to Task struct
-	task, err := convertMapToTask(taskData)
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to parse task: %v", err)
+	if len(req.Inputs) > 0 {
+		inputs := make([]interface{}, 0, len(req.Inputs))
+		for _, input := range req.Inputs {
+			inputs = append(inputs, map[string]interface{}{
+				"name":   input.Name,
+				"mode":   input.Mode,
+				"sha256": input.Sha256,
+			})
+		}
+		taskData["inputs"] = inputs
 	}
 
-	// Submit task
+	if req.Requests != nil {
+		requests := make(map[string]interface{})
+		quantities := map[string]string{
+			"cpu":    req.Requests.Cpu,
+			"memory": req.Requests.Memory,
+			"disk":   req.Requests.Disk,
+		}
+		for key, value := range quantities {
+			if value != "" {
+				requests[key] = value
+			}
+		}
+		taskData["requests"] = requests
+	}
+
+	return taskData
+}
+
+// submitTask submits a parsed task, mapping executor errors to gRPC status codes
+func (s *AgentService) submitTask(ctx context.Context, task *executor.Task) (*TaskResponse, error) {
 	taskID, err := s.agent.GetExecutor().SubmitTask(task)
 	if err != nil {
+		if errors.Is(err, executor.ErrInvalidTask) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if errors.Is(err, executor.ErrDraining) {
+			return nil, status.Errorf(codes.Unavailable, "%v", err)
+		}
+		var windowErr *executor.WindowError
+		if errors.As(err, &windowErr) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		var admissionErr *executor.AdmissionError
+		if errors.As(err, &admissionErr) {
+			retryAfter := int(math.Ceil(admissionErr.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(retryAfter)))
+			return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+		}
 		s.logger.WithError(err).Error("Failed to submit task")
 		return nil, status.Errorf(codes.Internal, "failed to submit task: %v", err)
 	}
 
+	// Pending and scheduled tasks report why they are not queued
+	message := "Task submitted successfully"
+	if task.PendingReason != "" {
+		message = "Task submitted, " + task.PendingReason
+	}
+
 	return &TaskResponse{
 		TaskId:  taskID,
-		Status:  "queued",
-		Message: "Task submitted successfully",
+		Status:  string(task.Status),
+		Message: message,
 	}, nil
 }
 
@@ -112,17 +319,34 @@ func (s *AgentService) SubmitTask(ctx context.Context, req *TaskRequest) (*TaskR
 func (s *AgentService) GetTask(ctx context.Context, req *TaskDetailRequest) (*TaskDetailResponse, error) {
 	s.logger.WithField("task_id", req.TaskId).Debug("GetTask called")
 
-	_, err := s.agent.GetExecutor().GetTask(req.TaskId)
+	task, err := s.agent.GetExecutor().GetTask(req.TaskId)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "task not found: %v", err)
 	}
 
-	// Convert task to response
-	// This would need proper type conversion
-	return &TaskDetailResponse{
-		TaskId: req.TaskId,
-		Status: "running", // Would come from actual task
-	}, nil
+	response := &TaskDetailResponse{
+		TaskId:        task.ID,
+		Type:          string(task.Type),
+		Status:        string(task.Status),
+		PendingReason: task.PendingReason,
+		StartedAt:     unixOrZero(task.StartedAt),
+		FinishedAt:    unixOrZero(task.FinishedAt),
+		Metadata:      convertToStringMap(task.Metadata),
+	}
+
+	if result := task.Result; result != nil {
+		response.ExitCode = int32(result.ExitCode)
+		response.Output = result.Output
+		response.Error = result.Error
+	}
+
+	if progress := task.GetProgress(); progress != nil {
+		response.Progress = float32(progress.Percent)
+		response.ProgressMessage = progress.Message
+		response.ProgressFields = convertToStringMap(progress.Fields)
+	}
+
+	return response, nil
 }
 
 // CancelTask cancels a running task
@@ -144,29 +368,68 @@ func (s *AgentService) CancelTask(ctx context.Context, req *TaskDetailRequest) (
 func (s *AgentService) ListTasks(ctx context.Context, req *ListTasksRequest) (*ListTasksResponse, error) {
 	s.logger.Debug("ListTasks called")
 
-	var tasks []*executor.Task
-	if req.Filter == "running" {
-		tasks = s.agent.GetExecutor().ListRunningTasks()
-	} else {
-		tasks = s.agent.GetExecutor().ListTasks()
+	query := executor.TaskQuery{
+		NamePrefix: req.NamePrefix,
+		Labels:     req.Labels,
+		Limit:      int(req.Limit),
+		Cursor:     req.Cursor,
+		SortBy:     executor.SortByCreatedAt,
+		Descending: true,
+	}
+	for _, status := range req.Status {
+		query.Statuses = append(query.Statuses, executor.TaskStatus(status))
+	}
+	for _, taskType := range req.Type {
+		query.Types = append(query.Types, executor.TaskType(taskType))
+	}
+	if req.Filter == "running" && len(query.Statuses) == 0 {
+		query.Statuses = []executor.TaskStatus{executor.TaskStatusRunning}
+	}
+	if req.Since > 0 {
+		query.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until > 0 {
+		query.Until = time.Unix(req.Until, 0)
+	}
+	if req.Sort != "" {
+		query.Descending = strings.HasPrefix(req.Sort, "-")
+		query.SortBy = strings.TrimPrefix(req.Sort, "-")
+	}
+
+	page, err := s.agent.GetExecutor().QueryTasks(query)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
 	}
 
 	// Convert tasks to response format
-	taskList := make([]*TaskSummary, 0, len(tasks))
-	for range tasks {
-		// This would need proper type conversion
+	taskList := make([]*TaskSummary, 0, len(page.Tasks))
+	for _, task := range page.Tasks {
 		taskList = append(taskList, &TaskSummary{
-			TaskId: "task-id", // Would come from actual task
-			Status: "running",
+			TaskId:     task.ID,
+			Type:       string(task.Type),
+			Status:     string(task.Status),
+			Name:       task.Name,
+			CreatedAt:  unixOrZero(task.CreatedAt),
+			StartedAt:  unixOrZero(task.StartedAt),
+			FinishedAt: unixOrZero(task.FinishedAt),
 		})
 	}
 
 	return &ListTasksResponse{
-		Tasks: taskList,
-		Total: int32(len(taskList)),
+		Tasks:      taskList,
+		Total:      int32(page.Total),
+		NextCursor: page.NextCursor,
 	}, nil
 }
 
+// unixOrZero converts a time to Unix seconds, keeping zero times at 0
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 // ExecuteFileOperation executes a file operation
 func (s *AgentService) ExecuteFileOperation(ctx context.Context, req *FileOperationRequest) (*FileOperationResponse, error) {
 	s.logger.WithFields(logrus.Fields{
@@ -182,6 +445,7 @@ func (s *AgentService) ExecuteFileOperation(ctx context.Context, req *FileOperat
 		"dest_path":   req.DestPath,
 		"recursive":   req.Recursive,
 		"overwrite":   req.Overwrite,
+		"permanent":   req.Metadata["permanent"] == "true",
 		"metadata":    req.Metadata,
 	}
 
@@ -190,10 +454,32 @@ func (s *AgentService) ExecuteFileOperation(ctx context.Context, req *FileOperat
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "failed to parse operation: %v", err)
 	}
+	operation.Actor = "grpc"
+	if p, ok := peer.FromContext(ctx); ok {
+		operation.Actor = "grpc:" + p.Addr.String()
+	}
 
 	// Execute operation
 	result, err := s.agent.GetFileOps().ExecuteOperation(ctx, operation)
 	if err != nil {
+		if errors.Is(err, fileops.ErrAccessDenied) {
+			return nil, status.Errorf(codes.PermissionDenied, "%v", err)
+		}
+		if errors.Is(err, fileops.ErrNoStreamer) {
+			return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+		}
+		if errors.Is(err, fileops.ErrArchiveLimit) || errors.Is(err, fileops.ErrWatchLimit) {
+			return nil, status.Errorf(codes.ResourceExhausted, "%v", err)
+		}
+		if errors.Is(err, fileops.ErrFileExists) {
+			return nil, status.Errorf(codes.AlreadyExists, "%v", err)
+		}
+		if errors.Is(err, fileops.ErrWatchNotFound) || errors.Is(err, fileops.ErrTrashNotFound) || errors.Is(err, fileops.ErrVersionNotFound) {
+			return nil, status.Errorf(codes.NotFound, "%v", err)
+		}
+		if errors.Is(err, fileops.ErrWatchUnsupported) {
+			return nil, status.Errorf(codes.Unimplemented, "%v", err)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to execute file operation: %v", err)
 	}
 
@@ -249,6 +535,8 @@ func (s *AgentService) HealthCheck(ctx context.Context, req *HealthCheckRequest)
 
 	if !healthy {
 		response.Status = "unhealthy"
+	} else if s.agent.GetExecutor().IsDraining() {
+		response.Status = "draining"
 	}
 
 	// Add detailed checks if requested
@@ -338,6 +626,54 @@ func convertMapToTask(data map[string]interface{}) (*executor.Task, error) {
 		task.WorkingDir = workingDir
 	}
 	
+	if workspace, ok := data["workspace"].(string); ok {
+		task.Workspace = workspace
+	}
+	
+	if sandbox, ok := data["sandbox"].(string); ok {
+		task.Sandbox = sandbox
+	}
+	
+	if success, ok := data["success"]; ok && success != nil {
+		criteria, err := executor.ParseSuccessCriteria(success)
+		if err != nil {
+			return nil, err
+		}
+		task.Success = criteria
+	}
+	
+	if inputs, ok := data["inputs"]; ok && inputs != nil {
+		parsed, err := executor.ParseTaskInputs(inputs)
+		if err != nil {
+			return nil, err
+		}
+		task.Inputs = parsed
+	}
+	
+	if override, ok := data["override_windows"].(bool); ok {
+		task.OverrideWindows = override
+	}
+	
+	if reason, ok := data["override_reason"].(string); ok {
+		task.OverrideReason = reason
+	}
+	
+	if requests, ok := data["requests"]; ok && requests != nil {
+		parsed, err := executor.ParseResourceRequests(requests)
+		if err != nil {
+			return nil, err
+		}
+		task.Requests = parsed
+	}
+	
+	if hooks, ok := data["hooks"]; ok && hooks != nil {
+		parsed, err := executor.ParseHooks(hooks)
+		if err != nil {
+			return nil, err
+		}
+		task.Hooks = parsed
+	}
+	
 	if metadata, ok := data["metadata"].(map[string]interface{}); ok {
 		task.Metadata = metadata
 	}
@@ -14,6 +14,7 @@ type AgentAPIServer interface {
 	GetInfo(context.Context, *InfoRequest) (*InfoResponse, error)
 	GetStatus(context.Context, *StatusRequest) (*StatusResponse, error)
 	SubmitTask(context.Context, *TaskRequest) (*TaskResponse, error)
+	SubmitTaskWithInputs(AgentAPI_SubmitTaskWithInputsServer) error
 	GetTask(context.Context, *TaskDetailRequest) (*TaskDetailResponse, error)
 	CancelTask(context.Context, *TaskDetailRequest) (*TaskResponse, error)
 	ListTasks(context.Context, *ListTasksRequest) (*ListTasksResponse, error)
@@ -37,6 +38,9 @@ func (UnimplementedAgentAPIServer) GetStatus(context.Context, *StatusRequest) (*
 func (UnimplementedAgentAPIServer) SubmitTask(context.Context, *TaskRequest) (*TaskResponse, error) {
 	return nil, nil
 }
+func (UnimplementedAgentAPIServer) SubmitTaskWithInputs(AgentAPI_SubmitTaskWithInputsServer) error {
+	return nil
+}
 func (UnimplementedAgentAPIServer) GetTask(context.Context, *TaskDetailRequest) (*TaskDetailResponse, error) {
 	return nil, nil
 }
@@ -72,6 +76,12 @@ type AgentAPI_StreamLogsServer interface {
 	grpc.ServerStream
 }
 
+type AgentAPI_SubmitTaskWithInputsServer interface {
+	SendAndClose(*TaskResponse) error
+	Recv() (*TaskInputChunk, error)
+	grpc.ServerStream
+}
+
 type AgentAPI_StreamMetricsServer interface {
 	Send(*MetricsResponse) error
 	grpc.ServerStream
@@ -117,6 +127,30 @@ type TaskRequest struct {
 	WorkingDir string            `json:"working_dir"`
 	Timeout    int32             `json:"timeout"`
 	Metadata   map[string]string `json:"metadata"`
+	Inputs     []*TaskInputSpec  `json:"inputs"`
+	Requests   *ResourceRequests `json:"requests"`
+}
+
+// ResourceRequests are quantities such as "500m" CPU or "512Mi" memory
+type ResourceRequests struct {
+	Cpu    string `json:"cpu"`
+	Memory string `json:"memory"`
+	Disk   string `json:"disk"`
+}
+
+type TaskInputSpec struct {
+	Name   string `json:"name"`
+	Mode   string `json:"mode"`
+	Sha256 string `json:"sha256"`
+}
+
+// TaskInputChunk is a message of a SubmitTaskWithInputs stream. The first
+// message carries the task, the following ones carry input file data in
+// order, one file after the other.
+type TaskInputChunk struct {
+	Task *TaskRequest `json:"task,omitempty"`
+	Name string       `json:"name"`
+	Data []byte       `json:"data"`
 }
 
 type TaskResponse struct {
@@ -130,33 +164,49 @@ type TaskDetailRequest struct {
 }
 
 type TaskDetailResponse struct {
-	TaskId     string            `json:"task_id"`
-	Type       string            `json:"type"`
-	Status     string            `json:"status"`
-	ExitCode   int32             `json:"exit_code"`
-	Output     string            `json:"output"`
-	Error      string            `json:"error"`
-	StartedAt  int64             `json:"started_at"`
-	FinishedAt int64             `json:"finished_at"`
-	Metadata   map[string]string `json:"metadata"`
+	TaskId          string            `json:"task_id"`
+	Type            string            `json:"type"`
+	Status          string            `json:"status"`
+	PendingReason   string            `json:"pending_reason,omitempty"`
+	ExitCode        int32             `json:"exit_code"`
+	Output          string            `json:"output"`
+	Error           string            `json:"error"`
+	StartedAt       int64             `json:"started_at"`
+	FinishedAt      int64             `json:"finished_at"`
+	Metadata        map[string]string `json:"metadata"`
+	Progress        float32           `json:"progress"`
+	ProgressMessage string            `json:"progress_message,omitempty"`
+	ProgressFields  map[string]string `json:"progress_fields,omitempty"`
 }
 
 type ListTasksRequest struct {
-	Filter string `json:"filter"`
-	Limit  int32  `json:"limit"`
-	Offset int32  `json:"offset"`
+	Filter     string            `json:"filter"`
+	Limit      int32             `json:"limit"`
+	Offset     int32             `json:"offset"`
+	Status     []string          `json:"status"`
+	Type       []string          `json:"type"`
+	NamePrefix string            `json:"name_prefix"`
+	Since      int64             `json:"since"`
+	Until      int64             `json:"until"`
+	Labels     map[string]string `json:"labels"`
+	Sort       string            `json:"sort"`
+	Cursor     string            `json:"cursor"`
 }
 
 type ListTasksResponse struct {
-	Tasks []*TaskSummary `json:"tasks"`
-	Total int32          `json:"total"`
+	Tasks      []*TaskSummary `json:"tasks"`
+	Total      int32          `json:"total"`
+	NextCursor string         `json:"next_cursor"`
 }
 
 type TaskSummary struct {
-	TaskId    string `json:"task_id"`
-	Type      string `json:"type"`
-	Status    string `json:"status"`
-	StartedAt int64  `json:"started_at"`
+	TaskId     string `json:"task_id"`
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	StartedAt  int64  `json:"started_at"`
+	Name       string `json:"name"`
+	CreatedAt  int64  `json:"created_at"`
+	FinishedAt int64  `json:"finished_at"`
 }
 
 type FileOperationRequest struct {
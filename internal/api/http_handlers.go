@@ -1,15 +1,43 @@
 package api
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
+	"github.com/gorilla/websocket"
 )
 
+const (
+	// eventKeepalive is how often an idle event stream is kept alive
+	eventKeepalive = 15 * time.Second
+	// eventWriteTimeout bounds sending one event over a WebSocket
+	eventWriteTimeout = 10 * time.Second
+)
+
+// eventUpgrader upgrades event stream requests to WebSocket connections
+var eventUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
 // Response represents a standard API response
 type Response struct {
 	Success bool        `json:"success"`
@@ -63,7 +91,8 @@ func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ready := s.agent.IsRunning()
+	draining := s.agent.GetExecutor().IsDraining()
+	ready := s.agent.IsRunning() && !draining
 
 	status := http.StatusOK
 	if !ready {
@@ -73,7 +102,8 @@ func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
 	s.respondJSON(w, status, Response{
 		Success: ready,
 		Data: map[string]interface{}{
-			"ready": ready,
+			"ready":    ready,
+			"draining": draining,
 		},
 	})
 }
@@ -133,24 +163,89 @@ func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get query parameters
-	filter := r.URL.Query().Get("filter")
+	query, err := parseTaskQuery(r.URL.Query())
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	var tasks []*executor.Task
-	if filter == "running" {
-		tasks = s.agent.GetExecutor().ListRunningTasks()
-	} else {
-		tasks = s.agent.GetExecutor().ListTasks()
+	page, err := s.agent.GetExecutor().QueryTasks(query)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	s.respondJSON(w, http.StatusOK, Response{
 		Success: true,
 		Data: map[string]interface{}{
-			"tasks": tasks,
-			"count": len(tasks),
+			"tasks":       page.Tasks,
+			"count":       len(page.Tasks),
+			"total":       page.Total,
+			"next_cursor": page.NextCursor,
 		},
 	})
 }
 
+// parseTaskQuery builds a task query from list request parameters
+func parseTaskQuery(values url.Values) (executor.TaskQuery, error) {
+	query := executor.TaskQuery{
+		Statuses:   executor.ParseTaskStatuses(values.Get("status")),
+		Types:      executor.ParseTaskTypes(values.Get("type")),
+		NamePrefix: values.Get("name_prefix"),
+		Cursor:     values.Get("cursor"),
+		SortBy:     executor.SortByCreatedAt,
+		Descending: true,
+	}
+
+	// Legacy filter=running
+	if values.Get("filter") == "running" && len(query.Statuses) == 0 {
+		query.Statuses = []executor.TaskStatus{executor.TaskStatusRunning}
+	}
+
+	var err error
+	if query.Since, err = parseTimeParam(values.Get("since")); err != nil {
+		return query, fmt.Errorf("invalid since: %w", err)
+	}
+	if query.Until, err = parseTimeParam(values.Get("until")); err != nil {
+		return query, fmt.Errorf("invalid until: %w", err)
+	}
+
+	for _, label := range values["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return query, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		if query.Labels == nil {
+			query.Labels = make(map[string]string)
+		}
+		query.Labels[key] = value
+	}
+
+	if sortBy := values.Get("sort"); sortBy != "" {
+		query.Descending = strings.HasPrefix(sortBy, "-")
+		query.SortBy = strings.TrimPrefix(sortBy, "-")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 0 {
+			return query, fmt.Errorf("invalid limit: %s", limit)
+		}
+	}
+
+	return query, nil
+}
+
+// parseTimeParam parses an RFC 3339 timestamp or Unix seconds
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 // handleTaskSubmit handles task submission requests
 func (s *Server) handleTaskSubmit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -158,6 +253,12 @@ func (s *Server) handleTaskSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Tasks with input files are submitted as multipart forms
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		s.handleTaskSubmitMultipart(w, r)
+		return
+	}
+
 	// Parse request body
 	var taskData map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&taskData); err != nil {
@@ -172,34 +273,152 @@ func (s *Server) handleTaskSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Submit task
+	s.submitTask(w, task)
+}
+
+// handleTaskSubmitMultipart handles task submissions with input files. The
+// form has a "task" field with the task JSON and one "inputs[]" file part per
+// input, named by its path relative to the working directory.
+func (s *Server) handleTaskSubmitMultipart(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid multipart request: "+err.Error())
+		return
+	}
+
+	var (
+		taskData map[string]interface{}
+		received []*executor.TaskInput
+		queued   bool
+	)
+
+	// Received files are removed unless the task is queued
+	defer func() {
+		if !queued {
+			executor.DiscardInputs(received)
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid multipart request: "+err.Error())
+			return
+		}
+
+		switch part.FormName() {
+		case "task":
+			if err := json.NewDecoder(part).Decode(&taskData); err != nil {
+				s.respondError(w, http.StatusBadRequest, "Invalid task data: "+err.Error())
+				return
+			}
+		case "inputs[]", "inputs":
+			input, err := s.agent.GetExecutor().ReceiveInput(partFileName(part), part)
+			if err != nil {
+				s.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			received = append(received, input)
+		}
+		part.Close()
+	}
+
+	if taskData == nil {
+		s.respondError(w, http.StatusBadRequest, "Missing task field")
+		return
+	}
+
+	task, err := convertMapToTask(taskData)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid task data: "+err.Error())
+		return
+	}
+
+	if err := executor.AttachInputs(task, received); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	queued = s.submitTask(w, task)
+}
+
+// partFileName returns the unmodified file name of a multipart part.
+// Part.FileName strips directories, but inputs may be staged in
+// subdirectories of the working directory.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// submitTask submits a parsed task and writes the response. It reports
+// whether the task was queued.
+func (s *Server) submitTask(w http.ResponseWriter, task *executor.Task) bool {
 	taskID, err := s.agent.GetExecutor().SubmitTask(task)
 	if err != nil {
+		if s.respondAdmissionError(w, err) {
+			return false
+		}
+		if errors.Is(err, executor.ErrInvalidTask) {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
 		s.respondError(w, http.StatusInternalServerError, err.Error())
-		return
+		return false
+	}
+
+	// Pending and scheduled tasks report why they are not queued
+	data := map[string]interface{}{
+		"task_id": taskID,
+		"status":  task.Status,
+	}
+	if task.PendingReason != "" {
+		data["pending_reason"] = task.PendingReason
 	}
 
 	s.respondJSON(w, http.StatusAccepted, Response{
 		Success: true,
-		Data: map[string]interface{}{
-			"task_id": taskID,
-			"status":  "queued",
-		},
+		Data:    data,
 		Message: "Task submitted successfully",
 	})
+	return true
 }
 
 // handleTaskDetail handles task detail requests
 func (s *Server) handleTaskDetail(w http.ResponseWriter, r *http.Request) {
 	// Extract task ID from path
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/")
-	taskID := strings.Split(path, "/")[0]
+	parts := strings.Split(path, "/")
+	taskID := parts[0]
 
 	if taskID == "" {
 		s.respondError(w, http.StatusBadRequest, "Task ID is required")
 		return
 	}
 
+	// Task actions
+	if len(parts) > 1 {
+		if r.Method != http.MethodPost {
+			s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		switch parts[1] {
+		case "pause":
+			s.handleTaskPause(w, r, taskID)
+		case "resume":
+			s.handleTaskResume(w, r, taskID)
+		default:
+			s.respondError(w, http.StatusNotFound, "Unknown task action: "+parts[1])
+		}
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		s.handleTaskGet(w, r, taskID)
@@ -237,145 +456,1450 @@ func (s *Server) handleTaskCancel(w http.ResponseWriter, r *http.Request, taskID
 	})
 }
 
-// handleFiles handles file operation requests
-func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+// handleTaskPause handles pause task requests
+func (s *Server) handleTaskPause(w http.ResponseWriter, r *http.Request, taskID string) {
+	if err := s.agent.GetExecutor().PauseTask(taskID); err != nil {
+		s.respondError(w, http.StatusConflict, err.Error())
 		return
 	}
 
-	// Parse request body
-	var opData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&opData); err != nil {
-		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"task_id": taskID,
+			"status":  executor.TaskStatusPaused,
+		},
+		Message: "Task paused successfully",
+	})
+}
+
+// handleTaskResume handles resume task requests
+func (s *Server) handleTaskResume(w http.ResponseWriter, r *http.Request, taskID string) {
+	if err := s.agent.GetExecutor().ResumeTask(taskID); err != nil {
+		s.respondError(w, http.StatusConflict, err.Error())
 		return
 	}
 
-	// Parse operation
-	operation, err := fileops.ParseOperation(opData)
-	if err != nil {
-		s.respondError(w, http.StatusBadRequest, "Invalid operation: "+err.Error())
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"task_id": taskID,
+			"status":  executor.TaskStatusRunning,
+		},
+		Message: "Task resumed successfully",
+	})
+}
+
+// batchRequest is the body of a batch submission. Tasks are given either
+// explicitly or as a template expanded over a matrix of values.
+type batchRequest struct {
+	Name     string                   `json:"name"`
+	FailFast bool                     `json:"fail_fast"`
+	Tasks    []map[string]interface{} `json:"tasks"`
+	Template map[string]interface{}   `json:"template"`
+	Matrix   map[string][]interface{} `json:"matrix"`
+}
+
+// handleBatches handles batch submission and list requests
+func (s *Server) handleBatches(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		batches := s.agent.GetExecutor().ListBatches()
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"batches": batches,
+				"count":   len(batches),
+			},
+		})
+	case http.MethodPost:
+		s.handleBatchSubmit(w, r)
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleBatchSubmit handles batch submission requests
+func (s *Server) handleBatchSubmit(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	// Execute file operation
-	result, err := s.agent.GetFileOps().ExecuteOperation(r.Context(), operation)
+	// A bare array is a list of tasks
+	var req batchRequest
+	if err := json.Unmarshal(body, &req.Tasks); err != nil {
+		req.Tasks = nil
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	taskData := req.Tasks
+	if req.Template != nil {
+		if len(taskData) > 0 {
+			s.respondError(w, http.StatusBadRequest, "Specify either tasks or template, not both")
+			return
+		}
+
+		expanded, err := executor.ExpandMatrix(req.Template, req.Matrix)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid matrix: "+err.Error())
+			return
+		}
+		taskData = expanded
+	}
+
+	tasks := make([]*executor.Task, 0, len(taskData))
+	for i, data := range taskData {
+		task, err := convertMapToTask(data)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid task data for task %d: %s", i, err))
+			return
+		}
+		tasks = append(tasks, task)
+	}
+
+	batch, err := s.agent.GetExecutor().SubmitBatch(req.Name, tasks, req.FailFast)
 	if err != nil {
-		s.respondError(w, http.StatusInternalServerError, err.Error())
+		if s.respondAdmissionError(w, err) {
+			return
+		}
+		s.respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, Response{
+	s.respondJSON(w, http.StatusAccepted, Response{
 		Success: true,
-		Data:    result,
+		Data: map[string]interface{}{
+			"batch_id":  batch.ID,
+			"task_ids":  batch.TaskIDs,
+			"count":     len(batch.TaskIDs),
+			"fail_fast": batch.FailFast,
+		},
+		Message: "Batch submitted successfully",
 	})
 }
 
-// handleFileUpload handles file upload requests
-func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+// handleBatchDetail handles batch status and cancel requests
+func (s *Server) handleBatchDetail(w http.ResponseWriter, r *http.Request) {
+	batchID := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/batch/")
+	if batchID == "" {
+		s.respondError(w, http.StatusBadRequest, "Batch ID is required")
 		return
 	}
 
-	// Parse multipart form
-	if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB max
-		s.respondError(w, http.StatusBadRequest, "Failed to parse form")
-		return
+	switch r.Method {
+	case http.MethodGet:
+		status, err := s.agent.GetExecutor().GetBatch(batchID)
+		if err != nil {
+			s.respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    status,
+		})
+	case http.MethodDelete:
+		cancelled, err := s.agent.GetExecutor().CancelBatch(batchID)
+		if err != nil {
+			s.respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"batch_id":  batchID,
+				"cancelled": cancelled,
+			},
+			Message: "Batch cancelled successfully",
+		})
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
+}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		s.respondError(w, http.StatusBadRequest, "No file provided")
-		return
+// handleWorkers handles worker pool inspection and resize requests
+func (s *Server) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"workers": s.agent.GetExecutor().GetWorkerStats(),
+			},
+		})
+	case http.MethodPut, http.MethodPost:
+		var req struct {
+			MinWorkers int `json:"min_workers"`
+			MaxWorkers int `json:"max_workers"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := s.agent.GetExecutor().ResizeWorkerPool(req.MinWorkers, req.MaxWorkers); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"workers": s.agent.GetExecutor().GetWorkerStats(),
+			},
+			Message: "Worker pool resized successfully",
+		})
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
-	defer file.Close()
+}
 
-	destPath := r.FormValue("dest_path")
-	if destPath == "" {
-		s.respondError(w, http.StatusBadRequest, "Destination path is required")
+// handleResources handles resource scheduling status requests
+func (s *Server) handleResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Handle file upload
-	// Implementation would save the file
 	s.respondJSON(w, http.StatusOK, Response{
 		Success: true,
-		Data: map[string]interface{}{
-			"filename": header.Filename,
-			"size":     header.Size,
-			"dest":     destPath,
-		},
-		Message: "File uploaded successfully",
+		Data:    s.agent.GetExecutor().GetResourceStatus(),
 	})
 }
 
-// handleFileDownload handles file download requests
-func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
+// handleWindows handles maintenance window status requests
+func (s *Server) handleWindows(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	filePath := r.URL.Query().Get("path")
-	if filePath == "" {
-		s.respondError(w, http.StatusBadRequest, "File path is required")
-		return
-	}
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"windows": s.agent.GetExecutor().GetWindows(),
+		},
+	})
+}
+
+// handleDrain handles executor drain requests
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	exec := s.agent.GetExecutor()
+
+	switch r.Method {
+	case http.MethodGet:
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"draining": exec.IsDraining(),
+			},
+		})
+	case http.MethodPost:
+		timeout := s.agent.GetConfig().Executor.DrainTimeout
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				s.respondError(w, http.StatusBadRequest, "Invalid timeout")
+				return
+			}
+			timeout = parsed
+		}
+
+		handedBack, err := exec.BeginDrain()
+		if err != nil {
+			s.respondError(w, http.StatusConflict, err.Error())
+			return
+		}
 
-	// Serve file
-	http.ServeFile(w, r, filePath)
+		// Running tasks are cancelled once the timeout expires
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			exec.AwaitDrain(ctx)
+		}()
+
+		s.respondJSON(w, http.StatusAccepted, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"draining":    true,
+				"timeout":     timeout.String(),
+				"handed_back": handedBack,
+			},
+			Message: "Executor draining",
+		})
+	case http.MethodDelete:
+		resubmitted := exec.Undrain()
+
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"draining":    false,
+				"resubmitted": resubmitted,
+			},
+			Message: "Executor accepting tasks",
+		})
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
 }
 
-// handleTransferStatus handles transfer status requests
-func (s *Server) handleTransferStatus(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleFiles handles file operation requests
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Extract transfer ID from path
-	path := strings.TrimPrefix(r.URL.Path, "/api/v1/files/transfer/")
-	transferID := strings.Split(path, "/")[0]
+	// Parse request body
+	var opData map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&opData); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
 
-	if transferID == "" {
-		s.respondError(w, http.StatusBadRequest, "Transfer ID is required")
+	// Parse operation
+	operation, err := fileops.ParseOperation(opData)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid operation: "+err.Error())
 		return
 	}
 
-	transfer, err := s.agent.GetFileOps().GetTransfer(transferID)
+	operation.Actor = "api:" + r.RemoteAddr
+
+	// Execute file operation
+	result, err := s.agent.GetFileOps().ExecuteOperation(r.Context(), operation)
 	if err != nil {
-		s.respondError(w, http.StatusNotFound, err.Error())
+		s.respondFileError(w, err)
 		return
 	}
 
 	s.respondJSON(w, http.StatusOK, Response{
 		Success: true,
-		Data:    transfer,
+		Data:    result,
 	})
 }
 
-// handleMetrics handles metrics requests
-func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleFileUpload handles file upload requests. The file is streamed to
+// disk, either as the "file" part of a multipart form or as the raw request
+// body. Options are taken from query parameters and, for multipart forms,
+// from fields sent before the file part.
+func (s *Server) handleFileUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	var metrics map[string]interface{}
-	if s.agent.GetMetrics() != nil {
-		metrics = s.agent.GetMetrics().GetMetrics()
-	} else {
-		metrics = map[string]interface{}{
-			"message": "Metrics collection not enabled",
-		}
+	fields := r.URL.Query()
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		s.uploadFile(w, r, fields, r.Body)
+		return
 	}
 
-	s.respondJSON(w, http.StatusOK, Response{
-		Success: true,
-		Data:    metrics,
-	})
-}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid multipart request: "+err.Error())
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid multipart request: "+err.Error())
+			return
+		}
+
+		if part.FormName() == "file" {
+			s.uploadFile(w, r, fields, part)
+			part.Close()
+			return
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 4096))
+		part.Close()
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid multipart request: "+err.Error())
+			return
+		}
+		fields.Set(part.FormName(), string(value))
+	}
+
+	s.respondError(w, http.StatusBadRequest, "No file provided")
+}
+
+// uploadFile stores an upload and writes the response
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request, fields url.Values, body io.Reader) {
+	opts, err := parseUploadOptions(fields)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	transfer, err := s.agent.GetFileOps().Upload(r.Context(), body, opts)
+	if err != nil {
+		s.respondFileError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"transfer_id": transfer.ID,
+			"dest":        transfer.DestPath,
+			"size":        transfer.Size,
+			"sha256":      transfer.Checksum,
+		},
+		Message: "File uploaded successfully",
+	})
+}
+
+// parseUploadOptions reads upload options from form fields or query
+// parameters
+func parseUploadOptions(fields url.Values) (fileops.UploadOptions, error) {
+	opts := fileops.NewUploadOptions(fields.Get("dest_path"))
+	if opts.DestPath == "" {
+		return opts, errors.New("destination path is required")
+	}
+
+	if value := fields.Get("overwrite"); value != "" {
+		overwrite, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid overwrite value: %s", value)
+		}
+		opts.Overwrite = overwrite
+	}
+
+	if value := fields.Get("mode"); value != "" {
+		mode, err := fileops.ParseMode(value)
+		if err != nil {
+			return opts, err
+		}
+		opts.Mode = mode
+	}
+
+	uid, gid, err := fileops.ParseOwner(fields.Get("owner"))
+	if err != nil {
+		return opts, err
+	}
+	opts.UID, opts.GID = uid, gid
+
+	opts.SHA256 = fields.Get("sha256")
+	if value := fields.Get("size"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			return opts, fmt.Errorf("invalid size value: %s", value)
+		}
+		opts.Size = size
+	}
+
+	return opts, nil
+}
+
+// respondFileError maps file operation failures to status codes
+func (s *Server) respondFileError(w http.ResponseWriter, err error) {
+	var accessErr *fileops.AccessError
+	var checksumErr *fileops.ChecksumError
+	var offsetErr *fileops.OffsetError
+	switch {
+	case errors.As(err, &accessErr):
+		s.respondJSON(w, http.StatusForbidden, Response{
+			Success: false,
+			Data:    accessErr,
+			Error:   err.Error(),
+		})
+	case errors.As(err, &checksumErr):
+		s.respondJSON(w, http.StatusUnprocessableEntity, Response{
+			Success: false,
+			Data:    checksumErr,
+			Error:   err.Error(),
+		})
+	case errors.As(err, &offsetErr):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offsetErr.Offset, 10))
+		s.respondJSON(w, http.StatusConflict, Response{
+			Success: false,
+			Data:    offsetErr,
+			Error:   err.Error(),
+		})
+	case errors.Is(err, fileops.ErrUploadNotFound):
+		s.respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, fileops.ErrUploadBusy):
+		s.respondError(w, http.StatusLocked, err.Error())
+	case errors.Is(err, fileops.ErrInvalidPath), errors.Is(err, fileops.ErrInvalidDelta), errors.Is(err, fileops.ErrSizeMismatch):
+		s.respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, fileops.ErrFileExists), errors.Is(err, fileops.ErrUploadIncomplete):
+		s.respondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, fileops.ErrFileTooLarge), errors.Is(err, fileops.ErrArchiveLimit):
+		s.respondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, fileops.ErrWatchLimit):
+		s.respondError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, fileops.ErrWatchNotFound), errors.Is(err, fileops.ErrTrashNotFound), errors.Is(err, fileops.ErrVersionNotFound):
+		s.respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, fileops.ErrWatchUnsupported):
+		s.respondError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, fileops.ErrNoStreamer):
+		s.respondError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, os.ErrNotExist):
+		s.respondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, os.ErrPermission):
+		s.respondError(w, http.StatusForbidden, err.Error())
+	default:
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// handleUploadSessions creates resumable uploads. The JSON body takes the
+// same options as a direct upload; size is the total length of the file.
+func (s *Server) handleUploadSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	fields := url.Values{}
+	for key, value := range body {
+		switch v := value.(type) {
+		case string:
+			fields.Set(key, v)
+		case bool:
+			fields.Set(key, strconv.FormatBool(v))
+		case float64:
+			fields.Set(key, strconv.FormatInt(int64(v), 10))
+		}
+	}
+
+	opts, err := parseUploadOptions(fields)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session, err := s.agent.GetFileOps().CreateUpload(opts)
+	if err != nil {
+		s.respondFileError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/files/uploads/"+session.ID)
+	w.Header().Set("Upload-Offset", "0")
+	s.respondJSON(w, http.StatusCreated, Response{
+		Success: true,
+		Data:    session,
+		Message: "Upload session created",
+	})
+}
+
+// handleUploadSession handles a resumable upload: HEAD reports the offset,
+// PATCH appends a chunk at the Upload-Offset header, POST .../complete
+// verifies and commits the file and DELETE discards it
+func (s *Server) handleUploadSession(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/files/uploads/")
+	parts := strings.Split(path, "/")
+	uploadID := parts[0]
+
+	if uploadID == "" {
+		s.respondError(w, http.StatusBadRequest, "Upload ID is required")
+		return
+	}
+
+	fileOps := s.agent.GetFileOps()
+
+	if len(parts) > 1 {
+		if parts[1] != "complete" {
+			s.respondError(w, http.StatusNotFound, "Unknown upload action: "+parts[1])
+			return
+		}
+		if r.Method != http.MethodPost {
+			s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var body struct {
+			SHA256 string `json:"sha256"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				s.respondError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+
+		transfer, err := fileOps.CompleteUpload(uploadID, body.SHA256)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"transfer_id": transfer.ID,
+				"dest":        transfer.DestPath,
+				"size":        transfer.Size,
+				"sha256":      transfer.Checksum,
+			},
+			Message: "File uploaded successfully",
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		session, err := fileOps.GetUpload(uploadID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		setUploadHeaders(w, session)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		session, err := fileOps.GetUpload(uploadID)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		setUploadHeaders(w, session)
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    session,
+		})
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			s.respondError(w, http.StatusBadRequest, "Upload-Offset header is required")
+			return
+		}
+
+		session, err := fileOps.WriteUpload(r.Context(), uploadID, offset, r.Body)
+		if session != nil {
+			setUploadHeaders(w, session)
+		}
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := fileOps.AbortUpload(uploadID); err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func setUploadHeaders(w http.ResponseWriter, session *fileops.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if session.Size > 0 {
+		w.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+	}
+	w.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// handleFileDownload handles file download requests. Files are served with
+// range and conditional request support; directories are streamed as an
+// archive when a format is given.
+func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filePath := query.Get("path")
+	if filePath == "" {
+		s.respondError(w, http.StatusBadRequest, "File path is required")
+		return
+	}
+
+	if format := query.Get("format"); format != "" {
+		s.downloadArchive(w, r, filePath, format)
+		return
+	}
+
+	download, err := s.agent.GetFileOps().OpenDownload(filePath)
+	if err != nil {
+		switch {
+		case errors.Is(err, fileops.ErrInvalidPath):
+			s.respondError(w, http.StatusBadRequest, err.Error()+" (use format=tar.gz or format=zip for directories)")
+		case errors.Is(err, os.ErrNotExist):
+			s.respondError(w, http.StatusNotFound, "File not found: "+filePath)
+		default:
+			s.respondFileError(w, err)
+		}
+		return
+	}
+	defer download.File.Close()
+
+	sum, _ := hex.DecodeString(download.SHA256)
+	w.Header().Set("ETag", `"`+download.SHA256+`"`)
+	w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": download.Info.Name(),
+	}))
+
+	// ServeContent handles Range, If-Range, If-None-Match and HEAD
+	http.ServeContent(w, r, download.Info.Name(), download.Info.ModTime(), download.File)
+}
+
+// downloadArchive streams a directory as an archive. The include and exclude
+// parameters may be repeated or comma separated.
+func (s *Server) downloadArchive(w http.ResponseWriter, r *http.Request, dirPath, formatName string) {
+	format, err := fileops.ParseArchiveFormat(formatName)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	filters := fileops.Filters{
+		Include: splitParams(query["include"]),
+		Exclude: splitParams(query["exclude"]),
+	}
+	if err := filters.Validate(); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dirPath, err = s.agent.GetFileOps().ResolvePath(fileops.AccessRead, dirPath)
+	if err != nil {
+		s.respondFileError(w, err)
+		return
+	}
+
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "Directory not found: "+dirPath)
+		return
+	}
+	if !info.IsDir() {
+		s.respondError(w, http.StatusBadRequest, "Path is not a directory: "+dirPath)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filepath.Base(dirPath) + "." + string(format),
+	}))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The archive is streamed, so errors after this point can only be logged
+	// and surface to the client as a truncated archive
+	if err := s.agent.GetFileOps().WriteArchive(r.Context(), w, dirPath, format, filters); err != nil {
+		s.logger.WithError(err).WithField("path", dirPath).Error("Failed to stream archive")
+	}
+}
+
+// splitParams flattens repeated and comma separated query values
+func splitParams(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// syncRequest is the JSON body of the sync endpoints
+type syncRequest struct {
+	Path      string                 `json:"path"`
+	Source    *fileops.SyncManifest  `json:"source,omitempty"`
+	Files     []string               `json:"files,omitempty"`
+	File      string                 `json:"file,omitempty"`
+	BlockSize int                    `json:"block_size,omitempty"`
+	Signature *fileops.FileSignature `json:"signature,omitempty"`
+	Changes   []fileops.SyncChange   `json:"changes,omitempty"`
+	Options   fileops.SyncOptions    `json:"options"`
+	fileops.Filters
+}
+
+// handleFileSync handles delta directory sync. A client pushing a tree asks
+// for a plan against its manifest, applies deletes, directories and links,
+// sends each changed file as a delta against the signature of the agent's
+// copy, then applies attributes. A client pulling a tree fetches the
+// manifest and requests deltas against its own signatures.
+func (s *Server) handleFileSync(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/api/v1/files/sync/")
+	fileOps := s.agent.GetFileOps()
+
+	if action == "manifest" {
+		if r.Method != http.MethodGet {
+			s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("path") == "" {
+			s.respondError(w, http.StatusBadRequest, "Path is required")
+			return
+		}
+		filters := fileops.Filters{
+			Include: splitParams(query["include"]),
+			Exclude: splitParams(query["exclude"]),
+		}
+		manifest, err := fileOps.SyncManifest(query.Get("path"), filters)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    manifest,
+		})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if action == "patch" {
+		s.syncPatch(w, r)
+		return
+	}
+
+	var req syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Path == "" {
+		s.respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	switch action {
+	case "plan":
+		if req.Source == nil {
+			s.respondError(w, http.StatusBadRequest, "Source manifest is required")
+			return
+		}
+		plan, err := fileOps.PlanPush(req.Path, req.Source, req.Filters, req.Options)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    plan,
+		})
+
+	case "signatures":
+		signatures, err := fileOps.SyncSignatures(req.Path, req.Files, req.BlockSize)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    signatures,
+		})
+
+	case "delta":
+		if req.File == "" || req.Signature == nil {
+			s.respondError(w, http.StatusBadRequest, "File and signature are required")
+			return
+		}
+		// Errors before the first byte can still be reported; later ones
+		// surface to the client as a delta that fails verification
+		out := &countingWriter{w: w}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err := fileOps.SyncDelta(r.Context(), req.Path, req.File, req.Signature, out); err != nil {
+			if out.n == 0 {
+				s.respondFileError(w, err)
+				return
+			}
+			s.logger.WithError(err).WithField("file", req.File).Error("Failed to stream delta")
+		}
+
+	case "apply":
+		results, err := fileOps.ApplySync(r.Context(), req.Path, req.Changes, req.Options)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		failed := 0
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+			}
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: failed == 0,
+			Data:    results,
+			Message: fmt.Sprintf("%d changes applied, %d failed", len(results)-failed, failed),
+		})
+
+	default:
+		s.respondError(w, http.StatusNotFound, "Unknown sync endpoint: "+action)
+	}
+}
+
+// syncPatch applies a delta sent as the request body. The file's source
+// mode and modification time are given as the mode and mtime parameters.
+func (s *Server) syncPatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	root, file := query.Get("path"), query.Get("file")
+	if root == "" || file == "" {
+		s.respondError(w, http.StatusBadRequest, "Path and file are required")
+		return
+	}
+
+	entry := fileops.SyncEntry{Path: file, Type: fileops.SyncEntryFile}
+	var opts fileops.SyncOptions
+	if value := query.Get("mode"); value != "" {
+		mode, err := fileops.ParseMode(value)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		entry.Mode = mode
+		opts.PreserveMode = true
+	}
+	if value := query.Get("mtime"); value != "" {
+		mtime, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid mtime value: "+value)
+			return
+		}
+		entry.ModTime = mtime
+		opts.PreserveTimes = true
+	}
+
+	stats, err := s.agent.GetFileOps().SyncPatch(r.Context(), root, file, entry, r.Body, opts)
+	if err != nil {
+		s.respondFileError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data:    stats,
+		Message: "File synced",
+	})
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// handleTransferStatus handles transfer status requests
+func (s *Server) handleTransferStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Extract transfer ID from path
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/files/transfer/")
+	transferID := strings.Split(path, "/")[0]
+
+	if transferID == "" {
+		s.respondError(w, http.StatusBadRequest, "Transfer ID is required")
+		return
+	}
+
+	transfer, err := s.agent.GetFileOps().GetTransfer(transferID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data:    transfer,
+	})
+}
+
+// handleWatches lists file watches or creates one
+func (s *Server) handleWatches(w http.ResponseWriter, r *http.Request) {
+	fileOps := s.agent.GetFileOps()
+
+	switch r.Method {
+	case http.MethodGet:
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    fileOps.ListWatches(),
+		})
+	case http.MethodPost:
+		var req struct {
+			Path      string   `json:"path"`
+			Recursive bool     `json:"recursive"`
+			Include   []string `json:"include"`
+			Exclude   []string `json:"exclude"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.Path == "" {
+			s.respondError(w, http.StatusBadRequest, "Path is required")
+			return
+		}
+
+		filters := fileops.Filters{Include: req.Include, Exclude: req.Exclude}
+		if err := filters.Validate(); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		watch, err := fileOps.AddWatch(req.Path, fileops.WatchOptions{
+			Recursive: req.Recursive,
+			Filters:   filters,
+		})
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/v1/files/watches/"+watch.ID)
+		s.respondJSON(w, http.StatusCreated, Response{
+			Success: true,
+			Data:    watch,
+			Message: "Watch created",
+		})
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleWatch returns, removes or streams the events of a file watch
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/files/watches/")
+	parts := strings.Split(path, "/")
+	watchID := parts[0]
+
+	if watchID == "" {
+		s.respondError(w, http.StatusBadRequest, "Watch ID is required")
+		return
+	}
+
+	fileOps := s.agent.GetFileOps()
+
+	if len(parts) > 1 {
+		if parts[1] != "events" {
+			s.respondError(w, http.StatusNotFound, "Unknown watch action: "+parts[1])
+			return
+		}
+		if r.Method != http.MethodGet {
+			s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		// Subscribe first so the watch cannot end unnoticed
+		sub := s.agent.GetEventBus().Subscribe(0, events.TypeFileChanged)
+		defer sub.Close()
+
+		if _, err := fileOps.GetWatch(watchID); err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+
+		filter := func(envelope events.Envelope) (bool, bool) {
+			change, ok := envelope.Event.(events.FileChanged)
+			if !ok || change.WatchID != watchID {
+				return false, false
+			}
+			return true, change.Op == string(fileops.WatchOpClosed)
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			s.streamWebSocket(w, r, sub, filter)
+		} else {
+			s.streamEvents(w, r, sub, filter)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		watch, err := fileOps.GetWatch(watchID)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    watch,
+		})
+	case http.MethodDelete:
+		if err := fileOps.RemoveWatch(watchID); err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleTrash lists or empties the trash
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	fileOps := s.agent.GetFileOps()
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := fileOps.ListTrash()
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    entries,
+		})
+	case http.MethodDelete:
+		purged, err := fileOps.EmptyTrash()
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    purged,
+			Message: fmt.Sprintf("Purged %d trash entries", len(purged)),
+		})
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleTrashEntry returns, purges or restores a trash entry
+func (s *Server) handleTrashEntry(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/files/trash/")
+	parts := strings.Split(path, "/")
+	trashID := parts[0]
+
+	if trashID == "" {
+		s.respondError(w, http.StatusBadRequest, "Trash ID is required")
+		return
+	}
+
+	fileOps := s.agent.GetFileOps()
+
+	if len(parts) > 1 {
+		if parts[1] != "restore" {
+			s.respondError(w, http.StatusNotFound, "Unknown trash action: "+parts[1])
+			return
+		}
+		if r.Method != http.MethodPost {
+			s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var req struct {
+			DestPath string `json:"dest_path"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				s.respondError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+
+		entry, target, err := fileOps.RestoreTrash(trashID, req.DestPath)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data: map[string]interface{}{
+				"id":          entry.ID,
+				"path":        entry.Path,
+				"restored_to": target,
+			},
+			Message: "Restored from trash",
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, err := fileOps.GetTrash(trashID)
+		if err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{
+			Success: true,
+			Data:    entry,
+		})
+	case http.MethodDelete:
+		if _, err := fileOps.PurgeTrash(trashID); err != nil {
+			s.respondFileError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleVersions lists the kept versions of a file
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		s.respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	versions, err := s.agent.GetFileOps().ListVersions(path)
+	if err != nil {
+		s.respondFileError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data:    versions,
+	})
+}
+
+// handleVersionRestore restores a kept version of a file
+func (s *Server) handleVersionRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Path      string `json:"path"`
+		Version   string `json:"version"`
+		DestPath  string `json:"dest_path"`
+		Overwrite bool   `json:"overwrite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Path == "" || req.Version == "" {
+		s.respondError(w, http.StatusBadRequest, "Path and version are required")
+		return
+	}
+
+	version, target, err := s.agent.GetFileOps().RestoreVersion(req.Path, req.Version, req.DestPath, req.Overwrite)
+	if err != nil {
+		s.respondFileError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data: map[string]interface{}{
+			"path":        req.Path,
+			"version":     version.ID,
+			"restored_to": target,
+		},
+		Message: "Version restored",
+	})
+}
+
+// handleMetrics handles metrics requests
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var metrics map[string]interface{}
+	if s.agent.GetMetrics() != nil {
+		metrics = s.agent.GetMetrics().GetMetrics()
+	} else {
+		metrics = map[string]interface{}{
+			"message": "Metrics collection not enabled",
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{
+		Success: true,
+		Data:    metrics,
+	})
+}
+
+// handleEvents streams agent events as server-sent events, or over a
+// WebSocket when the request asks for an upgrade
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Optional comma-separated list of event types
+	var types []events.Type
+	if filter := r.URL.Query().Get("types"); filter != "" {
+		for _, t := range strings.Split(filter, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, events.Type(t))
+			}
+		}
+	}
+
+	sub := s.agent.GetEventBus().Subscribe(0, types...)
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamWebSocket(w, r, sub, nil)
+		return
+	}
+	s.streamEvents(w, r, sub, nil)
+}
+
+// eventFilter selects the events a stream sends, and reports whether an
+// event is the last one
+type eventFilter func(envelope events.Envelope) (send, last bool)
+
+// streamEvents sends the events of a subscription as server-sent events
+// until the client goes away
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, sub *events.Subscription, filter eventFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.respondError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// The stream outlives the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case envelope, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+
+			last := false
+			if filter != nil {
+				var send bool
+				if send, last = filter(envelope); !send {
+					continue
+				}
+			}
+
+			data, err := json.Marshal(envelope)
+			if err != nil {
+				s.logger.WithError(err).Error("Failed to encode event")
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", envelope.Sequence, envelope.Type, data)
+			flusher.Flush()
+			if last {
+				return
+			}
+		}
+	}
+}
+
+// streamWebSocket sends the events of a subscription as WebSocket text
+// messages until either side closes the connection
+func (s *Server) streamWebSocket(w http.ResponseWriter, r *http.Request, sub *events.Subscription, filter eventFilter) {
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded
+		s.logger.WithError(err).Debug("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	// Reading processes control frames and notices the client closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepalive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteTimeout)); err != nil {
+				return
+			}
+		case envelope, ok := <-sub.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(eventWriteTimeout))
+				return
+			}
+
+			last := false
+			if filter != nil {
+				var send bool
+				if send, last = filter(envelope); !send {
+					continue
+				}
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			if err := conn.WriteJSON(envelope); err != nil {
+				s.logger.WithError(err).Debug("Failed to send event")
+				return
+			}
+			if last {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(eventWriteTimeout))
+				return
+			}
+		}
+	}
+}
 
 // respondJSON sends a JSON response
 func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -395,6 +1919,53 @@ func (s *Server) respondError(w http.ResponseWriter, status int, message string)
 	})
 }
 
+// respondAdmissionError sends a 429 response with Retry-After if err is an
+// admission control rejection, a 409 if maintenance windows rejected the
+// task, or a 503 if the executor is draining, and reports whether it did so
+func (s *Server) respondAdmissionError(w http.ResponseWriter, err error) bool {
+	var windowErr *executor.WindowError
+	if errors.As(err, &windowErr) {
+		if !windowErr.OpensAt.IsZero() {
+			retryAfter := int(math.Ceil(time.Until(windowErr.OpensAt).Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+		s.respondJSON(w, http.StatusConflict, Response{
+			Success: false,
+			Data:    windowErr,
+			Error:   err.Error(),
+		})
+		return true
+	}
+
+	if errors.Is(err, executor.ErrDraining) {
+		s.respondJSON(w, http.StatusServiceUnavailable, Response{
+			Success: false,
+			Data: map[string]interface{}{
+				"status": "draining",
+			},
+			Error: err.Error(),
+		})
+		return true
+	}
+
+	var admissionErr *executor.AdmissionError
+	if !errors.As(err, &admissionErr) {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(admissionErr.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	s.respondError(w, http.StatusTooManyRequests, err.Error())
+	return true
+}
+
 // Middleware for logging
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -445,8 +2016,9 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Upload-Offset")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Upload-Expires")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
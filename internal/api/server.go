@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"io"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/executor"
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
 	"github.com/sirupsen/logrus"
@@ -41,6 +43,7 @@ type AgentInterface interface {
 	GetFileOps() FileOpsInterface
 	GetHealth() HealthInterface
 	GetMetrics() MetricsInterface
+	GetEventBus() *events.Bus
 	IsRunning() bool
 }
 
@@ -50,14 +53,56 @@ type ExecutorInterface interface {
 	GetTask(taskID string) (*executor.Task, error)
 	GetTaskResult(taskID string) (*executor.TaskResult, error)
 	CancelTask(taskID string) error
+	PauseTask(taskID string) error
+	ResumeTask(taskID string) error
+	ReceiveInput(name string, r io.Reader) (*executor.TaskInput, error)
+	SubmitBatch(name string, tasks []*executor.Task, failFast bool) (*executor.Batch, error)
+	GetBatch(batchID string) (*executor.BatchStatus, error)
+	ListBatches() []*executor.Batch
+	CancelBatch(batchID string) (int, error)
 	ListTasks() []*executor.Task
 	ListRunningTasks() []*executor.Task
+	QueryTasks(query executor.TaskQuery) (*executor.TaskPage, error)
 	GetStats() map[string]interface{}
+	GetWorkerStats() []executor.WorkerStats
+	GetResourceStatus() *executor.ResourceStatus
+	GetWindows() []executor.WindowStatus
+	ResizeWorkerPool(minWorkers, maxWorkers int) error
+	BeginDrain() ([]*executor.Task, error)
+	AwaitDrain(ctx context.Context) int
+	Undrain() int
+	IsDraining() bool
 }
 
 // FileOpsInterface defines the interface for file operations
 type FileOpsInterface interface {
 	ExecuteOperation(ctx context.Context, op *fileops.Operation) (map[string]interface{}, error)
+	Upload(ctx context.Context, r io.Reader, opts fileops.UploadOptions) (*fileops.Transfer, error)
+	CreateUpload(opts fileops.UploadOptions) (*fileops.UploadSession, error)
+	GetUpload(id string) (*fileops.UploadSession, error)
+	WriteUpload(ctx context.Context, id string, offset int64, r io.Reader) (*fileops.UploadSession, error)
+	CompleteUpload(id, checksum string) (*fileops.Transfer, error)
+	AbortUpload(id string) error
+	ResolvePath(access fileops.Access, path string) (string, error)
+	OpenDownload(path string) (*fileops.Download, error)
+	WriteArchive(ctx context.Context, w io.Writer, root string, format fileops.ArchiveFormat, filters fileops.Filters) error
+	SyncManifest(root string, filters fileops.Filters) (*fileops.SyncManifest, error)
+	PlanPush(root string, source *fileops.SyncManifest, filters fileops.Filters, opts fileops.SyncOptions) (*fileops.SyncPlan, error)
+	SyncSignatures(root string, paths []string, blockSize int) (map[string]*fileops.FileSignature, error)
+	SyncDelta(ctx context.Context, root, rel string, sig *fileops.FileSignature, w io.Writer) (fileops.DeltaStats, error)
+	SyncPatch(ctx context.Context, root, rel string, entry fileops.SyncEntry, delta io.Reader, opts fileops.SyncOptions) (fileops.DeltaStats, error)
+	ApplySync(ctx context.Context, root string, changes []fileops.SyncChange, opts fileops.SyncOptions) ([]fileops.SyncResult, error)
+	AddWatch(path string, opts fileops.WatchOptions) (*fileops.Watch, error)
+	RemoveWatch(id string) error
+	GetWatch(id string) (*fileops.Watch, error)
+	ListWatches() []*fileops.Watch
+	ListTrash() ([]*fileops.TrashEntry, error)
+	GetTrash(id string) (*fileops.TrashEntry, error)
+	RestoreTrash(id, dest string) (*fileops.TrashEntry, string, error)
+	PurgeTrash(id string) (*fileops.TrashEntry, error)
+	EmptyTrash() ([]*fileops.TrashEntry, error)
+	ListVersions(path string) ([]*fileops.Version, error)
+	RestoreVersion(path, id, dest string, overwrite bool) (*fileops.Version, string, error)
 	GetTransfer(transferID string) (*fileops.Transfer, error)
 	CancelTransfer(transferID string) error
 	CalculateChecksum(path string, algorithm string) (string, error)
@@ -257,17 +302,37 @@ func (s *Server) registerHTTPHandlers() {
 	// Task endpoints
 	s.httpMux.HandleFunc("/api/v1/tasks", s.handleTasks)
 	s.httpMux.HandleFunc("/api/v1/tasks/submit", s.handleTaskSubmit)
+	s.httpMux.HandleFunc("/api/v1/tasks/batch", s.handleBatches)
+	s.httpMux.HandleFunc("/api/v1/tasks/batch/", s.handleBatchDetail)
 	s.httpMux.HandleFunc("/api/v1/tasks/", s.handleTaskDetail)
 
+	// Executor endpoints
+	s.httpMux.HandleFunc("/api/v1/executor/workers", s.handleWorkers)
+	s.httpMux.HandleFunc("/api/v1/executor/drain", s.handleDrain)
+	s.httpMux.HandleFunc("/api/v1/executor/resources", s.handleResources)
+	s.httpMux.HandleFunc("/api/v1/executor/windows", s.handleWindows)
+
 	// File operation endpoints
 	s.httpMux.HandleFunc("/api/v1/files", s.handleFiles)
 	s.httpMux.HandleFunc("/api/v1/files/upload", s.handleFileUpload)
+	s.httpMux.HandleFunc("/api/v1/files/uploads", s.handleUploadSessions)
+	s.httpMux.HandleFunc("/api/v1/files/uploads/", s.handleUploadSession)
 	s.httpMux.HandleFunc("/api/v1/files/download", s.handleFileDownload)
 	s.httpMux.HandleFunc("/api/v1/files/transfer/", s.handleTransferStatus)
+	s.httpMux.HandleFunc("/api/v1/files/sync/", s.handleFileSync)
+	s.httpMux.HandleFunc("/api/v1/files/watches", s.handleWatches)
+	s.httpMux.HandleFunc("/api/v1/files/watches/", s.handleWatch)
+	s.httpMux.HandleFunc("/api/v1/files/trash", s.handleTrash)
+	s.httpMux.HandleFunc("/api/v1/files/trash/", s.handleTrashEntry)
+	s.httpMux.HandleFunc("/api/v1/files/versions", s.handleVersions)
+	s.httpMux.HandleFunc("/api/v1/files/versions/restore", s.handleVersionRestore)
 
 	// Metrics endpoint
 	s.httpMux.HandleFunc("/api/v1/metrics", s.handleMetrics)
 
+	// Event stream endpoint
+	s.httpMux.HandleFunc("/api/v1/events", s.handleEvents)
+
 	s.logger.Info("HTTP handlers registered")
 }
 
@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is a single record in the audit trail
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Action  string                 `json:"action"`
+	Subject string                 `json:"subject,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Logger appends audit entries as JSON lines to the audit log and rotates it
+// by size. Every entry is also written to the agent log. A nil *Logger only
+// discards entries.
+type Logger struct {
+	config config.AuditConfig
+	logger *logrus.Logger
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// New creates an audit logger. The log file is opened on the first entry so a
+// missing or unwritable log directory does not prevent the agent from starting.
+func New(cfg config.AuditConfig, logger *logrus.Logger) *Logger {
+	return &Logger{
+		config: cfg,
+		logger: logger,
+	}
+}
+
+// Record appends an entry to the audit trail
+func (l *Logger) Record(action, subject string, details map[string]interface{}) {
+	if l == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Subject: subject,
+		Details: details,
+	}
+
+	l.logger.WithFields(logrus.Fields{
+		"audit":   true,
+		"action":  action,
+		"subject": subject,
+	}).Info("Audit event")
+
+	if !l.config.Enabled || l.config.LogFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.WithError(err).Error("Failed to encode audit entry")
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.write(line); err != nil {
+		l.logger.WithError(err).WithField("file", l.config.LogFile).Error("Failed to write audit log")
+	}
+}
+
+// Close closes the audit log file
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// write appends a line, rotating the file first if it would exceed the
+// maximum size. Must be called with l.mu held.
+func (l *Logger) write(line []byte) error {
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	maxSize := int64(l.config.MaxSize) * 1024 * 1024
+	if maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// open opens the audit log for appending. Must be called with l.mu held.
+func (l *Logger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.config.LogFile), 0750); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(l.config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate renames the audit log to <file>.1, shifting older backups, and
+// removes backups beyond the configured count and age. Must be called with
+// l.mu held.
+func (l *Logger) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	path := l.config.LogFile
+	backups := l.config.MaxBackups
+	if backups <= 0 {
+		backups = 1
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", path, backups))
+	for i := backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	if l.config.MaxAge > 0 {
+		cutoff := time.Now().AddDate(0, 0, -l.config.MaxAge)
+		for i := 2; i <= backups; i++ {
+			backup := fmt.Sprintf("%s.%d", path, i)
+			if info, err := os.Stat(backup); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(backup)
+			}
+		}
+	}
+
+	return l.open()
+}
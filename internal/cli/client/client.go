@@ -1,13 +1,22 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
 )
 
 type Client struct {
@@ -171,6 +180,7 @@ func (c *Client) ListAgents(ctx context.Context) ([]AgentInfo, error) {
 type Task struct {
 	ID        string                 `json:"id"`
 	Type      string                 `json:"type"`
+	Name      string                 `json:"name"`
 	Status    string                 `json:"status"`
 	Payload   map[string]interface{} `json:"payload"`
 	Result    map[string]interface{} `json:"result,omitempty"`
@@ -179,10 +189,51 @@ type Task struct {
 	UpdatedAt time.Time              `json:"updated_at"`
 }
 
-func (c *Client) ListTasks(ctx context.Context, status string) ([]Task, error) {
+// TaskListOptions filters and pages a task listing
+type TaskListOptions struct {
+	Status     string   // comma separated statuses
+	Type       string   // comma separated task types
+	NamePrefix string
+	Since      string   // RFC 3339 or Unix seconds
+	Until      string   // RFC 3339 or Unix seconds
+	Labels     []string // key=value metadata labels
+	Sort       string   // field, prefixed with - for descending order
+	Limit      int
+	Cursor     string
+}
+
+// TaskList is a page of tasks
+type TaskList struct {
+	Tasks      []Task `json:"tasks"`
+	Count      int    `json:"count"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (c *Client) ListTasks(ctx context.Context, opts TaskListOptions) (*TaskList, error) {
+	query := url.Values{}
+	setParam := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	setParam("status", opts.Status)
+	setParam("type", opts.Type)
+	setParam("name_prefix", opts.NamePrefix)
+	setParam("since", opts.Since)
+	setParam("until", opts.Until)
+	setParam("sort", opts.Sort)
+	setParam("cursor", opts.Cursor)
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	for _, label := range opts.Labels {
+		query.Add("label", label)
+	}
+
 	path := "/api/v1/tasks"
-	if status != "" {
-		path += "?status=" + status
+	if len(query) > 0 {
+		path += "?" + query.Encode()
 	}
 
 	resp, err := c.doRequest(ctx, "GET", path, nil)
@@ -191,12 +242,14 @@ func (c *Client) ListTasks(ctx context.Context, status string) ([]Task, error) {
 	}
 	defer resp.Body.Close()
 
-	var tasks []Task
-	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+	var envelope struct {
+		Data TaskList `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return tasks, nil
+	return &envelope.Data, nil
 }
 
 func (c *Client) GetTask(ctx context.Context, taskID string) (*Task, error) {
@@ -261,6 +314,26 @@ func (c *Client) CancelTask(ctx context.Context, taskID string) error {
 	return nil
 }
 
+func (c *Client) PauseTask(ctx context.Context, taskID string) error {
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/tasks/"+taskID+"/pause", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+func (c *Client) ResumeTask(ctx context.Context, taskID string) error {
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/tasks/"+taskID+"/resume", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
 type FileInfo struct {
 	Name      string    `json:"name"`
 	Path      string    `json:"path"`
@@ -285,10 +358,45 @@ func (c *Client) ListFiles(ctx context.Context, path string) ([]FileInfo, error)
 	return files, nil
 }
 
-func (c *Client) UploadFile(ctx context.Context, file io.Reader, remotePath string) error {
-	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/files?path="+remotePath, file)
+// UploadOptions controls how an uploaded file is stored on the agent
+type UploadOptions struct {
+	Overwrite bool
+	Mode      string // octal, e.g. "0640"
+	Owner     string // user[:group] or uid[:gid]
+	SHA256    string // verified by the agent before the file is committed
+	Size      int64
+}
+
+type UploadResult struct {
+	TransferID string `json:"transfer_id"`
+	Dest       string `json:"dest"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+}
+
+// UploadFile streams file to remotePath on the agent
+func (c *Client) UploadFile(ctx context.Context, file io.Reader, remotePath string, opts UploadOptions) (*UploadResult, error) {
+	query := url.Values{}
+	query.Set("dest_path", remotePath)
+	if opts.Overwrite {
+		query.Set("overwrite", "true")
+	}
+	if opts.Mode != "" {
+		query.Set("mode", opts.Mode)
+	}
+	if opts.Owner != "" {
+		query.Set("owner", opts.Owner)
+	}
+	if opts.SHA256 != "" {
+		query.Set("sha256", opts.SHA256)
+	}
+	if opts.Size > 0 {
+		query.Set("size", strconv.FormatInt(opts.Size, 10))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/files/upload?"+query.Encode(), file)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	if c.token != "" {
@@ -296,36 +404,305 @@ func (c *Client) UploadFile(ctx context.Context, file io.Reader, remotePath stri
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 
-	resp, err := c.httpClient.Do(req)
+	// Large uploads outlast the default request timeout
+	resp, err := c.transferClient().Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
+	var result struct {
+		Data UploadResult `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result.Data, nil
 }
 
+// DownloadFile downloads a file and verifies it against the agent's Digest
+// header
 func (c *Client) DownloadFile(ctx context.Context, remotePath string, dest io.Writer) error {
-	resp, err := c.doRequest(ctx, "GET", "/api/v1/files/download?path="+remotePath, nil)
+	query := url.Values{}
+	query.Set("path", remotePath)
+
+	resp, err := c.doTransfer(ctx, "/api/v1/files/download?"+query.Encode())
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if _, err := io.Copy(dest, resp.Body); err != nil {
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dest, hash), resp.Body); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
+	if digest := resp.Header.Get("Digest"); strings.HasPrefix(digest, "sha-256=") {
+		if actual := base64.StdEncoding.EncodeToString(hash.Sum(nil)); actual != strings.TrimPrefix(digest, "sha-256=") {
+			return fmt.Errorf("checksum mismatch: agent sent %s, received %s", digest, "sha-256="+actual)
+		}
+	}
+
+	return nil
+}
+
+// DownloadArchive streams a remote directory as a tar.gz or zip archive
+func (c *Client) DownloadArchive(ctx context.Context, remotePath, format string, include, exclude []string, dest io.Writer) error {
+	query := url.Values{}
+	query.Set("path", remotePath)
+	query.Set("format", format)
+	for _, pattern := range include {
+		query.Add("include", pattern)
+	}
+	for _, pattern := range exclude {
+		query.Add("exclude", pattern)
+	}
+
+	resp, err := c.doTransfer(ctx, "/api/v1/files/download?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(dest, resp.Body); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return nil
+}
+
+// SyncManifest lists a remote directory tree for a pull
+func (c *Client) SyncManifest(ctx context.Context, remotePath string, filters fileops.Filters) (*fileops.SyncManifest, error) {
+	query := url.Values{}
+	query.Set("path", remotePath)
+	for _, pattern := range filters.Include {
+		query.Add("include", pattern)
+	}
+	for _, pattern := range filters.Exclude {
+		query.Add("exclude", pattern)
+	}
+
+	var manifest fileops.SyncManifest
+	if err := c.doSync(ctx, "GET", "manifest?"+query.Encode(), nil, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// PlanSync asks the agent for the changes that make remotePath match a
+// local manifest
+func (c *Client) PlanSync(ctx context.Context, remotePath string, source *fileops.SyncManifest, filters fileops.Filters, opts fileops.SyncOptions) (*fileops.SyncPlan, error) {
+	body := map[string]interface{}{
+		"path":    remotePath,
+		"source":  source,
+		"include": filters.Include,
+		"exclude": filters.Exclude,
+		"options": opts,
+	}
+
+	var plan fileops.SyncPlan
+	if err := c.doSync(ctx, "POST", "plan", body, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// SyncSignature returns the block signature of a file below remotePath
+func (c *Client) SyncSignature(ctx context.Context, remotePath, file string, blockSize int) (*fileops.FileSignature, error) {
+	body := map[string]interface{}{
+		"path":       remotePath,
+		"files":      []string{file},
+		"block_size": blockSize,
+	}
+
+	var signatures map[string]*fileops.FileSignature
+	if err := c.doSync(ctx, "POST", "signatures", body, &signatures); err != nil {
+		return nil, err
+	}
+	sig, ok := signatures[file]
+	if !ok {
+		return nil, fmt.Errorf("agent returned no signature for %s", file)
+	}
+	return sig, nil
+}
+
+// SyncDelta requests the delta that turns the local copy of a file,
+// described by sig, into the remote one
+func (c *Client) SyncDelta(ctx context.Context, remotePath, file string, sig *fileops.FileSignature) (io.ReadCloser, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"path":      remotePath,
+		"file":      file,
+		"signature": sig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	resp, err := c.doStream(ctx, "/api/v1/files/sync/delta", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// SyncPatch sends a delta for a file below remotePath. The entry's mode and
+// modification time are applied as opts asks.
+func (c *Client) SyncPatch(ctx context.Context, remotePath string, entry fileops.SyncEntry, opts fileops.SyncOptions, delta io.Reader) (*fileops.DeltaStats, error) {
+	query := url.Values{}
+	query.Set("path", remotePath)
+	query.Set("file", entry.Path)
+	if opts.PreserveMode {
+		query.Set("mode", fmt.Sprintf("%04o", entry.Mode.Perm()))
+	}
+	if opts.PreserveTimes {
+		query.Set("mtime", entry.ModTime.Format(time.RFC3339Nano))
+	}
+
+	resp, err := c.doStream(ctx, "/api/v1/files/sync/patch?"+query.Encode(), "application/octet-stream", delta)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data fileops.DeltaStats `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result.Data, nil
+}
+
+// ApplySync applies changes that need no file contents below remotePath
+func (c *Client) ApplySync(ctx context.Context, remotePath string, changes []fileops.SyncChange, opts fileops.SyncOptions) ([]fileops.SyncResult, error) {
+	body := map[string]interface{}{
+		"path":    remotePath,
+		"changes": changes,
+		"options": opts,
+	}
+
+	var results []fileops.SyncResult
+	if err := c.doSync(ctx, "POST", "apply", body, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// doSync calls a JSON sync endpoint and decodes the response data into out
+func (c *Client) doSync(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	return c.doData(ctx, method, "/api/v1/files/sync/"+endpoint, body, out)
+}
+
+// doData calls a JSON endpoint and decodes the response data into out
+func (c *Client) doData(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.doRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Data interface{} `json:"data"`
+	}{Data: out}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
 	return nil
 }
 
-func (c *Client) DeleteFile(ctx context.Context, remotePath string) error {
-	resp, err := c.doRequest(ctx, "DELETE", "/api/v1/files?path="+remotePath, nil)
+// doStream issues a POST whose request or response body is streamed
+func (c *Client) doStream(ctx context.Context, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// doTransfer issues a GET for a file transfer, which may outlast the
+// default request timeout
+func (c *Client) doTransfer(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// transferClient returns an HTTP client without a request timeout
+func (c *Client) transferClient() *http.Client {
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	return &httpClient
+}
+
+// CreateWatch starts watching a remote path for changes
+func (c *Client) CreateWatch(ctx context.Context, remotePath string, recursive bool, filters fileops.Filters) (*fileops.Watch, error) {
+	body := map[string]interface{}{
+		"path":      remotePath,
+		"recursive": recursive,
+		"include":   filters.Include,
+		"exclude":   filters.Exclude,
+	}
+
+	resp, err := c.doRequest(ctx, "POST", "/api/v1/files/watches", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var watch fileops.Watch
+	result := struct {
+		Data interface{} `json:"data"`
+	}{Data: &watch}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &watch, nil
+}
+
+// DeleteWatch stops a watch
+func (c *Client) DeleteWatch(ctx context.Context, watchID string) error {
+	resp, err := c.doRequest(ctx, "DELETE", "/api/v1/files/watches/"+url.PathEscape(watchID), nil)
 	if err != nil {
 		return err
 	}
@@ -333,3 +710,139 @@ func (c *Client) DeleteFile(ctx context.Context, remotePath string) error {
 
 	return nil
 }
+
+// WatchEvents calls fn with every change a watch reports until the watch
+// ends, fn fails or ctx is done
+func (c *Client) WatchEvents(ctx context.Context, watchID string, fn func(time.Time, events.FileChanged) error) error {
+	resp, err := c.doTransfer(ctx, "/api/v1/files/watches/"+url.PathEscape(watchID)+"/events")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data: ") {
+			data.WriteString(strings.TrimPrefix(line, "data: "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		var envelope struct {
+			Timestamp time.Time          `json:"timestamp"`
+			Event     events.FileChanged `json:"event"`
+		}
+		err := json.Unmarshal([]byte(data.String()), &envelope)
+		data.Reset()
+		if err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+
+		if err := fn(envelope.Timestamp, envelope.Event); err != nil {
+			return err
+		}
+		if envelope.Event.Op == string(fileops.WatchOpClosed) {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("event stream failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteFile deletes a file or directory and returns the ID of its trash
+// entry, which is empty if the agent deleted it permanently
+func (c *Client) DeleteFile(ctx context.Context, remotePath string, recursive, permanent bool) (string, error) {
+	body := map[string]interface{}{
+		"type":        "delete",
+		"source_path": remotePath,
+		"recursive":   recursive,
+		"permanent":   permanent,
+	}
+
+	var result struct {
+		TrashID string `json:"trash_id"`
+	}
+	if err := c.doData(ctx, "POST", "/api/v1/files", body, &result); err != nil {
+		return "", err
+	}
+	return result.TrashID, nil
+}
+
+// ListVersions returns the kept versions of a file, newest first
+func (c *Client) ListVersions(ctx context.Context, remotePath string) ([]*fileops.Version, error) {
+	var versions []*fileops.Version
+	if err := c.doData(ctx, "GET", "/api/v1/files/versions?path="+url.QueryEscape(remotePath), nil, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// RestoreVersion restores a kept version of a file, to dest if given, and
+// returns the path it was restored to
+func (c *Client) RestoreVersion(ctx context.Context, remotePath, version, dest string, overwrite bool) (string, error) {
+	body := map[string]interface{}{
+		"path":      remotePath,
+		"version":   version,
+		"dest_path": dest,
+		"overwrite": overwrite,
+	}
+
+	var result struct {
+		RestoredTo string `json:"restored_to"`
+	}
+	if err := c.doData(ctx, "POST", "/api/v1/files/versions/restore", body, &result); err != nil {
+		return "", err
+	}
+	return result.RestoredTo, nil
+}
+
+// ListTrash returns the agent's trash entries, most recently deleted first
+func (c *Client) ListTrash(ctx context.Context) ([]*fileops.TrashEntry, error) {
+	var entries []*fileops.TrashEntry
+	if err := c.doData(ctx, "GET", "/api/v1/files/trash", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RestoreTrash restores a trash entry to where it was deleted from, or to
+// dest if given, and returns the path it was restored to
+func (c *Client) RestoreTrash(ctx context.Context, trashID, dest string) (string, error) {
+	body := map[string]string{"dest_path": dest}
+
+	var result struct {
+		RestoredTo string `json:"restored_to"`
+	}
+	if err := c.doData(ctx, "POST", "/api/v1/files/trash/"+url.PathEscape(trashID)+"/restore", body, &result); err != nil {
+		return "", err
+	}
+	return result.RestoredTo, nil
+}
+
+// PurgeTrash permanently removes a trash entry
+func (c *Client) PurgeTrash(ctx context.Context, trashID string) error {
+	resp, err := c.doRequest(ctx, "DELETE", "/api/v1/files/trash/"+url.PathEscape(trashID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// EmptyTrash permanently removes every trash entry and returns those removed
+func (c *Client) EmptyTrash(ctx context.Context) ([]*fileops.TrashEntry, error) {
+	var entries []*fileops.TrashEntry
+	if err := c.doData(ctx, "DELETE", "/api/v1/files/trash", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -1,10 +1,24 @@
 package cli
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/cli/client"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +33,10 @@ func NewFileCommand() *cobra.Command {
 	cmd.AddCommand(newFileUploadCommand())
 	cmd.AddCommand(newFileDownloadCommand())
 	cmd.AddCommand(newFileDeleteCommand())
+	cmd.AddCommand(newFileTrashCommand())
+	cmd.AddCommand(newFileVersionsCommand())
+	cmd.AddCommand(newFileSyncCommand())
+	cmd.AddCommand(newFileWatchCommand())
 
 	return cmd
 }
@@ -48,10 +66,12 @@ func newFileListCommand() *cobra.Command {
 
 func newFileUploadCommand() *cobra.Command {
 	var remotePath string
+	var opts client.UploadOptions
 	
 	cmd := &cobra.Command{
 		Use:   "upload [local-file]",
 		Short: "Upload a file to the agent",
+		Long:  "Upload a file to the agent. The agent verifies the file's SHA-256 checksum before moving it into place.",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
@@ -61,67 +81,702 @@ func newFileUploadCommand() *cobra.Command {
 				return fmt.Errorf("failed to open file: %w", err)
 			}
 			defer file.Close()
+
+			// Checksum the local file first so the agent can verify the upload
+			hash := sha256.New()
+			size, err := io.Copy(hash, file)
+			if err != nil {
+				return fmt.Errorf("failed to read file: %w", err)
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to read file: %w", err)
+			}
+			opts.SHA256 = hex.EncodeToString(hash.Sum(nil))
+			opts.Size = size
 			
-			if err := c.UploadFile(cmd.Context(), file, remotePath); err != nil {
+			result, err := c.UploadFile(cmd.Context(), file, remotePath, opts)
+			if err != nil {
 				return fmt.Errorf("failed to upload file: %w", err)
 			}
 
-			fmt.Printf("File uploaded successfully to %s\n", remotePath)
+			fmt.Printf("File uploaded successfully to %s (%d bytes, sha256 %s)\n", result.Dest, result.Size, result.SHA256)
 			return nil
 		},
 	}
 	
 	cmd.Flags().StringVar(&remotePath, "remote-path", "", "Remote file path (required)")
+	cmd.Flags().BoolVar(&opts.Overwrite, "overwrite", false, "Replace the remote file if it exists")
+	cmd.Flags().StringVar(&opts.Mode, "mode", "", "Octal file mode, e.g. 0640")
+	cmd.Flags().StringVar(&opts.Owner, "owner", "", "File owner as user[:group] or uid[:gid]")
 	cmd.MarkFlagRequired("remote-path")
 	
 	return cmd
 }
 
 func newFileDownloadCommand() *cobra.Command {
-	var localPath string
+	var localPath, format string
+	var recursive bool
+	var include, exclude []string
 	
 	cmd := &cobra.Command{
 		Use:   "download [remote-file]",
 		Short: "Download a file from the agent",
+		Long: `Download a file from the agent. With --recursive a directory is downloaded
+as an archive and extracted into --local-path, or saved as-is with --format.`,
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
-			
-			file, err := os.Create(localPath)
-			if err != nil {
-				return fmt.Errorf("failed to create file: %w", err)
+
+			if !recursive {
+				file, err := os.Create(localPath)
+				if err != nil {
+					return fmt.Errorf("failed to create file: %w", err)
+				}
+				defer file.Close()
+				
+				if err := c.DownloadFile(cmd.Context(), args[0], file); err != nil {
+					return fmt.Errorf("failed to download file: %w", err)
+				}
+
+				fmt.Printf("File downloaded successfully to %s\n", localPath)
+				return nil
 			}
-			defer file.Close()
-			
-			if err := c.DownloadFile(cmd.Context(), args[0], file); err != nil {
-				return fmt.Errorf("failed to download file: %w", err)
+
+			if format != "" {
+				file, err := os.Create(localPath)
+				if err != nil {
+					return fmt.Errorf("failed to create file: %w", err)
+				}
+				defer file.Close()
+
+				if err := c.DownloadArchive(cmd.Context(), args[0], format, include, exclude, file); err != nil {
+					return fmt.Errorf("failed to download directory: %w", err)
+				}
+
+				fmt.Printf("Directory archived successfully to %s\n", localPath)
+				return nil
 			}
 
-			fmt.Printf("File downloaded successfully to %s\n", localPath)
+			// Extract while downloading
+			reader, writer := io.Pipe()
+			go func() {
+				writer.CloseWithError(c.DownloadArchive(cmd.Context(), args[0], "tar.gz", include, exclude, writer))
+			}()
+
+			count, err := extractTarGz(reader, localPath)
+			reader.CloseWithError(err)
+			if err != nil {
+				return fmt.Errorf("failed to download directory: %w", err)
+			}
+
+			fmt.Printf("Directory downloaded successfully to %s (%d files)\n", localPath, count)
 			return nil
 		},
 	}
 	
 	cmd.Flags().StringVar(&localPath, "local-path", "", "Local file path (required)")
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Download a directory")
+	cmd.Flags().StringVar(&format, "format", "", "Save the directory as an archive instead of extracting it (tar, tar.gz, tar.zst, zip)")
+	cmd.Flags().StringSliceVar(&include, "include", nil, "Only download files matching these globs")
+	cmd.Flags().StringSliceVar(&exclude, "exclude", nil, "Skip files and directories matching these globs")
 	cmd.MarkFlagRequired("local-path")
 	
 	return cmd
 }
 
+// extractTarGz extracts a directory archive from the agent into dest. The
+// archive's top-level directory is replaced by dest. Entries that would be
+// written outside dest, including through symbolic links, are rejected.
+func extractTarGz(r io.Reader, dest string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return 0, err
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		// Strip the top-level directory
+		name := strings.TrimSuffix(header.Name, "/")
+		i := strings.Index(name, "/")
+		if i < 0 {
+			continue
+		}
+		name = filepath.FromSlash(name[i+1:])
+		if name == "" || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return count, fmt.Errorf("unsafe path in archive: %s", header.Name)
+		}
+
+		target := filepath.Join(root, name)
+		parent, err := filepath.EvalSymlinks(filepath.Dir(target))
+		if err == nil && parent != root && !strings.HasPrefix(parent, root+string(filepath.Separator)) {
+			return count, fmt.Errorf("archive entry %s escapes %s", header.Name, dest)
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return count, err
+			}
+		case tar.TypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return count, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return count, err
+			}
+			os.Remove(target) // never write through an existing link
+			file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+			if err != nil {
+				return count, err
+			}
+			if _, err := io.Copy(file, tr); err != nil {
+				file.Close()
+				return count, err
+			}
+			if err := file.Close(); err != nil {
+				return count, err
+			}
+			os.Chtimes(target, header.ModTime, header.ModTime)
+			count++
+		}
+	}
+}
+
 func newFileDeleteCommand() *cobra.Command {
-	return &cobra.Command{
+	var recursive, permanent bool
+
+	cmd := &cobra.Command{
 		Use:   "delete [remote-file]",
 		Short: "Delete a file on the agent",
-		Args:  cobra.ExactArgs(1),
+		Long: `Delete a file or directory on the agent. If the agent keeps a trash,
+the entry is moved there and can be restored with "file trash restore"
+unless --permanent is given.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
-			
-			if err := c.DeleteFile(cmd.Context(), args[0]); err != nil {
+
+			trashID, err := c.DeleteFile(cmd.Context(), args[0], recursive, permanent)
+			if err != nil {
 				return fmt.Errorf("failed to delete file: %w", err)
 			}
 
+			if trashID != "" {
+				fmt.Printf("File %s moved to trash as %s\n", args[0], trashID)
+				return nil
+			}
 			fmt.Printf("File %s deleted successfully\n", args[0])
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Delete directories and their contents")
+	cmd.Flags().BoolVar(&permanent, "permanent", false, "Delete permanently instead of moving to the trash")
+
+	return cmd
+}
+
+func newFileTrashCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Manage deleted files kept in the agent's trash",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List trash entries",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+
+			entries, err := c.ListTrash(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to list trash: %w", err)
+			}
+
+			if globalFlags.Output == "json" || globalFlags.Output == "yaml" {
+				return printOutput(entries, globalFlags.Output)
+			}
+			for _, entry := range entries {
+				kind := "file"
+				if entry.IsDir {
+					kind = "dir"
+				}
+				fmt.Printf("%s  %s  %-4s %10d  %s\n", entry.ID, entry.DeletedAt.Local().Format(time.RFC3339), kind, entry.Size, entry.Path)
+			}
+			return nil
+		},
+	})
+
+	var dest string
+	restore := &cobra.Command{
+		Use:   "restore [trash-id]",
+		Short: "Restore a trash entry to where it was deleted from",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+
+			target, err := c.RestoreTrash(cmd.Context(), args[0], dest)
+			if err != nil {
+				return fmt.Errorf("failed to restore: %w", err)
+			}
+
+			fmt.Printf("Restored %s to %s\n", args[0], target)
+			return nil
+		},
+	}
+	restore.Flags().StringVar(&dest, "dest", "", "Restore to this path instead")
+	cmd.AddCommand(restore)
+
+	var all bool
+	purge := &cobra.Command{
+		Use:   "purge [trash-id]",
+		Short: "Permanently remove a trash entry",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+
+			if all {
+				purged, err := c.EmptyTrash(cmd.Context())
+				if err != nil {
+					return fmt.Errorf("failed to empty trash: %w", err)
+				}
+				fmt.Printf("Purged %d trash entries\n", len(purged))
+				return nil
+			}
+
+			if err := c.PurgeTrash(cmd.Context(), args[0]); err != nil {
+				return fmt.Errorf("failed to purge: %w", err)
+			}
+			fmt.Printf("Purged %s\n", args[0])
+			return nil
+		},
+	}
+	purge.Flags().BoolVar(&all, "all", false, "Purge every trash entry")
+	cmd.AddCommand(purge)
+
+	return cmd
+}
+
+func newFileVersionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "versions",
+		Short: "Manage previous versions of files on the agent",
+		Long: `Previous versions are kept when the agent keeps versions and a file is
+replaced by a copy, move, upload or archive with overwrite set.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list [remote-path]",
+		Short: "List the kept versions of a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+
+			versions, err := c.ListVersions(cmd.Context(), args[0])
+			if err != nil {
+				return fmt.Errorf("failed to list versions: %w", err)
+			}
+
+			if globalFlags.Output == "json" || globalFlags.Output == "yaml" {
+				return printOutput(versions, globalFlags.Output)
+			}
+			for _, version := range versions {
+				fmt.Printf("%-26s  %s  %10d\n", version.ID, version.ModTime.Local().Format(time.RFC3339), version.Size)
+			}
+			return nil
+		},
+	})
+
+	var dest string
+	var overwrite bool
+	restore := &cobra.Command{
+		Use:   "restore [remote-path] [version]",
+		Short: "Replace a file with one of its versions",
+		Long: `Replace a file with one of its versions, keeping the current content as a
+new version. With --dest the version is written there instead.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+
+			target, err := c.RestoreVersion(cmd.Context(), args[0], args[1], dest, overwrite)
+			if err != nil {
+				return fmt.Errorf("failed to restore version: %w", err)
+			}
+
+			fmt.Printf("Restored version %s of %s to %s\n", args[1], args[0], target)
+			return nil
+		},
+	}
+	restore.Flags().StringVar(&dest, "dest", "", "Write the version to this path instead")
+	restore.Flags().BoolVar(&overwrite, "overwrite", false, "Replace --dest if it exists")
+	cmd.AddCommand(restore)
+
+	return cmd
+}
+
+func newFileWatchCommand() *cobra.Command {
+	var recursive bool
+	var include, exclude []string
+
+	cmd := &cobra.Command{
+		Use:   "watch [remote-path]",
+		Short: "Print changes to a file or directory on the agent",
+		Long: `Watch a file or directory on the agent and print every change until
+interrupted. Changes to the same path within the agent's debounce period
+are combined into one.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+
+			filters := fileops.Filters{Include: include, Exclude: exclude}
+			if err := filters.Validate(); err != nil {
+				return err
+			}
+
+			watch, err := c.CreateWatch(cmd.Context(), args[0], recursive, filters)
+			if err != nil {
+				return fmt.Errorf("failed to watch path: %w", err)
+			}
+
+			// Remove the watch when interrupted
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			defer func() {
+				if err := c.DeleteWatch(context.Background(), watch.ID); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to remove watch %s: %v\n", watch.ID, err)
+				}
+			}()
+
+			fmt.Fprintf(os.Stderr, "Watching %s (%d directories)\n", watch.Path, watch.Directories)
+			return c.WatchEvents(ctx, watch.ID, func(at time.Time, change events.FileChanged) error {
+				if globalFlags.Output == "json" {
+					return json.NewEncoder(os.Stdout).Encode(change)
+				}
+				printFileChange(at, change)
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Watch everything below a directory")
+	cmd.Flags().StringSliceVar(&include, "include", nil, "Only report files matching these globs")
+	cmd.Flags().StringSliceVar(&exclude, "exclude", nil, "Ignore files and directories matching these globs")
+
+	return cmd
+}
+
+// printFileChange prints a change reported by a watch
+func printFileChange(at time.Time, change events.FileChanged) {
+	timestamp := at.Local().Format("15:04:05.000")
+	switch fileops.WatchOp(change.Op) {
+	case fileops.WatchOpRename:
+		fmt.Printf("%s %-8s %s -> %s\n", timestamp, change.Op, change.OldPath, change.Path)
+	case fileops.WatchOpOverflow, fileops.WatchOpClosed:
+		fmt.Printf("%s %-8s %s\n", timestamp, change.Op, change.Message)
+	default:
+		path := change.Path
+		if change.IsDir {
+			path += "/"
+		}
+		fmt.Printf("%s %-8s %s\n", timestamp, change.Op, path)
+	}
+}
+
+func newFileSyncCommand() *cobra.Command {
+	var pull, deleteExtra, dryRun, noPerms, noTimes bool
+	var include, exclude []string
+	var blockSize int
+
+	cmd := &cobra.Command{
+		Use:   "sync [local-dir] [remote-dir]",
+		Short: "Synchronize a directory with the agent",
+		Long: `Make remote-dir on the agent match local-dir, or local-dir match remote-dir
+with --pull. Unchanged files are skipped by size and modification time, and
+changed files are sent as deltas so only modified blocks cross the network.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+			ctx := cmd.Context()
+			local, remote := args[0], args[1]
+
+			filters := fileops.Filters{Include: include, Exclude: exclude}
+			if err := filters.Validate(); err != nil {
+				return err
+			}
+			if blockSize != 0 && (blockSize < fileops.MinBlockSize || blockSize > fileops.MaxBlockSize) {
+				return fmt.Errorf("block size must be between %d and %d", fileops.MinBlockSize, fileops.MaxBlockSize)
+			}
+			opts := fileops.SyncOptions{
+				Delete:        deleteExtra,
+				PreserveMode:  !noPerms,
+				PreserveTimes: !noTimes,
+			}
+
+			var plan *fileops.SyncPlan
+			var target syncTarget
+			if pull {
+				source, err := c.SyncManifest(ctx, remote, filters)
+				if err != nil {
+					return fmt.Errorf("failed to list remote directory: %w", err)
+				}
+				dest, err := fileops.BuildManifest(local, filters, nil)
+				if err != nil {
+					return fmt.Errorf("failed to list local directory: %w", err)
+				}
+				plan = fileops.PlanSync(source, dest, opts)
+				target = &localSyncTarget{client: c, local: local, remote: remote, opts: opts, blockSize: blockSize}
+			} else {
+				info, err := os.Stat(local)
+				if err != nil {
+					return fmt.Errorf("failed to stat local directory: %w", err)
+				}
+				if !info.IsDir() {
+					return fmt.Errorf("%s is not a directory", local)
+				}
+				source, err := fileops.BuildManifest(local, filters, nil)
+				if err != nil {
+					return fmt.Errorf("failed to list local directory: %w", err)
+				}
+				plan, err = c.PlanSync(ctx, remote, source, filters, opts)
+				if err != nil {
+					return fmt.Errorf("failed to plan sync: %w", err)
+				}
+				target = &remoteSyncTarget{client: c, local: local, remote: remote, opts: opts, blockSize: blockSize}
+			}
+
+			if dryRun {
+				return printSyncPlan(plan)
+			}
+			return runSyncPlan(ctx, plan, target)
+		},
+	}
+
+	cmd.Flags().BoolVar(&pull, "pull", false, "Copy remote-dir to local-dir instead")
+	cmd.Flags().BoolVar(&deleteExtra, "delete", false, "Delete files in the destination that are not in the source")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the changes that would be made")
+	cmd.Flags().BoolVar(&noPerms, "no-perms", false, "Do not preserve file modes")
+	cmd.Flags().BoolVar(&noTimes, "no-times", false, "Do not preserve modification times")
+	cmd.Flags().StringSliceVar(&include, "include", nil, "Only sync files matching these globs")
+	cmd.Flags().StringSliceVar(&exclude, "exclude", nil, "Skip files and directories matching these globs")
+	cmd.Flags().IntVar(&blockSize, "block-size", 0, "Delta block size in bytes (default depends on file size)")
+
+	return cmd
+}
+
+// syncTarget is the destination of a sync
+type syncTarget interface {
+	// apply makes changes that need no file contents
+	apply(ctx context.Context, changes []fileops.SyncChange) ([]fileops.SyncResult, error)
+	// transfer sends one file as a delta against the destination's copy
+	transfer(ctx context.Context, change fileops.SyncChange) (*fileops.DeltaStats, error)
+}
+
+// remoteSyncTarget pushes to the agent
+type remoteSyncTarget struct {
+	client        *client.Client
+	local, remote string
+	opts          fileops.SyncOptions
+	blockSize     int
+}
+
+func (t *remoteSyncTarget) apply(ctx context.Context, changes []fileops.SyncChange) ([]fileops.SyncResult, error) {
+	return t.client.ApplySync(ctx, t.remote, changes, t.opts)
+}
+
+func (t *remoteSyncTarget) transfer(ctx context.Context, change fileops.SyncChange) (*fileops.DeltaStats, error) {
+	sig, err := fileops.SignatureOf(nil, 0)
+	if change.Action == fileops.SyncActionUpdate {
+		sig, err = t.client.SyncSignature(ctx, t.remote, change.Path, t.blockSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := fileops.JoinSyncPath(t.local, change.Path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := fileops.WriteDelta(writer, sig, file)
+		writer.CloseWithError(err)
+	}()
+	stats, err := t.client.SyncPatch(ctx, t.remote, change.Entry, t.opts, reader)
+	reader.CloseWithError(err)
+	return stats, err
+}
+
+// localSyncTarget pulls from the agent
+type localSyncTarget struct {
+	client        *client.Client
+	local, remote string
+	opts          fileops.SyncOptions
+	blockSize     int
+}
+
+func (t *localSyncTarget) apply(ctx context.Context, changes []fileops.SyncChange) ([]fileops.SyncResult, error) {
+	if err := os.MkdirAll(t.local, 0755); err != nil {
+		return nil, err
+	}
+
+	results := make([]fileops.SyncResult, 0, len(changes))
+	for _, change := range changes {
+		result := fileops.SyncResult{Action: change.Action, Path: change.Path}
+		if err := fileops.ApplySyncChange(t.local, change, t.opts); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (t *localSyncTarget) transfer(ctx context.Context, change fileops.SyncChange) (*fileops.DeltaStats, error) {
+	basis, err := fileops.OpenSyncBasis(t.local, change.Path)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := fileops.SignatureOf(basis, t.blockSize)
+	if basis != nil {
+		basis.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := t.client.SyncDelta(ctx, t.remote, change.Path, sig)
+	if err != nil {
+		return nil, err
+	}
+	defer delta.Close()
+
+	stats, err := fileops.PatchFile(ctx, t.local, change.Path, change.Entry, delta, t.opts, 0)
+	return &stats, err
+}
+
+// runSyncPlan applies a plan in three passes: deletes, directories and
+// links, then file contents, then attributes, which must come last because
+// writing into a directory changes its modification time
+func runSyncPlan(ctx context.Context, plan *fileops.SyncPlan, target syncTarget) error {
+	var structure, contents, attrs []fileops.SyncChange
+	for _, change := range plan.Changes {
+		switch {
+		case change.NeedsData():
+			contents = append(contents, change)
+		case change.Action == fileops.SyncActionAttrs:
+			attrs = append(attrs, change)
+		default:
+			structure = append(structure, change)
+		}
+	}
+
+	failed := 0
+	report := func(results []fileops.SyncResult) {
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+				fmt.Fprintf(os.Stderr, "%-8s %s: %s\n", result.Action, result.Path, result.Error)
+				continue
+			}
+			if result.Action != fileops.SyncActionAttrs {
+				fmt.Printf("%-8s %s\n", result.Action, result.Path)
+			}
+		}
+	}
+
+	if len(structure) > 0 {
+		results, err := target.apply(ctx, structure)
+		if err != nil {
+			return fmt.Errorf("failed to sync: %w", err)
+		}
+		report(results)
+	}
+
+	var total fileops.DeltaStats
+	for _, change := range contents {
+		stats, err := target.transfer(ctx, change)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed++
+			fmt.Fprintf(os.Stderr, "%-8s %s: %v\n", change.Action, change.Path, err)
+			continue
+		}
+		total.Matched += stats.Matched
+		total.Literal += stats.Literal
+		fmt.Printf("%-8s %s (%d bytes sent, %d matched)\n", change.Action, change.Path, stats.Literal, stats.Matched)
+	}
+
+	if len(attrs) > 0 {
+		results, err := target.apply(ctx, attrs)
+		if err != nil {
+			return fmt.Errorf("failed to sync: %w", err)
+		}
+		report(results)
+	}
+
+	fmt.Printf("%d changes, %d files transferred: %d bytes sent, %d bytes matched\n",
+		len(plan.Changes)-failed, len(contents), total.Literal, total.Matched)
+	if failed > 0 {
+		return fmt.Errorf("%d changes failed", failed)
+	}
+	return nil
+}
+
+// printSyncPlan shows the changes a sync would make
+func printSyncPlan(plan *fileops.SyncPlan) error {
+	if globalFlags.Output == "json" || globalFlags.Output == "yaml" {
+		return printOutput(plan, globalFlags.Output)
+	}
+
+	for _, change := range plan.Changes {
+		fmt.Printf("%-8s %s\n", change.Action, change.Path)
+	}
+
+	var counts []string
+	for _, action := range []fileops.SyncAction{
+		fileops.SyncActionDelete, fileops.SyncActionMkdir, fileops.SyncActionSymlink,
+		fileops.SyncActionCreate, fileops.SyncActionUpdate, fileops.SyncActionAttrs,
+	} {
+		if n := plan.Summary[action]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, action))
+		}
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "up to date")
+	}
+	fmt.Printf("%d changes (dry run): %s; %d bytes of file data to compare\n", len(plan.Changes), strings.Join(counts, ", "), plan.Bytes)
+	return nil
 }
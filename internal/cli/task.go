@@ -18,12 +18,14 @@ func NewTaskCommand() *cobra.Command {
 	cmd.AddCommand(newTaskGetCommand())
 	cmd.AddCommand(newTaskExecuteCommand())
 	cmd.AddCommand(newTaskCancelCommand())
+	cmd.AddCommand(newTaskPauseCommand())
+	cmd.AddCommand(newTaskResumeCommand())
 
 	return cmd
 }
 
 func newTaskListCommand() *cobra.Command {
-	var status string
+	var opts client.TaskListOptions
 	
 	cmd := &cobra.Command{
 		Use:   "list",
@@ -31,7 +33,7 @@ func newTaskListCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
 			
-			tasks, err := c.ListTasks(cmd.Context(), status)
+			tasks, err := c.ListTasks(cmd.Context(), opts)
 			if err != nil {
 				return fmt.Errorf("failed to list tasks: %w", err)
 			}
@@ -40,7 +42,15 @@ func newTaskListCommand() *cobra.Command {
 		},
 	}
 	
-	cmd.Flags().StringVar(&status, "status", "", "Filter by status (pending, running, completed, failed)")
+	cmd.Flags().StringVar(&opts.Status, "status", "", "Filter by status, comma separated (scheduled, pending, queued, running, paused, completed, warning, failed, cancelled, timeout)")
+	cmd.Flags().StringVar(&opts.Type, "type", "", "Filter by task type, comma separated")
+	cmd.Flags().StringVar(&opts.NamePrefix, "name-prefix", "", "Filter by task name prefix")
+	cmd.Flags().StringVar(&opts.Since, "since", "", "Only tasks created at or after this time (RFC 3339 or Unix seconds)")
+	cmd.Flags().StringVar(&opts.Until, "until", "", "Only tasks created before this time (RFC 3339 or Unix seconds)")
+	cmd.Flags().StringSliceVar(&opts.Labels, "label", nil, "Filter by metadata label key=value (repeatable)")
+	cmd.Flags().StringVar(&opts.Sort, "sort", "", "Sort field (created_at, started_at, finished_at, name, priority), prefix with - for descending")
+	cmd.Flags().IntVar(&opts.Limit, "limit", 0, "Maximum number of tasks to return")
+	cmd.Flags().StringVar(&opts.Cursor, "cursor", "", "Cursor from a previous page")
 	
 	return cmd
 }
@@ -111,3 +121,39 @@ func newTaskCancelCommand() *cobra.Command {
 		},
 	}
 }
+
+func newTaskPauseCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause [task-id]",
+		Short: "Pause a running task",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+			
+			if err := c.PauseTask(cmd.Context(), args[0]); err != nil {
+				return fmt.Errorf("failed to pause task: %w", err)
+			}
+
+			fmt.Printf("Task %s paused successfully\n", args[0])
+			return nil
+		},
+	}
+}
+
+func newTaskResumeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume [task-id]",
+		Short: "Resume a paused task",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c := client.NewClient(globalFlags.AgentURL, globalFlags.Token)
+			
+			if err := c.ResumeTask(cmd.Context(), args[0]); err != nil {
+				return fmt.Errorf("failed to resume task: %w", err)
+			}
+
+			fmt.Printf("Task %s resumed successfully\n", args[0])
+			return nil
+		},
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -20,6 +21,7 @@ type Config struct {
 	Health     HealthConfig     `yaml:"health"`
 	Plugins    PluginsConfig    `yaml:"plugins"`
 	Executor   ExecutorConfig   `yaml:"executor"`
+	Events     EventsConfig     `yaml:"events"`
 }
 
 // AgentConfig contains agent-specific settings
@@ -116,10 +118,65 @@ type FirewallConfig struct {
 
 // StorageConfig contains storage settings
 type StorageConfig struct {
-	DataDir     string `yaml:"data_dir"`
-	TempDir     string `yaml:"temp_dir"`
-	MaxFileSize int64  `yaml:"max_file_size"`
-	Cleanup     CleanupConfig `yaml:"cleanup"`
+	DataDir      string        `yaml:"data_dir"`
+	TempDir      string        `yaml:"temp_dir"`
+	MaxFileSize  int64         `yaml:"max_file_size"`
+	UploadsDir   string        `yaml:"uploads_dir"`   // resumable upload sessions, defaults to <data_dir>/uploads
+	UploadExpiry time.Duration `yaml:"upload_expiry"` // idle resumable uploads are discarded after this
+	Access       AccessConfig  `yaml:"access"`
+	Extract      ExtractConfig `yaml:"extract"`
+	Watch        WatchConfig   `yaml:"watch"`
+	Trash        TrashConfig   `yaml:"trash"`
+	Versions     VersionConfig `yaml:"versions"`
+	Cleanup      CleanupConfig `yaml:"cleanup"`
+}
+
+// ExtractConfig limits what extracting an archive may write, guarding
+// against decompression bombs
+type ExtractConfig struct {
+	MaxSize    int64   `yaml:"max_size"`    // total bytes written
+	MaxEntries int     `yaml:"max_entries"` // entries in one archive
+	MaxRatio   float64 `yaml:"max_ratio"`   // bytes written per byte of archive
+}
+
+// WatchConfig limits file watches, which hold a kernel watch for every
+// directory they cover
+type WatchConfig struct {
+	MaxWatches     int           `yaml:"max_watches"`     // active watches
+	MaxDirectories int           `yaml:"max_directories"` // directories watched by all watches together
+	Debounce       time.Duration `yaml:"debounce"`        // quiet period before a change is reported
+}
+
+// TrashConfig makes deletes move entries into a trash directory on the same
+// filesystem, from which they can be restored until purged
+type TrashConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Dir     string        `yaml:"dir"`      // name of the trash directory, defaults to .ducla-trash
+	MaxAge  time.Duration `yaml:"max_age"`  // entries are purged after this
+	MaxSize int64         `yaml:"max_size"` // oldest entries are purged beyond this total size
+}
+
+// VersionConfig keeps the previous content of files replaced by copy, move,
+// upload or archive operations with overwrite set
+type VersionConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`    // name of the directory beside replaced files, defaults to .ducla-versions
+	Naming  string `yaml:"naming"` // numbered or timestamped
+	Keep    int    `yaml:"keep"`   // versions kept per file
+}
+
+// AccessConfig restricts the paths file operations and file tasks may use,
+// per kind of access
+type AccessConfig struct {
+	Read   AccessRule `yaml:"read"`   // list, stat, download, checksum, copy source
+	Write  AccessRule `yaml:"write"`  // upload, copy and move destination, chmod, chown
+	Delete AccessRule `yaml:"delete"` // delete, move source
+}
+
+// AccessRule allows paths under any of the roots unless they match a deny glob
+type AccessRule struct {
+	Roots []string `yaml:"roots"` // defaults to storage.data_dir and storage.temp_dir
+	Deny  []string `yaml:"deny"`
 }
 
 // CleanupConfig contains cleanup settings
@@ -177,10 +234,105 @@ type Plugin struct {
 
 // ExecutorConfig contains task executor settings
 type ExecutorConfig struct {
-	MaxConcurrentTasks int           `yaml:"max_concurrent_tasks"`
-	TaskTimeout        time.Duration `yaml:"task_timeout"`
-	WorkerPoolSize     int           `yaml:"worker_pool_size"`
-	QueueSize          int           `yaml:"queue_size"`
+	MaxConcurrentTasks int             `yaml:"max_concurrent_tasks"`
+	TaskTimeout        time.Duration   `yaml:"task_timeout"`
+	WorkerPoolSize     int             `yaml:"worker_pool_size"` // minimum number of workers
+	MaxWorkers         int             `yaml:"max_workers"`      // upper bound under queue pressure
+	IdleTimeout        time.Duration   `yaml:"idle_timeout"`     // idle time before surplus workers exit
+	QueueSize          int             `yaml:"queue_size"`
+	DrainTimeout       time.Duration   `yaml:"drain_timeout"` // time running tasks get to finish when draining
+	Admission          AdmissionConfig `yaml:"admission"`
+	Workspace          WorkspaceConfig `yaml:"workspace"`
+	SandboxProfiles    map[string]SandboxProfile `yaml:"sandbox_profiles"` // named profiles tasks can request
+	Hooks              []HookConfig    `yaml:"hooks"`                         // run for every finished task
+	InputsDir          string          `yaml:"inputs_dir"`                    // received task input files, defaults to <storage.temp_dir>/inputs
+	MaxInputSize       int64           `yaml:"max_input_size"`                // per input file, defaults to storage.max_file_size
+	Resources          ResourcesConfig `yaml:"resources"`
+	Windows            []WindowConfig  `yaml:"windows"` // maintenance windows and blackout periods
+}
+
+// WindowConfig describes a maintenance window, inside which matching tasks
+// may start, or a blackout period, inside which they may not. Recurring
+// windows start at the times of a cron expression or RRULE and last for
+// duration; without either, from and until define a single period.
+type WindowConfig struct {
+	Name      string            `yaml:"name"`
+	Kind      string            `yaml:"kind"`     // maintenance or blackout
+	Cron      string            `yaml:"cron"`     // window start times
+	RRule     string            `yaml:"rrule"`    // window start times, instead of cron
+	Duration  time.Duration     `yaml:"duration"` // length of each recurring window
+	From      time.Time         `yaml:"from"`     // start of a single period, or of the recurrence
+	Until     time.Time         `yaml:"until"`    // end of a single period, or of the recurrence
+	Timezone  string            `yaml:"timezone"` // defaults to the agent's local time zone
+	Action    string            `yaml:"action"`   // hold (default) or reject tasks outside their windows
+	TaskTypes []string          `yaml:"task_types"`
+	Templates []string          `yaml:"templates"` // matched against the task's metadata.template
+	Labels    map[string]string `yaml:"labels"`    // metadata key/value pairs
+}
+
+// ResourcesConfig contains resource-aware scheduling settings. Capacity is
+// measured from the host unless overridden; the headroom applies either way.
+type ResourcesConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	HeadroomPercent float64 `yaml:"headroom_percent"` // capacity kept free for the host, defaults to 10
+	CPU             float64 `yaml:"cpu"`              // cores, defaults to the CPUs in /proc/stat
+	Memory          int64   `yaml:"memory"`           // bytes, defaults to MemTotal
+	Disk            int64   `yaml:"disk"`             // bytes, defaults to the size of disk_path's file system
+	DiskPath        string  `yaml:"disk_path"`        // defaults to workspace.ephemeral_dir
+}
+
+// HookConfig describes a webhook or follow-up task run when a task finishes
+type HookConfig struct {
+	URL        string                 `yaml:"url"`
+	Secret     string                 `yaml:"secret"`      // signs the payload with HMAC-SHA256
+	Headers    map[string]string      `yaml:"headers"`
+	On         []string               `yaml:"on"`          // final statuses that fire the hook, all if empty
+	MaxRetries *int                   `yaml:"max_retries"` // defaults to 3, 0 disables retries
+	Timeout    time.Duration          `yaml:"timeout"`     // per attempt, defaults to 10s
+	Task       map[string]interface{} `yaml:"task"`        // follow-up task instead of a webhook
+}
+
+// SandboxProfile describes the isolation applied to tasks that request it
+type SandboxProfile struct {
+	MountNamespace   bool          `yaml:"mount_namespace"`
+	PIDNamespace     bool          `yaml:"pid_namespace"`
+	NetworkNamespace bool          `yaml:"network_namespace"`
+	IPCNamespace     bool          `yaml:"ipc_namespace"`
+	UTSNamespace     bool          `yaml:"uts_namespace"`
+	Hostname         string        `yaml:"hostname"`        // hostname inside the UTS namespace
+	ReadOnlyPaths    []string      `yaml:"read_only_paths"` // bind-mounted read-only, requires mount namespace
+	PrivateTmp       bool          `yaml:"private_tmp"`     // fresh tmpfs on /tmp, requires mount namespace
+	Seccomp          SeccompConfig `yaml:"seccomp"`
+}
+
+// SeccompConfig contains seccomp syscall filter settings
+type SeccompConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	DenySyscalls []string `yaml:"deny_syscalls"` // defaults to a list of privileged syscalls
+	Action       string   `yaml:"action"`        // errno (default) or kill
+}
+
+// WorkspaceConfig contains per-task workspace settings
+type WorkspaceConfig struct {
+	EphemeralDir  string        `yaml:"ephemeral_dir"`  // defaults to <storage.temp_dir>/workspaces
+	PersistentDir string        `yaml:"persistent_dir"` // defaults to <storage.data_dir>/workspaces
+	Retention     time.Duration `yaml:"retention"`      // how long finished ephemeral workspaces are kept
+}
+
+// AdmissionConfig contains task admission control settings
+type AdmissionConfig struct {
+	Enabled          bool           `yaml:"enabled"`
+	MaxCPULoad       float64        `yaml:"max_cpu_load"`       // load average per CPU
+	MaxMemoryPercent float64        `yaml:"max_memory_percent"` // used memory percentage
+	MaxDiskPercent   float64        `yaml:"max_disk_percent"`   // used disk percentage
+	CriticalPriority int            `yaml:"critical_priority"`  // tasks at or above are never shed
+	RetryAfter       time.Duration  `yaml:"retry_after"`
+	Quotas           map[string]int `yaml:"quotas"` // max in-flight tasks per task type
+}
+
+// EventsConfig contains internal event bus settings
+type EventsConfig struct {
+	BufferSize int `yaml:"buffer_size"`
 }
 
 // Load loads configuration from file
@@ -256,6 +408,53 @@ func (c *Config) setDefaults() {
 	if c.Storage.MaxFileSize == 0 {
 		c.Storage.MaxFileSize = 100 * 1024 * 1024 // 100MB
 	}
+	if c.Storage.UploadsDir == "" {
+		c.Storage.UploadsDir = filepath.Join(c.Storage.DataDir, "uploads")
+	}
+	if c.Storage.UploadExpiry == 0 {
+		c.Storage.UploadExpiry = 24 * time.Hour
+	}
+	if c.Storage.Extract.MaxSize == 0 {
+		c.Storage.Extract.MaxSize = 10 * 1024 * 1024 * 1024 // 10GB
+	}
+	if c.Storage.Extract.MaxEntries == 0 {
+		c.Storage.Extract.MaxEntries = 100000
+	}
+	if c.Storage.Extract.MaxRatio == 0 {
+		c.Storage.Extract.MaxRatio = 1000
+	}
+	if c.Storage.Watch.MaxWatches == 0 {
+		c.Storage.Watch.MaxWatches = 64
+	}
+	if c.Storage.Watch.MaxDirectories == 0 {
+		c.Storage.Watch.MaxDirectories = 8192
+	}
+	if c.Storage.Watch.Debounce == 0 {
+		c.Storage.Watch.Debounce = 500 * time.Millisecond
+	}
+	if c.Storage.Trash.Dir == "" {
+		c.Storage.Trash.Dir = ".ducla-trash"
+	}
+	if c.Storage.Trash.MaxAge == 0 {
+		c.Storage.Trash.MaxAge = 7 * 24 * time.Hour
+	}
+	if c.Storage.Trash.MaxSize == 0 {
+		c.Storage.Trash.MaxSize = 10 * 1024 * 1024 * 1024 // 10GB
+	}
+	if c.Storage.Versions.Dir == "" {
+		c.Storage.Versions.Dir = ".ducla-versions"
+	}
+	if c.Storage.Versions.Naming == "" {
+		c.Storage.Versions.Naming = "numbered"
+	}
+	if c.Storage.Versions.Keep == 0 {
+		c.Storage.Versions.Keep = 5
+	}
+	for _, rule := range []*AccessRule{&c.Storage.Access.Read, &c.Storage.Access.Write, &c.Storage.Access.Delete} {
+		if len(rule.Roots) == 0 {
+			rule.Roots = []string{c.Storage.DataDir, c.Storage.TempDir}
+		}
+	}
 
 	// Logging defaults
 	if c.Logging.Level == "" {
@@ -309,9 +508,59 @@ func (c *Config) setDefaults() {
 	if c.Executor.WorkerPoolSize == 0 {
 		c.Executor.WorkerPoolSize = 5
 	}
+	if c.Executor.MaxWorkers < c.Executor.WorkerPoolSize {
+		c.Executor.MaxWorkers = c.Executor.WorkerPoolSize
+	}
+	if c.Executor.IdleTimeout == 0 {
+		c.Executor.IdleTimeout = 5 * time.Minute
+	}
 	if c.Executor.QueueSize == 0 {
 		c.Executor.QueueSize = 100
 	}
+	if c.Executor.DrainTimeout == 0 {
+		c.Executor.DrainTimeout = 60 * time.Second
+	}
+	if c.Executor.Workspace.EphemeralDir == "" {
+		c.Executor.Workspace.EphemeralDir = filepath.Join(c.Storage.TempDir, "workspaces")
+	}
+	if c.Executor.Workspace.PersistentDir == "" {
+		c.Executor.Workspace.PersistentDir = filepath.Join(c.Storage.DataDir, "workspaces")
+	}
+	if c.Executor.InputsDir == "" {
+		c.Executor.InputsDir = filepath.Join(c.Storage.TempDir, "inputs")
+	}
+	if c.Executor.MaxInputSize == 0 {
+		c.Executor.MaxInputSize = c.Storage.MaxFileSize
+	}
+	if c.Executor.Resources.HeadroomPercent == 0 {
+		c.Executor.Resources.HeadroomPercent = 10
+	}
+	if c.Executor.Resources.DiskPath == "" {
+		c.Executor.Resources.DiskPath = c.Executor.Workspace.EphemeralDir
+	}
+	if c.Executor.Workspace.Retention == 0 {
+		c.Executor.Workspace.Retention = time.Hour
+	}
+	if c.Executor.Admission.MaxCPULoad == 0 {
+		c.Executor.Admission.MaxCPULoad = 2.0
+	}
+	if c.Executor.Admission.MaxMemoryPercent == 0 {
+		c.Executor.Admission.MaxMemoryPercent = 90
+	}
+	if c.Executor.Admission.MaxDiskPercent == 0 {
+		c.Executor.Admission.MaxDiskPercent = 95
+	}
+	if c.Executor.Admission.CriticalPriority == 0 {
+		c.Executor.Admission.CriticalPriority = 10
+	}
+	if c.Executor.Admission.RetryAfter == 0 {
+		c.Executor.Admission.RetryAfter = 5 * time.Second
+	}
+
+	// Events defaults
+	if c.Events.BufferSize == 0 {
+		c.Events.BufferSize = 256
+	}
 }
 
 // Validate validates the configuration
@@ -323,5 +572,30 @@ func (c *Config) Validate() error {
 	if c.Agent.ID == "" {
 		return fmt.Errorf("agent.id is required")
 	}
+	for name, profile := range c.Executor.SandboxProfiles {
+		if (len(profile.ReadOnlyPaths) > 0 || profile.PrivateTmp) && !profile.MountNamespace {
+			return fmt.Errorf("executor.sandbox_profiles.%s: read_only_paths and private_tmp require mount_namespace", name)
+		}
+		switch profile.Seccomp.Action {
+		case "", "errno", "kill":
+		default:
+			return fmt.Errorf("executor.sandbox_profiles.%s: invalid seccomp action %q", name, profile.Seccomp.Action)
+		}
+	}
+	if headroom := c.Executor.Resources.HeadroomPercent; headroom < 0 || headroom >= 100 {
+		return fmt.Errorf("executor.resources.headroom_percent must be between 0 and 100")
+	}
+	if dir := c.Storage.Trash.Dir; dir == "." || dir == ".." || filepath.Base(dir) != dir {
+		return fmt.Errorf("storage.trash.dir must be a plain directory name")
+	}
+	if dir := c.Storage.Versions.Dir; dir == "." || dir == ".." || filepath.Base(dir) != dir || dir == c.Storage.Trash.Dir {
+		return fmt.Errorf("storage.versions.dir must be a plain directory name other than storage.trash.dir")
+	}
+	if naming := c.Storage.Versions.Naming; naming != "numbered" && naming != "timestamped" {
+		return fmt.Errorf("storage.versions.naming must be numbered or timestamped")
+	}
+	if c.Storage.Versions.Keep < 0 {
+		return fmt.Errorf("storage.versions.keep must not be negative")
+	}
 	return nil
 }
\ No newline at end of file
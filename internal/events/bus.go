@@ -0,0 +1,217 @@
+package events
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultBufferSize is used for subscriptions that do not request a buffer size
+const DefaultBufferSize = 256
+
+// Bus is an in-process publish/subscribe event bus.
+//
+// Publishing never blocks: every subscriber has its own buffered channel and
+// events are dropped for subscribers whose buffer is full. A nil *Bus is valid
+// and silently discards everything published to it.
+type Bus struct {
+	logger     *logrus.Logger
+	bufferSize int
+
+	mu          sync.RWMutex
+	subscribers map[uint64]*Subscription
+	nextID      uint64
+	closed      bool
+
+	sequence  uint64
+	published uint64
+	dropped   uint64
+}
+
+// Subscription receives events from a Bus
+type Subscription struct {
+	id      uint64
+	bus     *Bus
+	ch      chan Envelope
+	types   map[Type]bool
+	dropped uint64
+	once    sync.Once
+}
+
+// NewBus creates a new event bus. A nil logger discards the bus's logs.
+func NewBus(bufferSize int, logger *logrus.Logger) *Bus {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(io.Discard)
+	}
+
+	return &Bus{
+		logger:      logger,
+		bufferSize:  bufferSize,
+		subscribers: make(map[uint64]*Subscription),
+	}
+}
+
+// Publish delivers an event to every interested subscriber without blocking
+func (b *Bus) Publish(event Event) {
+	if b == nil || event == nil {
+		return
+	}
+
+	envelope := Envelope{
+		Sequence:  atomic.AddUint64(&b.sequence, 1),
+		Type:      event.EventType(),
+		Timestamp: time.Now(),
+		Event:     event,
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	atomic.AddUint64(&b.published, 1)
+
+	for _, sub := range b.subscribers {
+		if !sub.accepts(envelope.Type) {
+			continue
+		}
+
+		select {
+		case sub.ch <- envelope:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			atomic.AddUint64(&b.dropped, 1)
+			b.logger.WithFields(logrus.Fields{
+				"subscription": sub.id,
+				"event_type":   envelope.Type,
+			}).Debug("Event dropped, subscriber buffer full")
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. An empty types list subscribes to all
+// event types; a non-positive bufferSize uses the bus default. Subscribing to
+// a nil *Bus returns a subscription whose channel is already closed.
+func (b *Bus) Subscribe(bufferSize int, types ...Type) *Subscription {
+	if b == nil {
+		sub := &Subscription{ch: make(chan Envelope)}
+		close(sub.ch)
+		return sub
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = b.bufferSize
+	}
+
+	sub := &Subscription{
+		bus: b,
+		ch:  make(chan Envelope, bufferSize),
+	}
+
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.ch)
+		return sub
+	}
+
+	b.nextID++
+	sub.id = b.nextID
+	b.subscribers[sub.id] = sub
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	if sub == nil {
+		return
+	}
+	sub.Close()
+}
+
+// Close closes the bus and all subscriber channels
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for id, sub := range b.subscribers {
+		delete(b.subscribers, id)
+		sub.once.Do(func() { close(sub.ch) })
+	}
+}
+
+// GetStats returns event bus statistics
+func (b *Bus) GetStats() map[string]interface{} {
+	if b == nil {
+		return map[string]interface{}{
+			"subscribers": 0,
+			"published":   uint64(0),
+			"dropped":     uint64(0),
+		}
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return map[string]interface{}{
+		"subscribers": len(b.subscribers),
+		"published":   atomic.LoadUint64(&b.published),
+		"dropped":     atomic.LoadUint64(&b.dropped),
+	}
+}
+
+// Events returns the channel on which events are delivered. The channel is
+// closed when the subscription is closed or the bus shuts down.
+func (s *Subscription) Events() <-chan Envelope {
+	return s.ch
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close removes the subscription from the bus
+func (s *Subscription) Close() {
+	if s.bus == nil {
+		return
+	}
+
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	delete(s.bus.subscribers, s.id)
+	s.once.Do(func() { close(s.ch) })
+}
+
+// accepts reports whether the subscription wants events of the given type
+func (s *Subscription) accepts(t Type) bool {
+	return s.types == nil || s.types[t]
+}
@@ -0,0 +1,36 @@
+package events
+
+import "testing"
+
+func TestPublishDropsWithNilLogger(t *testing.T) {
+	bus := NewBus(1, nil)
+	sub := bus.Subscribe(1)
+	defer sub.Close()
+
+	for i := 0; i < 3; i++ {
+		bus.Publish(TaskQueued{TaskID: "task"})
+	}
+
+	if got := sub.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+	if got := bus.GetStats()["dropped"]; got != uint64(2) {
+		t.Errorf("dropped = %v, want 2", got)
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(TaskQueued{TaskID: "task"})
+
+	sub := bus.Subscribe(0)
+	if _, ok := <-sub.Events(); ok {
+		t.Error("subscription to a nil bus delivered an event")
+	}
+	sub.Close()
+	bus.Close()
+
+	if got := bus.GetStats()["subscribers"]; got != 0 {
+		t.Errorf("subscribers = %v, want 0", got)
+	}
+}
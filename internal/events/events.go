@@ -0,0 +1,147 @@
+package events
+
+import "time"
+
+// Type identifies the kind of an event
+type Type string
+
+const (
+	TypeTaskQueued       Type = "task_queued"
+	TypeTaskStarted      Type = "task_started"
+	TypeTaskFinished     Type = "task_finished"
+	TypeTaskPaused       Type = "task_paused"
+	TypeTaskResumed      Type = "task_resumed"
+	TypeTaskProgress     Type = "task_progress"
+	TypeTransferProgress Type = "transfer_progress"
+	TypeHealthChanged    Type = "health_changed"
+	TypeFileChanged      Type = "file_changed"
+)
+
+// Event is implemented by every payload that can be published on the bus
+type Event interface {
+	EventType() Type
+}
+
+// Envelope wraps a published event with delivery metadata
+type Envelope struct {
+	Sequence  uint64    `json:"sequence"`
+	Type      Type      `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Event     Event     `json:"event"`
+}
+
+// TaskQueued is published when a task has been accepted by the executor
+type TaskQueued struct {
+	TaskID   string `json:"task_id"`
+	TaskType string `json:"task_type"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+}
+
+// EventType implements Event
+func (TaskQueued) EventType() Type { return TypeTaskQueued }
+
+// TaskStarted is published when a worker picks up a task
+type TaskStarted struct {
+	TaskID    string    `json:"task_id"`
+	TaskType  string    `json:"task_type"`
+	Name      string    `json:"name"`
+	WorkerID  int       `json:"worker_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// EventType implements Event
+func (TaskStarted) EventType() Type { return TypeTaskStarted }
+
+// TaskFinished is published when a task reaches a terminal status
+type TaskFinished struct {
+	TaskID     string        `json:"task_id"`
+	TaskType   string        `json:"task_type"`
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	ExitCode   int           `json:"exit_code"`
+	Error      string        `json:"error,omitempty"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// EventType implements Event
+func (TaskFinished) EventType() Type { return TypeTaskFinished }
+
+// TaskPaused is published when a running task has been suspended
+type TaskPaused struct {
+	TaskID   string    `json:"task_id"`
+	TaskType string    `json:"task_type"`
+	Name     string    `json:"name"`
+	PausedAt time.Time `json:"paused_at"`
+}
+
+// EventType implements Event
+func (TaskPaused) EventType() Type { return TypeTaskPaused }
+
+// TaskResumed is published when a paused task continues running
+type TaskResumed struct {
+	TaskID    string        `json:"task_id"`
+	TaskType  string        `json:"task_type"`
+	Name      string        `json:"name"`
+	PausedFor time.Duration `json:"paused_for"`
+}
+
+// EventType implements Event
+func (TaskResumed) EventType() Type { return TypeTaskResumed }
+
+// TaskProgress is published when a running task reports progress
+type TaskProgress struct {
+	TaskID    string                 `json:"task_id"`
+	TaskType  string                 `json:"task_type"`
+	Name      string                 `json:"name"`
+	Percent   float64                `json:"percent"`
+	Message   string                 `json:"message,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// EventType implements Event
+func (TaskProgress) EventType() Type { return TypeTaskProgress }
+
+// TransferProgress is published whenever a file transfer changes status or advances
+type TransferProgress struct {
+	TransferID  string  `json:"transfer_id"`
+	Type        string  `json:"type"`
+	Status      string  `json:"status"`
+	SourcePath  string  `json:"source_path"`
+	DestPath    string  `json:"dest_path"`
+	Size        int64   `json:"size"`
+	Transferred int64   `json:"transferred"`
+	Progress    float64 `json:"progress"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// EventType implements Event
+func (TransferProgress) EventType() Type { return TypeTransferProgress }
+
+// HealthChanged is published when a health check or the overall health
+// status transitions. Check is empty for the overall status.
+type HealthChanged struct {
+	Check    string `json:"check,omitempty"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+	Message  string `json:"message,omitempty"`
+}
+
+// EventType implements Event
+func (HealthChanged) EventType() Type { return TypeHealthChanged }
+
+// FileChanged is published when a watched path changes. OldPath is set for
+// renames.
+type FileChanged struct {
+	WatchID string `json:"watch_id"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+	IsDir   bool   `json:"is_dir,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// EventType implements Event
+func (FileChanged) EventType() Type { return TypeFileChanged }
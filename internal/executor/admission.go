@@ -0,0 +1,106 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AdmissionError is returned when a task is rejected before being queued.
+// Callers should retry the submission after RetryAfter.
+type AdmissionError struct {
+	Reason     string        `json:"reason"`
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+func (e *AdmissionError) Error() string {
+	return "task rejected: " + e.Reason
+}
+
+// HostLoad is a snapshot of host resource usage
+type HostLoad struct {
+	CPULoadPerCore float64
+	MemoryPercent  float64
+	DiskPercent    float64
+}
+
+// LoadProvider supplies host load readings to admission control
+type LoadProvider interface {
+	// GetHostLoad returns the latest reading, or false if none is available yet
+	GetHostLoad() (HostLoad, bool)
+}
+
+// SetLoadProvider sets the source of host load readings used for load shedding
+func (e *Executor) SetLoadProvider(provider LoadProvider) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loadProvider = provider
+}
+
+// admit decides whether a task may be queued. Must be called with e.mu held.
+func (e *Executor) admit(task *Task) error {
+	cfg := e.config.Admission
+	if !cfg.Enabled {
+		return nil
+	}
+
+	// Per-task-type quotas on in-flight tasks
+	if quota, ok := cfg.Quotas[string(task.Type)]; ok {
+		if inFlight := e.countInFlight(task.Type); inFlight >= quota {
+			return e.reject(task, fmt.Sprintf("quota for %s tasks exhausted (%d/%d in flight)", task.Type, inFlight, quota))
+		}
+	}
+
+	// Shed non-critical work while the host is struggling
+	if task.Priority >= cfg.CriticalPriority || e.loadProvider == nil {
+		return nil
+	}
+
+	load, ok := e.loadProvider.GetHostLoad()
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case cfg.MaxCPULoad > 0 && load.CPULoadPerCore > cfg.MaxCPULoad:
+		return e.reject(task, fmt.Sprintf("host CPU load too high (%.2f per CPU)", load.CPULoadPerCore))
+	case cfg.MaxMemoryPercent > 0 && load.MemoryPercent > cfg.MaxMemoryPercent:
+		return e.reject(task, fmt.Sprintf("host memory usage too high (%.1f%%)", load.MemoryPercent))
+	case cfg.MaxDiskPercent > 0 && load.DiskPercent > cfg.MaxDiskPercent:
+		return e.reject(task, fmt.Sprintf("host disk usage too high (%.1f%%)", load.DiskPercent))
+	}
+
+	return nil
+}
+
+// reject records a rejected submission and builds the admission error.
+// Must be called with e.mu held.
+func (e *Executor) reject(task *Task, reason string) *AdmissionError {
+	e.rejectedTasks++
+
+	e.logger.WithFields(logrus.Fields{
+		"task_id": task.ID,
+		"type":    task.Type,
+		"reason":  reason,
+	}).Warn("Task rejected by admission control")
+
+	return &AdmissionError{
+		Reason:     reason,
+		RetryAfter: e.config.Admission.RetryAfter,
+	}
+}
+
+// countInFlight counts pending, queued and running tasks of a type. Must be called with e.mu held.
+func (e *Executor) countInFlight(taskType TaskType) int {
+	count := 0
+	for _, task := range e.tasks {
+		if task.Type != taskType {
+			continue
+		}
+		if task.Status == TaskStatusPending || task.Status == TaskStatusQueued || task.Status == TaskStatusRunning || task.Status == TaskStatusPaused {
+			count++
+		}
+	}
+	return count
+}
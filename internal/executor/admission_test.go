@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLoad is a LoadProvider returning a fixed reading
+type fakeLoad struct {
+	mu   sync.Mutex
+	load *HostLoad
+}
+
+func (f *fakeLoad) GetHostLoad() (HostLoad, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.load == nil {
+		return HostLoad{}, false
+	}
+	return *f.load, true
+}
+
+func (f *fakeLoad) set(load *HostLoad) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.load = load
+}
+
+// admissionExecutor starts an executor with admission control enabled
+func admissionExecutor(t *testing.T, quotas map[string]int) *Executor {
+	cfg := testConfig(t)
+	cfg.Admission.Enabled = true
+	cfg.Admission.MaxCPULoad = 2
+	cfg.Admission.MaxMemoryPercent = 90
+	cfg.Admission.MaxDiskPercent = 95
+	cfg.Admission.CriticalPriority = 10
+	cfg.Admission.RetryAfter = 5 * time.Second
+	cfg.Admission.Quotas = quotas
+	return startExecutor(t, cfg)
+}
+
+func TestAdmissionShedsUnderLoad(t *testing.T) {
+	e := admissionExecutor(t, nil)
+	load := &fakeLoad{}
+	e.SetLoadProvider(load)
+
+	if _, err := e.SubmitTask(sleepTask(10 * time.Millisecond)); err != nil {
+		t.Fatalf("SubmitTask() without a load reading error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		load HostLoad
+	}{
+		{"cpu", HostLoad{CPULoadPerCore: 3}},
+		{"memory", HostLoad{MemoryPercent: 95}},
+		{"disk", HostLoad{DiskPercent: 99}},
+	}
+	for _, tt := range tests {
+		load.set(&tt.load)
+
+		_, err := e.SubmitTask(sleepTask(10 * time.Millisecond))
+		var admissionErr *AdmissionError
+		if !errors.As(err, &admissionErr) {
+			t.Errorf("%s: SubmitTask() error = %v, want an AdmissionError", tt.name, err)
+			continue
+		}
+		if admissionErr.RetryAfter != 5*time.Second {
+			t.Errorf("%s: retry after = %s, want 5s", tt.name, admissionErr.RetryAfter)
+		}
+
+		critical := sleepTask(10 * time.Millisecond)
+		critical.Priority = 10
+		if _, err := e.SubmitTask(critical); err != nil {
+			t.Errorf("%s: critical task rejected: %v", tt.name, err)
+		}
+	}
+
+	if rejected := e.GetStats()["rejected_tasks"]; rejected != len(tests) {
+		t.Errorf("rejected_tasks = %v, want %d", rejected, len(tests))
+	}
+}
+
+func TestAdmissionQuota(t *testing.T) {
+	e := admissionExecutor(t, map[string]int{"command": 1})
+
+	first, err := e.SubmitTask(sleepTask(200 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var admissionErr *AdmissionError
+	if _, err := e.SubmitTask(sleepTask(10 * time.Millisecond)); !errors.As(err, &admissionErr) {
+		t.Fatalf("SubmitTask() beyond the quota error = %v, want an AdmissionError", err)
+	}
+	if _, err := e.SubmitTask(&Task{Type: TaskTypeScript, Command: "true"}); err != nil {
+		t.Errorf("task of another type rejected: %v", err)
+	}
+
+	waitForStatus(t, e, first, TaskStatusCompleted)
+	if _, err := e.SubmitTask(sleepTask(10 * time.Millisecond)); err != nil {
+		t.Errorf("SubmitTask() after the quota freed up error = %v", err)
+	}
+}
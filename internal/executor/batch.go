@@ -0,0 +1,302 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxBatchSize caps the number of tasks in a batch, including matrix expansion
+const MaxBatchSize = 1000
+
+// Batch groups tasks submitted together
+type Batch struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	TaskIDs     []string  `json:"task_ids"`
+	FailFast    bool      `json:"fail_fast"`
+	CreatedAt   time.Time `json:"created_at"`
+	Cancelled   bool      `json:"cancelled"`
+	CancelCause string    `json:"cancel_cause,omitempty"`
+}
+
+// BatchStatus is the aggregate state of a batch
+type BatchStatus struct {
+	*Batch
+	Status string             `json:"status"` // running, completed, failed or cancelled
+	Total  int                `json:"total"`
+	Counts map[TaskStatus]int `json:"counts"`
+}
+
+// SubmitBatch validates and queues a group of tasks. Either every task is
+// queued or none is. The queue must have room for the whole batch, and the
+// batch is queued under the executor lock, which workers take before
+// starting a task, so a task rejected late in the batch cancels the earlier
+// ones before any of them starts.
+func (e *Executor) SubmitBatch(name string, tasks []*Task, failFast bool) (*Batch, error) {
+	if len(tasks) == 0 {
+		return nil, errors.New("batch has no tasks")
+	}
+	if len(tasks) > MaxBatchSize {
+		return nil, fmt.Errorf("batch has %d tasks, at most %d allowed", len(tasks), MaxBatchSize)
+	}
+
+	for i, task := range tasks {
+		if err := e.validateTask(task); err != nil {
+			return nil, fmt.Errorf("invalid task %d: %w", i, err)
+		}
+	}
+
+	batch := &Batch{
+		ID:        uuid.New().String(),
+		Name:      name,
+		TaskIDs:   make([]string, 0, len(tasks)),
+		FailFast:  failFast,
+		CreatedAt: time.Now(),
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if free := cap(e.taskQueue) - len(e.taskQueue) - len(e.held); len(tasks) > free {
+		e.rejectedTasks += len(tasks)
+		return nil, &AdmissionError{
+			Reason:     fmt.Sprintf("task queue has room for %d of %d tasks", free, len(tasks)),
+			RetryAfter: e.config.Admission.RetryAfter,
+		}
+	}
+
+	// Register the batch first so fail_fast sees results of early tasks
+	e.batches[batch.ID] = batch
+
+	for i, task := range tasks {
+		if task.ID == "" {
+			task.ID = uuid.New().String()
+		}
+		if task.Timeout == 0 {
+			task.Timeout = e.config.TaskTimeout
+		}
+		task.BatchID = batch.ID
+		if task.Metadata == nil {
+			task.Metadata = make(map[string]interface{})
+		}
+		task.Metadata["batch_id"] = batch.ID
+
+		if err := e.enqueueLocked(e.ctx, task); err != nil {
+			for _, id := range batch.TaskIDs {
+				queued := e.tasks[id]
+				queued.cancel()
+				queued.Status = TaskStatusCancelled
+			}
+			delete(e.batches, batch.ID)
+
+			e.logger.WithFields(logrus.Fields{
+				"batch_id":  batch.ID,
+				"cancelled": len(batch.TaskIDs),
+			}).Warn("Batch submission failed")
+
+			return nil, fmt.Errorf("failed to queue task %d: %w", i, err)
+		}
+
+		batch.TaskIDs = append(batch.TaskIDs, task.ID)
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"batch_id":  batch.ID,
+		"tasks":     len(tasks),
+		"fail_fast": failFast,
+	}).Info("Batch submitted for execution")
+
+	return batch, nil
+}
+
+// GetBatch returns the aggregate status of a batch
+func (e *Executor) GetBatch(batchID string) (*BatchStatus, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	batch, exists := e.batches[batchID]
+	if !exists {
+		return nil, fmt.Errorf("batch not found: %s", batchID)
+	}
+
+	status := &BatchStatus{
+		Batch:  batch,
+		Total:  len(batch.TaskIDs),
+		Counts: make(map[TaskStatus]int),
+	}
+
+	active, failed := false, false
+	for _, id := range batch.TaskIDs {
+		task, ok := e.tasks[id]
+		if !ok {
+			continue
+		}
+		status.Counts[task.Status]++
+
+		switch task.Status {
+		case TaskStatusPending, TaskStatusScheduled, TaskStatusQueued, TaskStatusRunning, TaskStatusPaused:
+			active = true
+		case TaskStatusFailed, TaskStatusTimeout:
+			failed = true
+		}
+	}
+
+	switch {
+	case active:
+		status.Status = "running"
+	case batch.Cancelled:
+		status.Status = "cancelled"
+	case failed:
+		status.Status = "failed"
+	default:
+		status.Status = "completed"
+	}
+
+	return status, nil
+}
+
+// ListBatches returns all batches, newest first
+func (e *Executor) ListBatches() []*Batch {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	batches := make([]*Batch, 0, len(e.batches))
+	for _, batch := range e.batches {
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.After(batches[j].CreatedAt)
+	})
+
+	return batches
+}
+
+// CancelBatch cancels every task of a batch that has not finished yet
+func (e *Executor) CancelBatch(batchID string) (int, error) {
+	e.mu.RLock()
+	batch, exists := e.batches[batchID]
+	e.mu.RUnlock()
+
+	if !exists {
+		return 0, fmt.Errorf("batch not found: %s", batchID)
+	}
+
+	return e.cancelBatch(batch, "cancelled by request"), nil
+}
+
+// cancelBatch marks a batch cancelled and cancels its unfinished tasks. It
+// returns the number of cancelled tasks.
+func (e *Executor) cancelBatch(batch *Batch, cause string) int {
+	e.mu.Lock()
+	if !batch.Cancelled {
+		batch.Cancelled = true
+		batch.CancelCause = cause
+	}
+	ids := append([]string(nil), batch.TaskIDs...)
+	e.mu.Unlock()
+
+	cancelled := 0
+	for _, id := range ids {
+		if err := e.CancelTask(id); err == nil {
+			cancelled++
+		}
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"batch_id":  batch.ID,
+		"cancelled": cancelled,
+		"cause":     cause,
+	}).Info("Batch cancelled")
+
+	return cancelled
+}
+
+// failFast cancels the rest of a fail_fast batch after one of its tasks
+// failed. Must be called with the executor lock held.
+func (e *Executor) failFast(task *Task) {
+	batch, exists := e.batches[task.BatchID]
+	if !exists || !batch.FailFast || batch.Cancelled {
+		return
+	}
+
+	switch task.Status {
+	case TaskStatusFailed, TaskStatusTimeout:
+		go e.cancelBatch(batch, fmt.Sprintf("task %s %s", task.ID, task.Status))
+	}
+}
+
+// ExpandMatrix expands a task template over every combination of matrix
+// values. "{{matrix.<key>}}" in string fields of the template is replaced by
+// the value of the combination, which is also recorded in the task metadata.
+func ExpandMatrix(template map[string]interface{}, matrix map[string][]interface{}) ([]map[string]interface{}, error) {
+	keys := make([]string, 0, len(matrix))
+	total := 1
+	for key, values := range matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix key %s has no values", key)
+		}
+		keys = append(keys, key)
+		total *= len(values)
+		if total > MaxBatchSize {
+			return nil, fmt.Errorf("matrix expands to more than %d tasks", MaxBatchSize)
+		}
+	}
+	sort.Strings(keys)
+
+	expanded := make([]map[string]interface{}, 0, total)
+	for i := 0; i < total; i++ {
+		// Decode the combination index, last key varying fastest
+		combination := make(map[string]interface{}, len(keys))
+		replacements := make([]string, 0, 2*len(keys))
+		n := i
+		for k := len(keys) - 1; k >= 0; k-- {
+			values := matrix[keys[k]]
+			value := values[n%len(values)]
+			n /= len(values)
+
+			combination[keys[k]] = value
+			replacements = append(replacements, "{{matrix."+keys[k]+"}}", fmt.Sprint(value))
+		}
+
+		data := substitute(template, strings.NewReplacer(replacements...)).(map[string]interface{})
+		metadata, _ := data["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		metadata["matrix"] = combination
+		data["metadata"] = metadata
+
+		expanded = append(expanded, data)
+	}
+
+	return expanded, nil
+}
+
+// substitute returns a deep copy of a decoded JSON value with placeholders
+// replaced in every string
+func substitute(value interface{}, replacer *strings.Replacer) interface{} {
+	switch v := value.(type) {
+	case string:
+		return replacer.Replace(v)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = substitute(item, replacer)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = substitute(item, replacer)
+		}
+		return copied
+	default:
+		return v
+	}
+}
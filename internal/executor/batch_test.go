@@ -0,0 +1,146 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// touchTask returns a command task creating a file, to detect tasks that ran
+func touchTask(path string) *Task {
+	return &Task{Type: TaskTypeCommand, Command: "touch", Args: []string{path}}
+}
+
+// assertNotRun fails if any of the files was created
+func assertNotRun(t *testing.T, paths ...string) {
+	t.Helper()
+	time.Sleep(200 * time.Millisecond)
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("task creating %s ran", filepath.Base(path))
+		}
+	}
+}
+
+func TestSubmitBatchLargerThanQueue(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.QueueSize = 3
+	e := startExecutor(t, cfg)
+	dir := t.TempDir()
+
+	var tasks []*Task
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		tasks = append(tasks, touchTask(path))
+		paths = append(paths, path)
+	}
+
+	_, err := e.SubmitBatch("too-big", tasks, false)
+	var admissionErr *AdmissionError
+	if !errors.As(err, &admissionErr) {
+		t.Fatalf("SubmitBatch() error = %v, want an AdmissionError", err)
+	}
+	if len(e.ListTasks()) != 0 || len(e.ListBatches()) != 0 {
+		t.Error("rejected batch left tasks or a batch behind")
+	}
+	assertNotRun(t, paths...)
+}
+
+func TestSubmitBatchRejectedLate(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Admission.Enabled = true
+	cfg.Admission.Quotas = map[string]int{"script": 1}
+	e := startExecutor(t, cfg)
+	dir := t.TempDir()
+
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	tasks := []*Task{
+		touchTask(first),
+		touchTask(second),
+		{Type: TaskTypeScript, Command: "true"},
+		{Type: TaskTypeScript, Command: "true"},
+	}
+
+	if _, err := e.SubmitBatch("quota", tasks, false); err == nil {
+		t.Fatal("SubmitBatch() succeeded beyond the script quota")
+	}
+	assertNotRun(t, first, second)
+	for _, task := range tasks[:3] {
+		waitForStatus(t, e, task.ID, TaskStatusCancelled)
+	}
+	if len(e.ListBatches()) != 0 {
+		t.Error("rejected batch is still listed")
+	}
+}
+
+func TestBatchFailFast(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+
+	tasks := []*Task{
+		{Type: TaskTypeCommand, Command: "false"},
+		sleepTask(30 * time.Second),
+	}
+	batch, err := e.SubmitBatch("fail-fast", tasks, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, e, tasks[1].ID, TaskStatusCancelled)
+	var status *BatchStatus
+	waitFor(t, "the batch to finish", func() bool {
+		status, err = e.GetBatch(batch.ID)
+		return err == nil && status.Status != "running"
+	})
+	if status.Status != "cancelled" || status.Total != 2 || status.Counts[TaskStatusFailed] != 1 {
+		t.Errorf("batch status = %s, total %d, counts %v", status.Status, status.Total, status.Counts)
+	}
+	if tasks[1].Metadata["batch_id"] != batch.ID {
+		t.Errorf("task metadata batch_id = %v, want %s", tasks[1].Metadata["batch_id"], batch.ID)
+	}
+}
+
+func TestExpandMatrix(t *testing.T) {
+	template := map[string]interface{}{
+		"type":    "command",
+		"command": "deploy {{matrix.host}}",
+		"args":    []interface{}{"--count={{matrix.count}}"},
+	}
+	expanded, err := ExpandMatrix(template, map[string][]interface{}{
+		"host":  {"a", "b"},
+		"count": {1.0, 3.0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][2]string
+	for _, data := range expanded {
+		got = append(got, [2]string{data["command"].(string), data["args"].([]interface{})[0].(string)})
+		if data["metadata"].(map[string]interface{})["matrix"] == nil {
+			t.Error("expanded task has no matrix metadata")
+		}
+	}
+	want := [][2]string{
+		{"deploy a", "--count=1"},
+		{"deploy b", "--count=1"},
+		{"deploy a", "--count=3"},
+		{"deploy b", "--count=3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandMatrix() = %v, want %v", got, want)
+	}
+	if template["command"] != "deploy {{matrix.host}}" {
+		t.Error("ExpandMatrix() modified the template")
+	}
+
+	if _, err := ExpandMatrix(template, map[string][]interface{}{"host": {}}); err == nil {
+		t.Error("ExpandMatrix() accepted a key without values")
+	}
+	if _, err := ExpandMatrix(template, map[string][]interface{}{"a": make([]interface{}, 100), "b": make([]interface{}, 11)}); err == nil {
+		t.Error("ExpandMatrix() accepted more than MaxBatchSize tasks")
+	}
+}
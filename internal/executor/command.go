@@ -4,17 +4,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
 	"github.com/sirupsen/logrus"
 )
 
 // CommandExecutor executes command tasks
 type CommandExecutor struct {
-	logger *logrus.Logger
+	logger  *logrus.Logger
+	sandbox *config.SandboxProfile
+	events  *events.Bus
 }
 
 // NewCommandExecutor creates a new command executor
@@ -33,20 +40,23 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *Task, result *TaskR
 	}).Debug("Executing command")
 
 	// Create command
-	cmd := exec.CommandContext(ctx, task.Command, task.Args...)
+	cmd, err := newCommand(ctx, e.sandbox, task.Command, task.Args...)
+	if err != nil {
+		return err
+	}
 
 	// Set working directory
 	if task.WorkingDir != "" {
 		cmd.Dir = task.WorkingDir
 	}
 
-	// Set environment variables
+	// Set environment variables on top of the agent's, so tasks keep PATH
 	if len(task.Env) > 0 {
 		env := make([]string, 0, len(task.Env))
 		for key, value := range task.Env {
 			env = append(env, fmt.Sprintf("%s=%s", key, value))
 		}
-		cmd.Env = append(cmd.Env, env...)
+		cmd.Env = append(os.Environ(), env...)
 	}
 
 	// Capture output
@@ -56,7 +66,7 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *Task, result *TaskR
 
 	// Execute command
 	startTime := time.Now()
-	err := cmd.Run()
+	err = runTracked(ctx, cmd, task, e.events)
 	duration := time.Since(startTime)
 
 	// Get exit code
@@ -78,6 +88,9 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *Task, result *TaskR
 	result.Metadata["duration_ms"] = duration.Milliseconds()
 	result.Metadata["command"] = task.Command
 	result.Metadata["args"] = task.Args
+	if task.Sandbox != "" {
+		result.Metadata["sandbox"] = task.Sandbox
+	}
 
 	e.logger.WithFields(logrus.Fields{
 		"task_id":   task.ID,
@@ -85,8 +98,11 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *Task, result *TaskR
 		"duration":  duration,
 	}).Debug("Command execution completed")
 
-	if err != nil && exitCode != 0 {
-		return fmt.Errorf("command failed with exit code %d: %s", exitCode, stderr.String())
+	if err != nil {
+		if exitCode != 0 {
+			return &exitError{kind: "command", code: exitCode, stderr: stderr.String()}
+		}
+		return fmt.Errorf("failed to run command: %w", err)
 	}
 
 	return nil
@@ -94,7 +110,9 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *Task, result *TaskR
 
 // ScriptExecutor executes script tasks
 type ScriptExecutor struct {
-	logger *logrus.Logger
+	logger  *logrus.Logger
+	sandbox *config.SandboxProfile
+	events  *events.Bus
 }
 
 // NewScriptExecutor creates a new script executor
@@ -127,20 +145,23 @@ func (e *ScriptExecutor) Execute(ctx context.Context, task *Task, result *TaskRe
 	}
 
 	// Create command
-	cmd := exec.CommandContext(ctx, interpreter, "-c", task.Command)
+	cmd, err := newCommand(ctx, e.sandbox, interpreter, "-c", task.Command)
+	if err != nil {
+		return err
+	}
 
 	// Set working directory
 	if task.WorkingDir != "" {
 		cmd.Dir = task.WorkingDir
 	}
 
-	// Set environment variables
+	// Set environment variables on top of the agent's, so tasks keep PATH
 	if len(task.Env) > 0 {
 		env := make([]string, 0, len(task.Env))
 		for key, value := range task.Env {
 			env = append(env, fmt.Sprintf("%s=%s", key, value))
 		}
-		cmd.Env = append(cmd.Env, env...)
+		cmd.Env = append(os.Environ(), env...)
 	}
 
 	// Capture output
@@ -150,7 +171,7 @@ func (e *ScriptExecutor) Execute(ctx context.Context, task *Task, result *TaskRe
 
 	// Execute script
 	startTime := time.Now()
-	err := cmd.Run()
+	err = runTracked(ctx, cmd, task, e.events)
 	duration := time.Since(startTime)
 
 	// Get exit code
@@ -171,6 +192,9 @@ func (e *ScriptExecutor) Execute(ctx context.Context, task *Task, result *TaskRe
 	}
 	result.Metadata["duration_ms"] = duration.Milliseconds()
 	result.Metadata["interpreter"] = interpreter
+	if task.Sandbox != "" {
+		result.Metadata["sandbox"] = task.Sandbox
+	}
 
 	e.logger.WithFields(logrus.Fields{
 		"task_id":   task.ID,
@@ -178,16 +202,49 @@ func (e *ScriptExecutor) Execute(ctx context.Context, task *Task, result *TaskRe
 		"duration":  duration,
 	}).Debug("Script execution completed")
 
-	if err != nil && exitCode != 0 {
-		return fmt.Errorf("script failed with exit code %d: %s", exitCode, stderr.String())
+	if err != nil {
+		if exitCode != 0 {
+			return &exitError{kind: "script", code: exitCode, stderr: stderr.String()}
+		}
+		return fmt.Errorf("failed to run script: %w", err)
 	}
 
 	return nil
 }
 
+// PathPolicy checks the paths of file operation tasks against the file
+// access policy
+type PathPolicy interface {
+	Resolve(access fileops.Access, path string) (string, error)
+}
+
+// SetPathPolicy sets the file access policy applied to file operation tasks.
+// It must be called before Start.
+func (e *Executor) SetPathPolicy(policy PathPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.paths = policy
+}
+
+// Deleter removes paths for delete tasks, moving them to the trash when
+// it is enabled
+type Deleter interface {
+	Delete(path, actor string, permanent bool) (*fileops.TrashEntry, error)
+}
+
+// SetDeleter sets what delete tasks remove paths with instead of rm. It
+// must be called before Start.
+func (e *Executor) SetDeleter(deleter Deleter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.deleter = deleter
+}
+
 // FileExecutor executes file operation tasks
 type FileExecutor struct {
-	logger *logrus.Logger
+	logger  *logrus.Logger
+	paths   PathPolicy
+	deleter Deleter
 }
 
 // NewFileExecutor creates a new file executor
@@ -213,32 +270,102 @@ func (e *FileExecutor) Execute(ctx context.Context, task *Task, result *TaskResu
 		if len(args) < 2 {
 			return fmt.Errorf("copy operation requires source and destination")
 		}
-		return e.copyFile(ctx, args[0], args[1], result)
+		src, dst, err := e.resolvePair(fileops.AccessRead, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if err := checkTarget(src, dst, task); err != nil {
+			return err
+		}
+		return e.copyFile(ctx, src, dst, result)
 	case "move":
 		if len(args) < 2 {
 			return fmt.Errorf("move operation requires source and destination")
 		}
-		return e.moveFile(ctx, args[0], args[1], result)
+		src, dst, err := e.resolvePair(fileops.AccessDelete, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if err := checkTarget(src, dst, task); err != nil {
+			return err
+		}
+		return e.moveFile(ctx, src, dst, result)
 	case "delete":
 		if len(args) < 1 {
 			return fmt.Errorf("delete operation requires file path")
 		}
-		return e.deleteFile(ctx, args[0], result)
+		if e.deleter != nil {
+			permanent, _ := task.Metadata["permanent"].(bool)
+			return e.delete(task.ID, args[0], permanent, result)
+		}
+		path, err := e.resolve(fileops.AccessDelete, args[0])
+		if err != nil {
+			return err
+		}
+		return e.deleteFile(ctx, path, result)
 	case "chmod":
 		if len(args) < 2 {
 			return fmt.Errorf("chmod operation requires file path and mode")
 		}
-		return e.chmodFile(ctx, args[0], args[1], result)
+		path, err := e.resolve(fileops.AccessWrite, args[0])
+		if err != nil {
+			return err
+		}
+		return e.chmodFile(ctx, path, args[1], result)
 	case "chown":
 		if len(args) < 2 {
 			return fmt.Errorf("chown operation requires file path and owner")
 		}
-		return e.chownFile(ctx, args[0], args[1], result)
+		path, err := e.resolve(fileops.AccessWrite, args[0])
+		if err != nil {
+			return err
+		}
+		return e.chownFile(ctx, path, args[1], result)
 	default:
 		return fmt.Errorf("unsupported file operation: %s", operation)
 	}
 }
 
+// resolve checks a task path against the file access policy, if one is set
+func (e *FileExecutor) resolve(access fileops.Access, path string) (string, error) {
+	if e.paths == nil {
+		return path, nil
+	}
+	return e.paths.Resolve(access, path)
+}
+
+// resolvePair resolves a source with the given access and a destination
+// with write access
+func (e *FileExecutor) resolvePair(srcAccess fileops.Access, src, dst string) (string, string, error) {
+	src, err := e.resolve(srcAccess, src)
+	if err != nil {
+		return "", "", err
+	}
+	dst, err = e.resolve(fileops.AccessWrite, dst)
+	if err != nil {
+		return "", "", err
+	}
+	return src, dst, nil
+}
+
+// checkTarget refuses to copy or move onto an existing path unless the
+// task's metadata sets overwrite. Like cp and mv, a source copied or moved
+// to a directory lands inside it.
+func checkTarget(src, dst string, task *Task) error {
+	if overwrite, _ := task.Metadata["overwrite"].(bool); overwrite {
+		return nil
+	}
+
+	target := dst
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		target = filepath.Join(dst, filepath.Base(src))
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%w: %s", fileops.ErrFileExists, target)
+	}
+	return nil
+}
+
 func (e *FileExecutor) copyFile(ctx context.Context, src, dst string, result *TaskResult) error {
 	cmd := exec.CommandContext(ctx, "cp", "-r", src, dst)
 	output, err := cmd.CombinedOutput()
@@ -260,6 +387,18 @@ func (e *FileExecutor) deleteFile(ctx context.Context, path string, result *Task
 	return err
 }
 
+// delete removes a path through the deleter
+func (e *FileExecutor) delete(taskID, path string, permanent bool, result *TaskResult) error {
+	entry, err := e.deleter.Delete(path, "task:"+taskID, permanent)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		result.Output = fmt.Sprintf("moved %s to trash as %s\n", path, entry.ID)
+	}
+	return nil
+}
+
 func (e *FileExecutor) chmodFile(ctx context.Context, path, mode string, result *TaskResult) error {
 	cmd := exec.CommandContext(ctx, "chmod", mode, path)
 	output, err := cmd.CombinedOutput()
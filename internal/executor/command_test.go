@@ -0,0 +1,72 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/fileops"
+)
+
+func TestTaskEnvExtendsAgentEnvironment(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+
+	tasks := []*Task{
+		{Type: TaskTypeCommand, Command: "sh", Args: []string{"-c", `echo "$PATH"; echo "$GREETING"`}},
+		{Type: TaskTypeScript, Command: `echo "$PATH"; echo "$GREETING"`},
+	}
+	for _, task := range tasks {
+		task.Env = map[string]string{"GREETING": "hello"}
+		id, err := e.SubmitTask(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForStatus(t, e, id, TaskStatusCompleted)
+
+		e.mu.RLock()
+		output := task.Result.Output
+		e.mu.RUnlock()
+		if want := os.Getenv("PATH") + "\nhello"; strings.TrimSpace(output) != want {
+			t.Errorf("%s task output = %q, want %q", task.Type, output, want)
+		}
+	}
+}
+
+func TestFileTaskTargetNeedsOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.yaml")
+	into := filepath.Join(dir, "into")
+	for _, path := range []string{src, filepath.Join(into, "app.yaml"), filepath.Join(dir, "existing")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		dst       string
+		overwrite bool
+		wantErr   bool
+	}{
+		{filepath.Join(dir, "new"), false, false},
+		{filepath.Join(dir, "existing"), false, true},
+		{filepath.Join(dir, "existing"), true, false},
+		{into, false, true}, // lands on into/app.yaml
+		{into, true, false},
+	}
+
+	for _, tt := range tests {
+		task := &Task{Metadata: map[string]interface{}{"overwrite": tt.overwrite}}
+		err := checkTarget(src, tt.dst, task)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkTarget(%s, overwrite %v) error = %v, want error %v", tt.dst, tt.overwrite, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, fileops.ErrFileExists) {
+			t.Errorf("checkTarget(%s) error = %v, want ErrFileExists", tt.dst, err)
+		}
+	}
+}
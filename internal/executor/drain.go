@@ -0,0 +1,213 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDraining is returned for submissions while the executor is draining
+var ErrDraining = errors.New("executor is draining, not accepting new tasks")
+
+// ErrAlreadyDraining is returned by BeginDrain when a drain is in progress
+var ErrAlreadyDraining = errors.New("executor is already draining")
+
+// drainPollInterval is how often running tasks are checked while draining
+const drainPollInterval = 200 * time.Millisecond
+
+// Drain stops accepting new tasks, hands back every queued task and waits for
+// running tasks to finish. Tasks still running when ctx is done are cancelled.
+// The caller takes over the handed back tasks, including those of a drain
+// already in progress: Undrain does not resubmit them.
+func (e *Executor) Drain(ctx context.Context) ([]*Task, error) {
+	if _, err := e.BeginDrain(); err != nil && !errors.Is(err, ErrAlreadyDraining) {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	handedBack := e.drained
+	e.drained = nil
+	e.mu.Unlock()
+
+	e.AwaitDrain(ctx)
+	return handedBack, nil
+}
+
+// BeginDrain switches the executor into draining mode and removes all queued
+// tasks from the queue. The removed tasks are returned to the caller and are
+// resubmitted if the drain is cancelled with Undrain.
+func (e *Executor) BeginDrain() ([]*Task, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.draining {
+		return nil, ErrAlreadyDraining
+	}
+	e.draining = true
+
+	// Scheduled and pending tasks are handed back first, their watchers
+	// see they are gone
+	var handedBack []*Task
+	for id, task := range e.scheduled {
+		delete(e.scheduled, id)
+		handedBack = append(handedBack, task)
+	}
+	handedBack = append(handedBack, e.held...)
+	e.held = nil
+	for _, task := range handedBack {
+		e.handBack(task)
+	}
+
+	for {
+		select {
+		case task, ok := <-e.taskQueue:
+			if !ok {
+				e.drained = handedBack
+				return handedBack, nil
+			}
+
+			e.handBack(task)
+			handedBack = append(handedBack, task)
+		default:
+			e.drained = handedBack
+			e.logger.WithField("handed_back", len(handedBack)).Info("Executor draining")
+			return handedBack, nil
+		}
+	}
+}
+
+// handBack cancels a task that was taken off the queue while draining. Must
+// be called with e.mu held.
+func (e *Executor) handBack(task *Task) {
+	e.release(task)
+	if task.cancel != nil {
+		task.cancel()
+	}
+	task.Status = TaskStatusCancelled
+	task.PendingReason = ""
+	task.FinishedAt = time.Now()
+	task.Result = &TaskResult{
+		TaskID:     task.ID,
+		Status:     TaskStatusCancelled,
+		Error:      "handed back while draining",
+		FinishedAt: task.FinishedAt,
+		Metadata:   map[string]interface{}{"drained": true},
+	}
+	e.completedTasks[task.ID] = task
+}
+
+// AwaitDrain waits until no worker is busy. Tasks still running when ctx is
+// done are cancelled. It returns the number of cancelled tasks.
+func (e *Executor) AwaitDrain(ctx context.Context) int {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if e.busyWorkers() == 0 {
+			e.logger.Info("Executor drained")
+			return 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			cancelled := e.cancelRunning()
+			e.logger.WithField("cancelled", cancelled).Warn("Drain deadline reached, running tasks cancelled")
+			return cancelled
+		}
+	}
+}
+
+// Undrain resumes accepting task submissions after a drain and resubmits
+// the tasks handed back by BeginDrain. It returns the number of tasks
+// resubmitted; tasks that cannot be resubmitted stay cancelled.
+func (e *Executor) Undrain() int {
+	e.mu.Lock()
+	if !e.draining {
+		e.mu.Unlock()
+		return 0
+	}
+	e.draining = false
+	drained := e.drained
+	e.drained = nil
+	e.mu.Unlock()
+
+	e.logger.Info("Executor accepting tasks again")
+
+	resubmitted := 0
+	for _, task := range drained {
+		if err := e.resubmit(task); err != nil {
+			e.logger.WithError(err).WithField("task_id", task.ID).Error("Failed to resubmit drained task")
+			continue
+		}
+		resubmitted++
+	}
+
+	if len(drained) > 0 {
+		e.logger.WithField("tasks", resubmitted).Info("Resubmitted tasks handed back by drain")
+	}
+	return resubmitted
+}
+
+// resubmit queues a handed back task again, resetting the state recorded
+// when it was handed back. The task keeps its ID.
+func (e *Executor) resubmit(task *Task) error {
+	e.mu.Lock()
+	status, result, finishedAt := task.Status, task.Result, task.FinishedAt
+	task.Status = TaskStatusPending
+	task.Result = nil
+	task.FinishedAt = time.Time{}
+	delete(e.completedTasks, task.ID)
+	e.mu.Unlock()
+
+	if _, err := e.SubmitTask(task); err != nil {
+		e.mu.Lock()
+		task.Status, task.Result, task.FinishedAt = status, result, finishedAt
+		e.completedTasks[task.ID] = task
+		e.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// IsDraining returns whether the executor is draining
+func (e *Executor) IsDraining() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.draining
+}
+
+// busyWorkers returns the number of workers currently executing a task
+func (e *Executor) busyWorkers() int {
+	busy := 0
+	for _, worker := range e.GetWorkerStats() {
+		if worker.Busy {
+			busy++
+		}
+	}
+	return busy
+}
+
+// cancelRunning cancels every running task and returns how many were cancelled
+func (e *Executor) cancelRunning() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	cancelled := 0
+	for _, task := range e.tasks {
+		if (task.Status != TaskStatusRunning && task.Status != TaskStatusPaused) || task.cancel == nil {
+			continue
+		}
+
+		task.cancel()
+		cancelled++
+
+		e.logger.WithFields(logrus.Fields{
+			"task_id": task.ID,
+		}).Warn("Running task cancelled by drain deadline")
+	}
+
+	return cancelled
+}
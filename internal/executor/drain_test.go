@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// drainingExecutor starts an executor running one task with two more queued
+func drainingExecutor(t *testing.T, running time.Duration) (e *Executor, runningID string, queued []string) {
+	e = startExecutor(t, testConfig(t))
+
+	runningID, err := e.SubmitTask(sleepTask(running))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, runningID, TaskStatusRunning)
+
+	for i := 0; i < 2; i++ {
+		id, err := e.SubmitTask(sleepTask(10 * time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued = append(queued, id)
+	}
+	return e, runningID, queued
+}
+
+func TestUndrainResubmitsHandedBackTasks(t *testing.T) {
+	e, running, queued := drainingExecutor(t, 500*time.Millisecond)
+
+	handedBack, err := e.BeginDrain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handedBack) != 2 {
+		t.Fatalf("handed back %d tasks, want 2", len(handedBack))
+	}
+	for _, id := range queued {
+		waitForStatus(t, e, id, TaskStatusCancelled)
+	}
+	if _, err := e.SubmitTask(sleepTask(time.Second)); !errors.Is(err, ErrDraining) {
+		t.Errorf("SubmitTask() while draining error = %v, want ErrDraining", err)
+	}
+	if _, err := e.BeginDrain(); !errors.Is(err, ErrAlreadyDraining) {
+		t.Errorf("second BeginDrain() error = %v, want ErrAlreadyDraining", err)
+	}
+
+	if cancelled := e.AwaitDrain(context.Background()); cancelled != 0 {
+		t.Errorf("AwaitDrain() cancelled %d tasks", cancelled)
+	}
+	waitForStatus(t, e, running, TaskStatusCompleted)
+
+	if resubmitted := e.Undrain(); resubmitted != 2 {
+		t.Errorf("Undrain() resubmitted %d tasks, want 2", resubmitted)
+	}
+	for _, id := range queued {
+		waitForStatus(t, e, id, TaskStatusCompleted)
+	}
+}
+
+func TestDrainTakesOverDrainInProgress(t *testing.T) {
+	e, running, queued := drainingExecutor(t, 500*time.Millisecond)
+
+	if _, err := e.BeginDrain(); err != nil {
+		t.Fatal(err)
+	}
+
+	handedBack, err := e.Drain(context.Background())
+	if err != nil {
+		t.Fatalf("Drain() while draining error = %v", err)
+	}
+	if len(handedBack) != len(queued) {
+		t.Errorf("Drain() returned %d tasks, want the %d handed back earlier", len(handedBack), len(queued))
+	}
+
+	// Drain waits for the running task
+	if busy := e.busyWorkers(); busy != 0 {
+		t.Errorf("%d workers busy after Drain()", busy)
+	}
+	waitForStatus(t, e, running, TaskStatusCompleted)
+
+	if resubmitted := e.Undrain(); resubmitted != 0 {
+		t.Errorf("Undrain() resubmitted %d tasks taken over by Drain", resubmitted)
+	}
+}
+
+func TestDrainCancelsTasksAtDeadline(t *testing.T) {
+	e, running, _ := drainingExecutor(t, 30*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := e.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, running, TaskStatusCancelled)
+}
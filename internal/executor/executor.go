@@ -2,11 +2,15 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -15,17 +19,55 @@ import (
 type Executor struct {
 	config config.ExecutorConfig
 	logger *logrus.Logger
+	events *events.Bus
 
 	// Task management
 	mu            sync.RWMutex
 	tasks         map[string]*Task
 	runningTasks  map[string]*Task
 	completedTasks map[string]*Task
+	batches        map[string]*Batch
+
+	// Admission control
+	loadProvider  LoadProvider
+	rejectedTasks int
+	draining      bool
+	drained       []*Task // handed back by BeginDrain, resubmitted by Undrain
+
+	// Resource-aware scheduling
+	capacity           ResourceRequests
+	capacityMeasuredAt time.Time
+	reserved           ResourceRequests
+	reservations       map[string]ResourceRequests
+	held               []*Task // pending until their requests fit
+
+	// Maintenance windows
+	windows   []*Window
+	scheduled map[string]*Task // held until their windows open
+	auditor   Auditor
+
+	// Task workspaces
+	workspaces *WorkspaceManager
+
+	// File access policy and deleter for file operation tasks
+	paths   PathPolicy
+	deleter Deleter
+
+	// Completion hooks
+	hooks      []Hook
+	hookClient *http.Client
+	hookCtx    context.Context
+	hookCancel context.CancelFunc
+	hookWg     sync.WaitGroup
 
 	// Worker pool
-	workers    []*Worker
-	taskQueue  chan *Task
-	resultChan chan *TaskResult
+	poolMu       sync.Mutex
+	workers      []*Worker
+	minWorkers   int
+	maxWorkers   int
+	nextWorkerID int
+	taskQueue    chan *Task
+	resultChan   chan *TaskResult
 
 	// Lifecycle
 	ctx    context.Context
@@ -35,27 +77,50 @@ type Executor struct {
 
 // Task represents a task to be executed
 type Task struct {
-	ID          string                 `json:"id"`
-	Type        TaskType               `json:"type"`
-	Name        string                 `json:"name"`
-	Command     string                 `json:"command"`
-	Args        []string               `json:"args"`
-	Env         map[string]string      `json:"env"`
-	WorkingDir  string                 `json:"working_dir"`
-	Timeout     time.Duration          `json:"timeout"`
-	Priority    int                    `json:"priority"`
-	Metadata    map[string]interface{} `json:"metadata"`
+	ID                string                 `json:"id"`
+	Type              TaskType               `json:"type"`
+	Name              string                 `json:"name"`
+	Command           string                 `json:"command"`
+	Args              []string               `json:"args"`
+	Env               map[string]string      `json:"env"`
+	WorkingDir        string                 `json:"working_dir"`
+	Workspace         string                 `json:"workspace,omitempty"`           // "ephemeral" or "persistent:<name>"
+	Sandbox           string                 `json:"sandbox,omitempty"`             // name of a configured sandbox profile
+	Success           *SuccessCriteria       `json:"success,omitempty"`             // decides completed/warning/failed, exit 0 by default
+	Timeout           time.Duration          `json:"timeout"`
+	ExcludePausedTime bool                   `json:"exclude_paused_time,omitempty"` // timeout only counts running time
+	Priority          int                    `json:"priority"`
+	Metadata          map[string]interface{} `json:"metadata"`
+	BatchID           string                 `json:"batch_id,omitempty"`
+	Hooks             []Hook                 `json:"hooks,omitempty"`    // run when the task finishes
+	Inputs            []TaskInput            `json:"inputs,omitempty"`   // files staged into the working directory
+	Requests          *ResourceRequests      `json:"requests,omitempty"` // reserved while the task runs
+	OverrideWindows   bool                   `json:"override_windows,omitempty"` // start regardless of maintenance windows, audited
+	OverrideReason    string                 `json:"override_reason,omitempty"`
 	
 	// Execution state
-	Status      TaskStatus    `json:"status"`
-	StartedAt   time.Time     `json:"started_at,omitempty"`
-	FinishedAt  time.Time     `json:"finished_at,omitempty"`
-	Result      *TaskResult   `json:"result,omitempty"`
-	Error       error         `json:"error,omitempty"`
+	Status        TaskStatus    `json:"status"`
+	PendingReason string        `json:"pending_reason,omitempty"` // why a pending or scheduled task has not been queued yet
+	ScheduledAt   time.Time     `json:"scheduled_at,omitempty"`   // when a scheduled task is released
+	CreatedAt     time.Time     `json:"created_at"`
+	StartedAt     time.Time     `json:"started_at,omitempty"`
+	FinishedAt    time.Time     `json:"finished_at,omitempty"`
+	Result        *TaskResult   `json:"result,omitempty"`
+	Error         error         `json:"error,omitempty"`
+	PausedTime    time.Duration `json:"paused_time,omitempty"`
+	Progress      *Progress     `json:"progress,omitempty"` // latest progress reported by the task
 	
 	// Cancellation
 	ctx         context.Context
 	cancel      context.CancelFunc
+
+	// Process control for pause/resume, also guards Progress
+	procMu   sync.Mutex
+	process  *os.Process
+	pausedAt time.Time
+	runTimer *runTimer
+	cgroup   *taskCgroup // nil where the cgroup freezer is unavailable
+	frozen   bool
 }
 
 // TaskType represents the type of task
@@ -76,9 +141,12 @@ type TaskStatus string
 
 const (
 	TaskStatusPending   TaskStatus = "pending"
+	TaskStatusScheduled TaskStatus = "scheduled" // held until a maintenance window opens
 	TaskStatusQueued    TaskStatus = "queued"
 	TaskStatusRunning   TaskStatus = "running"
+	TaskStatusPaused    TaskStatus = "paused"
 	TaskStatusCompleted TaskStatus = "completed"
+	TaskStatusWarning   TaskStatus = "warning" // completed with a degraded exit code
 	TaskStatusFailed    TaskStatus = "failed"
 	TaskStatusCancelled TaskStatus = "cancelled"
 	TaskStatusTimeout   TaskStatus = "timeout"
@@ -98,16 +166,46 @@ type TaskResult struct {
 }
 
 // New creates a new executor instance
-func New(cfg config.ExecutorConfig, logger *logrus.Logger) (*Executor, error) {
+func New(cfg config.ExecutorConfig, bus *events.Bus, logger *logrus.Logger) (*Executor, error) {
+	if err := validateSandboxProfiles(cfg.SandboxProfiles); err != nil {
+		return nil, err
+	}
+
+	hooks, err := hooksFromConfig(cfg.Hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	windows, err := windowsFromConfig(cfg.Windows)
+	if err != nil {
+		return nil, err
+	}
+
 	executor := &Executor{
 		config:         cfg,
 		logger:         logger,
+		events:         bus,
 		tasks:          make(map[string]*Task),
 		runningTasks:   make(map[string]*Task),
 		completedTasks: make(map[string]*Task),
+		batches:        make(map[string]*Batch),
+		reservations:   make(map[string]ResourceRequests),
+		windows:        windows,
+		scheduled:      make(map[string]*Task),
 		taskQueue:      make(chan *Task, cfg.QueueSize),
 		resultChan:     make(chan *TaskResult, cfg.QueueSize),
-		workers:        make([]*Worker, cfg.WorkerPoolSize),
+		workers:        make([]*Worker, 0, cfg.MaxWorkers),
+		minWorkers:     cfg.WorkerPoolSize,
+		maxWorkers:     cfg.MaxWorkers,
+		workspaces:     NewWorkspaceManager(cfg.Workspace, logger),
+		hooks:          hooks,
+		hookClient:     &http.Client{},
+	}
+	executor.hookCtx, executor.hookCancel = context.WithCancel(context.Background())
+
+	if cfg.Resources.Enabled {
+		executor.capacity = measureCapacity(cfg.Resources)
+		executor.capacityMeasuredAt = time.Now()
 	}
 
 	return executor, nil
@@ -123,29 +221,34 @@ func (e *Executor) Start(ctx context.Context) error {
 	e.ctx, e.cancel = context.WithCancel(ctx)
 
 	// Start workers
-	for i := 0; i < e.config.WorkerPoolSize; i++ {
-		worker := NewWorker(i, e.taskQueue, e.resultChan, e.logger)
-		e.workers[i] = worker
-
-		e.wg.Add(1)
-		go func(w *Worker) {
-			defer e.wg.Done()
-			w.Start(e.ctx)
-		}(worker)
+	e.poolMu.Lock()
+	for len(e.workers) < e.minWorkers {
+		e.spawnWorker()
 	}
+	workerCount := len(e.workers)
+	e.poolMu.Unlock()
 
-	// Start result handler
-	e.wg.Add(1)
+	// Start result handler, pool scaler and workspace cleanup
+	e.wg.Add(3)
 	go e.handleResults()
+	go e.scaleLoop()
+	go e.workspaceLoop()
 
-	e.logger.WithField("workers", e.config.WorkerPoolSize).Info("Task executor started")
+	if e.config.Resources.Enabled {
+		e.logger.WithFields(logrus.Fields{
+			"cpu":    formatCPU(e.capacity.CPU),
+			"memory": formatBytes(e.capacity.Memory),
+			"disk":   formatBytes(e.capacity.Disk),
+		}).Info("Resource-aware scheduling enabled")
+	}
+
+	e.logger.WithField("workers", workerCount).Info("Task executor started")
 	return nil
 }
 
 // Stop stops the executor and all workers
 func (e *Executor) Stop(ctx context.Context) error {
 	e.mu.Lock()
-	defer e.mu.Unlock()
 
 	e.logger.Info("Stopping task executor")
 
@@ -156,19 +259,44 @@ func (e *Executor) Stop(ctx context.Context) error {
 		}
 	}
 
-	// Cancel context
+	// Cancel pending and scheduled tasks, whose contexts may come from
+	// ExecuteTask callers
+	for _, task := range e.held {
+		task.cancel()
+	}
+	for _, task := range e.scheduled {
+		task.cancel()
+	}
+
+	// Cancel context, no workers are spawned after this
+	e.poolMu.Lock()
 	if e.cancel != nil {
 		e.cancel()
 	}
+	e.poolMu.Unlock()
 
 	// Close channels
 	close(e.taskQueue)
+	e.mu.Unlock()
 
 	// Wait for workers to finish
 	e.wg.Wait()
 
 	close(e.resultChan)
 
+	// Give pending hook deliveries until the stop deadline
+	hooksDone := make(chan struct{})
+	go func() {
+		e.hookWg.Wait()
+		close(hooksDone)
+	}()
+	select {
+	case <-hooksDone:
+	case <-ctx.Done():
+		e.logger.Warn("Abandoning pending hook deliveries")
+	}
+	e.hookCancel()
+
 	e.logger.Info("Task executor stopped")
 	return nil
 }
@@ -182,7 +310,7 @@ func (e *Executor) Name() string {
 func (e *Executor) ExecuteTask(ctx context.Context, task *Task) (*TaskResult, error) {
 	// Validate task
 	if err := e.validateTask(task); err != nil {
-		return nil, fmt.Errorf("invalid task: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTask, err)
 	}
 
 	// Set task ID if not provided
@@ -195,18 +323,11 @@ func (e *Executor) ExecuteTask(ctx context.Context, task *Task) (*TaskResult, er
 		task.Timeout = e.config.TaskTimeout
 	}
 
-	// Create task context with timeout
-	taskCtx, cancel := context.WithTimeout(ctx, task.Timeout)
-	task.ctx = taskCtx
-	task.cancel = cancel
-
-	// Set initial status
-	task.Status = TaskStatusQueued
-
-	// Store task
-	e.mu.Lock()
-	e.tasks[task.ID] = task
-	e.mu.Unlock()
+	// Admit and queue task
+	if err := e.enqueue(ctx, task); err != nil {
+		return nil, err
+	}
+	taskCtx, cancel := task.ctx, task.cancel
 
 	e.logger.WithFields(logrus.Fields{
 		"task_id": task.ID,
@@ -214,22 +335,10 @@ func (e *Executor) ExecuteTask(ctx context.Context, task *Task) (*TaskResult, er
 		"name":    task.Name,
 	}).Info("Task queued for execution")
 
-	// Queue task
-	select {
-	case e.taskQueue <- task:
-		// Task queued successfully
-	case <-ctx.Done():
-		cancel()
-		return nil, ctx.Err()
-	case <-time.After(5 * time.Second):
-		cancel()
-		return nil, fmt.Errorf("task queue is full")
-	}
-
 	// Wait for result
 	select {
 	case <-taskCtx.Done():
-		if taskCtx.Err() == context.DeadlineExceeded {
+		if task.timedOut() {
 			task.Status = TaskStatusTimeout
 			return &TaskResult{
 				TaskID:     task.ID,
@@ -249,7 +358,7 @@ func (e *Executor) ExecuteTask(ctx context.Context, task *Task) (*TaskResult, er
 func (e *Executor) SubmitTask(task *Task) (string, error) {
 	// Validate task
 	if err := e.validateTask(task); err != nil {
-		return "", fmt.Errorf("invalid task: %w", err)
+		return "", fmt.Errorf("%w: %w", ErrInvalidTask, err)
 	}
 
 	// Set task ID if not provided
@@ -262,33 +371,95 @@ func (e *Executor) SubmitTask(task *Task) (string, error) {
 		task.Timeout = e.config.TaskTimeout
 	}
 
-	// Create task context with timeout
-	taskCtx, cancel := context.WithTimeout(e.ctx, task.Timeout)
+	// Admit and queue task
+	if err := e.enqueue(e.ctx, task); err != nil {
+		return "", err
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"task_id": task.ID,
+		"type":    task.Type,
+		"name":    task.Name,
+	}).Info("Task submitted for execution")
+
+	return task.ID, nil
+}
+
+// enqueue runs admission control and places a task on the queue without
+// blocking. A full queue is reported as an AdmissionError.
+func (e *Executor) enqueue(parent context.Context, task *Task) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enqueueLocked(parent, task)
+}
+
+// enqueueLocked is enqueue for callers holding e.mu
+func (e *Executor) enqueueLocked(parent context.Context, task *Task) error {
+	// Create task context. The worker enforces the timeout from when the
+	// task starts, so time spent waiting in the queue does not count.
+	taskCtx, cancel := context.WithCancel(parent)
 	task.ctx = taskCtx
 	task.cancel = cancel
 
 	// Set initial status
 	task.Status = TaskStatusQueued
+	if task.CreatedAt.IsZero() {
+		task.CreatedAt = time.Now()
+	}
 
-	// Store task
-	e.mu.Lock()
-	e.tasks[task.ID] = task
-	e.mu.Unlock()
+	if e.ctx == nil || e.ctx.Err() != nil {
+		cancel()
+		return fmt.Errorf("executor is not running")
+	}
 
-	e.logger.WithFields(logrus.Fields{
-		"task_id": task.ID,
-		"type":    task.Type,
-		"name":    task.Name,
-	}).Info("Task submitted for execution")
+	if e.draining {
+		cancel()
+		return ErrDraining
+	}
 
-	// Queue task
+	// Hold the task until its maintenance windows allow it to start
+	scheduled, err := e.gateWindows(parent, task)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if scheduled {
+		return nil
+	}
+
+	return e.queue(task)
+}
+
+// queue runs admission control and resource scheduling and places a task on
+// the queue without blocking. Must be called with e.mu held.
+func (e *Executor) queue(task *Task) error {
+	if err := e.admit(task); err != nil {
+		task.cancel()
+		return err
+	}
+
+	// Hold the task until its resource requests fit
+	held, err := e.schedule(task)
+	if err != nil {
+		task.cancel()
+		return err
+	}
+	if held {
+		return nil
+	}
+
+	// Queue and store task
 	select {
 	case e.taskQueue <- task:
-		return task.ID, nil
-	case <-time.After(5 * time.Second):
-		cancel()
-		return "", fmt.Errorf("task queue is full")
+		e.tasks[task.ID] = task
+	default:
+		e.release(task)
+		task.cancel()
+		return e.reject(task, "task queue is full")
 	}
+
+	e.publishQueued(task)
+	return nil
 }
 
 // GetTask retrieves a task by ID
@@ -320,15 +491,15 @@ func (e *Executor) GetTaskResult(taskID string) (*TaskResult, error) {
 
 // CancelTask cancels a running task
 func (e *Executor) CancelTask(taskID string) error {
-	e.mu.RLock()
-	task, exists := e.tasks[taskID]
-	e.mu.RUnlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
+	task, exists := e.tasks[taskID]
 	if !exists {
 		return fmt.Errorf("task not found: %s", taskID)
 	}
 
-	if task.Status != TaskStatusRunning && task.Status != TaskStatusQueued {
+	if task.Status != TaskStatusRunning && task.Status != TaskStatusQueued && task.Status != TaskStatusPaused && task.Status != TaskStatusPending && task.Status != TaskStatusScheduled {
 		return fmt.Errorf("task cannot be cancelled (status: %s)", task.Status)
 	}
 
@@ -370,6 +541,18 @@ func (e *Executor) ListRunningTasks() []*Task {
 
 // GetStats returns executor statistics
 func (e *Executor) GetStats() map[string]interface{} {
+	workers := e.GetWorkerStats()
+	busy := 0
+	for _, worker := range workers {
+		if worker.Busy {
+			busy++
+		}
+	}
+
+	e.poolMu.Lock()
+	minWorkers, maxWorkers := e.minWorkers, e.maxWorkers
+	e.poolMu.Unlock()
+
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
@@ -377,8 +560,18 @@ func (e *Executor) GetStats() map[string]interface{} {
 		"total_tasks":     len(e.tasks),
 		"running_tasks":   len(e.runningTasks),
 		"completed_tasks": len(e.completedTasks),
+		"rejected_tasks":  e.rejectedTasks,
+		"pending_tasks":   len(e.held),
+		"scheduled_tasks": len(e.scheduled),
+		"draining":        e.draining,
 		"queue_size":      len(e.taskQueue),
-		"worker_count":    len(e.workers),
+		"worker_count":    len(workers),
+		"busy_workers":    busy,
+		"min_workers":     minWorkers,
+		"max_workers":     maxWorkers,
+		"workers":         workers,
+		"workspaces":      e.workspaces.GetStats(),
+		"resources":       e.resourceStatus(),
 	}
 }
 
@@ -420,11 +613,35 @@ func (e *Executor) processResult(result *TaskResult) {
 	delete(e.runningTasks, task.ID)
 	e.completedTasks[task.ID] = task
 
+	// Hand the freed resources to pending tasks
+	e.release(task)
+	e.dispatchHeld()
+
+	// Remove inputs of tasks that finished without staging them
+	releaseInputs(task)
+
 	// Cancel task context
 	if task.cancel != nil {
 		task.cancel()
 	}
 
+	if task.BatchID != "" {
+		e.failFast(task)
+	}
+
+	e.fireHooks(task, result)
+
+	e.events.Publish(events.TaskFinished{
+		TaskID:     task.ID,
+		TaskType:   string(task.Type),
+		Name:       task.Name,
+		Status:     string(result.Status),
+		ExitCode:   result.ExitCode,
+		Error:      result.Error,
+		FinishedAt: result.FinishedAt,
+		Duration:   result.Duration,
+	})
+
 	e.logger.WithFields(logrus.Fields{
 		"task_id":  task.ID,
 		"status":   result.Status,
@@ -432,6 +649,19 @@ func (e *Executor) processResult(result *TaskResult) {
 	}).Info("Task completed")
 }
 
+// publishQueued publishes a TaskQueued event for a task
+func (e *Executor) publishQueued(task *Task) {
+	e.events.Publish(events.TaskQueued{
+		TaskID:   task.ID,
+		TaskType: string(task.Type),
+		Name:     task.Name,
+		Priority: task.Priority,
+	})
+}
+
+// ErrInvalidTask wraps validation errors of submitted tasks
+var ErrInvalidTask = errors.New("invalid task")
+
 // validateTask validates a task
 func (e *Executor) validateTask(task *Task) error {
 	if task == nil {
@@ -446,6 +676,40 @@ func (e *Executor) validateTask(task *Task) error {
 		return fmt.Errorf("command is required for command tasks")
 	}
 
+	if err := validateTaskID(task.ID); err != nil {
+		return err
+	}
+
+	if err := validateWorkspace(task.Workspace); err != nil {
+		return err
+	}
+
+	if _, err := sandboxProfile(e.config.SandboxProfiles, task); err != nil {
+		return err
+	}
+
+	if task.Success != nil {
+		if err := task.Success.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateInputs(task.Inputs, e.config.InputsDir); err != nil {
+		return err
+	}
+
+	if task.Requests != nil {
+		if err := task.Requests.validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range task.Hooks {
+		if err := task.Hooks[i].validate(); err != nil {
+			return fmt.Errorf("hook %d: %w", i, err)
+		}
+	}
+
 	return nil
 }
 
@@ -497,11 +761,70 @@ func ParseTask(data map[string]interface{}) (*Task, error) {
 		task.WorkingDir = workingDir
 	}
 
+	// Parse workspace
+	if workspace, ok := data["workspace"].(string); ok {
+		task.Workspace = workspace
+	}
+
+	// Parse sandbox profile
+	if sandbox, ok := data["sandbox"].(string); ok {
+		task.Sandbox = sandbox
+	}
+
+	// Parse success criteria
+	if success, ok := data["success"]; ok && success != nil {
+		criteria, err := ParseSuccessCriteria(success)
+		if err != nil {
+			return nil, err
+		}
+		task.Success = criteria
+	}
+
+	// Parse completion hooks
+	if hooks, ok := data["hooks"]; ok && hooks != nil {
+		parsed, err := ParseHooks(hooks)
+		if err != nil {
+			return nil, err
+		}
+		task.Hooks = parsed
+	}
+
+	// Parse input declarations, the files are attached by the API
+	if inputs, ok := data["inputs"]; ok && inputs != nil {
+		parsed, err := ParseTaskInputs(inputs)
+		if err != nil {
+			return nil, err
+		}
+		task.Inputs = parsed
+	}
+
+	// Parse resource requests
+	if requests, ok := data["requests"]; ok && requests != nil {
+		parsed, err := ParseResourceRequests(requests)
+		if err != nil {
+			return nil, err
+		}
+		task.Requests = parsed
+	}
+
+	// Parse maintenance window override
+	if override, ok := data["override_windows"].(bool); ok {
+		task.OverrideWindows = override
+	}
+	if reason, ok := data["override_reason"].(string); ok {
+		task.OverrideReason = reason
+	}
+
 	// Parse timeout
 	if timeout, ok := data["timeout"].(float64); ok {
 		task.Timeout = time.Duration(timeout) * time.Second
 	}
 
+	// Parse paused time handling
+	if exclude, ok := data["exclude_paused_time"].(bool); ok {
+		task.ExcludePausedTime = exclude
+	}
+
 	// Parse priority
 	if priority, ok := data["priority"].(float64); ok {
 		task.Priority = int(priority)
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// testConfig returns a config for a small executor using temp directories
+func testConfig(t *testing.T) config.ExecutorConfig {
+	dir := t.TempDir()
+	return config.ExecutorConfig{
+		TaskTimeout:    time.Minute,
+		WorkerPoolSize: 1,
+		MaxWorkers:     1,
+		QueueSize:      10,
+		DrainTimeout:   10 * time.Second,
+		InputsDir:      dir + "/inputs",
+		Workspace: config.WorkspaceConfig{
+			EphemeralDir:  dir + "/ephemeral",
+			PersistentDir: dir + "/persistent",
+			Retention:     time.Hour,
+		},
+	}
+}
+
+// startExecutor starts an executor that is stopped when the test ends
+func startExecutor(t *testing.T, cfg config.ExecutorConfig) *Executor {
+	t.Helper()
+	e, err := New(cfg, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if e.ctx.Err() == nil {
+			stopExecutor(t, e)
+		}
+	})
+	return e
+}
+
+// stopExecutor stops an executor and fails the test if Stop hangs
+func stopExecutor(t *testing.T, e *Executor) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		e.Stop(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
+
+// sleepTask returns a command task running for d
+func sleepTask(d time.Duration) *Task {
+	return &Task{Type: TaskTypeCommand, Command: "sleep", Args: []string{strconv.FormatFloat(d.Seconds(), 'f', -1, 64)}}
+}
+
+// waitForStatus waits until a task has one of the given statuses
+func waitForStatus(t *testing.T, e *Executor, taskID string, statuses ...TaskStatus) *Task {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		e.mu.RLock()
+		task := e.tasks[taskID]
+		var status TaskStatus
+		if task != nil {
+			status = task.Status
+		}
+		e.mu.RUnlock()
+
+		for _, want := range statuses {
+			if status == want {
+				return task
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s has status %q, want one of %v", taskID, status, statuses)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitFor polls cond until it holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
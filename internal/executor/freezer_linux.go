@@ -0,0 +1,271 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// freezeTimeout bounds waiting for the kernel to report a cgroup frozen
+const freezeTimeout = 2 * time.Second
+
+// taskCgroup is a cgroup holding the processes of one task so the freezer
+// can suspend them without sending them signals. Both the cgroup v2 freezer
+// and the v1 freezer controller are supported.
+type taskCgroup struct {
+	dir    string
+	parent string // the agent's cgroup
+	v2     bool
+}
+
+// newTaskCgroup creates a cgroup for a task below the agent's own cgroup
+// and moves the task's process into it. It fails where no freezer is
+// available or cgroups cannot be created.
+func newTaskCgroup(taskID string, pid int) (*taskCgroup, error) {
+	parents, err := freezerParents()
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("no cgroup freezer available")
+	for _, cg := range parents {
+		cg.dir = filepath.Join(cg.parent, "ducla-task-"+taskID)
+		if err = os.Mkdir(cg.dir, 0755); err != nil {
+			err = fmt.Errorf("failed to create cgroup: %w", err)
+			continue
+		}
+		// The cgroup v2 freezer needs Linux 5.2
+		if _, err = os.Stat(filepath.Join(cg.dir, cg.control())); err != nil {
+			os.Remove(cg.dir)
+			continue
+		}
+		if err = cg.add(pid); err != nil {
+			os.Remove(cg.dir)
+			continue
+		}
+		return cg, nil
+	}
+	return nil, err
+}
+
+// control returns the file controlling the freezer
+func (cg *taskCgroup) control() string {
+	if cg.v2 {
+		return "cgroup.freeze"
+	}
+	return "freezer.state"
+}
+
+// freeze moves every process of the task's process group into the cgroup,
+// catching children forked before the leader was moved, and freezes it
+func (cg *taskCgroup) freeze(pgid int) error {
+	for _, pid := range processGroupMembers(pgid) {
+		cg.add(pid) // the process may have exited meanwhile
+	}
+
+	if cg.v2 {
+		return cg.setState("1", "cgroup.events", "frozen 1")
+	}
+	return cg.setState("FROZEN", "freezer.state", "FROZEN")
+}
+
+// thaw lets the task's processes run again
+func (cg *taskCgroup) thaw() error {
+	if cg.v2 {
+		return cg.setState("0", "cgroup.events", "frozen 0")
+	}
+	return cg.setState("THAWED", "freezer.state", "THAWED")
+}
+
+// remove thaws the cgroup, moves processes that outlived the task back to
+// the parent cgroup and removes the task's cgroup
+func (cg *taskCgroup) remove() error {
+	cg.thaw()
+
+	if data, err := os.ReadFile(filepath.Join(cg.dir, "cgroup.procs")); err == nil {
+		for _, field := range strings.Fields(string(data)) {
+			writeCgroupFile(filepath.Join(cg.parent, "cgroup.procs"), field)
+		}
+	}
+	return os.Remove(cg.dir)
+}
+
+// add moves a process into the cgroup
+func (cg *taskCgroup) add(pid int) error {
+	if err := writeCgroupFile(filepath.Join(cg.dir, "cgroup.procs"), strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to move process %d to cgroup: %w", pid, err)
+	}
+	return nil
+}
+
+// setState writes a freezer state and waits until the kernel reports it
+func (cg *taskCgroup) setState(value, stateFile, want string) error {
+	if err := writeCgroupFile(filepath.Join(cg.dir, cg.control()), value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", cg.control(), err)
+	}
+
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		data, err := os.ReadFile(filepath.Join(cg.dir, stateFile))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", stateFile, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == want {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cgroup did not reach %q", want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// freezerParents returns the agent's own cgroups in the hierarchies that
+// may have a freezer, cgroup v2 first
+func freezerParents() ([]*taskCgroup, error) {
+	paths, err := readCgroupPaths("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := readCgroupMounts("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []*taskCgroup
+	for _, key := range []string{"", "freezer"} {
+		mount, mounted := mounts[key]
+		path, ok := paths[key]
+		if mounted && ok {
+			parents = append(parents, &taskCgroup{parent: mount.dir(path), v2: key == ""})
+		}
+	}
+	return parents, nil
+}
+
+// cgroupMount is a mounted cgroup hierarchy
+type cgroupMount struct {
+	root  string // cgroup path mounted
+	point string // where it is mounted
+}
+
+// dir returns the directory of a cgroup path in the mounted hierarchy
+func (m cgroupMount) dir(path string) string {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = "."
+	}
+	return filepath.Join(m.point, rel)
+}
+
+// readCgroupPaths reads a process's cgroup paths by controller. The cgroup
+// v2 path is keyed by the empty string.
+func readCgroupPaths(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// readCgroupMounts reads the mounted cgroup hierarchies by controller,
+// keeping the cgroup v2 hierarchy under the empty string
+func readCgroupMounts(file string) (map[string]cgroupMount, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts := make(map[string]cgroupMount)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// ID parent major:minor root mount-point options [optional...] - type source super-options
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+4 {
+			continue
+		}
+
+		mount := cgroupMount{root: fields[3], point: fields[4]}
+		switch fields[sep+1] {
+		case "cgroup2":
+			mounts[""] = mount
+		case "cgroup":
+			for _, option := range strings.Split(fields[sep+3], ",") {
+				if option == "freezer" {
+					mounts["freezer"] = mount
+				}
+			}
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// processGroupMembers returns the processes in a process group
+func processGroupMembers(pgid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid pgrp ...; comm may contain spaces
+		stat := string(data)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 3 {
+			continue
+		}
+		if pgrp, err := strconv.Atoi(fields[2]); err == nil && pgrp == pgid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// writeCgroupFile writes a value to a cgroup control file
+func writeCgroupFile(file, value string) error {
+	return os.WriteFile(file, []byte(value), 0)
+}
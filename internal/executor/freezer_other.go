@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import "fmt"
+
+// taskCgroup is unavailable: the cgroup freezer is Linux only
+type taskCgroup struct{}
+
+// newTaskCgroup fails: tasks are paused with signals instead
+func newTaskCgroup(taskID string, pid int) (*taskCgroup, error) {
+	return nil, fmt.Errorf("no cgroup freezer available")
+}
+
+func (cg *taskCgroup) freeze(pgid int) error { return fmt.Errorf("no cgroup freezer available") }
+func (cg *taskCgroup) thaw() error           { return nil }
+func (cg *taskCgroup) remove() error         { return nil }
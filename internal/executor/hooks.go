@@ -0,0 +1,339 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHookRetries = 3
+	defaultHookTimeout = 10 * time.Second
+	hookRetryBackoff   = time.Second
+
+	// HookSignatureHeader carries the HMAC-SHA256 of the webhook body
+	HookSignatureHeader = "X-Ducla-Signature"
+
+	// hookParentKey marks tasks submitted by a follow-up hook
+	hookParentKey = "hook_parent"
+)
+
+// Hook is notified when a task reaches one of the listed statuses, either by
+// a webhook or by submitting a follow-up task
+type Hook struct {
+	URL        string                 `json:"url,omitempty"`
+	Secret     string                 `json:"-"` // HMAC key, never serialized
+	Headers    map[string]string      `json:"headers,omitempty"`
+	On         []TaskStatus           `json:"on,omitempty"`          // final statuses that fire the hook, all if empty
+	MaxRetries *int                   `json:"max_retries,omitempty"` // defaults to 3, 0 disables retries
+	Timeout    time.Duration          `json:"timeout,omitempty"`
+	Task       map[string]interface{} `json:"task,omitempty"` // follow-up task
+}
+
+// HookDelivery records one attempt to run a hook
+type HookDelivery struct {
+	Hook       string    `json:"hook"` // webhook URL or "task"
+	Status     string    `json:"status"`
+	Attempt    int       `json:"attempt"`
+	Success    bool      `json:"success"`
+	StatusCode int       `json:"status_code,omitempty"`
+	TaskID     string    `json:"task_id,omitempty"` // follow-up task
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// HookPayload is the JSON body posted to webhooks
+type HookPayload struct {
+	Event     string      `json:"event"`
+	Delivery  string      `json:"delivery"`
+	Timestamp time.Time   `json:"timestamp"`
+	TaskID    string      `json:"task_id"`
+	TaskType  TaskType    `json:"task_type"`
+	Name      string      `json:"name"`
+	Status    TaskStatus  `json:"status"`
+	BatchID   string      `json:"batch_id,omitempty"`
+	Result    *TaskResult `json:"result"`
+}
+
+// ParseHooks parses task hooks from decoded JSON. The timeout is in seconds.
+func ParseHooks(data interface{}) ([]Hook, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hooks: %w", err)
+	}
+
+	var specs []struct {
+		Hook
+		Secret  string  `json:"secret"`
+		Timeout float64 `json:"timeout"`
+	}
+	if err := json.Unmarshal(encoded, &specs); err != nil {
+		return nil, fmt.Errorf("invalid hooks: %w", err)
+	}
+
+	hooks := make([]Hook, len(specs))
+	for i, spec := range specs {
+		hooks[i] = spec.Hook
+		hooks[i].Secret = spec.Secret
+		hooks[i].Timeout = time.Duration(spec.Timeout * float64(time.Second))
+	}
+
+	return hooks, nil
+}
+
+// hooksFromConfig converts the global hooks of the executor configuration
+func hooksFromConfig(configs []config.HookConfig) ([]Hook, error) {
+	hooks := make([]Hook, 0, len(configs))
+	for i, cfg := range configs {
+		hook := Hook{
+			URL:        cfg.URL,
+			Secret:     cfg.Secret,
+			Headers:    cfg.Headers,
+			MaxRetries: cfg.MaxRetries,
+			Timeout:    cfg.Timeout,
+			Task:       cfg.Task,
+		}
+		for _, status := range cfg.On {
+			hook.On = append(hook.On, TaskStatus(status))
+		}
+
+		if err := hook.validate(); err != nil {
+			return nil, fmt.Errorf("hook %d: %w", i, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// validate checks that a hook has exactly one target and valid statuses
+func (h *Hook) validate() error {
+	if (h.URL == "") == (h.Task == nil) {
+		return errors.New("hook needs either a url or a task")
+	}
+
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid hook url: %s", h.URL)
+		}
+	}
+
+	for _, status := range h.On {
+		if !finalStatus(status) {
+			return fmt.Errorf("hooks can only fire on final statuses, not %s", status)
+		}
+	}
+
+	return nil
+}
+
+// fires reports whether the hook is interested in a status
+func (h *Hook) fires(status TaskStatus) bool {
+	return len(h.On) == 0 || containsStatus(h.On, status)
+}
+
+func finalStatus(status TaskStatus) bool {
+	switch status {
+	case TaskStatusCompleted, TaskStatusWarning, TaskStatusFailed, TaskStatusCancelled, TaskStatusTimeout:
+		return true
+	}
+	return false
+}
+
+// fireHooks runs the global and task hooks for a finished task in the
+// background. Must be called with the executor lock held. Tasks submitted by
+// a hook only run their own hooks, so global follow-up hooks cannot loop.
+func (e *Executor) fireHooks(task *Task, result *TaskResult) {
+	hooks := make([]Hook, 0, len(e.hooks)+len(task.Hooks))
+	if _, triggered := task.Metadata[hookParentKey]; !triggered {
+		hooks = append(hooks, e.hooks...)
+	}
+	hooks = append(hooks, task.Hooks...)
+
+	var matching []Hook
+	for _, hook := range hooks {
+		if hook.fires(result.Status) {
+			matching = append(matching, hook)
+		}
+	}
+	if len(matching) == 0 {
+		return
+	}
+
+	payload := HookPayload{
+		Event:    "task.finished",
+		TaskID:   task.ID,
+		TaskType: task.Type,
+		Name:     task.Name,
+		Status:   result.Status,
+		BatchID:  task.BatchID,
+		Result:   result,
+	}
+
+	e.hookWg.Add(1)
+	go func() {
+		defer e.hookWg.Done()
+		for _, hook := range matching {
+			e.runHook(task, hook, payload)
+		}
+	}()
+}
+
+// runHook delivers a webhook with retries, or submits a follow-up task
+func (e *Executor) runHook(task *Task, hook Hook, payload HookPayload) {
+	if hook.Task != nil {
+		e.recordDelivery(task, e.submitFollowUp(task, hook, payload.Status))
+		return
+	}
+
+	retries := defaultHookRetries
+	if hook.MaxRetries != nil && *hook.MaxRetries >= 0 {
+		retries = *hook.MaxRetries
+	}
+
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		delivery := e.deliverWebhook(hook, payload, attempt)
+		e.recordDelivery(task, delivery)
+		if delivery.Success {
+			return
+		}
+
+		e.logger.WithFields(logrus.Fields{
+			"task_id": task.ID,
+			"url":     hook.URL,
+			"attempt": attempt,
+			"error":   delivery.Error,
+		}).Warn("Webhook delivery failed")
+
+		if attempt <= retries {
+			select {
+			case <-time.After(hookRetryBackoff << (attempt - 1)):
+			case <-e.hookCtx.Done():
+				return
+			}
+		}
+	}
+}
+
+// deliverWebhook posts the signed payload to a webhook once
+func (e *Executor) deliverWebhook(hook Hook, payload HookPayload, attempt int) HookDelivery {
+	delivery := HookDelivery{
+		Hook:    hook.URL,
+		Status:  string(payload.Status),
+		Attempt: attempt,
+		At:      time.Now(),
+	}
+
+	payload.Delivery = uuid.New().String()
+	payload.Timestamp = delivery.At
+	body, err := json.Marshal(payload)
+	if err != nil {
+		delivery.Error = fmt.Sprintf("failed to encode payload: %v", err)
+		return delivery
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(e.hookCtx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ducla-agent-hooks")
+	req.Header.Set("X-Ducla-Event", payload.Event)
+	req.Header.Set("X-Ducla-Delivery", payload.Delivery)
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	if hook.Secret != "" {
+		req.Header.Set(HookSignatureHeader, SignHookPayload(hook.Secret, body))
+	}
+
+	resp, err := e.hookClient.Do(req)
+	delivery.DurationMs = time.Since(delivery.At).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("unexpected status: %s", resp.Status)
+	}
+
+	return delivery
+}
+
+// SignHookPayload returns the signature header value for a webhook body
+func SignHookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// submitFollowUp submits the follow-up task of a hook. The hook's task is
+// shared by every task the hook fires for, so it is parsed from a copy.
+func (e *Executor) submitFollowUp(task *Task, hook Hook, status TaskStatus) HookDelivery {
+	delivery := HookDelivery{
+		Hook:    "task",
+		Status:  string(status),
+		Attempt: 1,
+		At:      time.Now(),
+	}
+
+	spec := substitute(hook.Task, strings.NewReplacer()).(map[string]interface{})
+	followUp, err := ParseTask(spec)
+	if err == nil {
+		followUp.Metadata[hookParentKey] = task.ID
+		followUp.Metadata["hook_parent_status"] = string(status)
+		followUp.Env["DUCLA_PARENT_TASK_ID"] = task.ID
+		followUp.Env["DUCLA_PARENT_TASK_STATUS"] = string(status)
+		delivery.TaskID, err = e.SubmitTask(followUp)
+	}
+
+	delivery.DurationMs = time.Since(delivery.At).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		e.logger.WithError(err).WithField("task_id", task.ID).Warn("Failed to submit follow-up task")
+		return delivery
+	}
+
+	delivery.Success = true
+	return delivery
+}
+
+// recordDelivery appends a delivery attempt to the task's metadata
+func (e *Executor) recordDelivery(task *Task, delivery HookDelivery) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if task.Metadata == nil {
+		task.Metadata = make(map[string]interface{})
+	}
+	deliveries, _ := task.Metadata["hook_deliveries"].([]HookDelivery)
+	task.Metadata["hook_deliveries"] = append(deliveries, delivery)
+}
@@ -0,0 +1,211 @@
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// hookDeliveries returns the deliveries recorded for a task
+func hookDeliveries(e *Executor, task *Task) []HookDelivery {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	deliveries, _ := task.Metadata["hook_deliveries"].([]HookDelivery)
+	return append([]HookDelivery(nil), deliveries...)
+}
+
+func TestFollowUpHooksConcurrent(t *testing.T) {
+	spec := map[string]interface{}{
+		"type":     "command",
+		"command":  "true",
+		"metadata": map[string]interface{}{"team": "ops"},
+	}
+	cfg := testConfig(t)
+	cfg.WorkerPoolSize = 4
+	cfg.MaxWorkers = 4
+	cfg.QueueSize = 50
+	cfg.Hooks = []config.HookConfig{{Task: spec}, {Task: spec}}
+	e := startExecutor(t, cfg)
+
+	var parents []*Task
+	for i := 0; i < 10; i++ {
+		task := &Task{Type: TaskTypeCommand, Command: "true"}
+		if _, err := e.SubmitTask(task); err != nil {
+			t.Fatal(err)
+		}
+		parents = append(parents, task)
+	}
+
+	followUps := make(map[string]string) // follow-up ID -> parent ID
+	for _, parent := range parents {
+		waitFor(t, "two follow-up deliveries", func() bool {
+			return len(hookDeliveries(e, parent)) == 2
+		})
+		for _, delivery := range hookDeliveries(e, parent) {
+			if !delivery.Success || delivery.TaskID == "" {
+				t.Fatalf("delivery = %+v, want a submitted follow-up", delivery)
+			}
+			followUps[delivery.TaskID] = parent.ID
+		}
+	}
+	if len(followUps) != 20 {
+		t.Fatalf("got %d distinct follow-ups, want 20", len(followUps))
+	}
+
+	for id, parentID := range followUps {
+		task := waitForStatus(t, e, id, TaskStatusCompleted)
+		e.mu.RLock()
+		parent, team := task.Metadata[hookParentKey], task.Metadata["team"]
+		e.mu.RUnlock()
+		if parent != parentID || team != "ops" {
+			t.Errorf("follow-up %s metadata parent = %v, team = %v, want %s, ops", id, parent, team, parentID)
+		}
+		if deliveries := hookDeliveries(e, task); len(deliveries) != 0 {
+			t.Errorf("follow-up %s ran %d global hooks", id, len(deliveries))
+		}
+	}
+
+	metadata := spec["metadata"].(map[string]interface{})
+	if len(metadata) != 1 {
+		t.Errorf("hook configuration metadata was modified: %v", metadata)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	zero, one := 0, 1
+	tests := []struct {
+		name       string
+		maxRetries *int
+		want       int
+	}{
+		{name: "retries disabled", maxRetries: &zero, want: 1},
+		{name: "one retry", maxRetries: &one, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer server.Close()
+
+			e := startExecutor(t, testConfig(t))
+			task := &Task{Type: TaskTypeCommand, Command: "true", Hooks: []Hook{{URL: server.URL, MaxRetries: tt.maxRetries}}}
+			if _, err := e.SubmitTask(task); err != nil {
+				t.Fatal(err)
+			}
+
+			waitFor(t, "webhook attempts", func() bool {
+				return len(hookDeliveries(e, task)) == tt.want
+			})
+			time.Sleep(1500 * time.Millisecond) // longer than the first backoff
+
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != tt.want || len(hookDeliveries(e, task)) != tt.want {
+				t.Errorf("webhook attempted %d times, want %d", attempts, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	e := startExecutor(t, testConfig(t))
+	task := &Task{
+		Type:    TaskTypeCommand,
+		Command: "false",
+		Hooks: []Hook{
+			{URL: server.URL, Secret: "s3cret", On: []TaskStatus{TaskStatusFailed}, Headers: map[string]string{"X-Team": "ops"}},
+			{URL: server.URL, On: []TaskStatus{TaskStatusCompleted}},
+		},
+	}
+	if _, err := e.SubmitTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-received:
+		body = <-bodies
+	case <-time.After(10 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	if got, want := r.Header.Get(HookSignatureHeader), SignHookPayload("s3cret", body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if r.Header.Get("X-Team") != "ops" {
+		t.Errorf("custom header missing")
+	}
+
+	var payload HookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.TaskID != task.ID || payload.Status != TaskStatusFailed || payload.Result == nil {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	waitFor(t, "the delivery record", func() bool {
+		deliveries := hookDeliveries(e, task)
+		return len(deliveries) == 1 && deliveries[0].Success
+	})
+}
+
+func TestParseHooks(t *testing.T) {
+	hooks, err := ParseHooks([]interface{}{
+		map[string]interface{}{"url": "https://example.com/hook", "secret": "s", "timeout": 2.5, "max_retries": 0.0, "on": []interface{}{"failed"}},
+		map[string]interface{}{"task": map[string]interface{}{"type": "command", "command": "true"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hooks[0].Secret != "s" || hooks[0].Timeout != 2500*time.Millisecond {
+		t.Errorf("hook = %+v", hooks[0])
+	}
+	if hooks[0].MaxRetries == nil || *hooks[0].MaxRetries != 0 {
+		t.Errorf("max_retries = %v, want 0", hooks[0].MaxRetries)
+	}
+	if hooks[1].MaxRetries != nil {
+		t.Errorf("unset max_retries = %v, want nil", *hooks[1].MaxRetries)
+	}
+	for i := range hooks {
+		if err := hooks[i].validate(); err != nil {
+			t.Errorf("hook %d: %v", i, err)
+		}
+	}
+
+	invalid := []Hook{
+		{},
+		{URL: "https://example.com", Task: map[string]interface{}{}},
+		{URL: "ftp://example.com"},
+		{URL: "https://example.com", On: []TaskStatus{TaskStatusRunning}},
+	}
+	for _, hook := range invalid {
+		if err := hook.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded", hook)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// defaultInputMode is used for inputs that declare no mode
+const defaultInputMode = 0644
+
+// TaskInput is a file attached to a task at submission. It is received into
+// the inputs directory, staged into the task's working directory before the
+// task runs and removed afterwards.
+type TaskInput struct {
+	Name   string `json:"name"`             // path relative to the working directory
+	Mode   string `json:"mode,omitempty"`   // octal file mode, 0644 by default
+	SHA256 string `json:"sha256,omitempty"` // expected checksum, verified on receipt
+	Size   int64  `json:"size"`
+	Path   string `json:"path,omitempty"` // received file in the inputs directory
+}
+
+// ParseTaskInputs parses input declarations from decoded JSON
+func ParseTaskInputs(data interface{}) ([]TaskInput, error) {
+	items, ok := data.([]interface{})
+	if !ok {
+		return nil, errors.New("inputs must be a list")
+	}
+
+	inputs := make([]TaskInput, 0, len(items))
+	for _, item := range items {
+		spec, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid input declaration")
+		}
+
+		input := TaskInput{}
+		input.Name, _ = spec["name"].(string)
+		input.SHA256, _ = spec["sha256"].(string)
+		switch mode := spec["mode"].(type) {
+		case string:
+			input.Mode = mode
+		case float64:
+			input.Mode = strconv.FormatInt(int64(mode), 8)
+		}
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
+
+// validateInputs checks input names and modes and that every input was
+// received into dir and is still available
+func validateInputs(inputs []TaskInput, dir string) error {
+	seen := make(map[string]bool, len(inputs))
+	for _, input := range inputs {
+		if err := validateInputName(input.Name); err != nil {
+			return err
+		}
+		if seen[input.Name] {
+			return fmt.Errorf("duplicate input: %s", input.Name)
+		}
+		seen[input.Name] = true
+
+		if _, err := input.fileMode(); err != nil {
+			return err
+		}
+		if input.Path == "" {
+			return fmt.Errorf("input %s has not been uploaded", input.Name)
+		}
+		if filepath.Dir(input.Path) != filepath.Clean(dir) {
+			return fmt.Errorf("input %s was not received by this agent", input.Name)
+		}
+		if _, err := os.Stat(input.Path); err != nil {
+			return fmt.Errorf("input %s is not available: %w", input.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateInputName rejects names that would be staged outside the working
+// directory
+func validateInputName(name string) error {
+	if name == "" {
+		return errors.New("input name is required")
+	}
+	clean := filepath.Clean(name)
+	if filepath.IsAbs(name) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid input name: %s", name)
+	}
+	return nil
+}
+
+func (i *TaskInput) fileMode() (os.FileMode, error) {
+	if i.Mode == "" {
+		return defaultInputMode, nil
+	}
+	mode, err := strconv.ParseUint(i.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q for input %s", i.Mode, i.Name)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ReceiveInput stores an input file in the inputs directory and computes its
+// checksum. The returned input is attached to a task with AttachInputs.
+func (e *Executor) ReceiveInput(name string, r io.Reader) (*TaskInput, error) {
+	if err := validateInputName(name); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(e.config.InputsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create inputs directory: %w", err)
+	}
+
+	path := filepath.Join(e.config.InputsDir, uuid.New().String())
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create input file: %w", err)
+	}
+
+	// Read one byte past the limit to detect oversized inputs
+	hash := sha256.New()
+	limit := e.config.MaxInputSize
+	reader := r
+	if limit > 0 {
+		reader = io.LimitReader(r, limit+1)
+	}
+	size, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && limit > 0 && size > limit {
+		err = fmt.Errorf("input %s exceeds maximum size of %d bytes", name, limit)
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to receive input %s: %w", name, err)
+	}
+
+	return &TaskInput{
+		Name:   name,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+		Size:   size,
+		Path:   path,
+	}, nil
+}
+
+// AttachInputs matches received files with the task's input declarations,
+// verifying declared checksums. Received files without a declaration are
+// attached with default settings.
+func AttachInputs(task *Task, received []*TaskInput) error {
+	byName := make(map[string]*TaskInput, len(received))
+	for _, input := range received {
+		byName[input.Name] = input
+	}
+
+	for i := range task.Inputs {
+		declared := &task.Inputs[i]
+		input, ok := byName[declared.Name]
+		if !ok {
+			return fmt.Errorf("input %s was declared but not uploaded", declared.Name)
+		}
+		delete(byName, declared.Name)
+
+		if declared.SHA256 != "" && !strings.EqualFold(declared.SHA256, input.SHA256) {
+			return fmt.Errorf("checksum mismatch for input %s: expected %s, got %s", declared.Name, declared.SHA256, input.SHA256)
+		}
+		declared.SHA256 = input.SHA256
+		declared.Size = input.Size
+		declared.Path = input.Path
+	}
+
+	for _, input := range received {
+		if _, ok := byName[input.Name]; ok {
+			task.Inputs = append(task.Inputs, *input)
+		}
+	}
+
+	return nil
+}
+
+// DiscardInputs removes received input files of a task that was not submitted
+func DiscardInputs(inputs []*TaskInput) {
+	for _, input := range inputs {
+		if input.Path != "" {
+			os.Remove(input.Path)
+		}
+	}
+}
+
+// DiscardTaskInputs removes the received input files of a task that will not
+// be resubmitted. Files outside the inputs directory are left alone.
+func (e *Executor) DiscardTaskInputs(task *Task) {
+	for _, input := range task.Inputs {
+		if input.Path != "" && filepath.Dir(input.Path) == filepath.Clean(e.config.InputsDir) {
+			os.Remove(input.Path)
+		}
+	}
+}
+
+// releaseInputs removes the received input files a finished task did not
+// stage, such as those of tasks cancelled before they ran
+func releaseInputs(task *Task) {
+	for _, input := range task.Inputs {
+		if input.Path != "" {
+			os.Remove(input.Path)
+		}
+	}
+}
+
+// stagedInputs tracks the files staged for a running task
+type stagedInputs struct {
+	files   []string
+	dirs    []string
+	tempDir string
+}
+
+// stageInputs moves a task's inputs into its working directory. Tasks
+// without a working directory get a temporary one.
+func (w *Worker) stageInputs(task *Task) (*stagedInputs, error) {
+	staged := &stagedInputs{}
+	if len(task.Inputs) == 0 {
+		return staged, nil
+	}
+
+	if task.WorkingDir == "" {
+		if err := os.MkdirAll(w.inputsDir, 0700); err != nil {
+			return staged, fmt.Errorf("failed to create inputs directory: %w", err)
+		}
+		dir, err := os.MkdirTemp(w.inputsDir, "task-")
+		if err != nil {
+			return staged, fmt.Errorf("failed to create working directory: %w", err)
+		}
+		staged.tempDir = dir
+		task.WorkingDir = dir
+	}
+
+	for i := range task.Inputs {
+		input := &task.Inputs[i]
+		if input.Path == "" {
+			return staged, fmt.Errorf("input %s is not available", input.Name)
+		}
+
+		mode, err := input.fileMode()
+		if err != nil {
+			return staged, err
+		}
+
+		dest := filepath.Join(task.WorkingDir, input.Name)
+		if _, err := os.Lstat(dest); err == nil {
+			return staged, fmt.Errorf("input %s already exists in the working directory", input.Name)
+		}
+
+		if err := staged.mkdirs(task.WorkingDir, filepath.Dir(input.Name)); err != nil {
+			return staged, err
+		}
+
+		if err := moveFile(input.Path, dest); err != nil {
+			return staged, fmt.Errorf("failed to stage input %s: %w", input.Name, err)
+		}
+		staged.files = append(staged.files, dest)
+
+		if err := os.Chmod(dest, mode); err != nil {
+			return staged, fmt.Errorf("failed to set mode of input %s: %w", input.Name, err)
+		}
+	}
+
+	return staged, nil
+}
+
+// mkdirs creates the parent directories of an input, remembering the ones it
+// created so cleanup can remove them
+func (s *stagedInputs) mkdirs(root, rel string) error {
+	if rel == "." {
+		return nil
+	}
+
+	dir := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		if info, err := os.Lstat(dir); err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("input directory %s is not a directory", dir)
+			}
+			continue
+		}
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create input directory: %w", err)
+		}
+		s.dirs = append(s.dirs, dir)
+	}
+	return nil
+}
+
+// cleanup removes staged inputs, the directories created for them and any
+// input files that were not staged
+func (s *stagedInputs) cleanup(task *Task) {
+	for _, file := range s.files {
+		os.Remove(file)
+	}
+	for i := len(s.dirs) - 1; i >= 0; i-- {
+		os.Remove(s.dirs[i]) // only succeeds if the task left it empty
+	}
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+
+	releaseInputs(task)
+}
+
+// moveFile renames src to dst, copying across file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,193 @@
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receiveInput receives an input file with the given content
+func receiveInput(t *testing.T, e *Executor, name, content string) *TaskInput {
+	t.Helper()
+	input, err := e.ReceiveInput(name, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input
+}
+
+// assertNoInputs fails if received input files are left in the inputs directory
+func assertNoInputs(t *testing.T, e *Executor) {
+	t.Helper()
+	entries, err := os.ReadDir(e.config.InputsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("input file %s was not removed", entry.Name())
+	}
+}
+
+func TestParseTaskInputs(t *testing.T) {
+	inputs, err := ParseTaskInputs([]interface{}{
+		map[string]interface{}{"name": "conf/app.yaml", "mode": "0600", "sha256": "abc", "path": "/etc/shadow"},
+		map[string]interface{}{"name": "run.sh", "mode": 493.0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inputs[0].Name != "conf/app.yaml" || inputs[0].Mode != "0600" || inputs[0].SHA256 != "abc" {
+		t.Errorf("input = %+v", inputs[0])
+	}
+	if inputs[0].Path != "" {
+		t.Errorf("ParseTaskInputs() took the path %q from the request", inputs[0].Path)
+	}
+	if inputs[1].Mode != "755" {
+		t.Errorf("numeric mode = %q, want 755", inputs[1].Mode)
+	}
+
+	if _, err := ParseTaskInputs("app.yaml"); err == nil {
+		t.Error("ParseTaskInputs() accepted a string")
+	}
+}
+
+func TestAttachInputs(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+	app := receiveInput(t, e, "app.yaml", "key: value\n")
+	extra := receiveInput(t, e, "extra.txt", "extra")
+
+	task := &Task{Inputs: []TaskInput{{Name: "app.yaml", Mode: "0600", SHA256: strings.ToUpper(app.SHA256)}}}
+	if err := AttachInputs(task, []*TaskInput{app, extra}); err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Inputs) != 2 || task.Inputs[0].Path != app.Path || task.Inputs[0].Mode != "0600" || task.Inputs[1].Name != "extra.txt" {
+		t.Errorf("inputs = %+v", task.Inputs)
+	}
+
+	mismatch := &Task{Inputs: []TaskInput{{Name: "app.yaml", SHA256: "0000"}}}
+	if err := AttachInputs(mismatch, []*TaskInput{app}); err == nil {
+		t.Error("AttachInputs() accepted a checksum mismatch")
+	}
+	missing := &Task{Inputs: []TaskInput{{Name: "missing.txt"}}}
+	if err := AttachInputs(missing, []*TaskInput{app}); err == nil {
+		t.Error("AttachInputs() accepted a declared input without a file")
+	}
+
+	DiscardInputs([]*TaskInput{app, extra})
+	assertNoInputs(t, e)
+}
+
+func TestInputsSurviveJSON(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+	task := &Task{Type: TaskTypeCommand, Command: "true"}
+	if err := AttachInputs(task, []*TaskInput{receiveInput(t, e, "app.yaml", "key: value\n")}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Task
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateInputs(decoded.Inputs, e.config.InputsDir); err != nil {
+		t.Fatalf("validateInputs() of a decoded task = %v", err)
+	}
+
+	os.Remove(decoded.Inputs[0].Path)
+	if err := validateInputs(decoded.Inputs, e.config.InputsDir); err == nil {
+		t.Error("validateInputs() accepted an input whose file is gone")
+	}
+}
+
+func TestInputsStaged(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+	task := &Task{Type: TaskTypeCommand, Command: "cat", Args: []string{"conf/app.yaml"}}
+	if err := AttachInputs(task, []*TaskInput{receiveInput(t, e, "conf/app.yaml", "key: value\n")}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := e.SubmitTask(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusCompleted)
+
+	e.mu.RLock()
+	output := task.Result.Output
+	e.mu.RUnlock()
+	if !strings.Contains(output, "key: value") {
+		t.Errorf("output = %q, want the staged input", output)
+	}
+	assertNoInputs(t, e)
+}
+
+func TestInputsOutsideInputsDir(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+	outside := t.TempDir() + "/secret"
+	if err := os.WriteFile(outside, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{Type: TaskTypeCommand, Command: "true", Inputs: []TaskInput{{Name: "secret", Path: outside}}}
+	if _, err := e.SubmitTask(task); err == nil {
+		t.Error("SubmitTask() accepted an input outside the inputs directory")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the inputs directory was moved: %v", err)
+	}
+}
+
+func TestInputsReleasedWhenCancelled(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor func(t *testing.T) *Executor
+	}{
+		{
+			name: "scheduled",
+			executor: func(t *testing.T) *Executor {
+				return windowExecutor(t, time.Now().Add(time.Hour), WindowActionHold)
+			},
+		},
+		{
+			name: "held",
+			executor: func(t *testing.T) *Executor {
+				e := resourceExecutor(t, 10)
+				running, err := e.SubmitTask(cpuTask(1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				waitForStatus(t, e, running, TaskStatusRunning)
+				return e
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.executor(t)
+			task := cpuTask(1)
+			if err := AttachInputs(task, []*TaskInput{receiveInput(t, e, "app.yaml", "key: value\n")}); err != nil {
+				t.Fatal(err)
+			}
+			id, err := e.SubmitTask(task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.CancelTask(id); err != nil {
+				t.Fatal(err)
+			}
+
+			waitFor(t, "the cancelled task's result", func() bool {
+				e.mu.RLock()
+				defer e.mu.RUnlock()
+				return task.Result != nil
+			})
+			assertNoInputs(t, e)
+		})
+	}
+}
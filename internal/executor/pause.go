@@ -0,0 +1,186 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/sirupsen/logrus"
+)
+
+// PauseTask suspends a running command or script task. Its processes are
+// frozen through their cgroup where available, otherwise the process group
+// is stopped with SIGSTOP.
+func (e *Executor) PauseTask(taskID string) error {
+	task, err := e.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+
+	task.procMu.Lock()
+	defer task.procMu.Unlock()
+
+	// The status is guarded by e.mu, the process by procMu
+	if status := e.taskStatus(task); status != TaskStatusRunning {
+		return fmt.Errorf("task cannot be paused (status: %s)", status)
+	}
+	if task.process == nil {
+		return fmt.Errorf("task type %s does not support pausing", task.Type)
+	}
+
+	if task.cgroup != nil && task.cgroup.freeze(task.process.Pid) == nil {
+		task.frozen = true
+	} else if err := signalProcessGroup(task.process, syscall.SIGSTOP); err != nil {
+		return fmt.Errorf("failed to pause task: %w", err)
+	}
+
+	e.setTaskStatus(task, TaskStatusPaused)
+	task.pausedAt = time.Now()
+	if task.runTimer != nil && task.ExcludePausedTime {
+		task.runTimer.pause()
+	}
+
+	e.events.Publish(events.TaskPaused{
+		TaskID:   task.ID,
+		TaskType: string(task.Type),
+		Name:     task.Name,
+		PausedAt: task.pausedAt,
+	})
+
+	e.logger.WithField("task_id", taskID).Info("Task paused")
+	return nil
+}
+
+// ResumeTask continues a paused task
+func (e *Executor) ResumeTask(taskID string) error {
+	task, err := e.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+
+	task.procMu.Lock()
+	defer task.procMu.Unlock()
+
+	if status := e.taskStatus(task); status != TaskStatusPaused {
+		return fmt.Errorf("task is not paused (status: %s)", status)
+	}
+
+	if task.frozen {
+		if err := task.cgroup.thaw(); err != nil {
+			return fmt.Errorf("failed to resume task: %w", err)
+		}
+		task.frozen = false
+	} else if task.process != nil {
+		if err := signalProcessGroup(task.process, syscall.SIGCONT); err != nil {
+			return fmt.Errorf("failed to resume task: %w", err)
+		}
+	}
+
+	pausedFor := time.Since(task.pausedAt)
+	task.PausedTime += pausedFor
+	task.pausedAt = time.Time{}
+	e.setTaskStatus(task, TaskStatusRunning)
+	if task.runTimer != nil && task.ExcludePausedTime {
+		task.runTimer.resume()
+	}
+
+	e.events.Publish(events.TaskResumed{
+		TaskID:    task.ID,
+		TaskType:  string(task.Type),
+		Name:      task.Name,
+		PausedFor: pausedFor,
+	})
+
+	e.logger.WithFields(logrus.Fields{
+		"task_id":    taskID,
+		"paused_for": pausedFor,
+	}).Info("Task resumed")
+	return nil
+}
+
+// taskStatus returns the status of a task
+func (e *Executor) taskStatus(task *Task) TaskStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return task.Status
+}
+
+// setTaskStatus changes the status of a task
+func (e *Executor) setTaskStatus(task *Task, status TaskStatus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	task.Status = status
+}
+
+// setProcess records the process of a running task and its cgroup, if any,
+// so it can be paused
+func (t *Task) setProcess(process *os.Process, cgroup *taskCgroup) {
+	t.procMu.Lock()
+	defer t.procMu.Unlock()
+	t.process = process
+	t.cgroup = cgroup
+	t.frozen = false
+}
+
+// thaw thaws a frozen task so its processes can act on pending signals
+func (t *Task) thaw() {
+	t.procMu.Lock()
+	defer t.procMu.Unlock()
+	if t.frozen {
+		t.cgroup.thaw()
+		t.frozen = false
+	}
+}
+
+// timedOut reports whether the task's run timer expired
+func (t *Task) timedOut() bool {
+	t.procMu.Lock()
+	defer t.procMu.Unlock()
+	return t.runTimer != nil && t.runTimer.expired
+}
+
+// signalProcessGroup sends a signal to the process group led by process
+func signalProcessGroup(process *os.Process, sig syscall.Signal) error {
+	return syscall.Kill(-process.Pid, sig)
+}
+
+// runTimer enforces the task timeout from when the task starts. With
+// ExcludePausedTime it is paused along with the task, so it only counts
+// running time. Its state is guarded by the task's procMu.
+type runTimer struct {
+	timer     *time.Timer
+	remaining time.Duration
+	started   time.Time
+	expired   bool
+	fire      func()
+}
+
+// startRunTimer starts a run timer for a task that cancels it on expiry
+func startRunTimer(task *Task, timeout time.Duration) *runTimer {
+	t := &runTimer{remaining: timeout}
+	t.fire = func() {
+		task.procMu.Lock()
+		t.expired = true
+		task.procMu.Unlock()
+		task.cancel()
+	}
+	t.resume()
+	return t
+}
+
+func (t *runTimer) pause() {
+	if t.timer.Stop() {
+		t.remaining -= time.Since(t.started)
+	}
+}
+
+func (t *runTimer) resume() {
+	t.started = time.Now()
+	t.timer = time.AfterFunc(t.remaining, t.fire)
+}
+
+func (t *runTimer) stop() {
+	t.timer.Stop()
+}
@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingTask returns a script task appending a line to path every 20ms
+func countingTask(path string, lines int) *Task {
+	return &Task{
+		Type:    TaskTypeScript,
+		Command: fmt.Sprintf(`i=0; while [ $i -lt %d ]; do i=$((i+1)); echo $i >> %q; sleep 0.02; done`, lines, path),
+	}
+}
+
+// countLines returns the number of lines in a file
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestPauseAndResume(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+	path := filepath.Join(t.TempDir(), "count")
+
+	task := countingTask(path, 40)
+	id, err := e.SubmitTask(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the task to start counting", func() bool {
+		return countLines(t, path) > 0
+	})
+
+	if err := e.PauseTask(id); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusPaused)
+	if err := e.PauseTask(id); err == nil {
+		t.Error("PauseTask() of a paused task succeeded")
+	}
+
+	time.Sleep(100 * time.Millisecond) // let writes in flight land
+	paused := countLines(t, path)
+	time.Sleep(300 * time.Millisecond)
+	if lines := countLines(t, path); lines != paused {
+		t.Errorf("paused task wrote %d more lines", lines-paused)
+	}
+
+	if err := e.ResumeTask(id); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusCompleted)
+	if lines := countLines(t, path); lines != 40 {
+		t.Errorf("task wrote %d lines, want 40", lines)
+	}
+
+	task.procMu.Lock()
+	pausedTime := task.PausedTime
+	task.procMu.Unlock()
+	if pausedTime < 400*time.Millisecond {
+		t.Errorf("paused time = %s, want at least 400ms", pausedTime)
+	}
+
+	if err := e.ResumeTask(id); err == nil {
+		t.Error("ResumeTask() of a finished task succeeded")
+	}
+}
+
+func TestCancelPausedTask(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+	path := filepath.Join(t.TempDir(), "count")
+
+	id, err := e.SubmitTask(countingTask(path, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the task to start counting", func() bool {
+		return countLines(t, path) > 0
+	})
+	if err := e.PauseTask(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.CancelTask(id); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusCancelled)
+}
+
+func TestPauseQueuedTask(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+
+	running, err := e.SubmitTask(sleepTask(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, running, TaskStatusRunning)
+
+	queued, err := e.SubmitTask(sleepTask(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.PauseTask(queued); err == nil {
+		t.Error("PauseTask() of a queued task succeeded")
+	}
+	if err := e.ResumeTask(running); err == nil {
+		t.Error("ResumeTask() of a running task succeeded")
+	}
+}
@@ -0,0 +1,166 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scaleInterval is how often the pool checks for queue pressure
+const scaleInterval = time.Second
+
+// ResizeWorkerPool changes the worker pool bounds at runtime. The pool never
+// shrinks below minWorkers and grows up to maxWorkers under queue pressure.
+// Workers removed while busy finish their current task before exiting.
+func (e *Executor) ResizeWorkerPool(minWorkers, maxWorkers int) error {
+	if minWorkers < 1 {
+		return fmt.Errorf("worker pool size must be at least 1")
+	}
+	if maxWorkers < minWorkers {
+		maxWorkers = minWorkers
+	}
+
+	e.poolMu.Lock()
+	defer e.poolMu.Unlock()
+
+	e.minWorkers = minWorkers
+	e.maxWorkers = maxWorkers
+
+	// Only adjust live workers once the executor is running
+	if e.ctx == nil || e.ctx.Err() != nil {
+		return nil
+	}
+
+	for len(e.workers) < minWorkers {
+		e.spawnWorker()
+	}
+
+	// Remove surplus workers, idle ones first
+	for i := len(e.workers) - 1; i >= 0 && len(e.workers) > maxWorkers; i-- {
+		if !e.workers[i].IsBusy() {
+			e.removeWorker(e.workers[i])
+		}
+	}
+	for len(e.workers) > maxWorkers {
+		e.removeWorker(e.workers[len(e.workers)-1])
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"min_workers": minWorkers,
+		"max_workers": maxWorkers,
+		"workers":     len(e.workers),
+	}).Info("Worker pool resized")
+
+	return nil
+}
+
+// GetWorkerStats returns per-worker statistics
+func (e *Executor) GetWorkerStats() []WorkerStats {
+	e.poolMu.Lock()
+	defer e.poolMu.Unlock()
+
+	stats := make([]WorkerStats, 0, len(e.workers))
+	for _, worker := range e.workers {
+		stats = append(stats, worker.GetStats())
+	}
+
+	return stats
+}
+
+// spawnWorker starts a new worker. Must be called with e.poolMu held.
+func (e *Executor) spawnWorker() {
+	worker := NewWorker(e.nextWorkerID, e.taskQueue, e.resultChan, e.events, e.logger)
+	worker.idleTimeout = e.config.IdleTimeout
+	worker.retire = e.retireWorker
+	worker.dequeued = e.taskDequeued
+	worker.workspaces = e.workspaces
+	worker.sandboxes = e.config.SandboxProfiles
+	worker.inputsDir = e.config.InputsDir
+	worker.paths = e.paths
+	worker.deleter = e.deleter
+	e.nextWorkerID++
+	e.workers = append(e.workers, worker)
+
+	e.wg.Add(1)
+	go func(w *Worker) {
+		defer e.wg.Done()
+		w.Start(e.ctx)
+	}(worker)
+}
+
+// removeWorker stops a worker and removes it from the pool. Must be called
+// with e.poolMu held.
+func (e *Executor) removeWorker(worker *Worker) {
+	for i, w := range e.workers {
+		if w == worker {
+			e.workers = append(e.workers[:i], e.workers[i+1:]...)
+			break
+		}
+	}
+	worker.Stop()
+}
+
+// retireWorker is called by idle workers and reports whether the worker may exit
+func (e *Executor) retireWorker(worker *Worker) bool {
+	e.poolMu.Lock()
+	defer e.poolMu.Unlock()
+
+	if len(e.workers) <= e.minWorkers {
+		return false
+	}
+
+	e.removeWorker(worker)
+	return true
+}
+
+// scaleLoop grows the pool while tasks are waiting and no worker is idle
+func (e *Executor) scaleLoop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			e.scaleUp()
+		}
+	}
+}
+
+// scaleUp adds workers for queued tasks that no idle worker can take
+func (e *Executor) scaleUp() {
+	pending := len(e.taskQueue)
+	if pending == 0 {
+		return
+	}
+
+	e.poolMu.Lock()
+	defer e.poolMu.Unlock()
+
+	if e.ctx.Err() != nil {
+		return
+	}
+
+	for _, worker := range e.workers {
+		if !worker.IsBusy() {
+			pending--
+		}
+	}
+
+	added := 0
+	for ; pending > 0 && len(e.workers) < e.maxWorkers; pending-- {
+		e.spawnWorker()
+		added++
+	}
+
+	if added > 0 {
+		e.logger.WithFields(logrus.Fields{
+			"added":   added,
+			"workers": len(e.workers),
+		}).Info("Worker pool scaled up")
+	}
+}
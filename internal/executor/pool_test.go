@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResizeWorkerPool(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+
+	if err := e.ResizeWorkerPool(0, 2); err == nil {
+		t.Error("ResizeWorkerPool() accepted an empty pool")
+	}
+
+	if err := e.ResizeWorkerPool(3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if workers := len(e.GetWorkerStats()); workers != 3 {
+		t.Fatalf("pool has %d workers, want 3", workers)
+	}
+
+	// Busy workers removed by a shrink finish their task
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := e.SubmitTask(sleepTask(300 * time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		waitForStatus(t, e, id, TaskStatusRunning)
+	}
+
+	if err := e.ResizeWorkerPool(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if workers := len(e.GetWorkerStats()); workers != 1 {
+		t.Errorf("pool has %d workers after shrinking, want 1", workers)
+	}
+	for _, id := range ids {
+		waitForStatus(t, e, id, TaskStatusCompleted)
+	}
+
+	id, err := e.SubmitTask(sleepTask(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusCompleted)
+}
+
+func TestPoolScalesWithQueuePressure(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxWorkers = 3
+	cfg.IdleTimeout = 200 * time.Millisecond
+	e := startExecutor(t, cfg)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := e.SubmitTask(sleepTask(2 * time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Queued tasks start on added workers instead of waiting for the first
+	for _, id := range ids {
+		waitForStatus(t, e, id, TaskStatusRunning)
+	}
+	if workers := len(e.GetWorkerStats()); workers != 3 {
+		t.Errorf("pool has %d workers, want 3", workers)
+	}
+
+	// Idle workers above the minimum retire
+	for _, id := range ids {
+		waitForStatus(t, e, id, TaskStatusCompleted)
+	}
+	waitFor(t, "idle workers to retire", func() bool {
+		return len(e.GetWorkerStats()) == 1
+	})
+}
@@ -0,0 +1,237 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+)
+
+const (
+	// ProgressFileEnvVar names the path a task writes JSON progress lines to
+	ProgressFileEnvVar = "DUCLA_PROGRESS_FILE"
+
+	// ProgressFDEnvVar names the file descriptor a task writes progress lines to
+	ProgressFDEnvVar = "DUCLA_PROGRESS_FD"
+
+	// progressPublishInterval limits how often progress events are published
+	progressPublishInterval = 250 * time.Millisecond
+
+	// progressDrainTimeout is how long progress lines are still read after the
+	// task exited, in case a child process keeps the descriptor open
+	progressDrainTimeout = time.Second
+
+	// maxProgressLine caps the size of a single progress line
+	maxProgressLine = 64 * 1024
+)
+
+// Progress is the latest progress reported by a running task
+type Progress struct {
+	Percent   float64                `json:"percent"`
+	Message   string                 `json:"message,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"` // structured key/value status
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// progressReporter reads progress lines written by a task process
+type progressReporter struct {
+	task   *Task
+	events *events.Bus
+	reader *os.File
+	done   chan struct{}
+
+	lastPublished time.Time
+	pending       bool
+}
+
+// attachProgress passes the write end of a pipe to cmd and exports its
+// location to the process. Must be called before cmd.Start.
+func attachProgress(cmd *exec.Cmd, task *Task, bus *events.Bus) (*progressReporter, *os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create progress pipe: %w", err)
+	}
+
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, writer)
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		ProgressFDEnvVar+"="+strconv.Itoa(fd),
+		ProgressFileEnvVar+"=/dev/fd/"+strconv.Itoa(fd),
+	)
+
+	return &progressReporter{
+		task:   task,
+		events: bus,
+		reader: reader,
+		done:   make(chan struct{}),
+	}, writer, nil
+}
+
+// run reads progress lines until the pipe is closed
+func (p *progressReporter) run() {
+	defer close(p.done)
+
+	scanner := bufio.NewScanner(p.reader)
+	scanner.Buffer(make([]byte, 4096), maxProgressLine)
+	for scanner.Scan() {
+		update, err := parseProgressLine(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		p.apply(update)
+	}
+
+	// Keep the pipe drained after an oversized line so the task never blocks
+	if scanner.Err() != nil {
+		io.Copy(io.Discard, p.reader)
+	}
+
+	if p.pending {
+		p.publish()
+	}
+}
+
+// close stops reading once the task has exited
+func (p *progressReporter) close() {
+	select {
+	case <-p.done:
+	case <-time.After(progressDrainTimeout):
+	}
+	p.reader.Close()
+	<-p.done
+}
+
+// apply merges a progress update into the task's progress
+func (p *progressReporter) apply(update progressLine) {
+	p.task.procMu.Lock()
+	progress := Progress{UpdatedAt: time.Now()}
+	if previous := p.task.Progress; previous != nil {
+		progress = *previous
+		progress.UpdatedAt = time.Now()
+	}
+	if update.Percent != nil {
+		progress.Percent = clampPercent(*update.Percent)
+	}
+	if update.Message != nil {
+		progress.Message = *update.Message
+	}
+	if len(update.Fields) > 0 {
+		fields := make(map[string]interface{}, len(progress.Fields)+len(update.Fields))
+		for key, value := range progress.Fields {
+			fields[key] = value
+		}
+		for key, value := range update.Fields {
+			fields[key] = value
+		}
+		progress.Fields = fields
+	}
+	p.task.Progress = &progress
+	p.task.procMu.Unlock()
+
+	p.pending = true
+	if time.Since(p.lastPublished) >= progressPublishInterval || progress.Percent >= 100 {
+		p.publish()
+	}
+}
+
+// publish publishes the task's current progress on the event bus
+func (p *progressReporter) publish() {
+	progress := p.task.GetProgress()
+	if progress == nil {
+		return
+	}
+
+	p.events.Publish(events.TaskProgress{
+		TaskID:    p.task.ID,
+		TaskType:  string(p.task.Type),
+		Name:      p.task.Name,
+		Percent:   progress.Percent,
+		Message:   progress.Message,
+		Fields:    progress.Fields,
+		UpdatedAt: progress.UpdatedAt,
+	})
+	p.lastPublished = time.Now()
+	p.pending = false
+}
+
+// GetProgress returns a snapshot of the task's latest progress, or nil
+func (t *Task) GetProgress() *Progress {
+	t.procMu.Lock()
+	defer t.procMu.Unlock()
+
+	if t.Progress == nil {
+		return nil
+	}
+	progress := *t.Progress
+	return &progress
+}
+
+// progressLine is a single JSON line written by a task. Keys other than
+// "progress" and "message" are structured status fields.
+type progressLine struct {
+	Percent *float64
+	Message *string
+	Fields  map[string]interface{}
+}
+
+func parseProgressLine(line []byte) (progressLine, error) {
+	var update progressLine
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return update, err
+	}
+
+	for key, value := range raw {
+		switch key {
+		case "progress":
+			percent, ok := value.(float64)
+			if !ok {
+				return update, fmt.Errorf("progress must be a number")
+			}
+			update.Percent = &percent
+		case "message":
+			message := fmt.Sprint(value)
+			update.Message = &message
+		case "fields":
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return update, fmt.Errorf("fields must be an object")
+			}
+			for k, v := range fields {
+				update.setField(k, v)
+			}
+		default:
+			update.setField(key, value)
+		}
+	}
+
+	return update, nil
+}
+
+func (u *progressLine) setField(key string, value interface{}) {
+	if u.Fields == nil {
+		u.Fields = make(map[string]interface{})
+	}
+	u.Fields[key] = value
+}
+
+func clampPercent(percent float64) float64 {
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
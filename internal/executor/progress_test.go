@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	update, err := parseProgressLine([]byte(`{"progress": 42.5, "message": "copying", "stage": "copy", "fields": {"files": 3}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Percent == nil || *update.Percent != 42.5 || update.Message == nil || *update.Message != "copying" {
+		t.Errorf("update = %+v", update)
+	}
+	want := map[string]interface{}{"stage": "copy", "files": 3.0}
+	if !reflect.DeepEqual(update.Fields, want) {
+		t.Errorf("fields = %v, want %v", update.Fields, want)
+	}
+
+	for _, line := range []string{`not json`, `{"progress": "half"}`, `{"fields": [1]}`} {
+		if _, err := parseProgressLine([]byte(line)); err == nil {
+			t.Errorf("parseProgressLine(%s) succeeded", line)
+		}
+	}
+}
+
+func TestProgressReported(t *testing.T) {
+	bus := events.NewBus(100, testLogger())
+	defer bus.Close()
+	sub := bus.Subscribe(100, events.TypeTaskProgress)
+
+	e, err := New(testConfig(t), bus, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer stopExecutor(t, e)
+
+	task := &Task{
+		Type: TaskTypeScript,
+		Command: `echo '{"progress": 50, "message": "half", "stage": "copy"}' > "$DUCLA_PROGRESS_FILE"
+echo 'ignored' > "$DUCLA_PROGRESS_FILE"
+echo '{"progress": 150, "files": 3}' > "/dev/fd/$DUCLA_PROGRESS_FD"`,
+	}
+	id, err := e.SubmitTask(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusCompleted)
+
+	progress := task.GetProgress()
+	if progress == nil {
+		t.Fatal("task reported no progress")
+	}
+	if progress.Percent != 100 || progress.Message != "half" {
+		t.Errorf("progress = %+v, want 100%% with the earlier message", progress)
+	}
+	if want := map[string]interface{}{"stage": "copy", "files": 3.0}; !reflect.DeepEqual(progress.Fields, want) {
+		t.Errorf("fields = %v, want %v", progress.Fields, want)
+	}
+
+	// Completion is always published, even within the publish interval
+	for {
+		select {
+		case envelope := <-sub.Events():
+			event := envelope.Event.(events.TaskProgress)
+			if event.TaskID == id && event.Percent == 100 {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no progress event for completion")
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package executor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultQueryLimit is the page size used when a query sets no limit
+	DefaultQueryLimit = 100
+
+	// MaxQueryLimit caps the page size of a query
+	MaxQueryLimit = 1000
+)
+
+// Task fields a query can sort by
+const (
+	SortByCreatedAt  = "created_at"
+	SortByStartedAt  = "started_at"
+	SortByFinishedAt = "finished_at"
+	SortByName       = "name"
+	SortByPriority   = "priority"
+)
+
+// TaskQuery selects and orders tasks from the task history
+type TaskQuery struct {
+	Statuses   []TaskStatus      // any of these statuses, all if empty
+	Types      []TaskType        // any of these types, all if empty
+	NamePrefix string            // task name prefix
+	Since      time.Time         // created at or after, if set
+	Until      time.Time         // created before, if set
+	Labels     map[string]string // metadata key/value pairs that must all match
+	SortBy     string            // one of the SortBy constants, created_at by default
+	Descending bool
+	Limit      int
+	Cursor     string // opaque cursor from a previous page
+}
+
+// TaskPage is a page of query results
+type TaskPage struct {
+	Tasks      []*Task `json:"tasks"`
+	Total      int     `json:"total"` // number of tasks matching the filters
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// queryCursor marks the position after the last task of a page
+type queryCursor struct {
+	Key string `json:"k"`
+	ID  string `json:"id"`
+}
+
+// QueryTasks returns the tasks matching a query, one page at a time
+func (e *Executor) QueryTasks(query TaskQuery) (*TaskPage, error) {
+	if query.SortBy == "" {
+		query.SortBy = SortByCreatedAt
+	}
+	if !validSortField(query.SortBy) {
+		return nil, fmt.Errorf("invalid sort field: %s", query.SortBy)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var after *queryCursor
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	// Snapshot matching tasks with their sort keys under the lock
+	type entry struct {
+		task *Task
+		key  string
+	}
+
+	e.mu.RLock()
+	entries := make([]entry, 0, len(e.tasks))
+	for _, task := range e.tasks {
+		if query.matches(task) {
+			entries = append(entries, entry{task: task, key: sortKey(task, query.SortBy)})
+		}
+	}
+	e.mu.RUnlock()
+
+	less := func(a, b entry) bool {
+		if a.key != b.key {
+			return (a.key < b.key) != query.Descending
+		}
+		if a.task.ID == b.task.ID {
+			return false
+		}
+		return (a.task.ID < b.task.ID) != query.Descending
+	}
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	page := &TaskPage{
+		Tasks: make([]*Task, 0, limit),
+		Total: len(entries),
+	}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(entries), func(i int) bool {
+			return less(entry{task: &Task{ID: after.ID}, key: after.Key}, entries[i])
+		})
+	}
+
+	for i := start; i < len(entries) && len(page.Tasks) < limit; i++ {
+		page.Tasks = append(page.Tasks, entries[i].task)
+	}
+
+	if end := start + len(page.Tasks); end < len(entries) && len(page.Tasks) > 0 {
+		last := entries[end-1]
+		page.NextCursor = encodeCursor(queryCursor{Key: last.key, ID: last.task.ID})
+	}
+
+	return page, nil
+}
+
+// ParseTaskStatuses parses a comma separated list of task statuses
+func ParseTaskStatuses(value string) []TaskStatus {
+	var statuses []TaskStatus
+	for _, part := range splitList(value) {
+		statuses = append(statuses, TaskStatus(part))
+	}
+	return statuses
+}
+
+// ParseTaskTypes parses a comma separated list of task types
+func ParseTaskTypes(value string) []TaskType {
+	var types []TaskType
+	for _, part := range splitList(value) {
+		types = append(types, TaskType(part))
+	}
+	return types
+}
+
+// matches reports whether a task passes the query filters. Must be called
+// with the executor lock held.
+func (q *TaskQuery) matches(task *Task) bool {
+	if len(q.Statuses) > 0 && !containsStatus(q.Statuses, task.Status) {
+		return false
+	}
+	if len(q.Types) > 0 && !containsType(q.Types, task.Type) {
+		return false
+	}
+	if q.NamePrefix != "" && !strings.HasPrefix(task.Name, q.NamePrefix) {
+		return false
+	}
+	if !q.Since.IsZero() && task.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !task.CreatedAt.Before(q.Until) {
+		return false
+	}
+	for key, value := range q.Labels {
+		label, ok := task.Metadata[key]
+		if !ok || fmt.Sprint(label) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func validSortField(field string) bool {
+	switch field {
+	case SortByCreatedAt, SortByStartedAt, SortByFinishedAt, SortByName, SortByPriority:
+		return true
+	}
+	return false
+}
+
+// sortKey returns a string that orders tasks by field when compared lexically
+func sortKey(task *Task, field string) string {
+	switch field {
+	case SortByStartedAt:
+		return timeKey(task.StartedAt)
+	case SortByFinishedAt:
+		return timeKey(task.FinishedAt)
+	case SortByName:
+		return task.Name
+	case SortByPriority:
+		return fmt.Sprintf("%020d", int64(task.Priority)+1<<31)
+	default:
+		return timeKey(task.CreatedAt)
+	}
+}
+
+func timeKey(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+func encodeCursor(cursor queryCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*queryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var cursor queryCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+func splitList(value string) []string {
+	var parts []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func containsStatus(statuses []TaskStatus, status TaskStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []TaskType, taskType TaskType) bool {
+	for _, t := range types {
+		if t == taskType {
+			return true
+		}
+	}
+	return false
+}
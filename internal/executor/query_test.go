@@ -0,0 +1,175 @@
+package executor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// queryExecutor returns an executor holding the given tasks
+func queryExecutor(tasks ...*Task) *Executor {
+	e := &Executor{tasks: make(map[string]*Task)}
+	for _, task := range tasks {
+		e.tasks[task.ID] = task
+	}
+	return e
+}
+
+// queryAll pages through a query and returns the task IDs in order. Before
+// each page after the first, between is called to change the tasks.
+func queryAll(t *testing.T, e *Executor, query TaskQuery, between func(page int)) []string {
+	t.Helper()
+
+	var ids []string
+	for page := 0; ; page++ {
+		if page > 0 && between != nil {
+			e.mu.Lock()
+			between(page)
+			e.mu.Unlock()
+		}
+
+		result, err := e.QueryTasks(query)
+		if err != nil {
+			t.Fatalf("QueryTasks: %v", err)
+		}
+		if len(result.Tasks) > query.Limit {
+			t.Fatalf("page %d has %d tasks, limit %d", page, len(result.Tasks), query.Limit)
+		}
+		for _, task := range result.Tasks {
+			ids = append(ids, task.ID)
+		}
+		if result.NextCursor == "" {
+			return ids
+		}
+		if page > 100 {
+			t.Fatal("pagination does not terminate")
+		}
+		query.Cursor = result.NextCursor
+	}
+}
+
+func TestQueryTasksCursor(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTasks := func() []*Task {
+		// Pairs of tasks share a creation time and priority so ties are
+		// broken by ID
+		var tasks []*Task
+		for i := 0; i < 10; i++ {
+			tasks = append(tasks, &Task{
+				ID:        fmt.Sprintf("task-%02d", i),
+				Name:      fmt.Sprintf("job-%d", 9-i),
+				Priority:  i/2 - 2,
+				CreatedAt: base.Add(time.Duration(i/2) * time.Minute),
+			})
+		}
+		return tasks
+	}
+
+	tests := []struct {
+		name    string
+		query   TaskQuery
+		between func(e *Executor) func(page int)
+		want    []string
+	}{
+		{
+			name:  "created ascending",
+			query: TaskQuery{Limit: 3},
+			want:  []string{"task-00", "task-01", "task-02", "task-03", "task-04", "task-05", "task-06", "task-07", "task-08", "task-09"},
+		},
+		{
+			name:  "created descending",
+			query: TaskQuery{Limit: 4, Descending: true},
+			want:  []string{"task-09", "task-08", "task-07", "task-06", "task-05", "task-04", "task-03", "task-02", "task-01", "task-00"},
+		},
+		{
+			name:  "negative priorities",
+			query: TaskQuery{Limit: 2, SortBy: SortByPriority, Descending: true},
+			want:  []string{"task-09", "task-08", "task-07", "task-06", "task-05", "task-04", "task-03", "task-02", "task-01", "task-00"},
+		},
+		{
+			name:  "name",
+			query: TaskQuery{Limit: 3, SortBy: SortByName},
+			want:  []string{"task-09", "task-08", "task-07", "task-06", "task-05", "task-04", "task-03", "task-02", "task-01", "task-00"},
+		},
+		{
+			name:  "limit larger than result",
+			query: TaskQuery{Limit: 50},
+			want:  []string{"task-00", "task-01", "task-02", "task-03", "task-04", "task-05", "task-06", "task-07", "task-08", "task-09"},
+		},
+		{
+			name:  "task added before the cursor is not returned",
+			query: TaskQuery{Limit: 3},
+			between: func(e *Executor) func(int) {
+				return func(page int) {
+					if page == 1 {
+						e.tasks["task-00a"] = &Task{ID: "task-00a", CreatedAt: base}
+					}
+				}
+			},
+			want: []string{"task-00", "task-01", "task-02", "task-03", "task-04", "task-05", "task-06", "task-07", "task-08", "task-09"},
+		},
+		{
+			name:  "task added after the cursor is returned once",
+			query: TaskQuery{Limit: 3},
+			between: func(e *Executor) func(int) {
+				return func(page int) {
+					if page == 1 {
+						e.tasks["task-99"] = &Task{ID: "task-99", CreatedAt: base.Add(time.Hour)}
+					}
+				}
+			},
+			want: []string{"task-00", "task-01", "task-02", "task-03", "task-04", "task-05", "task-06", "task-07", "task-08", "task-09", "task-99"},
+		},
+		{
+			name:  "removing returned tasks skips nothing",
+			query: TaskQuery{Limit: 3},
+			between: func(e *Executor) func(int) {
+				return func(page int) {
+					if page == 1 {
+						delete(e.tasks, "task-01")
+						delete(e.tasks, "task-02")
+					}
+				}
+			},
+			want: []string{"task-00", "task-01", "task-02", "task-03", "task-04", "task-05", "task-06", "task-07", "task-08", "task-09"},
+		},
+		{
+			name:  "removing the next task skips only it",
+			query: TaskQuery{Limit: 3},
+			between: func(e *Executor) func(int) {
+				return func(page int) {
+					if page == 1 {
+						delete(e.tasks, "task-03")
+					}
+				}
+			},
+			want: []string{"task-00", "task-01", "task-02", "task-04", "task-05", "task-06", "task-07", "task-08", "task-09"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := queryExecutor(newTasks()...)
+			var between func(int)
+			if tt.between != nil {
+				between = tt.between(e)
+			}
+
+			got := queryAll(t, e, tt.query, between)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got  %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTasksErrors(t *testing.T) {
+	e := queryExecutor(&Task{ID: "a"})
+
+	if _, err := e.QueryTasks(TaskQuery{SortBy: "size"}); err == nil {
+		t.Error("expected error for unknown sort field")
+	}
+	if _, err := e.QueryTasks(TaskQuery{Cursor: "not a cursor!"}); err == nil {
+		t.Error("expected error for malformed cursor")
+	}
+}
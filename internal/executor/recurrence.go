@@ -0,0 +1,328 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRecurrenceSearch bounds how far ahead the next occurrence is searched
+const maxRecurrenceSearch = 5 * 366 * 24 * time.Hour
+
+// recurrence is a set of start times at minute resolution, parsed from a
+// cron expression or an RRULE
+type recurrence struct {
+	minutes  uint64 // bit 0-59
+	hours    uint64 // bit 0-23
+	days     uint64 // bit 1-31
+	months   uint64 // bit 1-12
+	weekdays uint64 // bit 0-6, Sunday is 0
+	dayOr    bool   // cron: day of month and day of week match independently
+	until    time.Time
+	location *time.Location
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronWeekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+var rruleWeekdays = map[string]int{
+	"SU": 0, "MO": 1, "TU": 2, "WE": 3, "TH": 4, "FR": 5, "SA": 6,
+}
+
+// parseCron parses a five-field cron expression (minute, hour, day of month,
+// month, day of week) or one of the @daily style macros
+func parseCron(expr string, location *time.Location) (*recurrence, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	r := &recurrence{location: location}
+	var err error
+	if r.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if r.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if r.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if r.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	if r.weekdays, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("invalid day of week field: %w", err)
+	}
+
+	// 7 is Sunday as well
+	if r.weekdays&(1<<7) != 0 {
+		r.weekdays = r.weekdays&^(1<<7) | 1
+	}
+
+	// Like cron, a restricted day of month and day of week match either
+	r.dayOr = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+
+	return r, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = cronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			value, err := cronValue(part, names)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			high = value
+			if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToLower(s)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return value, nil
+}
+
+// parseRRule parses the subset of RFC 5545 recurrence rules that maps onto
+// fixed minutes, hours, days and months: FREQ (HOURLY to YEARLY), BYMINUTE,
+// BYHOUR, BYDAY without ordinals, BYMONTHDAY, BYMONTH and UNTIL. Components
+// that are not given default to the start of the period, e.g. midnight for
+// DAILY and the first of the month for MONTHLY.
+func parseRRule(rule string, location *time.Location) (*recurrence, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+
+	parts := make(map[string]string)
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rrule part %q", part)
+		}
+		parts[strings.ToUpper(kv[0])] = strings.ToUpper(kv[1])
+	}
+
+	r := &recurrence{location: location, months: rangeBits(1, 12)}
+	var err error
+	for key, value := range parts {
+		switch key {
+		case "FREQ":
+		case "INTERVAL":
+			if value != "1" {
+				return nil, errors.New("rrule INTERVAL other than 1 is not supported")
+			}
+		case "BYMINUTE":
+			r.minutes, err = parseRRuleList(value, 0, 59)
+		case "BYHOUR":
+			r.hours, err = parseRRuleList(value, 0, 23)
+		case "BYMONTHDAY":
+			r.days, err = parseRRuleList(value, 1, 31)
+		case "BYMONTH":
+			r.months, err = parseRRuleList(value, 1, 12)
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := rruleWeekdays[day]
+				if !ok {
+					return nil, fmt.Errorf("unsupported rrule BYDAY value %q", day)
+				}
+				r.weekdays |= 1 << uint(weekday)
+			}
+		case "UNTIL":
+			r.until, err = parseRRuleUntil(value, location)
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("rrule %s is not supported", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid rrule %s: %w", key, err)
+		}
+	}
+
+	if r.minutes == 0 {
+		r.minutes = 1
+	}
+
+	switch parts["FREQ"] {
+	case "HOURLY":
+		if r.hours == 0 {
+			r.hours = rangeBits(0, 23)
+		}
+	case "DAILY":
+	case "WEEKLY":
+		if r.weekdays == 0 {
+			return nil, errors.New("rrule FREQ=WEEKLY requires BYDAY")
+		}
+	case "MONTHLY":
+		if r.days == 0 && r.weekdays == 0 {
+			r.days = 1 << 1
+		}
+	case "YEARLY":
+		if _, ok := parts["BYMONTH"]; !ok {
+			r.months = 1 << 1
+		}
+		if r.days == 0 && r.weekdays == 0 {
+			r.days = 1 << 1
+		}
+	case "":
+		return nil, errors.New("rrule FREQ is required")
+	default:
+		return nil, fmt.Errorf("rrule FREQ=%s is not supported", parts["FREQ"])
+	}
+
+	if r.hours == 0 {
+		r.hours = 1
+	}
+	if r.days == 0 {
+		r.days = rangeBits(1, 31)
+	}
+	if r.weekdays == 0 {
+		r.weekdays = rangeBits(0, 6)
+	}
+
+	return r, nil
+}
+
+func parseRRuleList(value string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("value %q is out of range %d-%d", item, min, max)
+		}
+		bits |= 1 << uint(n)
+	}
+	return bits, nil
+}
+
+func parseRRuleUntil(value string, location *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, location); err == nil {
+		return t, nil
+	}
+	// A date includes the whole day
+	t, err := time.ParseInLocation("20060102", value, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+func rangeBits(low, high int) uint64 {
+	var bits uint64
+	for v := low; v <= high; v++ {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+// next returns the first start time strictly after t, or the zero time if
+// there is none within the search horizon
+func (r *recurrence) next(t time.Time) time.Time {
+	// Truncate the instant rather than rebuilding it from the wall clock,
+	// which would move times in a repeated hour back to the first pass
+	t = t.In(r.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxRecurrenceSearch)
+
+	for t.Before(limit) {
+		if !r.until.IsZero() && t.After(r.until) {
+			return time.Time{}
+		}
+
+		previous := t
+		switch {
+		case r.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, r.location)
+		case !r.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, r.location)
+		case r.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, r.location)
+		case r.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+
+		// Daylight saving transitions can map a wall clock time backwards
+		if !t.After(previous) {
+			t = previous.Add(time.Hour)
+		}
+	}
+
+	return time.Time{}
+}
+
+func (r *recurrence) dayMatches(t time.Time) bool {
+	day := r.days&(1<<uint(t.Day())) != 0
+	weekday := r.weekdays&(1<<uint(t.Weekday())) != 0
+	if r.dayOr {
+		return day || weekday
+	}
+	return day && weekday
+}
@@ -0,0 +1,159 @@
+package executor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNext(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	monday := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		cron     string
+		rrule    string
+		location *time.Location
+		from     time.Time
+		want     time.Time // zero if there is no next occurrence
+	}{
+		{name: "every 15 minutes", cron: "*/15 * * * *", from: monday, want: utc(2024, 1, 15, 10, 45)},
+		{name: "strictly after", cron: "30 10 * * *", from: monday, want: utc(2024, 1, 16, 10, 30)},
+		{name: "seconds are ignored", cron: "31 10 * * *", from: monday.Add(30 * time.Second), want: utc(2024, 1, 15, 10, 31)},
+		{name: "weekdays", cron: "0 9 * * 1-5", from: monday, want: utc(2024, 1, 16, 9, 0)},
+		{name: "weekday names", cron: "0 9 * * sat,sun", from: monday, want: utc(2024, 1, 20, 9, 0)},
+		{name: "sunday as 7", cron: "0 0 * * 7", from: monday, want: utc(2024, 1, 21, 0, 0)},
+		{name: "macro", cron: "@hourly", from: monday, want: utc(2024, 1, 15, 11, 0)},
+		{name: "first of month", cron: "0 0 1 * *", from: monday, want: utc(2024, 2, 1, 0, 0)},
+		{name: "day of month or weekday", cron: "0 12 13 * 5", from: monday, want: utc(2024, 1, 19, 12, 0)},
+		{name: "month names", cron: "0 0 * feb-mar 0", from: monday, want: utc(2024, 2, 4, 0, 0)},
+		{name: "skips short months", cron: "0 0 31 * *", from: utc(2024, 2, 1, 0, 0), want: utc(2024, 3, 31, 0, 0)},
+		{name: "leap day", cron: "0 0 29 2 *", from: utc(2024, 3, 1, 0, 0), want: utc(2028, 2, 29, 0, 0)},
+		{name: "never within horizon", cron: "0 0 31 2 *", from: monday},
+		{name: "location", cron: "5 4 * * *", location: tokyo, from: monday, want: utc(2024, 1, 15, 19, 5)},
+
+		{name: "rrule daily", rrule: "FREQ=DAILY;BYHOUR=2;BYMINUTE=30", from: monday, want: utc(2024, 1, 16, 2, 30)},
+		{name: "rrule prefix", rrule: "RRULE:FREQ=HOURLY;BYMINUTE=10", from: monday, want: utc(2024, 1, 15, 11, 10)},
+		{name: "rrule weekly", rrule: "FREQ=WEEKLY;BYDAY=MO,WE;BYHOUR=9", from: monday, want: utc(2024, 1, 17, 9, 0)},
+		{name: "rrule monthly default day", rrule: "FREQ=MONTHLY", from: monday, want: utc(2024, 2, 1, 0, 0)},
+		{name: "rrule monthly later today", rrule: "FREQ=MONTHLY;BYMONTHDAY=15;BYHOUR=12", from: monday, want: utc(2024, 1, 15, 12, 0)},
+		{name: "rrule yearly", rrule: "FREQ=YEARLY", from: monday, want: utc(2025, 1, 1, 0, 0)},
+		{name: "rrule yearly by month", rrule: "FREQ=YEARLY;BYMONTH=6;BYMONTHDAY=30", from: monday, want: utc(2024, 6, 30, 0, 0)},
+		{name: "rrule until date includes the day", rrule: "FREQ=DAILY;BYHOUR=9;UNTIL=20240116", from: monday, want: utc(2024, 1, 16, 9, 0)},
+		{name: "rrule until passed", rrule: "FREQ=DAILY;BYHOUR=9;UNTIL=20240116", from: utc(2024, 1, 16, 9, 0)},
+		{name: "rrule until timestamp", rrule: "FREQ=DAILY;UNTIL=20240115T120000Z", from: monday},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := tt.location
+			if location == nil {
+				location = time.UTC
+			}
+
+			var r *recurrence
+			var err error
+			if tt.cron != "" {
+				r, err = parseCron(tt.cron, location)
+			} else {
+				r, err = parseRRule(tt.rrule, location)
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			got := r.next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceParseErrors(t *testing.T) {
+	crons := []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+	}
+	for _, expr := range crons {
+		if _, err := parseCron(expr, time.UTC); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want error", expr)
+		}
+	}
+
+	rrules := []string{
+		"BYHOUR=1",
+		"FREQ=SECONDLY",
+		"FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=2",
+		"FREQ=DAILY;COUNT=3",
+		"FREQ=DAILY;BYDAY=1MO",
+		"FREQ=DAILY;BYHOUR=24",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ",
+	}
+	for _, rule := range rrules {
+		if _, err := parseRRule(rule, time.UTC); err == nil {
+			t.Errorf("parseRRule(%q) succeeded, want error", rule)
+		}
+	}
+}
+
+// Occurrences must keep increasing when clocks are set back or forward. A
+// time in the repeated hour occurs twice, a time in the skipped hour not at
+// all.
+func TestRecurrenceNextDaylightSaving(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		cron  string
+		from  time.Time
+		until time.Time
+		count int
+	}{
+		// 1:00 to 1:59 happens twice on November 3rd
+		{"fall back, every 20 minutes", "*/20 * * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, newYork), time.Date(2024, 11, 3, 3, 0, 0, 0, newYork), 11},
+		{"fall back, daily", "30 1 * * *", time.Date(2024, 11, 2, 12, 0, 0, 0, newYork), time.Date(2024, 11, 5, 0, 0, 0, 0, newYork), 3},
+		// 2:00 to 2:59 does not exist on March 10th
+		{"spring forward, every 20 minutes", "*/20 * * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 10, 4, 0, 0, 0, newYork), 8},
+		{"spring forward, daily", "30 2 * * *", time.Date(2024, 3, 9, 12, 0, 0, 0, newYork), time.Date(2024, 3, 12, 0, 0, 0, 0, newYork), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseCron(tt.cron, newYork)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			count := 0
+			for previous := tt.from; ; count++ {
+				at := r.next(previous)
+				if !at.After(previous) {
+					t.Fatalf("next(%s) = %s, not after it", previous, at)
+				}
+				if !at.Before(tt.until) {
+					break
+				}
+				previous = at
+			}
+			if count != tt.count {
+				t.Errorf("%d occurrences, want %d", count, tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package executor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ResourceRequests are the resources a task expects to use while it runs.
+// CPU is in cores, memory and disk in bytes.
+type ResourceRequests struct {
+	CPU    float64 `json:"cpu,omitempty"`
+	Memory int64   `json:"memory,omitempty"`
+	Disk   int64   `json:"disk,omitempty"`
+}
+
+// ResourceStatus describes the schedulable capacity and its reservations
+type ResourceStatus struct {
+	Enabled    bool             `json:"enabled"`
+	Capacity   ResourceRequests `json:"capacity"` // after headroom, zero if unknown
+	Reserved   ResourceRequests `json:"reserved"`
+	Available  ResourceRequests `json:"available"`
+	Running    int              `json:"running"` // tasks holding a reservation
+	Pending    []string         `json:"pending"` // tasks waiting for resources, in dispatch order
+	MeasuredAt time.Time        `json:"measured_at"`
+}
+
+// byteUnits are the suffixes accepted in memory and disk requests
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"t":   1e12,
+	"tb":  1e12,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseResourceRequests parses resource requests from decoded JSON. CPU is a
+// number of cores or millicores ("500m"), memory and disk are bytes or
+// quantities such as "512Mi" or "2G".
+func ParseResourceRequests(data interface{}) (*ResourceRequests, error) {
+	spec, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("requests must be an object")
+	}
+
+	requests := &ResourceRequests{}
+	for key, value := range spec {
+		var err error
+		switch key {
+		case "cpu":
+			requests.CPU, err = parseCPUQuantity(value)
+		case "memory":
+			requests.Memory, err = parseByteQuantity(value)
+		case "disk":
+			requests.Disk, err = parseByteQuantity(value)
+		default:
+			return nil, fmt.Errorf("unknown resource request: %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s request: %w", key, err)
+		}
+	}
+
+	return requests, nil
+}
+
+func parseCPUQuantity(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		s := strings.TrimSpace(v)
+		if strings.HasSuffix(s, "m") {
+			millis, err := strconv.ParseFloat(strings.TrimSuffix(s, "m"), 64)
+			return millis / 1000, err
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	return 0, fmt.Errorf("unsupported value %v", value)
+}
+
+func parseByteQuantity(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case float64:
+		return int64(v), nil
+	case string:
+		s := strings.TrimSpace(v)
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i < 0 {
+			i = len(s)
+		}
+		number, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, err
+		}
+		unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit in %q", v)
+		}
+		return int64(number * unit), nil
+	}
+	return 0, fmt.Errorf("unsupported value %v", value)
+}
+
+// validate rejects negative requests
+func (r *ResourceRequests) validate() error {
+	if r.CPU < 0 || math.IsNaN(r.CPU) || r.Memory < 0 || r.Disk < 0 {
+		return errors.New("resource requests must not be negative")
+	}
+	return nil
+}
+
+func (r *ResourceRequests) isZero() bool {
+	return r.CPU == 0 && r.Memory == 0 && r.Disk == 0
+}
+
+func (r *ResourceRequests) add(other ResourceRequests) {
+	r.CPU += other.CPU
+	r.Memory += other.Memory
+	r.Disk += other.Disk
+}
+
+func (r *ResourceRequests) sub(other ResourceRequests) {
+	r.CPU -= other.CPU
+	r.Memory -= other.Memory
+	r.Disk -= other.Disk
+}
+
+// measureCapacity returns the schedulable capacity: the host's resources,
+// or the configured overrides, minus the headroom
+func measureCapacity(cfg config.ResourcesConfig) ResourceRequests {
+	total := ResourceRequests{CPU: cfg.CPU, Memory: cfg.Memory, Disk: cfg.Disk}
+	if total.CPU <= 0 {
+		total.CPU = float64(hostCPUs())
+	}
+	if total.Memory <= 0 {
+		total.Memory = hostMemory()
+	}
+	if total.Disk <= 0 {
+		total.Disk = diskSize(cfg.DiskPath)
+	}
+
+	keep := 1 - cfg.HeadroomPercent/100
+	return ResourceRequests{
+		CPU:    total.CPU * keep,
+		Memory: int64(float64(total.Memory) * keep),
+		Disk:   int64(float64(total.Disk) * keep),
+	}
+}
+
+// hostCPUs counts the CPUs listed in /proc/stat
+func hostCPUs() int {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) > 3 && strings.HasPrefix(line, "cpu") && line[3] >= '0' && line[3] <= '9' {
+			count++
+		}
+	}
+	if count == 0 {
+		return runtime.NumCPU()
+	}
+	return count
+}
+
+// hostMemory returns MemTotal from /proc/meminfo in bytes, or 0 if unknown
+func hostMemory() int64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// diskSize returns the size of the file system holding path, or 0 if unknown.
+// The path does not have to exist yet.
+func diskSize(path string) int64 {
+	if path == "" {
+		return 0
+	}
+
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(path, &stat)
+		if err == nil {
+			return int64(stat.Blocks) * int64(stat.Bsize)
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0
+		}
+		path = parent
+	}
+}
+
+// GetResourceStatus returns the schedulable capacity, current reservations
+// and the tasks waiting for resources
+func (e *Executor) GetResourceStatus() *ResourceStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.resourceStatus()
+}
+
+// resourceStatus must be called with e.mu held
+func (e *Executor) resourceStatus() *ResourceStatus {
+	status := &ResourceStatus{
+		Enabled:    e.config.Resources.Enabled,
+		Capacity:   e.capacity,
+		Reserved:   e.reserved,
+		Available:  e.available(),
+		Running:    len(e.reservations),
+		Pending:    make([]string, 0, len(e.held)),
+		MeasuredAt: e.capacityMeasuredAt,
+	}
+	for _, task := range e.held {
+		status.Pending = append(status.Pending, task.ID)
+	}
+	return status
+}
+
+// available returns the unreserved capacity. Must be called with e.mu held.
+func (e *Executor) available() ResourceRequests {
+	available := e.capacity
+	available.sub(e.reserved)
+	return available
+}
+
+// schedule reserves the resources a task requests, or holds the task as
+// pending when they do not fit yet. It reports whether the task was held.
+// Must be called with e.mu held.
+func (e *Executor) schedule(task *Task) (bool, error) {
+	if !e.config.Resources.Enabled || task.Requests == nil || task.Requests.isZero() {
+		return false, nil
+	}
+
+	if err := e.checkCapacity(*task.Requests); err != nil {
+		return false, err
+	}
+
+	// Pending tasks of the same or higher priority go first
+	reason := e.shortfall(*task.Requests)
+	if len(e.held) > 0 && e.held[0].Priority >= task.Priority {
+		reason = "waiting behind pending task " + e.held[0].ID
+	}
+	if reason == "" {
+		e.reserve(task)
+		return false, nil
+	}
+
+	if len(e.held)+len(e.taskQueue) >= cap(e.taskQueue) {
+		return false, e.reject(task, "task queue is full")
+	}
+
+	e.hold(task, reason)
+	return true, nil
+}
+
+// checkCapacity rejects requests that could never be scheduled. Must be
+// called with e.mu held.
+func (e *Executor) checkCapacity(requests ResourceRequests) error {
+	switch {
+	case e.capacity.CPU > 0 && requests.CPU > e.capacity.CPU:
+		return fmt.Errorf("cpu request %s exceeds schedulable capacity %s", formatCPU(requests.CPU), formatCPU(e.capacity.CPU))
+	case e.capacity.Memory > 0 && requests.Memory > e.capacity.Memory:
+		return fmt.Errorf("memory request %s exceeds schedulable capacity %s", formatBytes(requests.Memory), formatBytes(e.capacity.Memory))
+	case e.capacity.Disk > 0 && requests.Disk > e.capacity.Disk:
+		return fmt.Errorf("disk request %s exceeds schedulable capacity %s", formatBytes(requests.Disk), formatBytes(e.capacity.Disk))
+	}
+	return nil
+}
+
+// shortfall explains which requested resources are not available, or
+// returns an empty string if the requests fit. Resources of unknown
+// capacity are not checked. Must be called with e.mu held.
+func (e *Executor) shortfall(requests ResourceRequests) string {
+	available := e.available()
+
+	var missing []string
+	if e.capacity.CPU > 0 && requests.CPU > available.CPU+1e-9 {
+		missing = append(missing, fmt.Sprintf("cpu (requested %s, %s of %s available)",
+			formatCPU(requests.CPU), formatCPU(available.CPU), formatCPU(e.capacity.CPU)))
+	}
+	if e.capacity.Memory > 0 && requests.Memory > available.Memory {
+		missing = append(missing, fmt.Sprintf("memory (requested %s, %s of %s available)",
+			formatBytes(requests.Memory), formatBytes(available.Memory), formatBytes(e.capacity.Memory)))
+	}
+	if e.capacity.Disk > 0 && requests.Disk > available.Disk {
+		missing = append(missing, fmt.Sprintf("disk (requested %s, %s of %s available)",
+			formatBytes(requests.Disk), formatBytes(available.Disk), formatBytes(e.capacity.Disk)))
+	}
+
+	if len(missing) == 0 {
+		return ""
+	}
+	return "insufficient " + strings.Join(missing, ", ")
+}
+
+// reserve records a task's requests. Must be called with e.mu held.
+func (e *Executor) reserve(task *Task) {
+	e.reservations[task.ID] = *task.Requests
+	e.reserved.add(*task.Requests)
+}
+
+// release frees a task's reservation, if any. Must be called with e.mu held.
+func (e *Executor) release(task *Task) {
+	if requests, ok := e.reservations[task.ID]; ok {
+		delete(e.reservations, task.ID)
+		e.reserved.sub(requests)
+	}
+}
+
+// hold adds a task to the pending tasks, ordered by priority and then by
+// submission. Must be called with e.mu held.
+func (e *Executor) hold(task *Task, reason string) {
+	task.Status = TaskStatusPending
+	task.PendingReason = reason
+
+	i := len(e.held)
+	for j, held := range e.held {
+		if held.Priority < task.Priority {
+			i = j
+			break
+		}
+	}
+	e.held = append(e.held, nil)
+	copy(e.held[i+1:], e.held[i:])
+	e.held[i] = task
+	e.tasks[task.ID] = task
+
+	e.wg.Add(1)
+	go e.awaitHeld(task)
+
+	e.logger.WithFields(logrus.Fields{
+		"task_id": task.ID,
+		"reason":  reason,
+	}).Info("Task pending until resources are available")
+}
+
+// removeHeld removes a task from the pending tasks and reports whether it
+// was pending. Must be called with e.mu held.
+func (e *Executor) removeHeld(task *Task) bool {
+	for i, held := range e.held {
+		if held == task {
+			e.held = append(e.held[:i], e.held[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchHeld queues pending tasks whose requests fit, in order. Tasks
+// behind one that does not fit keep waiting, so large requests are not
+// starved by smaller ones. When the queue is full it is called again as
+// workers take tasks off the queue. Nothing is dispatched once Stop has
+// closed the queue. Must be called with e.mu held.
+func (e *Executor) dispatchHeld() {
+	if e.ctx == nil || e.ctx.Err() != nil {
+		return
+	}
+
+	for len(e.held) > 0 {
+		task := e.held[0]
+		if reason := e.shortfall(*task.Requests); reason != "" {
+			task.PendingReason = reason
+			break
+		}
+
+		e.reserve(task)
+		select {
+		case e.taskQueue <- task:
+		default:
+			e.release(task)
+			task.PendingReason = "task queue is full"
+			return
+		}
+
+		e.held = e.held[1:]
+		task.Status = TaskStatusQueued
+		task.PendingReason = ""
+		e.publishQueued(task)
+	}
+
+	if len(e.held) > 1 {
+		for _, task := range e.held[1:] {
+			task.PendingReason = "waiting behind pending task " + e.held[0].ID
+		}
+	}
+}
+
+// taskDequeued is called by workers after taking a task off the queue. It
+// marks the task running, so Stop cancels it, and dispatches pending tasks
+// held back by a full queue.
+func (e *Executor) taskDequeued(task *Task) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	task.Status = TaskStatusRunning
+	task.StartedAt = time.Now()
+	e.runningTasks[task.ID] = task
+
+	if len(e.held) > 0 {
+		e.dispatchHeld()
+	}
+}
+
+// awaitHeld finishes a pending task that is cancelled before its resources
+// become available, including by Stop. The task timeout only starts once
+// the task runs.
+func (e *Executor) awaitHeld(task *Task) {
+	defer e.wg.Done()
+	<-task.ctx.Done()
+
+	e.mu.Lock()
+	held := e.removeHeld(task)
+	reason := task.PendingReason
+	if held {
+		task.PendingReason = ""
+		e.dispatchHeld()
+	}
+	e.mu.Unlock()
+
+	if !held {
+		return
+	}
+
+	e.processResult(&TaskResult{
+		TaskID:     task.ID,
+		Status:     TaskStatusCancelled,
+		ExitCode:   -1,
+		Error:      "cancelled while waiting for resources",
+		FinishedAt: time.Now(),
+		Metadata:   map[string]interface{}{"pending_reason": reason},
+	})
+}
+
+func formatCPU(cores float64) string {
+	return strconv.FormatFloat(math.Round(cores*1000)/1000, 'f', -1, 64)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
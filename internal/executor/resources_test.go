@@ -0,0 +1,168 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// resourceExecutor starts an executor with one schedulable CPU
+func resourceExecutor(t *testing.T, queueSize int) *Executor {
+	cfg := testConfig(t)
+	cfg.QueueSize = queueSize
+	cfg.Resources.Enabled = true
+	cfg.Resources.CPU = 1
+	cfg.Resources.Memory = 1 << 30
+	cfg.Resources.Disk = 1 << 30
+	return startExecutor(t, cfg)
+}
+
+func cpuTask(cpu float64) *Task {
+	task := sleepTask(30 * time.Second)
+	task.Requests = &ResourceRequests{CPU: cpu}
+	return task
+}
+
+func TestHeldTasksDispatchInOrder(t *testing.T) {
+	e := resourceExecutor(t, 10)
+
+	first, err := e.SubmitTask(cpuTask(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, first, TaskStatusRunning)
+
+	second, err := e.SubmitTask(cpuTask(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := e.SubmitTask(cpuTask(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := e.GetResourceStatus()
+	if len(status.Pending) != 2 || status.Pending[0] != second || status.Pending[1] != third {
+		t.Fatalf("pending = %v, want [%s %s]", status.Pending, second, third)
+	}
+	task, _ := e.GetTask(third)
+	if task.Status != TaskStatusPending || task.PendingReason != "waiting behind pending task "+second {
+		t.Errorf("third task = %s (%s), want pending behind %s", task.Status, task.PendingReason, second)
+	}
+
+	if err := e.CancelTask(first); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, second, TaskStatusRunning)
+	if task := waitForStatus(t, e, third, TaskStatusPending); task.PendingReason == "" {
+		t.Error("third task has no pending reason")
+	}
+}
+
+func TestCancelHeldTask(t *testing.T) {
+	e := resourceExecutor(t, 10)
+
+	running, err := e.SubmitTask(cpuTask(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, running, TaskStatusRunning)
+
+	held, err := e.SubmitTask(cpuTask(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CancelTask(held); err != nil {
+		t.Fatal(err)
+	}
+
+	task := waitForStatus(t, e, held, TaskStatusCancelled)
+	waitFor(t, "the cancelled task's result", func() bool {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return task.Result != nil
+	})
+	if len(e.GetResourceStatus().Pending) != 0 {
+		t.Error("cancelled task is still pending")
+	}
+}
+
+func TestStopWithHeldTasks(t *testing.T) {
+	e := resourceExecutor(t, 10)
+
+	running, err := e.SubmitTask(cpuTask(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, running, TaskStatusRunning)
+
+	// Held tasks of ExecuteTask callers have contexts Stop does not own
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := e.ExecuteTask(context.Background(), cpuTask(0.5))
+			errs <- err
+		}()
+	}
+	waitFor(t, "two pending tasks", func() bool {
+		return len(e.GetResourceStatus().Pending) == 2
+	})
+
+	stopExecutor(t, e)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("ExecuteTask of a held task succeeded after Stop")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ExecuteTask did not return after Stop")
+		}
+	}
+}
+
+func TestHeldTaskRejectedWhenQueueFull(t *testing.T) {
+	e := resourceExecutor(t, 1)
+
+	running, err := e.SubmitTask(cpuTask(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, running, TaskStatusRunning)
+
+	if _, err := e.SubmitTask(cpuTask(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.SubmitTask(cpuTask(1)); err == nil {
+		t.Error("SubmitTask succeeded with the queue full of pending tasks")
+	}
+}
+
+func TestParseResourceRequests(t *testing.T) {
+	tests := []struct {
+		spec    map[string]interface{}
+		want    ResourceRequests
+		wantErr bool
+	}{
+		{spec: map[string]interface{}{"cpu": 1.5}, want: ResourceRequests{CPU: 1.5}},
+		{spec: map[string]interface{}{"cpu": "500m"}, want: ResourceRequests{CPU: 0.5}},
+		{spec: map[string]interface{}{"memory": "512Mi", "disk": "2G"}, want: ResourceRequests{Memory: 512 << 20, Disk: 2e9}},
+		{spec: map[string]interface{}{"memory": float64(1024)}, want: ResourceRequests{Memory: 1024}},
+		{spec: map[string]interface{}{"memory": "1XB"}, wantErr: true},
+		{spec: map[string]interface{}{"gpu": 1.0}, wantErr: true},
+		{spec: map[string]interface{}{"cpu": true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseResourceRequests(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseResourceRequests(%v) succeeded, want an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil || *got != tt.want {
+			t.Errorf("ParseResourceRequests(%v) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
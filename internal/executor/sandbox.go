@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/sandbox"
+)
+
+// validateSandboxProfiles checks the configured sandbox profiles
+func validateSandboxProfiles(profiles map[string]config.SandboxProfile) error {
+	for name, profile := range profiles {
+		if err := sandbox.ValidateSeccomp(profile.Seccomp); err != nil {
+			return fmt.Errorf("sandbox profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sandboxProfile returns the sandbox profile requested by a task, or nil
+func sandboxProfile(profiles map[string]config.SandboxProfile, task *Task) (*config.SandboxProfile, error) {
+	if task.Sandbox == "" {
+		return nil, nil
+	}
+
+	profile, ok := profiles[task.Sandbox]
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox profile: %s", task.Sandbox)
+	}
+
+	return &profile, nil
+}
+
+// newCommand creates a command, running it inside the sandbox profile if set.
+// The command leads its own process group so it can be paused and killed
+// together with its children.
+func newCommand(ctx context.Context, profile *config.SandboxProfile, name string, args ...string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if profile != nil {
+		var err error
+		if cmd, err = sandbox.Command(ctx, *profile, name, args...); err != nil {
+			return nil, fmt.Errorf("failed to create sandbox: %w", err)
+		}
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	return cmd, nil
+}
+
+// runTracked runs a command, recording its process on the task while it runs
+// and reading the progress the process reports. The command is moved into a
+// cgroup of its own where the freezer is available. When ctx is done the
+// whole process group is killed, thawing it first if it was frozen.
+func runTracked(ctx context.Context, cmd *exec.Cmd, task *Task, bus *events.Bus) error {
+	progress, writer, err := attachProgress(cmd, task, bus)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		progress.reader.Close()
+		return err
+	}
+
+	go progress.run()
+	defer progress.close()
+
+	cgroup, _ := newTaskCgroup(task.ID, cmd.Process.Pid)
+	if cgroup != nil {
+		defer cgroup.remove()
+	}
+	task.setProcess(cmd.Process, cgroup)
+	defer task.setProcess(nil, nil)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			signalProcessGroup(cmd.Process, syscall.SIGKILL)
+			task.thaw()
+		case <-done:
+		}
+	}()
+
+	return cmd.Wait()
+}
@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+func TestSandboxProfiles(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SandboxProfiles = map[string]config.SandboxProfile{
+		"broken": {Seccomp: config.SeccompConfig{Enabled: true, DenySyscalls: []string{"no_such_syscall"}}},
+	}
+	if _, err := New(cfg, nil, testLogger()); err == nil {
+		t.Error("New() accepted a profile with an unsupported syscall")
+	}
+
+	cfg.SandboxProfiles = map[string]config.SandboxProfile{"offline": {NetworkNamespace: true}}
+	e := startExecutor(t, cfg)
+
+	task := &Task{Type: TaskTypeCommand, Command: "true", Sandbox: "missing"}
+	if _, err := e.SubmitTask(task); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("SubmitTask() with an unknown profile error = %v, want ErrInvalidTask", err)
+	}
+
+	profile, err := sandboxProfile(cfg.SandboxProfiles, &Task{Sandbox: "offline"})
+	if err != nil || profile == nil || !profile.NetworkNamespace {
+		t.Errorf("sandboxProfile() = %+v, %v", profile, err)
+	}
+	if profile, err := sandboxProfile(cfg.SandboxProfiles, &Task{}); profile != nil || err != nil {
+		t.Errorf("sandboxProfile() without a profile = %+v, %v, want nil", profile, err)
+	}
+}
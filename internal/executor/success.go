@@ -0,0 +1,199 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SuccessCriteria decides the outcome of a task from its exit code and output
+// instead of treating every nonzero exit as a failure
+type SuccessCriteria struct {
+	ExitCodes         []int                  `json:"exit_codes,omitempty"`         // successful exit codes, [0] by default
+	WarningExitCodes  []int                  `json:"warning_exit_codes,omitempty"` // exit codes reported as warning
+	StdoutRegex       string                 `json:"stdout_regex,omitempty"`       // stdout must match
+	StderrMustBeEmpty bool                   `json:"stderr_must_be_empty,omitempty"`
+	JSONPathEquals    map[string]interface{} `json:"json_path_equals,omitempty"` // dotted path into stdout JSON -> expected value
+}
+
+// exitError reports a process that ran and exited with a nonzero code
+type exitError struct {
+	kind   string
+	code   int
+	stderr string
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("%s failed with exit code %d: %s", e.kind, e.code, e.stderr)
+}
+
+// ParseSuccessCriteria parses success criteria from decoded JSON
+func ParseSuccessCriteria(data interface{}) (*SuccessCriteria, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid success criteria: %w", err)
+	}
+
+	var criteria SuccessCriteria
+	if err := json.Unmarshal(encoded, &criteria); err != nil {
+		return nil, fmt.Errorf("invalid success criteria: %w", err)
+	}
+
+	return &criteria, nil
+}
+
+// validate checks that the criteria can be evaluated
+func (c *SuccessCriteria) validate() error {
+	if c.StdoutRegex != "" {
+		if _, err := regexp.Compile(c.StdoutRegex); err != nil {
+			return fmt.Errorf("invalid stdout_regex: %w", err)
+		}
+	}
+
+	for path := range c.JSONPathEquals {
+		if len(splitJSONPath(path)) == 0 {
+			return fmt.Errorf("invalid json_path_equals path: %q", path)
+		}
+	}
+
+	return nil
+}
+
+// taskOutcome decides the final status of a task from the executor error and
+// the task's success criteria
+func taskOutcome(task *Task, result *TaskResult, runErr error) (TaskStatus, error) {
+	criteria := task.Success
+	if criteria == nil {
+		if runErr != nil {
+			return TaskStatusFailed, runErr
+		}
+		return TaskStatusCompleted, nil
+	}
+
+	// Errors other than a nonzero exit mean the task did not run to completion
+	var exitErr *exitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return TaskStatusFailed, runErr
+	}
+
+	status := TaskStatusCompleted
+	exitCodes := criteria.ExitCodes
+	if len(exitCodes) == 0 {
+		exitCodes = []int{0}
+	}
+
+	switch {
+	case containsInt(criteria.WarningExitCodes, result.ExitCode):
+		status = TaskStatusWarning
+	case !containsInt(exitCodes, result.ExitCode):
+		if runErr != nil {
+			return TaskStatusFailed, runErr
+		}
+		return TaskStatusFailed, fmt.Errorf("exit code %d is not a success exit code", result.ExitCode)
+	}
+
+	if criteria.StderrMustBeEmpty && strings.TrimSpace(result.Error) != "" {
+		return TaskStatusFailed, fmt.Errorf("stderr is not empty: %s", result.Error)
+	}
+
+	if criteria.StdoutRegex != "" {
+		matched, err := regexp.MatchString(criteria.StdoutRegex, result.Output)
+		if err != nil {
+			return TaskStatusFailed, fmt.Errorf("invalid stdout_regex: %w", err)
+		}
+		if !matched {
+			return TaskStatusFailed, fmt.Errorf("stdout does not match %q", criteria.StdoutRegex)
+		}
+	}
+
+	if len(criteria.JSONPathEquals) > 0 {
+		if err := checkJSONPaths(result.Output, criteria.JSONPathEquals); err != nil {
+			return TaskStatusFailed, err
+		}
+	}
+
+	return status, nil
+}
+
+// checkJSONPaths parses output as JSON and compares the values at each path
+func checkJSONPaths(output string, expected map[string]interface{}) error {
+	var document interface{}
+	if err := json.Unmarshal([]byte(output), &document); err != nil {
+		return fmt.Errorf("stdout is not valid JSON: %w", err)
+	}
+
+	for path, want := range expected {
+		got, ok := lookupJSONPath(document, splitJSONPath(path))
+		if !ok {
+			return fmt.Errorf("json path %s not found in stdout", path)
+		}
+
+		// Normalize the expected value to the types produced by json.Unmarshal
+		normalized, err := normalizeJSON(want)
+		if err != nil {
+			return fmt.Errorf("invalid expected value for %s: %w", path, err)
+		}
+
+		if !reflect.DeepEqual(got, normalized) {
+			return fmt.Errorf("json path %s is %v, expected %v", path, got, want)
+		}
+	}
+
+	return nil
+}
+
+// splitJSONPath splits a dotted path such as "$.checks.0.status"
+func splitJSONPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// lookupJSONPath walks objects by key and arrays by index
+func lookupJSONPath(value interface{}, path []string) (interface{}, bool) {
+	for _, part := range path {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func normalizeJSON(value interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(encoded, &normalized)
+	return normalized, err
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
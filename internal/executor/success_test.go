@@ -0,0 +1,173 @@
+package executor
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTaskOutcome(t *testing.T) {
+	exit1 := &exitError{kind: "command", code: 1, stderr: "boom"}
+	exit3 := &exitError{kind: "command", code: 3}
+
+	tests := []struct {
+		name     string
+		criteria *SuccessCriteria
+		result   TaskResult
+		runErr   error
+		want     TaskStatus
+		wantErr  bool
+	}{
+		{
+			name: "no criteria, success",
+			want: TaskStatusCompleted,
+		},
+		{
+			name:    "no criteria, nonzero exit",
+			result:  TaskResult{ExitCode: 1},
+			runErr:  exit1,
+			want:    TaskStatusFailed,
+			wantErr: true,
+		},
+		{
+			name:     "default exit codes",
+			criteria: &SuccessCriteria{},
+			result:   TaskResult{ExitCode: 1},
+			runErr:   exit1,
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name:     "custom success exit code",
+			criteria: &SuccessCriteria{ExitCodes: []int{0, 3}},
+			result:   TaskResult{ExitCode: 3},
+			runErr:   exit3,
+			want:     TaskStatusCompleted,
+		},
+		{
+			name:     "zero exit not in success codes",
+			criteria: &SuccessCriteria{ExitCodes: []int{3}},
+			result:   TaskResult{ExitCode: 0},
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name:     "warning exit code",
+			criteria: &SuccessCriteria{WarningExitCodes: []int{3}},
+			result:   TaskResult{ExitCode: 3},
+			runErr:   exit3,
+			want:     TaskStatusWarning,
+		},
+		{
+			name:     "error other than exit fails",
+			criteria: &SuccessCriteria{ExitCodes: []int{0, 1}},
+			runErr:   errors.New("failed to start"),
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name:     "stderr must be empty",
+			criteria: &SuccessCriteria{StderrMustBeEmpty: true},
+			result:   TaskResult{Error: "warning: deprecated\n"},
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name:     "whitespace stderr counts as empty",
+			criteria: &SuccessCriteria{StderrMustBeEmpty: true},
+			result:   TaskResult{Error: " \n"},
+			want:     TaskStatusCompleted,
+		},
+		{
+			name:     "stdout regex matches",
+			criteria: &SuccessCriteria{StdoutRegex: `^ok \d+`},
+			result:   TaskResult{Output: "ok 42\n"},
+			want:     TaskStatusCompleted,
+		},
+		{
+			name:     "stdout regex does not match",
+			criteria: &SuccessCriteria{StdoutRegex: `^ok`},
+			result:   TaskResult{Output: "not ok\n"},
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name: "json paths equal",
+			criteria: &SuccessCriteria{JSONPathEquals: map[string]interface{}{
+				"$.status":         "healthy",
+				"checks.1.passed":  true,
+				"$.summary.failed": 0,
+			}},
+			result: TaskResult{Output: `{"status":"healthy","checks":[{"passed":false},{"passed":true}],"summary":{"failed":0}}`},
+			want:   TaskStatusCompleted,
+		},
+		{
+			name:     "json path differs",
+			criteria: &SuccessCriteria{JSONPathEquals: map[string]interface{}{"status": "healthy"}},
+			result:   TaskResult{Output: `{"status":"degraded"}`},
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name:     "json path missing",
+			criteria: &SuccessCriteria{JSONPathEquals: map[string]interface{}{"checks.5": true}},
+			result:   TaskResult{Output: `{"checks":[true]}`},
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name:     "stdout is not json",
+			criteria: &SuccessCriteria{JSONPathEquals: map[string]interface{}{"status": "healthy"}},
+			result:   TaskResult{Output: "healthy"},
+			want:     TaskStatusFailed,
+			wantErr:  true,
+		},
+		{
+			name: "warning exit still checks output",
+			criteria: &SuccessCriteria{
+				WarningExitCodes: []int{3},
+				StdoutRegex:      "done",
+			},
+			result:  TaskResult{ExitCode: 3, Output: "aborted"},
+			runErr:  exit3,
+			want:    TaskStatusFailed,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{Success: tt.criteria}
+			result := tt.result
+
+			got, err := taskOutcome(task, &result, tt.runErr)
+			if got != tt.want {
+				t.Errorf("status = %s, want %s", got, tt.want)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSuccessCriteriaValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria SuccessCriteria
+		wantErr  bool
+	}{
+		{"empty", SuccessCriteria{}, false},
+		{"valid regex", SuccessCriteria{StdoutRegex: `^ok$`}, false},
+		{"invalid regex", SuccessCriteria{StdoutRegex: `(`}, true},
+		{"valid path", SuccessCriteria{JSONPathEquals: map[string]interface{}{"$.a.b": 1}}, false},
+		{"root path", SuccessCriteria{JSONPathEquals: map[string]interface{}{"$": 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.criteria.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
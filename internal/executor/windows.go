@@ -0,0 +1,539 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Window kinds and actions
+const (
+	WindowKindMaintenance = "maintenance" // matching tasks only start inside the window
+	WindowKindBlackout    = "blackout"    // matching tasks never start inside the window
+
+	WindowActionHold   = "hold"   // hold tasks as scheduled until they may start
+	WindowActionReject = "reject" // reject tasks at submission
+
+	// maxWindowSteps bounds the search for the time a task may start
+	maxWindowSteps = 1000
+)
+
+// Window is a maintenance window or blackout period
+type Window struct {
+	Name      string
+	Kind      string
+	Action    string
+	Duration  time.Duration
+	From      time.Time
+	Until     time.Time
+	TaskTypes []string
+	Templates []string
+	Labels    map[string]string
+
+	schedule *recurrence // nil for a single period
+}
+
+// WindowStatus is the current state of a window
+type WindowStatus struct {
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	Action      string    `json:"action"`
+	Active      bool      `json:"active"`
+	ActiveUntil time.Time `json:"active_until,omitempty"`
+	NextStart   time.Time `json:"next_start,omitempty"`
+}
+
+// WindowError is returned for tasks rejected because of their windows
+type WindowError struct {
+	Windows []string  `json:"windows"`
+	OpensAt time.Time `json:"opens_at,omitempty"` // zero if no window opens again
+}
+
+func (e *WindowError) Error() string {
+	if e.OpensAt.IsZero() {
+		return fmt.Sprintf("task rejected: blocked by %s, no window opens again", strings.Join(e.Windows, ", "))
+	}
+	return fmt.Sprintf("task rejected: blocked by %s until %s", strings.Join(e.Windows, ", "), e.OpensAt.Format(time.RFC3339))
+}
+
+// Auditor records actions in the audit trail
+type Auditor interface {
+	Record(action, subject string, details map[string]interface{})
+}
+
+// SetAuditor sets the audit trail for window overrides
+func (e *Executor) SetAuditor(auditor Auditor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.auditor = auditor
+}
+
+// windowsFromConfig compiles the configured windows
+func windowsFromConfig(configs []config.WindowConfig) ([]*Window, error) {
+	windows := make([]*Window, 0, len(configs))
+	names := make(map[string]bool, len(configs))
+	for i, cfg := range configs {
+		window, err := newWindow(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i, err)
+		}
+		if names[window.Name] {
+			return nil, fmt.Errorf("duplicate window name: %s", window.Name)
+		}
+		names[window.Name] = true
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+func newWindow(cfg config.WindowConfig) (*Window, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("window name is required")
+	}
+
+	window := &Window{
+		Name:      cfg.Name,
+		Kind:      cfg.Kind,
+		Action:    cfg.Action,
+		Duration:  cfg.Duration,
+		From:      cfg.From,
+		Until:     cfg.Until,
+		TaskTypes: cfg.TaskTypes,
+		Templates: cfg.Templates,
+		Labels:    cfg.Labels,
+	}
+
+	switch window.Kind {
+	case WindowKindMaintenance, WindowKindBlackout:
+	default:
+		return nil, fmt.Errorf("window %s: kind must be %s or %s", cfg.Name, WindowKindMaintenance, WindowKindBlackout)
+	}
+
+	switch window.Action {
+	case "":
+		window.Action = WindowActionHold
+	case WindowActionHold, WindowActionReject:
+	default:
+		return nil, fmt.Errorf("window %s: action must be %s or %s", cfg.Name, WindowActionHold, WindowActionReject)
+	}
+
+	location := time.Local
+	if cfg.Timezone != "" {
+		loaded, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("window %s: invalid timezone: %w", cfg.Name, err)
+		}
+		location = loaded
+	}
+
+	var err error
+	switch {
+	case cfg.Cron != "" && cfg.RRule != "":
+		return nil, fmt.Errorf("window %s: set either cron or rrule", cfg.Name)
+	case cfg.Cron != "":
+		window.schedule, err = parseCron(cfg.Cron, location)
+	case cfg.RRule != "":
+		window.schedule, err = parseRRule(cfg.RRule, location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("window %s: %w", cfg.Name, err)
+	}
+
+	if window.schedule != nil && window.Duration <= 0 {
+		return nil, fmt.Errorf("window %s: recurring windows need a duration", cfg.Name)
+	}
+	if window.schedule == nil && (window.From.IsZero() || !window.Until.After(window.From)) {
+		return nil, fmt.Errorf("window %s: needs cron, rrule or a from/until period", cfg.Name)
+	}
+
+	return window, nil
+}
+
+// applies reports whether a window is attached to a task: by task type,
+// template or labels, or to every task if it names none of them
+func (w *Window) applies(task *Task) bool {
+	if len(w.TaskTypes) == 0 && len(w.Templates) == 0 && len(w.Labels) == 0 {
+		return true
+	}
+
+	for _, taskType := range w.TaskTypes {
+		if TaskType(taskType) == task.Type {
+			return true
+		}
+	}
+
+	if template, ok := task.Metadata["template"].(string); ok {
+		for _, name := range w.Templates {
+			if name == template {
+				return true
+			}
+		}
+	}
+
+	if len(w.Labels) > 0 {
+		for key, value := range w.Labels {
+			label, ok := task.Metadata[key]
+			if !ok || fmt.Sprint(label) != value {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// activeAt reports whether the window is open at t and when it closes
+func (w *Window) activeAt(t time.Time) (bool, time.Time) {
+	if w.schedule == nil {
+		if !t.Before(w.From) && t.Before(w.Until) {
+			return true, w.Until
+		}
+		return false, time.Time{}
+	}
+
+	// A recurrence that started within the last duration is still open
+	active, end := false, time.Time{}
+	for start := w.schedule.next(t.Add(-w.Duration)); !start.IsZero() && !start.After(t); start = w.schedule.next(start) {
+		if w.inBounds(start) {
+			active, end = true, start.Add(w.Duration)
+		}
+	}
+	return active, end
+}
+
+// nextStart returns the first time after t the window opens, or the zero time
+func (w *Window) nextStart(t time.Time) time.Time {
+	if w.schedule == nil {
+		if w.From.After(t) {
+			return w.From
+		}
+		return time.Time{}
+	}
+
+	if w.From.After(t) {
+		t = w.From.Add(-time.Nanosecond)
+	}
+	start := w.schedule.next(t)
+	if start.IsZero() || !w.inBounds(start) {
+		return time.Time{}
+	}
+	return start
+}
+
+func (w *Window) inBounds(start time.Time) bool {
+	return (w.From.IsZero() || !start.Before(w.From)) && (w.Until.IsZero() || start.Before(w.Until))
+}
+
+// status returns the state of the window at t
+func (w *Window) status(t time.Time) WindowStatus {
+	status := WindowStatus{
+		Name:      w.Name,
+		Kind:      w.Kind,
+		Action:    w.Action,
+		NextStart: w.nextStart(t),
+	}
+	status.Active, status.ActiveUntil = w.activeAt(t)
+	return status
+}
+
+// GetWindows returns the current state of all configured windows
+func (e *Executor) GetWindows() []WindowStatus {
+	now := time.Now()
+	statuses := make([]WindowStatus, 0, len(e.windows))
+	for _, window := range e.windows {
+		statuses = append(statuses, window.status(now))
+	}
+	return statuses
+}
+
+// evaluateWindows returns the windows that keep a task from starting at now
+// and the first time it may start, which is zero if it never may
+func (e *Executor) evaluateWindows(task *Task, now time.Time) ([]*Window, time.Time) {
+	var maintenance, blackouts []*Window
+	for _, window := range e.windows {
+		if !window.applies(task) {
+			continue
+		}
+		if window.Kind == WindowKindMaintenance {
+			maintenance = append(maintenance, window)
+		} else {
+			blackouts = append(blackouts, window)
+		}
+	}
+
+	blocking := blockingWindows(maintenance, blackouts, now)
+	if len(blocking) == 0 {
+		return nil, now
+	}
+
+	t := now
+	for i := 0; i < maxWindowSteps; i++ {
+		t = nextOpening(maintenance, blackouts, t)
+		if t.IsZero() {
+			break
+		}
+		if len(blockingWindows(maintenance, blackouts, t)) == 0 {
+			return blocking, t
+		}
+	}
+
+	return blocking, time.Time{}
+}
+
+// blockingWindows returns the windows that keep a task from starting at t:
+// its maintenance windows if none of them is open, and its open blackouts
+func blockingWindows(maintenance, blackouts []*Window, t time.Time) []*Window {
+	var blocking []*Window
+
+	open := len(maintenance) == 0
+	for _, window := range maintenance {
+		if active, _ := window.activeAt(t); active {
+			open = true
+			break
+		}
+	}
+	if !open {
+		blocking = append(blocking, maintenance...)
+	}
+
+	for _, window := range blackouts {
+		if active, _ := window.activeAt(t); active {
+			blocking = append(blocking, window)
+		}
+	}
+
+	return blocking
+}
+
+// nextOpening returns the earliest time after t a blocked task could start:
+// once every open blackout has ended and a maintenance window is open. It
+// returns the zero time if no maintenance window opens again.
+func nextOpening(maintenance, blackouts []*Window, t time.Time) time.Time {
+	candidate := t
+	for _, window := range blackouts {
+		if active, end := window.activeAt(t); active && end.After(candidate) {
+			candidate = end
+		}
+	}
+
+	if len(maintenance) == 0 {
+		return candidate
+	}
+	for _, window := range maintenance {
+		if active, _ := window.activeAt(candidate); active {
+			return candidate
+		}
+	}
+
+	var earliest time.Time
+	for _, window := range maintenance {
+		start := window.nextStart(candidate)
+		if !start.IsZero() && (earliest.IsZero() || start.Before(earliest)) {
+			earliest = start
+		}
+	}
+	return earliest
+}
+
+// describeWindows explains why windows block a task
+func describeWindows(windows []*Window) string {
+	var maintenance, blackouts []string
+	for _, window := range windows {
+		if window.Kind == WindowKindMaintenance {
+			maintenance = append(maintenance, window.Name)
+		} else {
+			blackouts = append(blackouts, window.Name)
+		}
+	}
+
+	var reasons []string
+	if len(maintenance) > 0 {
+		reasons = append(reasons, "outside maintenance window "+strings.Join(maintenance, ", "))
+	}
+	if len(blackouts) > 0 {
+		reasons = append(reasons, "inside blackout "+strings.Join(blackouts, ", "))
+	}
+	return strings.Join(reasons, " and ")
+}
+
+func windowNames(windows []*Window) []string {
+	names := make([]string, len(windows))
+	for i, window := range windows {
+		names[i] = window.Name
+	}
+	return names
+}
+
+// gateWindows decides whether a task may be queued now. Tasks blocked by
+// their windows are rejected, or held as scheduled until they may start.
+// Overrides are recorded in the task metadata and the audit trail. It
+// reports whether the task was held. Must be called with e.mu held.
+func (e *Executor) gateWindows(parent context.Context, task *Task) (bool, error) {
+	if len(e.windows) == 0 {
+		return false, nil
+	}
+
+	blocking, opensAt := e.evaluateWindows(task, time.Now())
+
+	if task.OverrideWindows {
+		names := windowNames(blocking)
+		if task.Metadata == nil {
+			task.Metadata = make(map[string]interface{})
+		}
+		task.Metadata["window_override"] = names
+		if e.auditor != nil {
+			e.auditor.Record("task.window_override", task.ID, map[string]interface{}{
+				"task_type": string(task.Type),
+				"name":      task.Name,
+				"bypassed":  names,
+				"reason":    task.OverrideReason,
+			})
+		}
+		if len(blocking) > 0 {
+			e.logger.WithFields(logrus.Fields{
+				"task_id": task.ID,
+				"windows": names,
+			}).Warn("Task overrides maintenance windows")
+		}
+		return false, nil
+	}
+
+	if len(blocking) == 0 {
+		return false, nil
+	}
+
+	reject := opensAt.IsZero()
+	for _, window := range blocking {
+		if window.Action == WindowActionReject {
+			reject = true
+		}
+	}
+	if reject {
+		return false, &WindowError{Windows: windowNames(blocking), OpensAt: opensAt}
+	}
+
+	task.Status = TaskStatusScheduled
+	task.ScheduledAt = opensAt
+	task.PendingReason = describeWindows(blocking)
+	e.scheduled[task.ID] = task
+	e.tasks[task.ID] = task
+
+	e.wg.Add(1)
+	go e.awaitWindow(task)
+
+	e.logger.WithFields(logrus.Fields{
+		"task_id":  task.ID,
+		"reason":   task.PendingReason,
+		"start_at": opensAt,
+	}).Info("Task scheduled for its next window")
+
+	return true, nil
+}
+
+// awaitWindow releases a scheduled task once its windows allow it to start,
+// or finishes it if it or the executor is stopped first
+func (e *Executor) awaitWindow(task *Task) {
+	defer e.wg.Done()
+
+	for {
+		e.mu.RLock()
+		startAt, ctx := task.ScheduledAt, task.ctx
+		e.mu.RUnlock()
+
+		timer := time.NewTimer(time.Until(startAt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		case <-e.ctx.Done():
+		}
+		timer.Stop()
+
+		if ctx.Err() != nil || e.ctx.Err() != nil {
+			e.finishScheduled(task)
+			return
+		}
+
+		released, err := e.releaseScheduled(task)
+		if err != nil {
+			e.processResult(&TaskResult{
+				TaskID:     task.ID,
+				Status:     TaskStatusFailed,
+				ExitCode:   -1,
+				Error:      err.Error(),
+				FinishedAt: time.Now(),
+				Metadata:   make(map[string]interface{}),
+			})
+			return
+		}
+		if released {
+			return
+		}
+	}
+}
+
+// releaseScheduled queues a scheduled task if its windows allow it to start
+// now, or moves its start time. It reports whether the task left the
+// scheduled tasks. Once Stop has closed the queue the task stays scheduled
+// until it is finished as cancelled.
+func (e *Executor) releaseScheduled(task *Task) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.scheduled[task.ID]; !ok {
+		return true, nil
+	}
+	if e.ctx.Err() != nil {
+		return false, nil
+	}
+
+	blocking, opensAt := e.evaluateWindows(task, time.Now())
+	if len(blocking) > 0 && !opensAt.IsZero() {
+		task.ScheduledAt = opensAt
+		task.PendingReason = describeWindows(blocking)
+		return false, nil
+	}
+	delete(e.scheduled, task.ID)
+
+	if len(blocking) > 0 {
+		return true, &WindowError{Windows: windowNames(blocking)}
+	}
+
+	task.Status = TaskStatusQueued
+	task.PendingReason = ""
+
+	e.logger.WithField("task_id", task.ID).Info("Scheduled task released")
+
+	return true, e.queue(task)
+}
+
+// finishScheduled finishes a scheduled task that was cancelled before its
+// window opened
+func (e *Executor) finishScheduled(task *Task) {
+	e.mu.Lock()
+	_, ok := e.scheduled[task.ID]
+	reason := task.PendingReason
+	if ok {
+		delete(e.scheduled, task.ID)
+		task.PendingReason = ""
+	}
+	e.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	e.processResult(&TaskResult{
+		TaskID:     task.ID,
+		Status:     TaskStatusCancelled,
+		ExitCode:   -1,
+		Error:      "cancelled while scheduled",
+		FinishedAt: time.Now(),
+		Metadata:   map[string]interface{}{"pending_reason": reason},
+	})
+}
@@ -0,0 +1,173 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// windowExecutor starts an executor whose only maintenance window opens at
+// from and lasts an hour
+func windowExecutor(t *testing.T, from time.Time, action string) *Executor {
+	cfg := testConfig(t)
+	cfg.Windows = []config.WindowConfig{{
+		Name:   "test",
+		Kind:   WindowKindMaintenance,
+		Action: action,
+		From:   from,
+		Until:  from.Add(time.Hour),
+	}}
+	return startExecutor(t, cfg)
+}
+
+func TestScheduledTaskReleased(t *testing.T) {
+	from := time.Now().Add(200 * time.Millisecond)
+	e := windowExecutor(t, from, WindowActionHold)
+
+	id, err := e.SubmitTask(sleepTask(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, _ := e.GetTask(id)
+	e.mu.RLock()
+	status, scheduledAt, reason := task.Status, task.ScheduledAt, task.PendingReason
+	e.mu.RUnlock()
+	if status != TaskStatusScheduled || !scheduledAt.Equal(from) {
+		t.Fatalf("task = %s at %s, want scheduled at %s", status, scheduledAt, from)
+	}
+	if reason != "outside maintenance window test" {
+		t.Errorf("pending reason = %q", reason)
+	}
+
+	waitForStatus(t, e, id, TaskStatusCompleted)
+	if time.Now().Before(from) {
+		t.Error("task finished before its window opened")
+	}
+}
+
+func TestWindowRejectAction(t *testing.T) {
+	e := windowExecutor(t, time.Now().Add(time.Hour), WindowActionReject)
+
+	_, err := e.SubmitTask(sleepTask(time.Second))
+	var windowErr *WindowError
+	if !errors.As(err, &windowErr) {
+		t.Fatalf("SubmitTask() error = %v, want a WindowError", err)
+	}
+	if len(windowErr.Windows) != 1 || windowErr.Windows[0] != "test" {
+		t.Errorf("windows = %v, want [test]", windowErr.Windows)
+	}
+
+	override := sleepTask(10 * time.Millisecond)
+	override.OverrideWindows = true
+	id, err := e.SubmitTask(override)
+	if err != nil {
+		t.Fatalf("SubmitTask() with override error = %v", err)
+	}
+	waitForStatus(t, e, id, TaskStatusCompleted)
+}
+
+func TestCancelScheduledTask(t *testing.T) {
+	e := windowExecutor(t, time.Now().Add(time.Hour), WindowActionHold)
+
+	id, err := e.SubmitTask(sleepTask(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CancelTask(id); err != nil {
+		t.Fatal(err)
+	}
+
+	task := waitForStatus(t, e, id, TaskStatusCancelled)
+	waitFor(t, "the cancelled task's result", func() bool {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return task.Result != nil && len(e.scheduled) == 0
+	})
+}
+
+func TestStopWithScheduledTasks(t *testing.T) {
+	e := windowExecutor(t, time.Now().Add(time.Hour), WindowActionHold)
+
+	// Scheduled tasks of ExecuteTask callers have contexts Stop does not own
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := e.ExecuteTask(context.Background(), sleepTask(time.Second))
+			errs <- err
+		}()
+	}
+	waitFor(t, "two scheduled tasks", func() bool {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return len(e.scheduled) == 2
+	})
+
+	stopExecutor(t, e)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("ExecuteTask of a scheduled task succeeded after Stop")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ExecuteTask did not return after Stop")
+		}
+	}
+}
+
+func TestStopWhileWindowOpens(t *testing.T) {
+	// Windows opening while Stop closes the queue must not send on it
+	for i := 0; i < 20; i++ {
+		opens := time.Duration(i%5) * time.Millisecond
+		e := windowExecutor(t, time.Now().Add(opens), WindowActionHold)
+		for j := 0; j < 3; j++ {
+			if _, err := e.SubmitTask(sleepTask(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(opens)
+		stopExecutor(t, e)
+	}
+}
+
+func TestEvaluateWindows(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC) // a Monday
+	windows, err := windowsFromConfig([]config.WindowConfig{
+		{Name: "nightly", Kind: WindowKindMaintenance, Cron: "0 22 * * *", Duration: 4 * time.Hour, Timezone: "UTC", TaskTypes: []string{"script"}},
+		{Name: "lunch", Kind: WindowKindBlackout, From: now.Add(-time.Hour), Until: now.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{windows: windows}
+
+	tests := []struct {
+		taskType TaskType
+		blocking []string
+		opensAt  time.Time
+	}{
+		{TaskTypeCommand, []string{"lunch"}, now.Add(time.Hour)},
+		{TaskTypeScript, []string{"nightly", "lunch"}, time.Date(2024, 3, 4, 22, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		blocking, opensAt := e.evaluateWindows(&Task{Type: tt.taskType}, now)
+		names := windowNames(blocking)
+		if len(names) != len(tt.blocking) {
+			t.Errorf("%s: blocking = %v, want %v", tt.taskType, names, tt.blocking)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.blocking[i] {
+				t.Errorf("%s: blocking = %v, want %v", tt.taskType, names, tt.blocking)
+			}
+		}
+		if !opensAt.Equal(tt.opensAt) {
+			t.Errorf("%s: opens at %s, want %s", tt.taskType, opensAt, tt.opensAt)
+		}
+	}
+}
@@ -3,8 +3,11 @@ package executor
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,39 +16,155 @@ type Worker struct {
 	id         int
 	taskQueue  <-chan *Task
 	resultChan chan<- *TaskResult
+	events     *events.Bus
 	logger     *logrus.Logger
+
+	// Pool management
+	quit        chan struct{}
+	quitOnce    sync.Once
+	idleTimeout time.Duration
+	retire      func(w *Worker) bool
+	dequeued    func(task *Task) // called after taking a task off the queue
+	workspaces  *WorkspaceManager
+	sandboxes   map[string]config.SandboxProfile
+	inputsDir   string
+	paths       PathPolicy
+	deleter     Deleter
+
+	// Statistics
+	mu          sync.RWMutex
+	currentTask string
+	tasksDone   int64
+	busyTime    time.Duration
+	startedAt   time.Time
+	lastActive  time.Time
+}
+
+// WorkerStats represents the statistics of a single worker
+type WorkerStats struct {
+	ID          int       `json:"id"`
+	CurrentTask string    `json:"current_task,omitempty"`
+	Busy        bool      `json:"busy"`
+	TasksDone   int64     `json:"tasks_done"`
+	BusyTimeMs  int64     `json:"busy_time_ms"`
+	StartedAt   time.Time `json:"started_at"`
+	LastActive  time.Time `json:"last_active"`
 }
 
 // NewWorker creates a new worker
-func NewWorker(id int, taskQueue <-chan *Task, resultChan chan<- *TaskResult, logger *logrus.Logger) *Worker {
+func NewWorker(id int, taskQueue <-chan *Task, resultChan chan<- *TaskResult, bus *events.Bus, logger *logrus.Logger) *Worker {
 	return &Worker{
 		id:         id,
 		taskQueue:  taskQueue,
 		resultChan: resultChan,
+		events:     bus,
 		logger:     logger,
+		quit:       make(chan struct{}),
 	}
 }
 
 // Start starts the worker
 func (w *Worker) Start(ctx context.Context) {
+	w.mu.Lock()
+	w.startedAt = time.Now()
+	w.lastActive = w.startedAt
+	w.mu.Unlock()
+
 	w.logger.WithField("worker_id", w.id).Info("Worker started")
 
 	for {
+		task, ok := w.nextTask(ctx)
+		if !ok {
+			return
+		}
+
+		w.executeTask(ctx, task)
+	}
+}
+
+// nextTask waits for the next task and returns false when the worker should exit
+func (w *Worker) nextTask(ctx context.Context) (*Task, bool) {
+	// Idle workers may be retired by the pool after the idle timeout
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if w.idleTimeout > 0 && w.retire != nil {
+		timer = time.NewTimer(w.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	for {
+		// A removed worker must not take another task, even if one is
+		// waiting: select picks among ready cases at random
+		select {
+		case <-w.quit:
+			w.logger.WithField("worker_id", w.id).Info("Worker removed from pool")
+			return nil, false
+		default:
+		}
+
 		select {
 		case <-ctx.Done():
 			w.logger.WithField("worker_id", w.id).Info("Worker stopped")
-			return
+			return nil, false
+		case <-w.quit:
+			w.logger.WithField("worker_id", w.id).Info("Worker removed from pool")
+			return nil, false
+		case <-idle:
+			if w.retire(w) {
+				w.logger.WithField("worker_id", w.id).Info("Idle worker retired")
+				return nil, false
+			}
+			timer.Reset(w.idleTimeout)
 		case task, ok := <-w.taskQueue:
 			if !ok {
 				w.logger.WithField("worker_id", w.id).Info("Task queue closed, worker stopping")
-				return
+				return nil, false
 			}
-
-			w.executeTask(ctx, task)
+			if w.dequeued != nil {
+				w.dequeued(task)
+			} else {
+				task.Status = TaskStatusRunning
+				task.StartedAt = time.Now()
+			}
+			return task, true
 		}
 	}
 }
 
+// Stop asks the worker to exit once its current task, if any, is finished
+func (w *Worker) Stop() {
+	w.quitOnce.Do(func() { close(w.quit) })
+}
+
+// ID returns the worker ID
+func (w *Worker) ID() int {
+	return w.id
+}
+
+// IsBusy returns whether the worker is currently executing a task
+func (w *Worker) IsBusy() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.currentTask != ""
+}
+
+// GetStats returns worker statistics
+func (w *Worker) GetStats() WorkerStats {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return WorkerStats{
+		ID:          w.id,
+		CurrentTask: w.currentTask,
+		Busy:        w.currentTask != "",
+		TasksDone:   w.tasksDone,
+		BusyTimeMs:  w.busyTime.Milliseconds(),
+		StartedAt:   w.startedAt,
+		LastActive:  w.lastActive,
+	}
+}
+
 // executeTask executes a single task
 func (w *Worker) executeTask(ctx context.Context, task *Task) {
 	w.logger.WithFields(logrus.Fields{
@@ -54,9 +173,26 @@ func (w *Worker) executeTask(ctx context.Context, task *Task) {
 		"task_type": task.Type,
 	}).Info("Executing task")
 
-	// Update task status
-	task.Status = TaskStatusRunning
-	task.StartedAt = time.Now()
+	w.mu.Lock()
+	w.currentTask = task.ID
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.currentTask = ""
+		w.tasksDone++
+		w.busyTime += time.Since(task.StartedAt)
+		w.lastActive = time.Now()
+		w.mu.Unlock()
+	}()
+
+	w.events.Publish(events.TaskStarted{
+		TaskID:    task.ID,
+		TaskType:  string(task.Type),
+		Name:      task.Name,
+		WorkerID:  w.id,
+		StartedAt: task.StartedAt,
+	})
 
 	// Create result
 	result := &TaskResult{
@@ -65,43 +201,69 @@ func (w *Worker) executeTask(ctx context.Context, task *Task) {
 		Metadata:  make(map[string]interface{}),
 	}
 
-	// Execute based on task type
-	var err error
-	switch task.Type {
-	case TaskTypeCommand:
-		err = w.executeCommand(task.ctx, task, result)
-	case TaskTypeScript:
-		err = w.executeScript(task.ctx, task, result)
-	case TaskTypeFile:
-		err = w.executeFileOperation(task.ctx, task, result)
-	case TaskTypeHTTP:
-		err = w.executeHTTPRequest(task.ctx, task, result)
-	case TaskTypeDocker:
-		err = w.executeDockerTask(task.ctx, task, result)
-	case TaskTypeKubernetes:
-		err = w.executeKubernetesTask(task.ctx, task, result)
-	case TaskTypeCustom:
-		err = w.executeCustomTask(task.ctx, task, result)
-	default:
-		err = fmt.Errorf("unsupported task type: %s", task.Type)
+	// The timeout starts now, not when the task was queued
+	if task.Timeout > 0 {
+		task.procMu.Lock()
+		task.runTimer = startRunTimer(task, task.Timeout)
+		task.procMu.Unlock()
+
+		defer func() {
+			task.procMu.Lock()
+			task.runTimer.stop()
+			task.procMu.Unlock()
+		}()
+	}
+
+	// Execute inside the requested workspace, if any
+	workingDir, env := task.WorkingDir, task.Env
+	workspace, err := w.workspaces.Acquire(task.ctx, task)
+	if err == nil {
+		if workspace != nil {
+			w.useWorkspace(task, workspace)
+		}
+
+		// Stage input files into the working directory
+		var inputs *stagedInputs
+		inputs, err = w.stageInputs(task)
+		if err == nil {
+			err = w.run(task, result)
+		}
+		inputs.cleanup(task)
+
+		w.workspaces.Release(workspace, result)
+
+		// The workspace and staged inputs only apply to this run, a
+		// resubmitted task starts from the submitted settings
+		task.WorkingDir, task.Env = workingDir, env
 	}
 
 	// Update result
 	result.FinishedAt = time.Now()
 	result.Duration = result.FinishedAt.Sub(result.StartedAt)
 
+	// Apply the task's success criteria
+	result.Status, err = taskOutcome(task, result, err)
+
+	switch {
+	case task.timedOut():
+		result.Status = TaskStatusTimeout
+		err = fmt.Errorf("task execution timeout after %s", task.Timeout)
+	case task.ctx.Err() == context.Canceled:
+		result.Status = TaskStatusCancelled
+		err = fmt.Errorf("task cancelled")
+	}
+
 	if err != nil {
-		result.Status = TaskStatusFailed
 		result.Error = err.Error()
 		w.logger.WithError(err).WithFields(logrus.Fields{
 			"worker_id": w.id,
 			"task_id":   task.ID,
 		}).Error("Task execution failed")
 	} else {
-		result.Status = TaskStatusCompleted
 		w.logger.WithFields(logrus.Fields{
 			"worker_id": w.id,
 			"task_id":   task.ID,
+			"status":    result.Status,
 			"duration":  result.Duration,
 		}).Info("Task execution completed")
 	}
@@ -116,21 +278,74 @@ func (w *Worker) executeTask(ctx context.Context, task *Task) {
 	}
 }
 
+// run dispatches a task to the executor for its type
+func (w *Worker) run(task *Task, result *TaskResult) error {
+	switch task.Type {
+	case TaskTypeCommand:
+		return w.executeCommand(task.ctx, task, result)
+	case TaskTypeScript:
+		return w.executeScript(task.ctx, task, result)
+	case TaskTypeFile:
+		return w.executeFileOperation(task.ctx, task, result)
+	case TaskTypeHTTP:
+		return w.executeHTTPRequest(task.ctx, task, result)
+	case TaskTypeDocker:
+		return w.executeDockerTask(task.ctx, task, result)
+	case TaskTypeKubernetes:
+		return w.executeKubernetesTask(task.ctx, task, result)
+	case TaskTypeCustom:
+		return w.executeCustomTask(task.ctx, task, result)
+	default:
+		return fmt.Errorf("unsupported task type: %s", task.Type)
+	}
+}
+
+// useWorkspace runs the task in its workspace and exports the workspace path
+func (w *Worker) useWorkspace(task *Task, workspace *Workspace) {
+	if task.WorkingDir == "" {
+		task.WorkingDir = workspace.Path
+	}
+
+	// Copy the environment, the submitted one is restored after the run
+	env := make(map[string]string, len(task.Env)+1)
+	for key, value := range task.Env {
+		env[key] = value
+	}
+	env[WorkspaceEnvVar] = workspace.Path
+	task.Env = env
+}
+
 // executeCommand executes a command task
 func (w *Worker) executeCommand(ctx context.Context, task *Task, result *TaskResult) error {
+	profile, err := sandboxProfile(w.sandboxes, task)
+	if err != nil {
+		return err
+	}
+
 	executor := NewCommandExecutor(w.logger)
+	executor.sandbox = profile
+	executor.events = w.events
 	return executor.Execute(ctx, task, result)
 }
 
 // executeScript executes a script task
 func (w *Worker) executeScript(ctx context.Context, task *Task, result *TaskResult) error {
+	profile, err := sandboxProfile(w.sandboxes, task)
+	if err != nil {
+		return err
+	}
+
 	executor := NewScriptExecutor(w.logger)
+	executor.sandbox = profile
+	executor.events = w.events
 	return executor.Execute(ctx, task, result)
 }
 
 // executeFileOperation executes a file operation task
 func (w *Worker) executeFileOperation(ctx context.Context, task *Task, result *TaskResult) error {
 	executor := NewFileExecutor(w.logger)
+	executor.paths = w.paths
+	executor.deleter = w.deleter
 	return executor.Execute(ctx, task, result)
 }
 
@@ -0,0 +1,316 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WorkspaceEphemeral requests a fresh directory that is removed after the retention period
+	WorkspaceEphemeral = "ephemeral"
+
+	// workspacePersistentPrefix prefixes named workspaces reused across runs
+	workspacePersistentPrefix = "persistent:"
+
+	// WorkspaceEnvVar is the environment variable holding the workspace path
+	WorkspaceEnvVar = "DUCLA_WORKSPACE"
+
+	// workspaceCleanupInterval is how often expired ephemeral workspaces are removed
+	workspaceCleanupInterval = time.Minute
+)
+
+// workspaceNamePattern restricts persistent workspace names to a single path element
+var workspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Workspace is a directory a task runs in
+type Workspace struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Persistent bool      `json:"persistent"`
+	TaskID     string    `json:"task_id"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	Size       int64     `json:"size"`
+}
+
+// WorkspaceManager creates, locks and cleans up task workspaces
+type WorkspaceManager struct {
+	config config.WorkspaceConfig
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	active   map[string]*Workspace // by path
+	released map[string]time.Time  // ephemeral path -> release time
+	sizes    map[string]int64      // path -> size at release
+	locks    map[string]chan struct{}
+}
+
+// NewWorkspaceManager creates a new workspace manager
+func NewWorkspaceManager(cfg config.WorkspaceConfig, logger *logrus.Logger) *WorkspaceManager {
+	return &WorkspaceManager{
+		config:   cfg,
+		logger:   logger,
+		active:   make(map[string]*Workspace),
+		released: make(map[string]time.Time),
+		sizes:    make(map[string]int64),
+		locks:    make(map[string]chan struct{}),
+	}
+}
+
+// validateTaskID rejects task IDs that are not a single path element, since
+// they name ephemeral workspace directories
+func validateTaskID(id string) error {
+	if id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid task ID: %q", id)
+	}
+	return nil
+}
+
+// validateWorkspace checks a task workspace setting
+func validateWorkspace(workspace string) error {
+	if workspace == "" || workspace == WorkspaceEphemeral {
+		return nil
+	}
+
+	if !strings.HasPrefix(workspace, workspacePersistentPrefix) {
+		return fmt.Errorf("invalid workspace %q, expected %q or %q<name>", workspace, WorkspaceEphemeral, workspacePersistentPrefix)
+	}
+
+	name := strings.TrimPrefix(workspace, workspacePersistentPrefix)
+	if !workspaceNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid persistent workspace name: %q", name)
+	}
+
+	return nil
+}
+
+// Acquire prepares the workspace requested by a task. Persistent workspaces
+// are locked, so a task waits while another task uses the same workspace.
+// It returns nil if the task does not request a workspace.
+func (m *WorkspaceManager) Acquire(ctx context.Context, task *Task) (*Workspace, error) {
+	if task.Workspace == "" {
+		return nil, nil
+	}
+	if m == nil {
+		return nil, fmt.Errorf("workspaces are not configured")
+	}
+	if err := validateWorkspace(task.Workspace); err != nil {
+		return nil, err
+	}
+	if err := validateTaskID(task.ID); err != nil {
+		return nil, err
+	}
+
+	workspace := &Workspace{
+		TaskID:     task.ID,
+		AcquiredAt: time.Now(),
+	}
+
+	if task.Workspace == WorkspaceEphemeral {
+		workspace.Name = task.ID
+		workspace.Path = filepath.Join(m.config.EphemeralDir, task.ID)
+
+		if err := os.MkdirAll(m.config.EphemeralDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create workspace root: %w", err)
+		}
+		if err := os.Mkdir(workspace.Path, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create workspace: %w", err)
+		}
+	} else {
+		workspace.Name = strings.TrimPrefix(task.Workspace, workspacePersistentPrefix)
+		workspace.Path = filepath.Join(m.config.PersistentDir, workspace.Name)
+		workspace.Persistent = true
+
+		if err := m.lock(ctx, workspace.Name); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(workspace.Path, 0700); err != nil {
+			m.unlock(workspace.Name)
+			return nil, fmt.Errorf("failed to create workspace: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	m.active[workspace.Path] = workspace
+	delete(m.released, workspace.Path)
+	m.mu.Unlock()
+
+	m.logger.WithFields(logrus.Fields{
+		"task_id":   task.ID,
+		"workspace": workspace.Path,
+	}).Debug("Workspace acquired")
+
+	return workspace, nil
+}
+
+// Release records the workspace size in the result and unlocks persistent
+// workspaces. Ephemeral workspaces are removed once the retention expires.
+func (m *WorkspaceManager) Release(workspace *Workspace, result *TaskResult) {
+	if workspace == nil {
+		return
+	}
+
+	size, err := dirSize(workspace.Path)
+	if err != nil {
+		m.logger.WithError(err).WithField("workspace", workspace.Path).Warn("Failed to measure workspace size")
+	}
+	workspace.Size = size
+
+	result.Metadata["workspace"] = workspace.Path
+	result.Metadata["workspace_size_bytes"] = size
+
+	m.mu.Lock()
+	delete(m.active, workspace.Path)
+	m.sizes[workspace.Path] = size
+	if !workspace.Persistent {
+		m.released[workspace.Path] = time.Now()
+	}
+	m.mu.Unlock()
+
+	if workspace.Persistent {
+		m.unlock(workspace.Name)
+	}
+}
+
+// lock takes the lock of a persistent workspace, waiting until it is free
+func (m *WorkspaceManager) lock(ctx context.Context, name string) error {
+	for {
+		m.mu.Lock()
+		held, busy := m.locks[name]
+		if !busy {
+			m.locks[name] = make(chan struct{})
+			m.mu.Unlock()
+			return nil
+		}
+		m.mu.Unlock()
+
+		m.logger.WithField("workspace", name).Debug("Waiting for workspace lock")
+
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return fmt.Errorf("workspace %s is locked: %w", name, ctx.Err())
+		}
+	}
+}
+
+// unlock releases the lock of a persistent workspace
+func (m *WorkspaceManager) unlock(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if held, ok := m.locks[name]; ok {
+		close(held)
+		delete(m.locks, name)
+	}
+}
+
+// Cleanup removes ephemeral workspaces whose retention period has expired,
+// including ones left behind by a previous run of the agent
+func (m *WorkspaceManager) Cleanup() {
+	entries, err := os.ReadDir(m.config.EphemeralDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.logger.WithError(err).Warn("Failed to read workspace directory")
+		}
+		return
+	}
+
+	cutoff := time.Now().Add(-m.config.Retention)
+
+	for _, entry := range entries {
+		path := filepath.Join(m.config.EphemeralDir, entry.Name())
+
+		m.mu.Lock()
+		_, active := m.active[path]
+		releasedAt, tracked := m.released[path]
+		m.mu.Unlock()
+
+		if active {
+			continue
+		}
+
+		// Workspaces from a previous run are aged by modification time
+		if !tracked {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			releasedAt = info.ModTime()
+		}
+
+		if releasedAt.After(cutoff) {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			m.logger.WithError(err).WithField("workspace", path).Warn("Failed to remove workspace")
+			continue
+		}
+
+		m.mu.Lock()
+		delete(m.released, path)
+		delete(m.sizes, path)
+		m.mu.Unlock()
+
+		m.logger.WithField("workspace", path).Debug("Expired workspace removed")
+	}
+}
+
+// GetStats returns workspace statistics
+func (m *WorkspaceManager) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var retainedSize int64
+	for path := range m.released {
+		retainedSize += m.sizes[path]
+	}
+
+	return map[string]interface{}{
+		"active":              len(m.active),
+		"retained":            len(m.released),
+		"retained_size_bytes": retainedSize,
+		"locked_persistent":   len(m.locks),
+	}
+}
+
+// workspaceLoop periodically removes expired ephemeral workspaces
+func (e *Executor) workspaceLoop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(workspaceCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			e.workspaces.Cleanup()
+		}
+	}
+}
+
+// dirSize returns the total size of the regular files below path
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTaskIDEscapingWorkspaceRoot(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+
+	for _, id := range []string{"../../etc", "a/b", `a\b`, ".."} {
+		task := &Task{ID: id, Type: TaskTypeCommand, Command: "true", Workspace: WorkspaceEphemeral}
+		if _, err := e.SubmitTask(task); !errors.Is(err, ErrInvalidTask) {
+			t.Errorf("SubmitTask() with ID %q error = %v, want ErrInvalidTask", id, err)
+		}
+		if _, err := e.workspaces.Acquire(context.Background(), task); err == nil {
+			t.Errorf("Acquire() accepted task ID %q", id)
+		}
+	}
+}
+
+func TestEphemeralWorkspace(t *testing.T) {
+	cfg := testConfig(t)
+	e := startExecutor(t, cfg)
+
+	task := &Task{
+		Type:      TaskTypeCommand,
+		Command:   "sh",
+		Args:      []string{"-c", `pwd; echo "$DUCLA_WORKSPACE"`},
+		Workspace: WorkspaceEphemeral,
+	}
+	id, err := e.SubmitTask(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, e, id, TaskStatusCompleted)
+
+	e.mu.RLock()
+	output, workingDir, env := task.Result.Output, task.WorkingDir, task.Env
+	e.mu.RUnlock()
+
+	want := filepath.Join(cfg.Workspace.EphemeralDir, id)
+	if lines := strings.Fields(output); len(lines) != 2 || lines[0] != want || lines[1] != want {
+		t.Errorf("output = %q, want the workspace %s twice", output, want)
+	}
+	if workingDir != "" || env != nil {
+		t.Errorf("task kept working directory %q and environment %v of its run", workingDir, env)
+	}
+}
+
+func TestPersistentWorkspaceReused(t *testing.T) {
+	e := startExecutor(t, testConfig(t))
+
+	write := &Task{Type: TaskTypeCommand, Command: "sh", Args: []string{"-c", "echo kept > state"}, Workspace: "persistent:build"}
+	read := &Task{Type: TaskTypeCommand, Command: "cat", Args: []string{"state"}, Workspace: "persistent:build"}
+	for _, task := range []*Task{write, read} {
+		id, err := e.SubmitTask(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForStatus(t, e, id, TaskStatusCompleted)
+	}
+
+	e.mu.RLock()
+	output := read.Result.Output
+	e.mu.RUnlock()
+	if strings.TrimSpace(output) != "kept" {
+		t.Errorf("output = %q, want the file written by the first task", output)
+	}
+}
@@ -0,0 +1,338 @@
+package fileops
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveFormat is a format a directory can be streamed in
+type ArchiveFormat string
+
+const (
+	ArchiveFormatTar    ArchiveFormat = "tar"
+	ArchiveFormatTarGz  ArchiveFormat = "tar.gz"
+	ArchiveFormatTarZst ArchiveFormat = "tar.zst"
+	ArchiveFormatZip    ArchiveFormat = "zip"
+)
+
+// ParseArchiveFormat parses an archive format name
+func ParseArchiveFormat(format string) (ArchiveFormat, error) {
+	switch strings.ToLower(format) {
+	case "tar":
+		return ArchiveFormatTar, nil
+	case "tar.gz", "tgz":
+		return ArchiveFormatTarGz, nil
+	case "tar.zst", "tzst":
+		return ArchiveFormatTarZst, nil
+	case "zip":
+		return ArchiveFormatZip, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// ArchiveFormatFor returns the format a file name's extension implies
+func ArchiveFormatFor(name string) (ArchiveFormat, bool) {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.zst", ".tzst", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			format, err := ParseArchiveFormat(ext[1:])
+			return format, err == nil
+		}
+	}
+	return "", false
+}
+
+// ContentType returns the MIME type of the format
+func (f ArchiveFormat) ContentType() string {
+	switch f {
+	case ArchiveFormatZip:
+		return "application/zip"
+	case ArchiveFormatTar:
+		return "application/x-tar"
+	case ArchiveFormatTarZst:
+		return "application/zstd"
+	}
+	return "application/gzip"
+}
+
+// Filters selects the entries of a directory tree by glob. Patterns without
+// a slash match the base name at any depth; patterns with a slash match the
+// path relative to the root. A "**" element matches any number of
+// directories. Excluded directories are skipped entirely; include patterns
+// apply to files only.
+type Filters struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Validate checks that all patterns are well formed
+func (f Filters) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether the entry at rel, a slash separated path relative
+// to the root, is selected
+func (f Filters) Selects(rel string, isDir bool) bool {
+	for _, pattern := range f.Exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	if isDir || len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a filter pattern against a relative path
+func matchGlob(pattern, rel string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// WriteArchive streams the tree at root, a directory or a single file, to w
+// in the given format. Entries are named relative to the root's parent, so
+// extracting the archive recreates the directory. Symbolic links are archived as links and
+// never followed. Entries matching the access policy's read deny patterns
+// are skipped.
+func (m *Manager) WriteArchive(ctx context.Context, w io.Writer, root string, format ArchiveFormat, filters Filters) error {
+	root, err := m.ResolvePath(AccessRead, root)
+	if err != nil {
+		return err
+	}
+	if err := filters.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		return fmt.Errorf("failed to stat %s: %w", root, err)
+	}
+
+	archive, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Base(root)
+	var files int
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		name := base
+		if rel != "." {
+			// Entries denied by the access policy are left out
+			if !filters.Selects(rel, info.IsDir()) || !m.policy.Allows(AccessRead, p) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			name = base + "/" + rel
+		}
+
+		if !info.IsDir() {
+			files++
+		}
+		return archive.add(p, name, info)
+	})
+	if err != nil {
+		archive.close()
+		return fmt.Errorf("failed to archive %s: %w", root, err)
+	}
+
+	if err := archive.close(); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", root, err)
+	}
+
+	m.logger.WithFields(map[string]interface{}{
+		"path":   root,
+		"format": format,
+		"files":  files,
+	}).Debug("Archive written")
+	return nil
+}
+
+// archiveWriter adds file system entries to an archive
+type archiveWriter interface {
+	add(path, name string, info os.FileInfo) error
+	close() error
+}
+
+// newArchiveWriter returns a writer for the format
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case ArchiveFormatTar:
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	case ArchiveFormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{compressor: gz, tw: tar.NewWriter(gz)}, nil
+	case ArchiveFormatTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{compressor: zw, tw: tar.NewWriter(zw)}, nil
+	case ArchiveFormatZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// tarWriter writes a tar stream, optionally through a compressor
+type tarWriter struct {
+	compressor io.WriteCloser
+	tw         *tar.Writer
+}
+
+func (t *tarWriter) add(p, name string, info os.FileInfo) error {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		link = target
+	} else if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil // devices, sockets and pipes are skipped
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if info.Mode().IsRegular() {
+		// Copy exactly the size in the header in case the file changes
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(t.tw, file, header.Size)
+		return err
+	}
+	return nil
+}
+
+func (t *tarWriter) close() error {
+	err := t.tw.Close()
+	if t.compressor != nil {
+		if compressErr := t.compressor.Close(); err == nil {
+			err = compressErr
+		}
+	}
+	return err
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(p, name string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() && !info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	} else if info.Mode().IsRegular() {
+		header.Method = zip.Deflate
+	}
+
+	entry, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(entry, target)
+		return err
+	case info.Mode().IsRegular():
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(entry, file)
+		return err
+	}
+	return nil
+}
+
+func (z *zipWriter) close() error {
+	return z.zw.Close()
+}
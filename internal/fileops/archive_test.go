@@ -0,0 +1,195 @@
+package fileops
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestFiltersSelects(t *testing.T) {
+	filters := Filters{
+		Include: []string{"*.go", "docs/**/*.md"},
+		Exclude: []string{"vendor", "**/testdata/**"},
+	}
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"main.go", false, true},
+		{"pkg/deep/file.go", false, true},
+		{"README.md", false, false},
+		{"docs/guide.md", false, true},
+		{"docs/api/v1/index.md", false, true},
+		{"vendor", true, false},
+		{"pkg/vendor", true, false},
+		{"pkg/testdata/case.go", false, false},
+		{"pkg", true, true},
+	}
+	for _, tt := range tests {
+		if got := filters.Selects(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("Selects(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+
+	if err := (Filters{Include: []string{"[a-"}}).Validate(); err == nil {
+		t.Error("Validate() accepted a malformed pattern")
+	}
+}
+
+func TestParseArchiveFormat(t *testing.T) {
+	tests := map[string]ArchiveFormat{
+		"tar":     ArchiveFormatTar,
+		"TGZ":     ArchiveFormatTarGz,
+		"tar.zst": ArchiveFormatTarZst,
+		"zip":     ArchiveFormatZip,
+	}
+	for input, want := range tests {
+		if got, err := ParseArchiveFormat(input); err != nil || got != want {
+			t.Errorf("ParseArchiveFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseArchiveFormat("rar"); err == nil {
+		t.Error("ParseArchiveFormat() accepted rar")
+	}
+
+	if format, ok := ArchiveFormatFor("backup.TAR.GZ"); !ok || format != ArchiveFormatTarGz {
+		t.Errorf("ArchiveFormatFor() = %q, %v, want tar.gz", format, ok)
+	}
+	if _, ok := ArchiveFormatFor("notes.txt"); ok {
+		t.Error("ArchiveFormatFor() matched a text file")
+	}
+}
+
+// archiveTree creates a directory "site" in data to archive
+func archiveTree(t *testing.T, data string) string {
+	t.Helper()
+	root := filepath.Join(data, "site")
+	files := map[string]string{
+		"index.html":      "<html>",
+		"css/main.css":    "body {}",
+		"cache/page.html": "cached",
+		"server.key":      "secret",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("index.html", filepath.Join(root, "home.html")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// tarEntries reads the entries of a tar stream as name to content, with link
+// targets as content of links
+func tarEntries(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	entries := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			content = []byte(header.Linkname)
+		}
+		entries[header.Name] = string(content)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	m, data := testManager(t, func(cfg *config.StorageConfig) {
+		cfg.Access.Read.Deny = []string{"*.key"}
+	})
+	root := archiveTree(t, data)
+	filters := Filters{Exclude: []string{"cache"}}
+	want := map[string]string{
+		"site/":             "",
+		"site/index.html":   "<html>",
+		"site/home.html":    "index.html",
+		"site/css/":         "",
+		"site/css/main.css": "body {}",
+	}
+
+	t.Run("tar.gz", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := m.WriteArchive(context.Background(), &buf, root, ArchiveFormatTarGz, filters); err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tarEntries(t, gz); !reflect.DeepEqual(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("tar.zst", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := m.WriteArchive(context.Background(), &buf, root, ArchiveFormatTarZst, filters); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zstd.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		if got := tarEntries(t, zr); !reflect.DeepEqual(got, want) {
+			t.Errorf("entries = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := m.WriteArchive(context.Background(), &buf, root, ArchiveFormatZip, filters); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range zr.File {
+			names = append(names, file.Name)
+		}
+		sort.Strings(names)
+		wantNames := []string{"site/", "site/css/", "site/css/main.css", "site/home.html", "site/index.html"}
+		if !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("entries = %v, want %v", names, wantNames)
+		}
+	})
+}
+
+func TestWriteArchiveOutsideRoots(t *testing.T) {
+	m, data := testManager(t, nil)
+	var buf bytes.Buffer
+	if err := m.WriteArchive(context.Background(), &buf, filepath.Dir(data), ArchiveFormatTar, Filters{}); err == nil {
+		t.Error("WriteArchive() of a directory outside the allowed roots succeeded")
+	}
+}
@@ -0,0 +1,415 @@
+package fileops
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Block sizes used for file signatures. Without an explicit size the block
+// size grows with the square root of the file size, as in rsync.
+const (
+	MinBlockSize = 1024
+	MaxBlockSize = 128 * 1024
+)
+
+// maxLiteralSize bounds the literal data in one delta instruction
+const maxLiteralSize = 64 * 1024
+
+// deltaMagic starts every delta stream
+var deltaMagic = []byte("DLT1")
+
+// Delta instructions
+const (
+	deltaOpCopy    byte = 'C' // block index, block count
+	deltaOpLiteral byte = 'L' // length, data
+	deltaOpEnd     byte = 'E' // sha256 of the target
+)
+
+// ErrInvalidDelta is returned for malformed delta streams
+var ErrInvalidDelta = errors.New("invalid delta")
+
+// BlockSignature identifies one block of a file by a rolling checksum and a
+// strong hash
+type BlockSignature struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// FileSignature describes a file as a list of fixed size blocks. The last
+// block may be shorter. A missing file has an empty signature.
+type FileSignature struct {
+	BlockSize int              `json:"block_size"`
+	Size      int64            `json:"size"`
+	Blocks    []BlockSignature `json:"blocks"`
+}
+
+// DeltaStats counts the bytes of a target file that were found in the basis
+// file and the bytes that had to be sent
+type DeltaStats struct {
+	Matched int64 `json:"matched"`
+	Literal int64 `json:"literal"`
+}
+
+// BlockSizeFor returns the default block size for a file of the given size
+func BlockSizeFor(size int64) int {
+	blockSize := int(math.Sqrt(float64(size)))
+	blockSize = (blockSize + MinBlockSize - 1) / MinBlockSize * MinBlockSize
+	if blockSize < MinBlockSize {
+		return MinBlockSize
+	}
+	if blockSize > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return blockSize
+}
+
+// ComputeSignature reads r and returns its block signature. A block size of
+// 0 picks one from size, the expected length of r.
+func ComputeSignature(r io.Reader, size int64, blockSize int) (*FileSignature, error) {
+	if blockSize == 0 {
+		blockSize = BlockSizeFor(size)
+	}
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return nil, fmt.Errorf("block size must be between %d and %d", MinBlockSize, MaxBlockSize)
+	}
+
+	sig := &FileSignature{BlockSize: blockSize, Blocks: []BlockSignature{}}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sig.Size += int64(n)
+			sig.Blocks = append(sig.Blocks, BlockSignature{
+				Weak:   weakSum(buf[:n]),
+				Strong: strongSum(buf[:n]),
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// blockLen returns the length of block i
+func (s *FileSignature) blockLen(i int) int {
+	if i == len(s.Blocks)-1 {
+		if rest := int(s.Size - int64(i)*int64(s.BlockSize)); rest < s.BlockSize {
+			return rest
+		}
+	}
+	return s.BlockSize
+}
+
+// weakSum is the rsync rolling checksum of a block
+func weakSum(block []byte) uint32 {
+	a, b := rollingSums(block)
+	return a&0xffff | b<<16
+}
+
+func rollingSums(block []byte) (uint32, uint32) {
+	var a, b uint32
+	l := uint32(len(block))
+	for i, c := range block {
+		a += uint32(c)
+		b += (l - uint32(i)) * uint32(c)
+	}
+	return a, b
+}
+
+// strongSum is a truncated SHA-256 of a block
+func strongSum(block []byte) string {
+	sum := sha256.Sum256(block)
+	return hex.EncodeToString(sum[:16])
+}
+
+// deltaWriter encodes delta instructions, merging runs of adjacent blocks
+type deltaWriter struct {
+	w         *bufio.Writer
+	copyStart int
+	copyCount int
+	stats     DeltaStats
+}
+
+func (d *deltaWriter) copyBlock(index, length int) error {
+	d.stats.Matched += int64(length)
+	if d.copyCount > 0 && d.copyStart+d.copyCount == index {
+		d.copyCount++
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	d.copyStart, d.copyCount = index, 1
+	return nil
+}
+
+func (d *deltaWriter) flushCopy() error {
+	if d.copyCount == 0 {
+		return nil
+	}
+	var op [13]byte
+	op[0] = deltaOpCopy
+	binary.BigEndian.PutUint64(op[1:], uint64(d.copyStart))
+	binary.BigEndian.PutUint32(op[9:], uint32(d.copyCount))
+	d.copyCount = 0
+	_, err := d.w.Write(op[:])
+	return err
+}
+
+func (d *deltaWriter) literal(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	d.stats.Literal += int64(len(data))
+	var op [5]byte
+	op[0] = deltaOpLiteral
+	binary.BigEndian.PutUint32(op[1:], uint32(len(data)))
+	if _, err := d.w.Write(op[:]); err != nil {
+		return err
+	}
+	_, err := d.w.Write(data)
+	return err
+}
+
+// WriteDelta writes the instructions that turn the file described by sig
+// into the contents of r. Blocks of r found anywhere in the basis file are
+// sent as references, everything else as literal data.
+func WriteDelta(w io.Writer, sig *FileSignature, r io.Reader) (DeltaStats, error) {
+	blockSize := sig.BlockSize
+	if blockSize <= 0 {
+		blockSize = MinBlockSize
+	}
+
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for i, block := range sig.Blocks {
+		index[block.Weak] = append(index[block.Weak], i)
+	}
+
+	out := &deltaWriter{w: bufio.NewWriter(w)}
+	header := make([]byte, len(deltaMagic)+4)
+	copy(header, deltaMagic)
+	binary.BigEndian.PutUint32(header[len(deltaMagic):], uint32(blockSize))
+	if _, err := out.w.Write(header); err != nil {
+		return out.stats, err
+	}
+
+	hash := sha256.New()
+	src := bufio.NewReader(io.TeeReader(r, hash))
+
+	// data[litStart:pos] is pending literal data, data[pos:pos+l] the window
+	data := make([]byte, 0, 2*blockSize+maxLiteralSize)
+	var litStart, pos, l int
+	var a, b uint32
+	rolling, eof := false, false
+
+	// fill reads one byte past the window so it can slide
+	fill := func() error {
+		for !eof && len(data)-pos <= blockSize {
+			c, err := src.ReadByte()
+			if err == io.EOF {
+				eof = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			data = append(data, c)
+		}
+		return nil
+	}
+
+	for {
+		// Keep the buffer from growing by dropping flushed data
+		if litStart > blockSize+maxLiteralSize {
+			n := copy(data, data[litStart:])
+			data = data[:n]
+			pos -= litStart
+			litStart = 0
+		}
+
+		if err := fill(); err != nil {
+			return out.stats, err
+		}
+		avail := len(data) - pos
+		if avail == 0 {
+			break
+		}
+
+		if !rolling {
+			l = blockSize
+			if avail < l {
+				l = avail
+			}
+			a, b = rollingSums(data[pos : pos+l])
+			rolling = true
+		}
+
+		if match := findBlock(sig, index, a&0xffff|b<<16, data[pos:pos+l]); match >= 0 {
+			if err := out.literal(data[litStart:pos]); err != nil {
+				return out.stats, err
+			}
+			if err := out.copyBlock(match, l); err != nil {
+				return out.stats, err
+			}
+			pos += l
+			litStart = pos
+			rolling = false
+			continue
+		}
+
+		// Slide the window by one byte, shrinking it at the end of the input
+		old := uint32(data[pos])
+		pos++
+		if pos+l <= len(data) {
+			in := uint32(data[pos+l-1])
+			a = a - old + in
+			b = b - uint32(l)*old + a
+		} else {
+			a -= old
+			b -= uint32(l) * old
+			l--
+		}
+
+		if pos-litStart >= maxLiteralSize {
+			if err := out.literal(data[litStart:pos]); err != nil {
+				return out.stats, err
+			}
+			litStart = pos
+		}
+	}
+
+	if err := out.literal(data[litStart:pos]); err != nil {
+		return out.stats, err
+	}
+	if err := out.flushCopy(); err != nil {
+		return out.stats, err
+	}
+	if err := out.w.WriteByte(deltaOpEnd); err != nil {
+		return out.stats, err
+	}
+	if _, err := out.w.Write(hash.Sum(nil)); err != nil {
+		return out.stats, err
+	}
+	return out.stats, out.w.Flush()
+}
+
+// findBlock returns the index of a basis block equal to window, or -1
+func findBlock(sig *FileSignature, index map[uint32][]int, weak uint32, window []byte) int {
+	candidates, ok := index[weak]
+	if !ok {
+		return -1
+	}
+	var strong string
+	for _, i := range candidates {
+		if sig.blockLen(i) != len(window) {
+			continue
+		}
+		if strong == "" {
+			strong = strongSum(window)
+		}
+		if sig.Blocks[i].Strong == strong {
+			return i
+		}
+	}
+	return -1
+}
+
+// ApplyDelta writes the target file described by delta to w, reading
+// referenced blocks from basis. basis may be nil when the delta contains
+// only literal data. The result is verified against the checksum at the end
+// of the delta. A maxSize above 0 limits the size of the target.
+func ApplyDelta(w io.Writer, basis io.ReaderAt, delta io.Reader, maxSize int64) (DeltaStats, error) {
+	var stats DeltaStats
+	r := bufio.NewReader(delta)
+
+	header := make([]byte, len(deltaMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(deltaMagic)]) != string(deltaMagic) {
+		return stats, fmt.Errorf("%w: bad header", ErrInvalidDelta)
+	}
+	blockSize := int64(binary.BigEndian.Uint32(header[len(deltaMagic):]))
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return stats, fmt.Errorf("%w: block size %d", ErrInvalidDelta, blockSize)
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	checkSize := func() error {
+		if maxSize > 0 && stats.Matched+stats.Literal > maxSize {
+			return fmt.Errorf("%w of %d bytes", ErrFileTooLarge, maxSize)
+		}
+		return nil
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return stats, fmt.Errorf("%w: truncated", ErrInvalidDelta)
+		}
+
+		switch op {
+		case deltaOpCopy:
+			var args [12]byte
+			if _, err := io.ReadFull(r, args[:]); err != nil {
+				return stats, fmt.Errorf("%w: truncated", ErrInvalidDelta)
+			}
+			if basis == nil {
+				return stats, fmt.Errorf("%w: references a missing basis file", ErrInvalidDelta)
+			}
+			start := int64(binary.BigEndian.Uint64(args[:8]))
+			count := int64(binary.BigEndian.Uint32(args[8:]))
+			if start > math.MaxInt64/blockSize-count {
+				return stats, fmt.Errorf("%w: block %d out of range", ErrInvalidDelta, start)
+			}
+			n, err := io.Copy(out, io.NewSectionReader(basis, start*blockSize, count*blockSize))
+			stats.Matched += n
+			if err != nil {
+				return stats, fmt.Errorf("failed to copy block %d: %w", start, err)
+			}
+			if err := checkSize(); err != nil {
+				return stats, err
+			}
+
+		case deltaOpLiteral:
+			var args [4]byte
+			if _, err := io.ReadFull(r, args[:]); err != nil {
+				return stats, fmt.Errorf("%w: truncated", ErrInvalidDelta)
+			}
+			length := int64(binary.BigEndian.Uint32(args[:]))
+			stats.Literal += length
+			if err := checkSize(); err != nil {
+				return stats, err
+			}
+			if _, err := io.CopyN(out, r, length); err != nil {
+				if err == io.EOF {
+					err = fmt.Errorf("%w: truncated", ErrInvalidDelta)
+				}
+				return stats, err
+			}
+
+		case deltaOpEnd:
+			expected := make([]byte, sha256.Size)
+			if _, err := io.ReadFull(r, expected); err != nil {
+				return stats, fmt.Errorf("%w: truncated", ErrInvalidDelta)
+			}
+			if actual := hash.Sum(nil); string(actual) != string(expected) {
+				return stats, &ChecksumError{Expected: hex.EncodeToString(expected), Actual: hex.EncodeToString(actual)}
+			}
+			return stats, nil
+
+		default:
+			return stats, fmt.Errorf("%w: unknown instruction %q", ErrInvalidDelta, op)
+		}
+	}
+}
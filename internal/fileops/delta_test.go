@@ -0,0 +1,167 @@
+package fileops
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// basisReader returns a reader for a basis file, nil for a missing one
+func basisReader(data []byte) io.ReaderAt {
+	if data == nil {
+		return nil
+	}
+	return bytes.NewReader(data)
+}
+
+// makeDelta computes the signature of basis and the delta turning it into
+// target
+func makeDelta(t *testing.T, basis, target []byte, blockSize int) ([]byte, DeltaStats) {
+	t.Helper()
+
+	sig, err := ComputeSignature(bytes.NewReader(basis), int64(len(basis)), blockSize)
+	if err != nil {
+		t.Fatalf("ComputeSignature: %v", err)
+	}
+
+	var delta bytes.Buffer
+	stats, err := WriteDelta(&delta, sig, bytes.NewReader(target))
+	if err != nil {
+		t.Fatalf("WriteDelta: %v", err)
+	}
+	return delta.Bytes(), stats
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := randomBytes(1, 64*1024+123)
+
+	tests := []struct {
+		name       string
+		basis      []byte
+		target     []byte
+		blockSize  int   // 0 for the default, 1024 bytes for these sizes
+		maxLiteral int64 // upper bound on literal bytes sent
+	}{
+		{name: "identical", basis: base, target: base, maxLiteral: 0},
+		{name: "empty basis", basis: nil, target: base, maxLiteral: int64(len(base))},
+		{name: "empty target", basis: base, target: nil, maxLiteral: 0},
+		{name: "both empty", basis: nil, target: nil, maxLiteral: 0},
+		{name: "append", basis: base, target: join(base, []byte("tail")), maxLiteral: 1024 + 4},
+		{name: "prepend shifts every block", basis: base, target: join([]byte("head"), base), maxLiteral: 1024 + 4},
+		{name: "byte changed", basis: base, target: join(base[:30000], []byte{^base[30000]}, base[30001:]), maxLiteral: 2 * 1024},
+		{name: "middle removed", basis: base, target: join(base[:10000], base[20000:]), maxLiteral: 2 * 1024},
+		{name: "blocks reordered", basis: base, target: join(base[32*1024:], base[:32*1024]), maxLiteral: 2 * 1024},
+		{name: "block repeated", basis: base, target: join(base[:4096], base[:4096], base[:4096]), maxLiteral: 0},
+		{name: "unrelated", basis: base, target: randomBytes(2, 200*1024), maxLiteral: 200 * 1024},
+		{name: "target twice the basis", basis: base, target: join(base, base), maxLiteral: 2 * 1024},
+		{name: "large blocks", basis: base, target: join([]byte("x"), base), blockSize: 16 * 1024, maxLiteral: 16*1024 + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, written := makeDelta(t, tt.basis, tt.target, tt.blockSize)
+
+			var out bytes.Buffer
+			applied, err := ApplyDelta(&out, basisReader(tt.basis), bytes.NewReader(delta), 0)
+			if err != nil {
+				t.Fatalf("ApplyDelta: %v", err)
+			}
+
+			if !bytes.Equal(out.Bytes(), tt.target) {
+				t.Fatalf("target differs: got %d bytes, want %d", out.Len(), len(tt.target))
+			}
+			if applied != written {
+				t.Errorf("ApplyDelta stats %+v, WriteDelta stats %+v", applied, written)
+			}
+			if total := applied.Matched + applied.Literal; total != int64(len(tt.target)) {
+				t.Errorf("stats cover %d bytes, target has %d", total, len(tt.target))
+			}
+			if applied.Literal > tt.maxLiteral {
+				t.Errorf("sent %d literal bytes, want at most %d", applied.Literal, tt.maxLiteral)
+			}
+		})
+	}
+}
+
+func TestApplyDeltaErrors(t *testing.T) {
+	basis := randomBytes(3, 8*1024)
+	target := join(basis, []byte("more"))
+	delta, _ := makeDelta(t, basis, target, MinBlockSize)
+	literalOnly, _ := makeDelta(t, nil, target, MinBlockSize)
+
+	corrupted := append([]byte(nil), delta...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	badBlockSize := append([]byte(nil), delta...)
+	badBlockSize[len(deltaMagic)] = 0xff
+
+	tests := []struct {
+		name    string
+		basis   []byte
+		delta   []byte
+		maxSize int64
+		check   func(error) bool
+	}{
+		{"bad magic", basis, append([]byte("XXXX"), delta[4:]...), 0, isInvalidDelta},
+		{"bad block size", basis, badBlockSize, 0, isInvalidDelta},
+		{"empty", basis, nil, 0, isInvalidDelta},
+		{"truncated", basis, delta[:len(delta)-10], 0, isInvalidDelta},
+		{"missing end", basis, delta[:len(delta)-33], 0, isInvalidDelta},
+		{"unknown instruction", basis, join(delta[:8], []byte("Z")), 0, isInvalidDelta},
+		{"missing basis", nil, delta, 0, isInvalidDelta},
+		{"checksum mismatch", basis, corrupted, 0, func(err error) bool {
+			var checksumErr *ChecksumError
+			return errors.As(err, &checksumErr)
+		}},
+		{"too large", basis, delta, int64(len(target) - 1), func(err error) bool {
+			return errors.Is(err, ErrFileTooLarge)
+		}},
+		{"literal too large", nil, literalOnly, 100, func(err error) bool {
+			return errors.Is(err, ErrFileTooLarge)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ApplyDelta(&bytes.Buffer{}, basisReader(tt.basis), bytes.NewReader(tt.delta), tt.maxSize)
+			if !tt.check(err) {
+				t.Errorf("ApplyDelta error = %v", err)
+			}
+		})
+	}
+}
+
+func isInvalidDelta(err error) bool {
+	return errors.Is(err, ErrInvalidDelta)
+}
+
+func TestBlockSizeFor(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, MinBlockSize},
+		{1000, MinBlockSize},
+		{1 << 20, MinBlockSize},
+		{3 << 20, 2 * MinBlockSize},
+		{100 << 20, 10 * MinBlockSize},
+		{1 << 40, MaxBlockSize},
+	}
+	for _, tt := range tests {
+		if got := BlockSizeFor(tt.size); got != tt.want {
+			t.Errorf("BlockSizeFor(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
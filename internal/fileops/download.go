@@ -0,0 +1,97 @@
+package fileops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Download is an open file ready to be served with its content hash
+type Download struct {
+	File   *os.File
+	Info   os.FileInfo
+	SHA256 string
+}
+
+// cachedChecksum is the content hash of a file as of its size and
+// modification time
+type cachedChecksum struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
+
+// OpenDownload opens a regular file for download, subject to the access
+// policy, and returns it with its SHA-256 checksum. Checksums are cached until the file's size or
+// modification time changes. The caller closes the file.
+func (m *Manager) OpenDownload(path string) (*Download, error) {
+	path, err := m.ResolvePath(AccessRead, path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := m.policy.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidPath, path)
+	}
+
+	sum, err := m.contentHash(path, file, info)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+
+	return &Download{File: file, Info: info, SHA256: sum}, nil
+}
+
+// contentHash returns the cached checksum of an open file or computes it,
+// leaving the file positioned at the start
+func (m *Manager) contentHash(path string, file *os.File, info os.FileInfo) (string, error) {
+	m.mu.RLock()
+	cached, ok := m.checksums[path]
+	m.mu.RUnlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sha256, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	m.mu.Lock()
+	m.checksums[path] = cachedChecksum{size: info.Size(), modTime: info.ModTime(), sha256: sum}
+	m.mu.Unlock()
+
+	return sum, nil
+}
+
+// pruneChecksums drops cached checksums of files that changed or were removed
+func (m *Manager) pruneChecksums() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for path, cached := range m.checksums {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != cached.size || !info.ModTime().Equal(cached.modTime) {
+			delete(m.checksums, path)
+		}
+	}
+}
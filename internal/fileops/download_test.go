@@ -0,0 +1,76 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenDownload(t *testing.T) {
+	m, data := testManager(t, nil)
+	path := filepath.Join(data, "app.log")
+	if err := os.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	download, err := m.OpenDownload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	download.File.Close()
+	if download.SHA256 != sha256Hex("first") || download.Info.Size() != 5 {
+		t.Errorf("download = %s of %d bytes", download.SHA256, download.Info.Size())
+	}
+
+	// A changed file is hashed again rather than served from the cache
+	if err := os.WriteFile(path, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	download, err = m.OpenDownload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	download.File.Close()
+	if download.SHA256 != sha256Hex("second") {
+		t.Errorf("checksum of the changed file = %s, want %s", download.SHA256, sha256Hex("second"))
+	}
+
+	if _, err := m.OpenDownload(data); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("OpenDownload() of a directory error = %v, want ErrInvalidPath", err)
+	}
+	if _, err := m.OpenDownload(filepath.Join(data, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenDownload() of a missing file error = %v, want not exist", err)
+	}
+}
+
+func TestPruneChecksums(t *testing.T) {
+	m, data := testManager(t, nil)
+	path := filepath.Join(data, "file")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	download, err := m.OpenDownload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	download.File.Close()
+
+	m.pruneChecksums()
+	if _, ok := m.checksums[path]; !ok {
+		t.Fatal("checksum of an unchanged file was pruned")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	m.pruneChecksums()
+	if _, ok := m.checksums[path]; ok {
+		t.Error("checksum of a removed file was kept")
+	}
+}
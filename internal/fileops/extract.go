@@ -0,0 +1,731 @@
+package fileops
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrArchiveLimit is returned when extracting an archive would exceed the
+// configured size, ratio or entry limits
+var ErrArchiveLimit = errors.New("archive exceeds extraction limits")
+
+// maxSymlinkTarget bounds the size of a symbolic link stored in a zip entry
+const maxSymlinkTarget = 4096
+
+// zstdMaxMemory bounds the memory the zstd decoder may allocate
+const zstdMaxMemory = 256 * 1024 * 1024
+
+// ExtractOptions controls how an archive is extracted
+type ExtractOptions struct {
+	Format          ArchiveFormat // detected from the contents if empty
+	StripComponents int           // leading path elements removed from entry names
+	Filters         Filters       // applied to names after stripping
+	Overwrite       bool          // replace existing files instead of skipping them
+}
+
+// ExtractStatus is the outcome of extracting one entry
+type ExtractStatus string
+
+const (
+	ExtractStatusExtracted ExtractStatus = "extracted"
+	ExtractStatusSkipped   ExtractStatus = "skipped"
+	ExtractStatusFailed    ExtractStatus = "failed"
+)
+
+// ExtractResult reports what happened to one archive entry
+type ExtractResult struct {
+	Name   string        `json:"name"`           // name in the archive
+	Path   string        `json:"path,omitempty"` // path written
+	Type   string        `json:"type"`
+	Size   int64         `json:"size,omitempty"`
+	Status ExtractStatus `json:"status"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// ExtractSummary is the outcome of an extraction
+type ExtractSummary struct {
+	Format    ArchiveFormat   `json:"format"`
+	Extracted int             `json:"extracted"`
+	Skipped   int             `json:"skipped"`
+	Failed    int             `json:"failed"`
+	Bytes     int64           `json:"bytes"`
+	Entries   []ExtractResult `json:"entries"`
+}
+
+// Kinds of archive entries
+const (
+	entryFile     = "file"
+	entryDir      = "dir"
+	entrySymlink  = "symlink"
+	entryHardlink = "hardlink"
+	entryOther    = "other"
+)
+
+// archiveEntry is an entry read from a tar or zip archive
+type archiveEntry struct {
+	name     string
+	kind     string
+	mode     os.FileMode
+	modTime  time.Time
+	size     int64 // declared size of the contents
+	linkname string
+	open     func() (io.ReadCloser, error)
+}
+
+// archiveReader iterates over the entries of an archive
+type archiveReader interface {
+	next() (*archiveEntry, error) // io.EOF after the last entry
+	close() error
+}
+
+// DetectArchiveFormat identifies an archive by its leading bytes
+func DetectArchiveFormat(r io.ReaderAt) (ArchiveFormat, error) {
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return ArchiveFormatZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return ArchiveFormatTarGz, nil
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return ArchiveFormatTarZst, nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return ArchiveFormatTar, nil
+	}
+	return "", fmt.Errorf("%w: unrecognized archive format", ErrInvalidPath)
+}
+
+// openArchiveReader opens an archive of the given format
+func openArchiveReader(file *os.File, size int64, format ArchiveFormat) (archiveReader, error) {
+	switch format {
+	case ArchiveFormatTar:
+		return &tarReader{tr: tar.NewReader(file)}, nil
+	case ArchiveFormatTarGz:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return &tarReader{tr: tar.NewReader(gz), closer: gz.Close}, nil
+	case ArchiveFormatTarZst:
+		zr, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(zstdMaxMemory))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return &tarReader{tr: tar.NewReader(zr), closer: func() error { zr.Close(); return nil }}, nil
+	case ArchiveFormatZip:
+		zr, err := zip.NewReader(file, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		return &zipReader{files: zr.File}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+type tarReader struct {
+	tr     *tar.Reader
+	closer func() error
+}
+
+func (t *tarReader) next() (*archiveEntry, error) {
+	header, err := t.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &archiveEntry{
+		name:     header.Name,
+		mode:     os.FileMode(header.Mode).Perm(),
+		modTime:  header.ModTime,
+		size:     header.Size,
+		linkname: header.Linkname,
+		open:     func() (io.ReadCloser, error) { return io.NopCloser(t.tr), nil },
+	}
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		entry.kind = entryFile
+	case tar.TypeDir:
+		entry.kind = entryDir
+	case tar.TypeSymlink:
+		entry.kind = entrySymlink
+	case tar.TypeLink:
+		entry.kind = entryHardlink
+	default:
+		entry.kind = entryOther
+	}
+	return entry, nil
+}
+
+func (t *tarReader) close() error {
+	if t.closer != nil {
+		return t.closer()
+	}
+	return nil
+}
+
+type zipReader struct {
+	files []*zip.File
+}
+
+func (z *zipReader) next() (*archiveEntry, error) {
+	if len(z.files) == 0 {
+		return nil, io.EOF
+	}
+	f := z.files[0]
+	z.files = z.files[1:]
+
+	mode := f.Mode()
+	entry := &archiveEntry{
+		name:    f.Name,
+		mode:    mode.Perm(),
+		modTime: f.Modified,
+		size:    int64(f.UncompressedSize64),
+		open:    f.Open,
+	}
+	switch {
+	case mode&os.ModeSymlink != 0:
+		entry.kind = entrySymlink
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		target, err := io.ReadAll(io.LimitReader(rc, maxSymlinkTarget+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(target) > maxSymlinkTarget {
+			return nil, fmt.Errorf("symbolic link %s is too long", f.Name)
+		}
+		entry.linkname = string(target)
+	case mode.IsDir() || strings.HasSuffix(f.Name, "/"):
+		entry.kind = entryDir
+	case mode.IsRegular():
+		entry.kind = entryFile
+	default:
+		entry.kind = entryOther
+	}
+	if entry.mode == 0 && entry.kind != entrySymlink {
+		// Archives written without Unix attributes
+		entry.mode = 0644
+		if entry.kind == entryDir {
+			entry.mode = 0755
+		}
+	}
+	return entry, nil
+}
+
+func (z *zipReader) close() error {
+	return nil
+}
+
+// archiveEntryPath turns an entry name into a path relative to the
+// extraction directory. Absolute names and names leading outside the
+// directory are rejected. It returns "" when stripping leaves nothing.
+func archiveEntryPath(name string, strip int) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("%w: entry name contains a NUL byte", ErrInvalidPath)
+	}
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("%w: absolute entry name %q", ErrInvalidPath, name)
+	}
+
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: entry %q leads outside the destination", ErrInvalidPath, name)
+	}
+	if clean == "." {
+		return "", nil
+	}
+
+	parts := strings.Split(clean, "/")
+	if strip >= len(parts) {
+		return "", nil
+	}
+	return strings.Join(parts[strip:], "/"), nil
+}
+
+// selectsEntry applies filters to an extracted path and all its parent
+// directories, so excluding a directory excludes everything in it
+func selectsEntry(filters Filters, rel string, isDir bool) bool {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if !filters.Selects(dir, true) {
+			return false
+		}
+	}
+	return filters.Selects(rel, isDir)
+}
+
+// extractedDir is a directory whose mode and time are set after its
+// contents are written
+type extractedDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// extraction tracks the state of one Extract call
+type extraction struct {
+	manager *Manager
+	dest    string
+	opts    ExtractOptions
+	written int64
+	limit   int64 // bytes that may be written, 0 for no limit
+	dirs    []extractedDir
+}
+
+// Extract unpacks the archive at archivePath into the directory dest.
+// Every entry must land inside dest: absolute names, ".." elements, paths
+// through symbolic links and links pointing outside dest are rejected.
+// Existing files are skipped unless opts.Overwrite is set. Exceeding the
+// configured total size, expansion ratio or entry count aborts the
+// extraction with ErrArchiveLimit, leaving the entries written so far.
+func (m *Manager) Extract(ctx context.Context, archivePath, dest string, opts ExtractOptions) (*ExtractSummary, error) {
+	if err := opts.Filters.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.StripComponents < 0 {
+		return nil, fmt.Errorf("%w: strip_components must not be negative", ErrInvalidPath)
+	}
+
+	file, err := m.policy.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", archivePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidPath, archivePath)
+	}
+
+	dest, err = m.ResolvePath(AccessWrite, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+
+	if opts.Format == "" {
+		if opts.Format, err = DetectArchiveFormat(file); err != nil {
+			return nil, err
+		}
+	}
+	reader, err := openArchiveReader(file, info.Size(), opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+
+	limits := m.config.Extract
+	x := &extraction{manager: m, dest: dest, opts: opts, limit: extractLimit(limits, info.Size())}
+	summary := &ExtractSummary{Format: opts.Format, Entries: []ExtractResult{}}
+
+	for count := 1; ; count++ {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		entry, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if limits.MaxEntries > 0 && count > limits.MaxEntries {
+			return summary, fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, limits.MaxEntries)
+		}
+
+		result, err := x.extract(entry)
+		switch result.Status {
+		case ExtractStatusExtracted:
+			summary.Extracted++
+			summary.Bytes += result.Size
+		case ExtractStatusSkipped:
+			summary.Skipped++
+		case ExtractStatusFailed:
+			summary.Failed++
+		}
+		summary.Entries = append(summary.Entries, result)
+		if errors.Is(err, ErrArchiveLimit) {
+			return summary, err
+		}
+	}
+
+	// Directory times change as entries are written into them, so they are
+	// set last, deepest first
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		dir := x.dirs[i]
+		os.Chmod(dir.path, dir.mode)
+		os.Chtimes(dir.path, dir.modTime, dir.modTime)
+	}
+
+	m.logger.WithFields(map[string]interface{}{
+		"archive":   archivePath,
+		"dest":      dest,
+		"format":    opts.Format,
+		"extracted": summary.Extracted,
+		"skipped":   summary.Skipped,
+		"failed":    summary.Failed,
+		"bytes":     summary.Bytes,
+	}).Info("Archive extracted")
+
+	return summary, nil
+}
+
+// extractLimit returns the bytes an archive of the given size may expand
+// to, the smaller of the size and the ratio limit, or 0 for no limit
+func extractLimit(limits config.ExtractConfig, archiveSize int64) int64 {
+	limit := limits.MaxSize
+	if limits.MaxRatio > 0 {
+		byRatio := int64(limits.MaxRatio * float64(archiveSize))
+		if byRatio < 1 {
+			byRatio = 1
+		}
+		if limit <= 0 || byRatio < limit {
+			limit = byRatio
+		}
+	}
+	return limit
+}
+
+// extract writes one entry. Errors that must stop the extraction are
+// returned; everything else is reported in the result.
+func (x *extraction) extract(entry *archiveEntry) (ExtractResult, error) {
+	result := ExtractResult{Name: entry.name, Type: entry.kind, Status: ExtractStatusFailed}
+	fail := func(err error) (ExtractResult, error) {
+		result.Error = err.Error()
+		return result, err
+	}
+	skip := func(reason string) (ExtractResult, error) {
+		result.Status = ExtractStatusSkipped
+		result.Error = reason
+		return result, nil
+	}
+
+	rel, err := archiveEntryPath(entry.name, x.opts.StripComponents)
+	if err != nil {
+		return fail(err)
+	}
+	if rel == "" {
+		return skip("no path left after stripping components")
+	}
+	if !selectsEntry(x.opts.Filters, rel, entry.kind == entryDir) {
+		return skip("excluded by filters")
+	}
+	if entry.kind == entryOther {
+		return skip("unsupported entry type")
+	}
+
+	target, err := JoinSyncPath(x.dest, rel)
+	if err != nil {
+		return fail(err)
+	}
+	if err := x.manager.checkSyncPath(AccessWrite, target); err != nil {
+		return fail(err)
+	}
+	result.Path = target
+
+	existing, err := os.Lstat(target)
+	exists := err == nil
+	if exists && entry.kind != entryDir {
+		if existing.IsDir() {
+			return fail(fmt.Errorf("%w: %s is a directory", ErrInvalidPath, target))
+		}
+		if !x.opts.Overwrite {
+			return skip(ErrFileExists.Error())
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fail(fmt.Errorf("failed to create directory: %w", err))
+	}
+
+	switch entry.kind {
+	case entryDir:
+		if exists && !existing.IsDir() {
+			return fail(fmt.Errorf("%w: %s exists and is not a directory", ErrInvalidPath, target))
+		}
+		if !exists {
+			if err := os.Mkdir(target, 0755); err != nil {
+				return fail(err)
+			}
+		}
+		if !exists || x.opts.Overwrite {
+			x.dirs = append(x.dirs, extractedDir{path: target, mode: entry.mode, modTime: entry.modTime})
+		}
+
+	case entrySymlink:
+		linkTarget, err := x.symlinkTarget(rel, entry.linkname)
+		if err != nil {
+			return fail(err)
+		}
+		if exists {
+			if err := os.Remove(target); err != nil {
+				return fail(err)
+			}
+		}
+		if err := os.Symlink(linkTarget, target); err != nil {
+			return fail(err)
+		}
+
+	case entryHardlink:
+		linkRel, err := archiveEntryPath(entry.linkname, x.opts.StripComponents)
+		if err == nil && linkRel == "" {
+			err = fmt.Errorf("%w: hard link target %q was stripped", ErrInvalidPath, entry.linkname)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		source, err := JoinSyncPath(x.dest, linkRel)
+		if err != nil {
+			return fail(err)
+		}
+		if info, err := os.Lstat(source); err != nil || !info.Mode().IsRegular() {
+			return fail(fmt.Errorf("%w: hard link target %q is not an extracted file", ErrInvalidPath, entry.linkname))
+		}
+		if exists {
+			if err := os.Remove(target); err != nil {
+				return fail(err)
+			}
+		}
+		if err := os.Link(source, target); err != nil {
+			return fail(err)
+		}
+
+	case entryFile:
+		size, err := x.writeFile(entry, target)
+		result.Size = size
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	result.Status = ExtractStatusExtracted
+	return result, nil
+}
+
+// symlinkTarget checks that a link created at rel stays inside the
+// destination and returns its cleaned target. Cleaning removes ".." after
+// other elements, so the link cannot escape through another link.
+func (x *extraction) symlinkTarget(rel, linkname string) (string, error) {
+	linkname = strings.ReplaceAll(linkname, "\\", "/")
+	if linkname == "" || strings.HasPrefix(linkname, "/") || (len(linkname) >= 2 && linkname[1] == ':') {
+		return "", fmt.Errorf("%w: symbolic link to %q leads outside the destination", ErrInvalidPath, linkname)
+	}
+
+	clean := path.Clean(linkname)
+	resolved := path.Join(path.Dir(rel), clean)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", fmt.Errorf("%w: symbolic link to %q leads outside the destination", ErrInvalidPath, linkname)
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+// writeFile writes a file entry next to its target and renames it into
+// place, counting its size against the extraction limit
+func (x *extraction) writeFile(entry *archiveEntry, target string) (int64, error) {
+	remaining := int64(-1)
+	if x.limit > 0 {
+		remaining = x.limit - x.written
+		if entry.size > remaining {
+			return 0, fmt.Errorf("%w: more than %d bytes", ErrArchiveLimit, x.limit)
+		}
+	}
+
+	rc, err := entry.open()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read entry: %w", err)
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".extract-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the file has been renamed
+
+	// Declared sizes can lie, so the limit is enforced on the data itself
+	var src io.Reader = rc
+	if remaining >= 0 {
+		src = io.LimitReader(rc, remaining+1)
+	}
+	written, err := io.Copy(tmp, src)
+	x.written += written
+	if err == nil && remaining >= 0 && written > remaining {
+		err = fmt.Errorf("%w: more than %d bytes", ErrArchiveLimit, x.limit)
+	}
+	if err == nil {
+		err = tmp.Chmod(entry.mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+
+	if !entry.modTime.IsZero() {
+		os.Chtimes(tmpPath, entry.modTime, entry.modTime)
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return written, fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return written, nil
+}
+
+// handleArchive writes source_path, a directory or file, to the archive
+// dest_path. The format is taken from metadata.format or the archive's
+// extension, and metadata.include and metadata.exclude select entries.
+func (m *Manager) handleArchive(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	src, err := m.ResolvePath(AccessRead, op.SourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source path: %w", err)
+	}
+	dest, err := m.ResolvePath(AccessWrite, op.DestPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dest path: %w", err)
+	}
+	if within(dest, src) {
+		return nil, fmt.Errorf("%w: the archive cannot be written inside %s", ErrInvalidPath, src)
+	}
+
+	var format ArchiveFormat
+	if name := metadataString(op.Metadata, "format"); name != "" {
+		if format, err = ParseArchiveFormat(name); err != nil {
+			return nil, err
+		}
+	} else if detected, ok := ArchiveFormatFor(dest); ok {
+		format = detected
+	} else {
+		return nil, fmt.Errorf("%w: cannot tell the archive format of %s, set metadata.format", ErrInvalidPath, dest)
+	}
+	filters := Filters{
+		Include: metadataStrings(op.Metadata, "include"),
+		Exclude: metadataStrings(op.Metadata, "exclude"),
+	}
+
+	if _, err := os.Lstat(dest); err == nil && !op.Overwrite {
+		return nil, fmt.Errorf("%w: %s", ErrFileExists, dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".archive-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the archive has been committed
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+	err = m.WriteArchive(ctx, counter, src, format, filters)
+	if err == nil {
+		mode := op.Mode
+		if mode == 0 {
+			mode = defaultUploadMode
+		}
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.commitVersioned(tmpPath, dest, op.Overwrite); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"source":      op.SourcePath,
+		"destination": op.DestPath,
+		"format":      format,
+		"size":        counter.n,
+		"sha256":      hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// handleExtract unpacks the archive at source_path into the directory
+// dest_path. metadata.format overrides format detection and
+// metadata.strip_components, metadata.include and metadata.exclude select
+// and rename entries.
+func (m *Manager) handleExtract(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	opts := ExtractOptions{
+		Overwrite: op.Overwrite,
+		Filters: Filters{
+			Include: metadataStrings(op.Metadata, "include"),
+			Exclude: metadataStrings(op.Metadata, "exclude"),
+		},
+	}
+	if name := metadataString(op.Metadata, "format"); name != "" {
+		format, err := ParseArchiveFormat(name)
+		if err != nil {
+			return nil, err
+		}
+		opts.Format = format
+	}
+	if strip, ok := metadataInt(op.Metadata, "strip_components"); ok {
+		opts.StripComponents = int(strip)
+	}
+
+	summary, err := m.Extract(ctx, op.SourcePath, op.DestPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"source":      op.SourcePath,
+		"destination": op.DestPath,
+		"format":      summary.Format,
+		"extracted":   summary.Extracted,
+		"skipped":     summary.Skipped,
+		"failed":      summary.Failed,
+		"bytes":       summary.Bytes,
+		"entries":     summary.Entries,
+	}, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,404 @@
+package fileops
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// testEntry describes one archive entry
+type testEntry struct {
+	name string
+	kind byte // tar type flag
+	body string
+	link string
+}
+
+func writeTar(t *testing.T, file string, gz bool, entries []testEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	var gw *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if gz {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	}
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.kind, Mode: 0644, Linkname: e.link}
+		switch e.kind {
+		case tar.TypeReg:
+			header.Size = int64(len(e.body))
+		case tar.TypeDir:
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, file string, entries []testEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		header.SetMode(0644)
+		if e.kind == tar.TypeSymlink {
+			header.SetMode(os.ModeSymlink | 0777)
+			e.body = e.link
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// extractManager returns a manager allowed to write below dir/data, with
+// dir/outside next to it
+func extractManager(t *testing.T, limits config.ExtractConfig) (*Manager, string) {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data")
+	if err := os.MkdirAll(filepath.Join(dir, "outside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := config.AccessRule{Roots: []string{data}}
+	manager, err := New(config.StorageConfig{
+		DataDir: data,
+		TempDir: filepath.Join(data, "tmp"),
+		Access:  config.AccessConfig{Read: rule, Write: rule, Delete: rule},
+		Extract: limits,
+	}, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, dir
+}
+
+func TestExtractEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		zip     bool
+		strip   int
+		entries []testEntry
+		status  map[string]ExtractStatus // by entry name
+		files   map[string]string        // contents below dest
+	}{
+		{
+			name: "regular entries and links",
+			entries: []testEntry{
+				{name: "dir/", kind: tar.TypeDir},
+				{name: "dir/a.txt", kind: tar.TypeReg, body: "alpha"},
+				{name: "dir/link", kind: tar.TypeSymlink, link: "a.txt"},
+				{name: "dir/hard", kind: tar.TypeLink, link: "dir/a.txt"},
+			},
+			status: map[string]ExtractStatus{
+				"dir/":      ExtractStatusExtracted,
+				"dir/a.txt": ExtractStatusExtracted,
+				"dir/link":  ExtractStatusExtracted,
+				"dir/hard":  ExtractStatusExtracted,
+			},
+			files: map[string]string{"dir/a.txt": "alpha", "dir/link": "alpha", "dir/hard": "alpha"},
+		},
+		{
+			name: "traversal names",
+			entries: []testEntry{
+				{name: "../evil", kind: tar.TypeReg, body: "x"},
+				{name: "/abs", kind: tar.TypeReg, body: "x"},
+				{name: "a/../../evil", kind: tar.TypeReg, body: "x"},
+				{name: "..\\evil", kind: tar.TypeReg, body: "x"},
+				{name: "C:\\evil", kind: tar.TypeReg, body: "x"},
+				{name: "ok", kind: tar.TypeReg, body: "fine"},
+			},
+			status: map[string]ExtractStatus{
+				"../evil":      ExtractStatusFailed,
+				"/abs":         ExtractStatusFailed,
+				"a/../../evil": ExtractStatusFailed,
+				"..\\evil":     ExtractStatusFailed,
+				"C:\\evil":     ExtractStatusFailed,
+				"ok":           ExtractStatusExtracted,
+			},
+			files: map[string]string{"ok": "fine"},
+		},
+		{
+			name: "links leading outside",
+			entries: []testEntry{
+				{name: "up", kind: tar.TypeSymlink, link: "../outside"},
+				{name: "sub/up", kind: tar.TypeSymlink, link: "../../outside"},
+				{name: "abs", kind: tar.TypeSymlink, link: "/etc/passwd"},
+				{name: "hard-abs", kind: tar.TypeLink, link: "/etc/passwd"},
+				{name: "hard-up", kind: tar.TypeLink, link: "../outside/secret"},
+				{name: "hard-missing", kind: tar.TypeLink, link: "missing"},
+				{name: "sub/inside", kind: tar.TypeSymlink, link: "../ok"},
+			},
+			status: map[string]ExtractStatus{
+				"up":           ExtractStatusFailed,
+				"sub/up":       ExtractStatusFailed,
+				"abs":          ExtractStatusFailed,
+				"hard-abs":     ExtractStatusFailed,
+				"hard-up":      ExtractStatusFailed,
+				"hard-missing": ExtractStatusFailed,
+				"sub/inside":   ExtractStatusExtracted,
+			},
+		},
+		{
+			name: "writing through a link",
+			entries: []testEntry{
+				{name: "inner/", kind: tar.TypeDir},
+				{name: "l", kind: tar.TypeSymlink, link: "inner"},
+				{name: "l/x", kind: tar.TypeReg, body: "x"},
+			},
+			status: map[string]ExtractStatus{
+				"inner/": ExtractStatusExtracted,
+				"l":      ExtractStatusExtracted,
+				"l/x":    ExtractStatusFailed,
+			},
+		},
+		{
+			name:  "strip components",
+			strip: 1,
+			entries: []testEntry{
+				{name: "top/", kind: tar.TypeDir},
+				{name: "top/a", kind: tar.TypeReg, body: "a"},
+				{name: "top/../../evil", kind: tar.TypeReg, body: "x"},
+				{name: "top/hard", kind: tar.TypeLink, link: "top/a"},
+			},
+			status: map[string]ExtractStatus{
+				"top/":           ExtractStatusSkipped,
+				"top/a":          ExtractStatusExtracted,
+				"top/../../evil": ExtractStatusFailed,
+				"top/hard":       ExtractStatusExtracted,
+			},
+			files: map[string]string{"a": "a", "hard": "a"},
+		},
+		{
+			name: "zip traversal",
+			zip:  true,
+			entries: []testEntry{
+				{name: "../zip-evil", body: "x"},
+				{name: "link", kind: tar.TypeSymlink, link: "../outside"},
+				{name: "z.txt", body: "zip"},
+			},
+			status: map[string]ExtractStatus{
+				"../zip-evil": ExtractStatusFailed,
+				"link":        ExtractStatusFailed,
+				"z.txt":       ExtractStatusExtracted,
+			},
+			files: map[string]string{"z.txt": "zip"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, dir := extractManager(t, config.ExtractConfig{})
+			archive := filepath.Join(dir, "data", "archive")
+			if tt.zip {
+				writeZip(t, archive, tt.entries)
+			} else {
+				writeTar(t, archive, false, tt.entries)
+			}
+			dest := filepath.Join(dir, "data", "dest")
+
+			summary, err := manager.Extract(context.Background(), archive, dest, ExtractOptions{StripComponents: tt.strip})
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if len(summary.Entries) != len(tt.entries) {
+				t.Fatalf("Extract() reported %d entries, want %d", len(summary.Entries), len(tt.entries))
+			}
+			for _, result := range summary.Entries {
+				if want := tt.status[result.Name]; result.Status != want {
+					t.Errorf("entry %q status = %s (%s), want %s", result.Name, result.Status, result.Error, want)
+				}
+				if result.Status == ExtractStatusFailed && !strings.Contains(result.Error, ErrInvalidPath.Error()) {
+					t.Errorf("entry %q error = %q, want an invalid path error", result.Name, result.Error)
+				}
+			}
+			for name, want := range tt.files {
+				got, err := os.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+				}
+			}
+
+			outside, err := os.ReadDir(filepath.Join(dir, "outside"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(outside) != 0 {
+				t.Errorf("extraction wrote %d entries outside the destination", len(outside))
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "data", "evil")); !os.IsNotExist(err) {
+				t.Errorf("extraction wrote beside the destination: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	zeros := strings.Repeat("\x00", 1<<20)
+
+	tests := []struct {
+		name    string
+		limits  config.ExtractConfig
+		gz      bool
+		entries []testEntry
+		wantErr bool
+	}{
+		{
+			name:    "within limits",
+			limits:  config.ExtractConfig{MaxSize: 100, MaxEntries: 2},
+			entries: []testEntry{{name: "a", kind: tar.TypeReg, body: "aaaa"}, {name: "b", kind: tar.TypeReg, body: "bbbb"}},
+		},
+		{
+			name:    "total size",
+			limits:  config.ExtractConfig{MaxSize: 6},
+			entries: []testEntry{{name: "a", kind: tar.TypeReg, body: "aaaa"}, {name: "b", kind: tar.TypeReg, body: "bbbb"}},
+			wantErr: true,
+		},
+		{
+			name:    "entry count",
+			limits:  config.ExtractConfig{MaxEntries: 2},
+			entries: []testEntry{{name: "a/", kind: tar.TypeDir}, {name: "a/b/", kind: tar.TypeDir}, {name: "a/c", kind: tar.TypeReg}},
+			wantErr: true,
+		},
+		{
+			name:    "expansion ratio",
+			limits:  config.ExtractConfig{MaxRatio: 10},
+			gz:      true,
+			entries: []testEntry{{name: "zeros", kind: tar.TypeReg, body: zeros}},
+			wantErr: true,
+		},
+		{
+			name:    "no ratio limit",
+			limits:  config.ExtractConfig{MaxSize: 2 << 20},
+			gz:      true,
+			entries: []testEntry{{name: "zeros", kind: tar.TypeReg, body: zeros}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, dir := extractManager(t, tt.limits)
+			archive := filepath.Join(dir, "data", "archive")
+			writeTar(t, archive, tt.gz, tt.entries)
+
+			_, err := manager.Extract(context.Background(), archive, filepath.Join(dir, "data", "dest"), ExtractOptions{})
+			if tt.wantErr != errors.Is(err, ErrArchiveLimit) {
+				t.Errorf("Extract() error = %v, want archive limit error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Extract() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractZipRatio(t *testing.T) {
+	manager, dir := extractManager(t, config.ExtractConfig{MaxRatio: 10})
+	archive := filepath.Join(dir, "data", "archive.zip")
+	writeZip(t, archive, []testEntry{{name: "zeros", body: strings.Repeat("\x00", 1<<20)}})
+
+	_, err := manager.Extract(context.Background(), archive, filepath.Join(dir, "data", "dest"), ExtractOptions{})
+	if !errors.Is(err, ErrArchiveLimit) {
+		t.Fatalf("Extract() error = %v, want %v", err, ErrArchiveLimit)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "data", "dest"))
+	if len(entries) != 0 {
+		t.Errorf("Extract() left %d entries after exceeding the limit", len(entries))
+	}
+}
+
+func TestExtractLimit(t *testing.T) {
+	tests := []struct {
+		limits config.ExtractConfig
+		size   int64
+		want   int64
+	}{
+		{config.ExtractConfig{}, 100, 0},
+		{config.ExtractConfig{MaxSize: 500}, 100, 500},
+		{config.ExtractConfig{MaxRatio: 2}, 100, 200},
+		{config.ExtractConfig{MaxSize: 150, MaxRatio: 2}, 100, 150},
+		{config.ExtractConfig{MaxSize: 500, MaxRatio: 2}, 100, 200},
+		{config.ExtractConfig{MaxRatio: 0.001}, 100, 1},
+	}
+	for _, tt := range tests {
+		if got := extractLimit(tt.limits, tt.size); got != tt.want {
+			t.Errorf("extractLimit(%+v, %d) = %d, want %d", tt.limits, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		strip   int
+		want    string
+		wantErr bool
+	}{
+		{name: "a/b/c", want: "a/b/c"},
+		{name: "./a//b/", want: "a/b"},
+		{name: "a\\b", want: "a/b"},
+		{name: "a/../b", want: "b"},
+		{name: "top/a/b", strip: 1, want: "a/b"},
+		{name: "top/", strip: 1, want: ""},
+		{name: ".", want: ""},
+		{name: "..", wantErr: true},
+		{name: "../a", wantErr: true},
+		{name: "a/../../b", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: "\\etc\\passwd", wantErr: true},
+		{name: "C:/windows", wantErr: true},
+		{name: "a\x00b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := archiveEntryPath(tt.name, tt.strip)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("archiveEntryPath(%q, %d) error = %v, want %v", tt.name, tt.strip, err, ErrInvalidPath)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("archiveEntryPath(%q, %d) = %q, %v, want %q", tt.name, tt.strip, got, err, tt.want)
+		}
+	}
+}
@@ -13,7 +13,7 @@ import (
 	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
-	"github.com/google/uuid"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,10 +21,18 @@ import (
 type Manager struct {
 	config config.StorageConfig
 	logger *logrus.Logger
+	events *events.Bus
 
 	// Transfer management
 	mu        sync.RWMutex
 	transfers map[string]*Transfer
+	uploads   map[string]*uploadSession // resumable uploads by transfer ID
+	checksums map[string]cachedChecksum // content hashes of downloaded files
+	policy    *Policy
+	streamer  Streamer // connection to the master server, if any
+	watches   map[string]*Watch
+	watchDirs int             // directories watched by all watches
+	trashDirs map[string]bool // trash directories in use
 	
 	// Cleanup
 	cleanupTicker *time.Ticker
@@ -42,6 +50,7 @@ type Transfer struct {
 	DestPath      string                 `json:"dest_path"`
 	Size          int64                  `json:"size"`
 	Transferred   int64                  `json:"transferred"`
+	Acknowledged  int64                  `json:"acknowledged,omitempty"` // bytes the master confirmed receiving
 	Progress      float64                `json:"progress"`
 	Checksum      string                 `json:"checksum"`
 	StartedAt     time.Time              `json:"started_at"`
@@ -83,7 +92,12 @@ type Operation struct {
 	Mode       os.FileMode            `json:"mode,omitempty"`
 	Recursive  bool                   `json:"recursive"`
 	Overwrite  bool                   `json:"overwrite"`
+	Permanent  bool                   `json:"permanent"` // delete without moving to the trash
 	Metadata   map[string]interface{} `json:"metadata"`
+
+	// Actor identifies who requested the operation. It is set by the
+	// caller, never parsed from the request.
+	Actor string `json:"-"`
 }
 
 // OperationType represents the type of file operation
@@ -99,6 +113,18 @@ const (
 	OperationTypeStat     OperationType = "stat"
 	OperationTypeChmod    OperationType = "chmod"
 	OperationTypeChown    OperationType = "chown"
+	OperationTypeSync     OperationType = "sync"
+	OperationTypeArchive  OperationType = "archive"
+	OperationTypeExtract  OperationType = "extract"
+	OperationTypeWatch    OperationType = "watch"
+	OperationTypeUnwatch  OperationType = "unwatch"
+
+	OperationTypeTrashList    OperationType = "trash_list"
+	OperationTypeTrashRestore OperationType = "trash_restore"
+	OperationTypeTrashPurge   OperationType = "trash_purge"
+
+	OperationTypeVersions       OperationType = "versions"
+	OperationTypeRestoreVersion OperationType = "restore_version"
 )
 
 // FileInfo represents file information
@@ -112,11 +138,22 @@ type FileInfo struct {
 }
 
 // New creates a new file operations manager
-func New(cfg config.StorageConfig, logger *logrus.Logger) (*Manager, error) {
+func New(cfg config.StorageConfig, bus *events.Bus, logger *logrus.Logger) (*Manager, error) {
+	policy, err := NewPolicy(cfg.Access, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file access policy: %w", err)
+	}
+
 	manager := &Manager{
 		config:    cfg,
 		logger:    logger,
+		events:    bus,
 		transfers: make(map[string]*Transfer),
+		uploads:   make(map[string]*uploadSession),
+		checksums: make(map[string]cachedChecksum),
+		watches:   make(map[string]*Watch),
+		trashDirs: make(map[string]bool),
+		policy:    policy,
 	}
 
 	// Create directories if they don't exist
@@ -136,6 +173,12 @@ func (m *Manager) Start(ctx context.Context) error {
 
 	m.ctx, m.cancel = context.WithCancel(ctx)
 
+	// Resume uploads interrupted by a restart
+	m.loadUploads()
+
+	// Find the trash directories of earlier runs
+	m.loadTrashDirs()
+
 	// Start cleanup routine if enabled
 	if m.config.Cleanup.Enabled {
 		m.cleanupTicker = time.NewTicker(m.config.Cleanup.Interval)
@@ -160,6 +203,9 @@ func (m *Manager) Stop(ctx context.Context) error {
 	}
 	m.mu.Unlock()
 
+	// Stop file watches
+	m.stopWatches()
+
 	// Stop cleanup routine
 	if m.cleanupTicker != nil {
 		m.cleanupTicker.Stop()
@@ -209,96 +255,63 @@ func (m *Manager) ExecuteOperation(ctx context.Context, op *Operation) (map[stri
 		return m.handleChmod(ctx, op)
 	case OperationTypeChown:
 		return m.handleChown(ctx, op)
+	case OperationTypeSync:
+		return m.handleSync(ctx, op)
+	case OperationTypeArchive:
+		return m.handleArchive(ctx, op)
+	case OperationTypeExtract:
+		return m.handleExtract(ctx, op)
+	case OperationTypeWatch:
+		return m.handleWatch(ctx, op)
+	case OperationTypeUnwatch:
+		return m.handleUnwatch(ctx, op)
+	case OperationTypeTrashList:
+		return m.handleTrashList(ctx, op)
+	case OperationTypeTrashRestore:
+		return m.handleTrashRestore(ctx, op)
+	case OperationTypeTrashPurge:
+		return m.handleTrashPurge(ctx, op)
+	case OperationTypeVersions:
+		return m.handleVersions(ctx, op)
+	case OperationTypeRestoreVersion:
+		return m.handleRestoreVersion(ctx, op)
 	default:
 		return nil, fmt.Errorf("unsupported operation type: %s", op.Type)
 	}
 }
 
-// handleUpload handles file upload operation
-func (m *Manager) handleUpload(ctx context.Context, op *Operation) (map[string]interface{}, error) {
-	transferID := uuid.New().String()
-	
-	transfer := &Transfer{
-		ID:         transferID,
-		Type:       TransferTypeUpload,
-		Status:     TransferStatusPending,
-		SourcePath: op.SourcePath,
-		DestPath:   op.DestPath,
-		StartedAt:  time.Now(),
-		Metadata:   op.Metadata,
-	}
-
-	transfer.ctx, transfer.cancel = context.WithCancel(ctx)
-
-	// Store transfer
-	m.mu.Lock()
-	m.transfers[transferID] = transfer
-	m.mu.Unlock()
-
-	// Execute upload
-	go m.executeUpload(transfer)
-
-	return map[string]interface{}{
-		"transfer_id": transferID,
-		"status":      transfer.Status,
-	}, nil
-}
-
-// handleDownload handles file download operation
-func (m *Manager) handleDownload(ctx context.Context, op *Operation) (map[string]interface{}, error) {
-	transferID := uuid.New().String()
-	
-	transfer := &Transfer{
-		ID:         transferID,
-		Type:       TransferTypeDownload,
-		Status:     TransferStatusPending,
-		SourcePath: op.SourcePath,
-		DestPath:   op.DestPath,
-		StartedAt:  time.Now(),
-		Metadata:   op.Metadata,
-	}
-
-	transfer.ctx, transfer.cancel = context.WithCancel(ctx)
-
-	// Store transfer
-	m.mu.Lock()
-	m.transfers[transferID] = transfer
-	m.mu.Unlock()
-
-	// Execute download
-	go m.executeDownload(transfer)
-
-	return map[string]interface{}{
-		"transfer_id": transferID,
-		"status":      transfer.Status,
-	}, nil
-}
-
 // handleCopy handles file copy operation
 func (m *Manager) handleCopy(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate paths
-	if err := m.validatePath(op.SourcePath); err != nil {
+	src, err := m.ResolvePath(AccessRead, op.SourcePath)
+	if err != nil {
 		return nil, fmt.Errorf("invalid source path: %w", err)
 	}
-	if err := m.validatePath(op.DestPath); err != nil {
+	dest, err := m.ResolvePath(AccessWrite, op.DestPath)
+	if err != nil {
 		return nil, fmt.Errorf("invalid dest path: %w", err)
 	}
 
 	// Check if source exists
-	srcInfo, err := os.Stat(op.SourcePath)
+	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return nil, fmt.Errorf("source file not found: %w", err)
 	}
 
+	// Existing files are only replaced with overwrite set
+	if _, err := os.Lstat(dest); err == nil && !op.Overwrite {
+		return nil, fmt.Errorf("%w: %s", ErrFileExists, op.DestPath)
+	}
+
 	// Copy file or directory
 	var bytesCopied int64
 	if srcInfo.IsDir() {
 		if !op.Recursive {
 			return nil, fmt.Errorf("source is a directory, use recursive flag")
 		}
-		bytesCopied, err = m.copyDir(op.SourcePath, op.DestPath)
+		bytesCopied, err = m.copyDir(src, dest, op.Overwrite)
 	} else {
-		bytesCopied, err = m.copyFile(op.SourcePath, op.DestPath)
+		bytesCopied, err = m.copyFile(src, dest, op.Overwrite)
 	}
 
 	if err != nil {
@@ -316,15 +329,29 @@ func (m *Manager) handleCopy(ctx context.Context, op *Operation) (map[string]int
 // handleMove handles file move operation
 func (m *Manager) handleMove(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate paths
-	if err := m.validatePath(op.SourcePath); err != nil {
+	src, err := m.ResolvePath(AccessDelete, op.SourcePath)
+	if err != nil {
 		return nil, fmt.Errorf("invalid source path: %w", err)
 	}
-	if err := m.validatePath(op.DestPath); err != nil {
+	dest, err := m.ResolvePath(AccessWrite, op.DestPath)
+	if err != nil {
 		return nil, fmt.Errorf("invalid dest path: %w", err)
 	}
 
+	// Existing files are only replaced with overwrite set
+	if info, err := os.Lstat(dest); err == nil {
+		if !op.Overwrite {
+			return nil, fmt.Errorf("%w: %s", ErrFileExists, op.DestPath)
+		}
+		if src != dest && !info.IsDir() {
+			if err := m.keepVersion(dest); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// Move file
-	if err := os.Rename(op.SourcePath, op.DestPath); err != nil {
+	if err := os.Rename(src, dest); err != nil {
 		return nil, fmt.Errorf("failed to move file: %w", err)
 	}
 
@@ -337,12 +364,13 @@ func (m *Manager) handleMove(ctx context.Context, op *Operation) (map[string]int
 // handleDelete handles file delete operation
 func (m *Manager) handleDelete(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate path
-	if err := m.validatePath(op.SourcePath); err != nil {
-		return nil, fmt.Errorf("invalid path: %w", err)
+	path, err := m.ResolvePath(AccessDelete, op.SourcePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if file exists
-	info, err := os.Stat(op.SourcePath)
+	info, err := os.Lstat(path)
 	if err != nil {
 		return nil, fmt.Errorf("file not found: %w", err)
 	}
@@ -352,25 +380,31 @@ func (m *Manager) handleDelete(ctx context.Context, op *Operation) (map[string]i
 		return nil, fmt.Errorf("path is a directory, use recursive flag")
 	}
 
-	if err := os.RemoveAll(op.SourcePath); err != nil {
-		return nil, fmt.Errorf("failed to delete: %w", err)
+	entry, err := m.delete(path, info, op.Actor, op.Permanent)
+	if err != nil {
+		return nil, err
 	}
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"path":    op.SourcePath,
 		"deleted": true,
-	}, nil
+	}
+	if entry != nil {
+		result["trash_id"] = entry.ID
+	}
+	return result, nil
 }
 
 // handleList handles directory listing operation
 func (m *Manager) handleList(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate path
-	if err := m.validatePath(op.SourcePath); err != nil {
-		return nil, fmt.Errorf("invalid path: %w", err)
+	path, err := m.ResolvePath(AccessRead, op.SourcePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Read directory
-	entries, err := os.ReadDir(op.SourcePath)
+	entries, err := os.ReadDir(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read directory: %w", err)
 	}
@@ -378,6 +412,10 @@ func (m *Manager) handleList(ctx context.Context, op *Operation) (map[string]int
 	// Build file list
 	files := make([]FileInfo, 0, len(entries))
 	for _, entry := range entries {
+		if !m.policy.Allows(AccessRead, filepath.Join(path, entry.Name())) {
+			continue
+		}
+
 		info, err := entry.Info()
 		if err != nil {
 			continue
@@ -403,12 +441,13 @@ func (m *Manager) handleList(ctx context.Context, op *Operation) (map[string]int
 // handleStat handles file stat operation
 func (m *Manager) handleStat(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate path
-	if err := m.validatePath(op.SourcePath); err != nil {
-		return nil, fmt.Errorf("invalid path: %w", err)
+	path, err := m.ResolvePath(AccessRead, op.SourcePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get file info
-	info, err := os.Stat(op.SourcePath)
+	info, err := os.Stat(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
@@ -430,12 +469,13 @@ func (m *Manager) handleStat(ctx context.Context, op *Operation) (map[string]int
 // handleChmod handles chmod operation
 func (m *Manager) handleChmod(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate path
-	if err := m.validatePath(op.SourcePath); err != nil {
-		return nil, fmt.Errorf("invalid path: %w", err)
+	path, err := m.ResolvePath(AccessWrite, op.SourcePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Change mode
-	if err := os.Chmod(op.SourcePath, op.Mode); err != nil {
+	if err := os.Chmod(path, op.Mode); err != nil {
 		return nil, fmt.Errorf("failed to chmod: %w", err)
 	}
 
@@ -448,8 +488,9 @@ func (m *Manager) handleChmod(ctx context.Context, op *Operation) (map[string]in
 // handleChown handles chown operation
 func (m *Manager) handleChown(ctx context.Context, op *Operation) (map[string]interface{}, error) {
 	// Validate path
-	if err := m.validatePath(op.SourcePath); err != nil {
-		return nil, fmt.Errorf("invalid path: %w", err)
+	path, err := m.ResolvePath(AccessWrite, op.SourcePath)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get UID and GID from metadata
@@ -457,7 +498,7 @@ func (m *Manager) handleChown(ctx context.Context, op *Operation) (map[string]in
 	gid, _ := op.Metadata["gid"].(int)
 
 	// Change ownership
-	if err := os.Chown(op.SourcePath, uid, gid); err != nil {
+	if err := os.Chown(path, uid, gid); err != nil {
 		return nil, fmt.Errorf("failed to chown: %w", err)
 	}
 
@@ -468,59 +509,66 @@ func (m *Manager) handleChown(ctx context.Context, op *Operation) (map[string]in
 	}, nil
 }
 
-// executeUpload executes file upload
-func (m *Manager) executeUpload(transfer *Transfer) {
-	transfer.Status = TransferStatusRunning
-
-	// Implementation would handle actual upload from master server
-	// This is a placeholder
-	m.logger.WithField("transfer_id", transfer.ID).Info("Upload not yet implemented")
-
-	transfer.Status = TransferStatusCompleted
-	transfer.CompletedAt = time.Now()
-}
-
-// executeDownload executes file download
-func (m *Manager) executeDownload(transfer *Transfer) {
-	transfer.Status = TransferStatusRunning
-
-	// Implementation would handle actual download to master server
-	// This is a placeholder
-	m.logger.WithField("transfer_id", transfer.ID).Info("Download not yet implemented")
-
-	transfer.Status = TransferStatusCompleted
-	transfer.CompletedAt = time.Now()
+// publishProgress publishes the current state of a transfer on the event bus
+func (m *Manager) publishProgress(transfer *Transfer) {
+	m.events.Publish(events.TransferProgress{
+		TransferID:  transfer.ID,
+		Type:        string(transfer.Type),
+		Status:      string(transfer.Status),
+		SourcePath:  transfer.SourcePath,
+		DestPath:    transfer.DestPath,
+		Size:        transfer.Size,
+		Transferred: transfer.Transferred,
+		Progress:    transfer.Progress,
+		Error:       transfer.Error,
+	})
 }
 
-// copyFile copies a single file
-func (m *Manager) copyFile(src, dst string) (int64, error) {
+// copyFile copies a single file. The copy is written beside dst and moved
+// into place, so an existing dst is replaced, never truncated, and only
+// with overwrite set.
+func (m *Manager) copyFile(src, dst string, overwrite bool) (int64, error) {
 	sourceFile, err := os.Open(src)
 	if err != nil {
 		return 0, err
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	sourceInfo, err := sourceFile.Stat()
 	if err != nil {
 		return 0, err
 	}
-	defer destFile.Close()
+	if _, err := os.Lstat(dst); err == nil && !overwrite {
+		return 0, fmt.Errorf("%w: %s", ErrFileExists, dst)
+	}
 
-	bytesCopied, err := io.Copy(destFile, sourceFile)
+	destFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".copy-*")
 	if err != nil {
 		return 0, err
 	}
+	defer os.Remove(destFile.Name()) // no-op once the copy has been committed
 
-	// Copy permissions
-	sourceInfo, err := os.Stat(src)
+	bytesCopied, err := io.Copy(destFile, sourceFile)
+	if err == nil {
+		// Copy permissions
+		err = destFile.Chmod(sourceInfo.Mode())
+	}
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return bytesCopied, err
+		return 0, err
 	}
-	return bytesCopied, os.Chmod(dst, sourceInfo.Mode())
+
+	return bytesCopied, m.commitVersioned(destFile.Name(), dst, overwrite)
 }
 
-// copyDir copies a directory recursively
-func (m *Manager) copyDir(src, dst string) (int64, error) {
+// copyDir copies a directory recursively, leaving out trash and versions
+// directories
+func (m *Manager) copyDir(src, dst string, overwrite bool) (int64, error) {
 	var totalBytes int64
 
 	return totalBytes, filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
@@ -536,15 +584,37 @@ func (m *Manager) copyDir(src, dst string) (int64, error) {
 		destPath := filepath.Join(dst, relPath)
 
 		if info.IsDir() {
+			if path != src && (m.trashName(info.Name()) || info.Name() == m.config.Versions.Dir) {
+				return filepath.SkipDir
+			}
 			return os.MkdirAll(destPath, info.Mode())
 		}
 
-		bytes, err := m.copyFile(path, destPath)
+		bytes, err := m.copyFile(path, destPath, overwrite)
 		totalBytes += bytes
 		return err
 	})
 }
 
+// ResolvePath checks a path against the file access policy and returns it
+// with symbolic links resolved
+func (m *Manager) ResolvePath(access Access, path string) (string, error) {
+	if err := m.validatePath(path); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	return m.policy.Resolve(access, path)
+}
+
+// Policy returns the file access policy, which also governs file tasks
+func (m *Manager) Policy() *Policy {
+	return m.policy
+}
+
+// SetAuditor sets the audit trail for denied file access
+func (m *Manager) SetAuditor(auditor Auditor) {
+	m.policy.auditor = auditor
+}
+
 // validatePath validates a file path
 func (m *Manager) validatePath(path string) error {
 	if path == "" {
@@ -562,7 +632,10 @@ func (m *Manager) validatePath(path string) error {
 
 // CalculateChecksum calculates file checksum
 func (m *Manager) CalculateChecksum(path string, algorithm string) (string, error) {
-	file, err := os.Open(path)
+	if err := m.validatePath(path); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	file, err := m.policy.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -599,12 +672,18 @@ func (m *Manager) GetTransfer(transferID string) (*Transfer, error) {
 	return transfer, nil
 }
 
-// CancelTransfer cancels a transfer
+// CancelTransfer cancels a transfer. Cancelling a resumable upload discards
+// it.
 func (m *Manager) CancelTransfer(transferID string) error {
 	m.mu.RLock()
 	transfer, exists := m.transfers[transferID]
+	_, resumable := m.uploads[transferID]
 	m.mu.RUnlock()
 
+	if resumable {
+		return m.AbortUpload(transferID)
+	}
+
 	if !exists {
 		return fmt.Errorf("transfer not found: %s", transferID)
 	}
@@ -614,6 +693,7 @@ func (m *Manager) CancelTransfer(transferID string) error {
 	}
 
 	transfer.Status = TransferStatusCancelled
+	m.publishProgress(transfer)
 	return nil
 }
 
@@ -640,8 +720,17 @@ func (m *Manager) performCleanup() {
 		m.logger.WithError(err).Error("Failed to cleanup temp directory")
 	}
 
+	// Expire idle resumable uploads
+	m.expireUploads()
+
 	// Clean completed transfers
 	m.cleanupTransfers()
+
+	// Forget checksums of changed files
+	m.pruneChecksums()
+
+	// Purge expired trash entries
+	m.expireTrash()
 }
 
 // cleanupDirectory cleans up old files in a directory
@@ -652,6 +741,9 @@ func (m *Manager) cleanupDirectory(dir string, maxAge time.Duration) error {
 		}
 
 		if info.IsDir() {
+			if path != dir && m.trashName(info.Name()) {
+				return filepath.SkipDir // the trash expires on its own terms
+			}
 			return nil
 		}
 
@@ -670,7 +762,8 @@ func (m *Manager) cleanupTransfers() {
 	defer m.mu.Unlock()
 
 	for id, transfer := range m.transfers {
-		if transfer.Status == TransferStatusCompleted || transfer.Status == TransferStatusFailed {
+		switch transfer.Status {
+		case TransferStatusCompleted, TransferStatusFailed, TransferStatusCancelled:
 			if time.Since(transfer.CompletedAt) > 24*time.Hour {
 				delete(m.transfers, id)
 			}
@@ -704,8 +797,28 @@ func ParseOperation(data map[string]interface{}) (*Operation, error) {
 		op.Overwrite = overwrite
 	}
 
-	if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+	if permanent, ok := data["permanent"].(bool); ok {
+		op.Permanent = permanent
+	}
+
+	switch mode := data["mode"].(type) {
+	case string:
+		parsed, err := ParseMode(mode)
+		if err != nil {
+			return nil, err
+		}
+		op.Mode = parsed
+	case float64:
+		op.Mode = os.FileMode(mode)
+	}
+
+	switch metadata := data["metadata"].(type) {
+	case map[string]interface{}:
 		op.Metadata = metadata
+	case map[string]string:
+		for key, value := range metadata {
+			op.Metadata[key] = value
+		}
 	}
 
 	return op, nil
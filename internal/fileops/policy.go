@@ -0,0 +1,310 @@
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// maxSymlinks bounds the symbolic links followed while resolving a path
+const maxSymlinks = 40
+
+// Access is the kind of access a file operation needs
+type Access string
+
+const (
+	AccessRead   Access = "read"
+	AccessWrite  Access = "write"
+	AccessDelete Access = "delete"
+)
+
+// ErrAccessDenied is matched by every AccessError
+var ErrAccessDenied = errors.New("access denied")
+
+// AccessError is returned when the file access policy rejects a path
+type AccessError struct {
+	Access   Access `json:"access"`
+	Path     string `json:"path"`
+	Resolved string `json:"resolved,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+func (e *AccessError) Error() string {
+	return fmt.Sprintf("access denied: %s %s: %s", e.Access, e.Path, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrAccessDenied) match
+func (e *AccessError) Is(target error) bool {
+	return target == ErrAccessDenied
+}
+
+// Auditor records security relevant events
+type Auditor interface {
+	Record(action, subject string, details map[string]interface{})
+}
+
+// Policy restricts file paths to allowed roots per kind of access. Paths are
+// resolved one component at a time without trusting symbolic links, so a
+// link cannot lead out of the root the path started in.
+type Policy struct {
+	rules   map[Access]*accessRule
+	logger  *logrus.Logger
+	auditor Auditor
+}
+
+type accessRule struct {
+	roots []string
+	deny  []string
+}
+
+// NewPolicy creates a policy from the access configuration. Roots are made
+// absolute and their own symbolic links resolved.
+func NewPolicy(cfg config.AccessConfig, logger *logrus.Logger) (*Policy, error) {
+	policy := &Policy{
+		rules:  make(map[Access]*accessRule, 3),
+		logger: logger,
+	}
+
+	for access, rule := range map[Access]config.AccessRule{
+		AccessRead:   cfg.Read,
+		AccessWrite:  cfg.Write,
+		AccessDelete: cfg.Delete,
+	} {
+		compiled := &accessRule{deny: rule.Deny}
+		for _, root := range rule.Roots {
+			abs, err := filepath.Abs(root)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s root %q: %w", access, root, err)
+			}
+			if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+				abs = resolved
+			}
+			compiled.roots = append(compiled.roots, abs)
+		}
+		if err := (Filters{Exclude: rule.Deny}).Validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s deny pattern: %w", access, err)
+		}
+		policy.rules[access] = compiled
+	}
+
+	return policy, nil
+}
+
+// Resolve checks a path against the policy and returns it with all symbolic
+// links resolved. Delete access does not follow a link in the final
+// component, so deleting a link removes the link itself. Missing trailing
+// components are allowed so new files can be created.
+func (p *Policy) Resolve(access Access, path string) (string, error) {
+	if p == nil {
+		return filepath.Clean(path), nil
+	}
+
+	rule, ok := p.rules[access]
+	if !ok {
+		return "", fmt.Errorf("unknown access %q", access)
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", p.deny(access, path, "", "path must be absolute")
+	}
+	clean := filepath.Clean(path)
+
+	root := rule.rootFor(clean)
+	if root == "" {
+		return "", p.deny(access, path, "", "outside allowed roots")
+	}
+
+	resolved, err := resolveIn(root, clean, access != AccessDelete)
+	if err != nil {
+		if errors.Is(err, errEscape) {
+			return "", p.deny(access, path, resolved, fmt.Sprintf("symbolic link leads outside %s", root))
+		}
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	if access == AccessDelete && resolved == root {
+		return "", p.deny(access, path, resolved, "allowed roots cannot be deleted")
+	}
+
+	for _, candidate := range []string{clean, resolved} {
+		if pattern := rule.denied(candidate); pattern != "" {
+			return "", p.deny(access, path, resolved, fmt.Sprintf("matches deny pattern %q", pattern))
+		}
+	}
+
+	return resolved, nil
+}
+
+// Open resolves a path for read access and opens it without following
+// symbolic links that appear after resolution
+func (p *Policy) Open(path string) (*os.File, error) {
+	resolved, err := p.Resolve(AccessRead, path)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return os.Open(resolved)
+	}
+	return openBeneath(p.rules[AccessRead].rootFor(resolved), resolved)
+}
+
+// Allows reports whether a path that is already known to be inside an
+// allowed root passes the deny patterns. It is used to filter entries while
+// walking a resolved directory.
+func (p *Policy) Allows(access Access, path string) bool {
+	if p == nil {
+		return true
+	}
+	rule, ok := p.rules[access]
+	return ok && rule.denied(path) == ""
+}
+
+// rootFor returns the allowed root containing a resolved path
+func (p *Policy) rootFor(access Access, path string) string {
+	if p == nil {
+		return string(filepath.Separator)
+	}
+	rule, ok := p.rules[access]
+	if !ok {
+		return ""
+	}
+	return rule.rootFor(path)
+}
+
+// deny logs and audits a rejected path
+func (p *Policy) deny(access Access, path, resolved, reason string) error {
+	err := &AccessError{Access: access, Path: path, Resolved: resolved, Reason: reason}
+
+	p.logger.WithFields(logrus.Fields{
+		"access": access,
+		"path":   path,
+		"reason": reason,
+	}).Warn("File access denied")
+
+	if p.auditor != nil {
+		details := map[string]interface{}{
+			"access": string(access),
+			"reason": reason,
+		}
+		if resolved != "" && resolved != path {
+			details["resolved"] = resolved
+		}
+		p.auditor.Record("file.access_denied", path, details)
+	}
+
+	return err
+}
+
+// rootFor returns the longest allowed root containing path
+func (r *accessRule) rootFor(path string) string {
+	best := ""
+	for _, root := range r.roots {
+		if within(path, root) && len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
+
+// denied returns the first deny pattern matching path
+func (r *accessRule) denied(path string) string {
+	rel := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	for _, pattern := range r.deny {
+		if matchGlob(pattern, rel) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+var errEscape = errors.New("path escapes root")
+
+// resolveIn resolves path, which lies lexically under root, one component
+// at a time with lstat. Symbolic links are expanded in place and the walk
+// fails with errEscape as soon as it would leave root. Once a component does
+// not exist the rest of the path is appended as is.
+func resolveIn(root, path string, followFinal bool) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+
+	current := root
+	parts := splitPath(rel)
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			if !within(current, root) {
+				return current, errEscape
+			}
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			result := filepath.Join(append([]string{next}, parts...)...)
+			if !within(result, root) {
+				return result, errEscape
+			}
+			return result, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 || (len(parts) == 0 && !followFinal) {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links at %s", next)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			target = filepath.Clean(target)
+			if !within(target, root) {
+				return target, errEscape
+			}
+			rel, _ := filepath.Rel(root, target)
+			current = root
+			parts = append(splitPath(rel), parts...)
+		} else {
+			parts = append(splitPath(target), parts...)
+		}
+	}
+
+	return current, nil
+}
+
+func splitPath(path string) []string {
+	if path == "." || path == "" {
+		return nil
+	}
+	return strings.Split(path, string(filepath.Separator))
+}
+
+// within reports whether path is root or below it
+func within(path, root string) bool {
+	if root == string(filepath.Separator) {
+		return true
+	}
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
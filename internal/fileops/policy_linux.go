@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// openBeneath opens a resolved path for reading by walking down from root
+// with openat and O_NOFOLLOW, so a symbolic link swapped into the path after
+// it was resolved makes the open fail instead of escaping root
+func openBeneath(root, path string) (*os.File, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	parts := splitPath(rel)
+	for i, part := range parts {
+		flags := unix.O_RDONLY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+		if i < len(parts)-1 {
+			flags |= unix.O_DIRECTORY
+		}
+
+		next, err := unix.Openat(fd, part, flags, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "openat", Path: filepath.Join(append([]string{root}, parts[:i+1]...)...), Err: err}
+		}
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package fileops
+
+import "os"
+
+// openBeneath opens a resolved path for reading. Without openat the path is
+// opened directly, relying on the resolution done by the policy.
+func openBeneath(root, path string) (*os.File, error) {
+	return os.Open(path)
+}
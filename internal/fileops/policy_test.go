@@ -0,0 +1,149 @@
+package fileops
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// policyTree creates an allowed root "data" next to a directory "outside"
+// with links leading in and out of the root
+func policyTree(t *testing.T) string {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{"data/sub/deep", "outside"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"data/file.txt", "data/sub/server.key", "outside/secret"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"data/escape":       "../outside",
+		"data/abs-escape":   filepath.Join(dir, "outside/secret"),
+		"data/inside":       "sub",
+		"data/abs-inside":   filepath.Join(dir, "data/sub/deep"),
+		"data/sub/up":       "../../outside",
+		"data/sub/sibling":  "../file.txt",
+		"data/dangling":     "../outside/new",
+		"data/loop-a":       "loop-b",
+		"data/loop-b":       "loop-a",
+		"data/chain":        "inside/deep",
+		"data/sub/deep/top": "../../..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestPolicyResolve(t *testing.T) {
+	dir := policyTree(t)
+	data := filepath.Join(dir, "data")
+
+	rule := config.AccessRule{Roots: []string{data}, Deny: []string{"*.key"}}
+	policy, err := NewPolicy(config.AccessConfig{Read: rule, Write: rule, Delete: rule}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		access Access
+		path   string // relative to dir
+		want   string // relative to dir
+		raw    bool   // pass path as is
+		denied bool
+	}{
+		{name: "file", access: AccessRead, path: "data/file.txt", want: "data/file.txt"},
+		{name: "root itself", access: AccessRead, path: "data", want: "data"},
+		{name: "new file", access: AccessWrite, path: "data/new/nested.txt", want: "data/new/nested.txt"},
+		{name: "relative path", access: AccessRead, path: "data/file.txt", raw: true, denied: true},
+		{name: "outside roots", access: AccessRead, path: "outside/secret", denied: true},
+		{name: "root prefix is not a root", access: AccessRead, path: "data-other/file", denied: true},
+		{name: "dot dot out of root", access: AccessRead, path: "data/../outside/secret", denied: true},
+		{name: "dot dot inside root", access: AccessRead, path: "data/sub/../file.txt", want: "data/file.txt"},
+		{name: "relative link out", access: AccessRead, path: "data/escape/secret", denied: true},
+		{name: "absolute link out", access: AccessRead, path: "data/abs-escape", denied: true},
+		{name: "nested link out", access: AccessRead, path: "data/sub/up/secret", denied: true},
+		{name: "dangling link out", access: AccessWrite, path: "data/dangling", denied: true},
+		{name: "link to root parent", access: AccessRead, path: "data/sub/deep/top/outside", denied: true},
+		{name: "relative link in", access: AccessRead, path: "data/inside/deep", want: "data/sub/deep"},
+		{name: "absolute link in", access: AccessRead, path: "data/abs-inside", want: "data/sub/deep"},
+		{name: "link with dot dot in", access: AccessRead, path: "data/sub/sibling", want: "data/file.txt"},
+		{name: "chained links", access: AccessRead, path: "data/chain", want: "data/sub/deep"},
+		{name: "delete keeps final link", access: AccessDelete, path: "data/escape", want: "data/escape"},
+		{name: "delete follows inner links", access: AccessDelete, path: "data/escape/secret", denied: true},
+		{name: "delete root", access: AccessDelete, path: "data", denied: true},
+		{name: "delete root through dot dot", access: AccessDelete, path: "data/sub/..", denied: true},
+		{name: "deny pattern", access: AccessRead, path: "data/sub/server.key", denied: true},
+		{name: "deny pattern through link", access: AccessRead, path: "data/inside/server.key", denied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if !tt.raw {
+				path = filepath.Join(dir, path)
+			}
+
+			got, err := policy.Resolve(tt.access, path)
+			if tt.denied {
+				if !errors.Is(err, ErrAccessDenied) {
+					t.Fatalf("Resolve(%s) = %q, %v; want access denied", tt.path, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%s): %v", tt.path, err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("Resolve(%s) = %s, want %s", tt.path, got, want)
+			}
+		})
+	}
+}
+
+func TestPolicyResolveSymlinkLoop(t *testing.T) {
+	dir := policyTree(t)
+	rule := config.AccessRule{Roots: []string{filepath.Join(dir, "data")}}
+	policy, err := NewPolicy(config.AccessConfig{Read: rule, Write: rule, Delete: rule}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = policy.Resolve(AccessRead, filepath.Join(dir, "data/loop-a"))
+	if err == nil || errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("Resolve of a link loop = %v, want a resolution error", err)
+	}
+}
+
+func TestNilPolicyResolve(t *testing.T) {
+	var policy *Policy
+	got, err := policy.Resolve(AccessRead, "/a/b/../c")
+	if err != nil || got != "/a/c" {
+		t.Errorf("Resolve = %q, %v; want /a/c", got, err)
+	}
+}
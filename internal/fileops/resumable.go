@@ -0,0 +1,580 @@
+package fileops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUploadNotFound is returned for unknown or expired upload sessions
+	ErrUploadNotFound = errors.New("upload session not found")
+	// ErrUploadBusy is returned when a session is already receiving data
+	ErrUploadBusy = errors.New("upload session is busy")
+	// ErrUploadIncomplete is returned when completing a session that has not
+	// received all of its data
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+)
+
+// OffsetError is returned when a chunk does not start at the session's
+// current offset
+type OffsetError struct {
+	Offset int64 `json:"offset"`
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("chunk does not start at upload offset %d", e.Offset)
+}
+
+// UploadSession describes a resumable upload
+type UploadSession struct {
+	ID        string         `json:"id"`
+	Status    TransferStatus `json:"status"`
+	DestPath  string         `json:"dest_path"`
+	Size      int64          `json:"size,omitempty"`
+	Offset    int64          `json:"offset"`
+	Progress  float64        `json:"progress"`
+	CreatedAt time.Time      `json:"created_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// uploadSession is the state of a resumable upload. Received data is
+// appended to a part file in the uploads directory and the state is saved
+// next to it after every chunk, so sessions survive agent restarts.
+type uploadSession struct {
+	Transfer  *Transfer     `json:"transfer"`
+	Options   UploadOptions `json:"options"`
+	HashState []byte        `json:"hash_state"` // SHA-256 state after Transfer.Transferred bytes
+	UpdatedAt time.Time     `json:"updated_at"`
+
+	busy    bool
+	aborted bool
+}
+
+// CreateUpload starts a resumable upload. Data is sent with WriteUpload and
+// the file is moved into place by CompleteUpload.
+func (m *Manager) CreateUpload(opts UploadOptions) (*UploadSession, error) {
+	if err := m.prepareUpload(&opts); err != nil {
+		return nil, err
+	}
+	if opts.Size < 0 {
+		return nil, fmt.Errorf("invalid upload size %d", opts.Size)
+	}
+	if limit := m.config.MaxFileSize; limit > 0 && opts.Size > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrFileTooLarge, limit)
+	}
+
+	if err := os.MkdirAll(m.config.UploadsDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	hashState, err := marshalHash(sha256.New())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &uploadSession{
+		Transfer: &Transfer{
+			ID:        uuid.New().String(),
+			Type:      TransferTypeUpload,
+			Status:    TransferStatusPending,
+			DestPath:  opts.DestPath,
+			Size:      opts.Size,
+			StartedAt: now,
+			Metadata: map[string]interface{}{
+				"resumable": true,
+			},
+		},
+		Options:   opts,
+		HashState: hashState,
+		UpdatedAt: now,
+	}
+
+	part, err := os.OpenFile(m.uploadPartPath(session.Transfer.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	part.Close()
+
+	if err := m.saveUpload(session); err != nil {
+		m.removeUploadFiles(session.Transfer.ID)
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.transfers[session.Transfer.ID] = session.Transfer
+	m.uploads[session.Transfer.ID] = session
+	info := m.uploadInfo(session)
+	m.mu.Unlock()
+
+	m.publishProgress(session.Transfer)
+
+	m.logger.WithFields(map[string]interface{}{
+		"transfer_id": session.Transfer.ID,
+		"dest_path":   opts.DestPath,
+		"size":        opts.Size,
+	}).Info("Resumable upload created")
+
+	return info, nil
+}
+
+// GetUpload returns the state of a resumable upload
+func (m *Manager) GetUpload(id string) (*UploadSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.uploads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	return m.uploadInfo(session), nil
+}
+
+// WriteUpload appends a chunk to a resumable upload. The chunk must start at
+// the session's current offset. Data received before the reader fails is
+// kept, so an interrupted chunk is resumed from wherever it stopped.
+func (m *Manager) WriteUpload(ctx context.Context, id string, offset int64, r io.Reader) (*UploadSession, error) {
+	session, err := m.acquireUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer m.releaseUpload(session)
+
+	transfer := session.Transfer
+	if offset != transfer.Transferred {
+		return nil, &OffsetError{Offset: transfer.Transferred}
+	}
+
+	hash, err := unmarshalHash(session.HashState)
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := os.OpenFile(m.uploadPartPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer part.Close()
+
+	// Drop anything past the saved offset, e.g. from a write that was
+	// interrupted before its state was saved
+	if err := part.Truncate(offset); err != nil {
+		return nil, fmt.Errorf("failed to truncate upload file: %w", err)
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek upload file: %w", err)
+	}
+
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m.mu.Lock()
+	transfer.ctx, transfer.cancel = writeCtx, cancel
+	transfer.Status = TransferStatusRunning
+	m.mu.Unlock()
+	m.publishProgress(transfer)
+
+	var src io.Reader = &contextReader{ctx: writeCtx, r: r}
+	remaining := m.uploadRemaining(session)
+	if remaining >= 0 {
+		src = io.LimitReader(src, remaining)
+	}
+
+	written, copyErr := io.Copy(io.MultiWriter(part, hash), &progressReader{r: src, transfer: transfer, manager: m})
+	if copyErr == nil && remaining >= 0 && written == remaining {
+		var probe [1]byte
+		if n, _ := io.ReadFull(r, probe[:]); n > 0 {
+			copyErr = fmt.Errorf("%w: upload is limited to %d bytes", ErrFileTooLarge, offset+remaining)
+		}
+	}
+
+	// Keep what was written, as long as it reached the disk
+	newOffset := offset + written
+	if err := part.Sync(); err != nil {
+		newOffset = offset
+		copyErr = fmt.Errorf("failed to sync upload file: %w", err)
+	}
+	if newOffset > offset {
+		if session.HashState, err = marshalHash(hash); err != nil {
+			newOffset = offset
+			copyErr = err
+		}
+	}
+
+	m.mu.Lock()
+	transfer.Transferred = newOffset
+	if transfer.Size > 0 {
+		transfer.Progress = float64(newOffset) / float64(transfer.Size) * 100
+	}
+	transfer.ctx, transfer.cancel = nil, nil
+	session.UpdatedAt = time.Now()
+	aborted := session.aborted
+	if !aborted {
+		transfer.Status = TransferStatusPending
+	}
+	m.mu.Unlock()
+
+	if aborted {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+
+	if err := m.saveUpload(session); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	m.publishProgress(transfer)
+
+	m.mu.RLock()
+	info := m.uploadInfo(session)
+	m.mu.RUnlock()
+
+	if copyErr != nil {
+		return info, fmt.Errorf("failed to write upload: %w", copyErr)
+	}
+	return info, nil
+}
+
+// CompleteUpload verifies a resumable upload and atomically moves it to its
+// destination. checksum, if given, takes precedence over the one supplied at
+// creation. A checksum mismatch discards the session; other failures keep it
+// so completion can be retried.
+func (m *Manager) CompleteUpload(id, checksum string) (*Transfer, error) {
+	session, err := m.acquireUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer m.releaseUpload(session)
+
+	transfer := session.Transfer
+	if transfer.Size > 0 && transfer.Transferred != transfer.Size {
+		return nil, fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, transfer.Transferred, transfer.Size)
+	}
+
+	hash, err := unmarshalHash(session.HashState)
+	if err != nil {
+		return nil, err
+	}
+	transfer.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if checksum == "" {
+		checksum = session.Options.SHA256
+	}
+	if checksum != "" && !strings.EqualFold(checksum, transfer.Checksum) {
+		err := &ChecksumError{Expected: strings.ToLower(checksum), Actual: transfer.Checksum}
+		m.finishUpload(session, TransferStatusFailed, err.Error())
+		return transfer, err
+	}
+
+	// The destination may have changed since the session was created
+	opts := session.Options
+	dest, err := m.ResolvePath(AccessWrite, opts.DestPath)
+	if err != nil {
+		return nil, err
+	}
+	opts.DestPath = dest
+	partPath := m.uploadPartPath(id)
+	if err := os.Chmod(partPath, opts.Mode); err != nil {
+		return nil, fmt.Errorf("failed to set mode: %w", err)
+	}
+	if opts.UID >= 0 || opts.GID >= 0 {
+		if err := os.Chown(partPath, opts.UID, opts.GID); err != nil {
+			return nil, fmt.Errorf("failed to set owner: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.DestPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if err := m.commitVersioned(partPath, opts.DestPath, opts.Overwrite); err != nil {
+		return nil, err
+	}
+
+	transfer.Size = transfer.Transferred
+	transfer.Progress = 100
+	m.finishUpload(session, TransferStatusCompleted, "")
+
+	m.logger.WithFields(map[string]interface{}{
+		"transfer_id": id,
+		"dest_path":   opts.DestPath,
+		"size":        transfer.Size,
+	}).Info("Resumable upload completed")
+
+	return transfer, nil
+}
+
+// AbortUpload discards a resumable upload, interrupting a chunk that is
+// being received
+func (m *Manager) AbortUpload(id string) error {
+	m.mu.Lock()
+	session, ok := m.uploads[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	delete(m.uploads, id)
+	session.aborted = true
+	busy := session.busy
+	if session.Transfer.cancel != nil {
+		session.Transfer.cancel()
+	}
+	session.Transfer.Status = TransferStatusCancelled
+	session.Transfer.CompletedAt = time.Now()
+	m.mu.Unlock()
+
+	// A busy session's files are removed when its writer finishes
+	if !busy {
+		m.removeUploadFiles(id)
+	}
+	m.publishProgress(session.Transfer)
+
+	m.logger.WithField("transfer_id", id).Info("Resumable upload aborted")
+	return nil
+}
+
+// acquireUpload marks a session busy so chunks are written one at a time
+func (m *Manager) acquireUpload(id string) (*uploadSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.uploads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, id)
+	}
+	if session.busy {
+		return nil, fmt.Errorf("%w: %s", ErrUploadBusy, id)
+	}
+	session.busy = true
+	return session, nil
+}
+
+// releaseUpload clears the busy flag and removes the files of a session that
+// was aborted or finished meanwhile
+func (m *Manager) releaseUpload(session *uploadSession) {
+	m.mu.Lock()
+	session.busy = false
+	aborted := session.aborted
+	m.mu.Unlock()
+
+	if aborted {
+		m.removeUploadFiles(session.Transfer.ID)
+	}
+}
+
+// finishUpload ends a session with a final transfer status. The session's
+// files are removed once it is released.
+func (m *Manager) finishUpload(session *uploadSession, status TransferStatus, errMsg string) {
+	m.mu.Lock()
+	delete(m.uploads, session.Transfer.ID)
+	session.aborted = true
+	session.Transfer.Status = status
+	session.Transfer.Error = errMsg
+	session.Transfer.CompletedAt = time.Now()
+	m.mu.Unlock()
+
+	m.publishProgress(session.Transfer)
+}
+
+// uploadRemaining returns how many more bytes a session accepts, or -1 if
+// there is no limit
+func (m *Manager) uploadRemaining(session *uploadSession) int64 {
+	transfer := session.Transfer
+	switch {
+	case transfer.Size > 0:
+		return transfer.Size - transfer.Transferred
+	case m.config.MaxFileSize > 0:
+		remaining := m.config.MaxFileSize - transfer.Transferred
+		if remaining < 0 {
+			return 0
+		}
+		return remaining
+	default:
+		return -1
+	}
+}
+
+// uploadInfo returns the public view of a session. Must be called with m.mu
+// held.
+func (m *Manager) uploadInfo(session *uploadSession) *UploadSession {
+	transfer := session.Transfer
+	return &UploadSession{
+		ID:        transfer.ID,
+		Status:    transfer.Status,
+		DestPath:  transfer.DestPath,
+		Size:      transfer.Size,
+		Offset:    transfer.Transferred,
+		Progress:  transfer.Progress,
+		CreatedAt: transfer.StartedAt,
+		ExpiresAt: session.UpdatedAt.Add(m.config.UploadExpiry),
+	}
+}
+
+// loadUploads restores the sessions saved in the uploads directory and
+// removes part files that have no session
+func (m *Manager) loadUploads() {
+	entries, err := os.ReadDir(m.config.UploadsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.logger.WithError(err).Error("Failed to read uploads directory")
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, ".json"):
+			id := strings.TrimSuffix(name, ".json")
+			session, err := m.loadUpload(id)
+			if err != nil {
+				m.logger.WithError(err).WithField("transfer_id", id).Warn("Discarding resumable upload")
+				m.removeUploadFiles(id)
+				continue
+			}
+
+			m.mu.Lock()
+			m.transfers[id] = session.Transfer
+			m.uploads[id] = session
+			m.mu.Unlock()
+		case strings.HasSuffix(name, ".part"):
+			id := strings.TrimSuffix(name, ".part")
+			if _, err := os.Stat(m.uploadStatePath(id)); os.IsNotExist(err) {
+				os.Remove(filepath.Join(m.config.UploadsDir, name))
+			}
+		}
+	}
+
+	if len(m.uploads) > 0 {
+		m.logger.WithField("count", len(m.uploads)).Info("Restored resumable uploads")
+	}
+}
+
+// loadUpload reads a saved session and trims its part file to the saved
+// offset
+func (m *Manager) loadUpload(id string) (*uploadSession, error) {
+	data, err := os.ReadFile(m.uploadStatePath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var session uploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("invalid upload state: %w", err)
+	}
+	if session.Transfer == nil || session.Transfer.ID != id {
+		return nil, errors.New("invalid upload state")
+	}
+
+	info, err := os.Stat(m.uploadPartPath(id))
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < session.Transfer.Transferred {
+		return nil, fmt.Errorf("upload file has %d of %d received bytes", info.Size(), session.Transfer.Transferred)
+	}
+	if info.Size() > session.Transfer.Transferred {
+		if err := os.Truncate(m.uploadPartPath(id), session.Transfer.Transferred); err != nil {
+			return nil, err
+		}
+	}
+
+	session.Transfer.Status = TransferStatusPending
+	return &session, nil
+}
+
+// saveUpload writes a session's state atomically
+func (m *Manager) saveUpload(session *uploadSession) error {
+	m.mu.RLock()
+	data, err := json.Marshal(session)
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode upload state: %w", err)
+	}
+
+	path := m.uploadStatePath(session.Transfer.ID)
+	tmp, err := os.CreateTemp(m.config.UploadsDir, ".state-*")
+	if err != nil {
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+	return nil
+}
+
+// expireUploads discards sessions that have not received data within the
+// upload expiry
+func (m *Manager) expireUploads() {
+	if m.config.UploadExpiry <= 0 {
+		return
+	}
+
+	var expired []*uploadSession
+	m.mu.Lock()
+	for id, session := range m.uploads {
+		if !session.busy && time.Since(session.UpdatedAt) > m.config.UploadExpiry {
+			delete(m.uploads, id)
+			session.Transfer.Status = TransferStatusFailed
+			session.Transfer.Error = "upload session expired"
+			session.Transfer.CompletedAt = time.Now()
+			expired = append(expired, session)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, session := range expired {
+		m.removeUploadFiles(session.Transfer.ID)
+		m.publishProgress(session.Transfer)
+		m.logger.WithField("transfer_id", session.Transfer.ID).Info("Resumable upload expired")
+	}
+}
+
+func (m *Manager) removeUploadFiles(id string) {
+	os.Remove(m.uploadPartPath(id))
+	os.Remove(m.uploadStatePath(id))
+}
+
+func (m *Manager) uploadPartPath(id string) string {
+	return filepath.Join(m.config.UploadsDir, id+".part")
+}
+
+func (m *Manager) uploadStatePath(id string) string {
+	return filepath.Join(m.config.UploadsDir, id+".json")
+}
+
+func marshalHash(h hash.Hash) ([]byte, error) {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save checksum state: %w", err)
+	}
+	return state, nil
+}
+
+func unmarshalHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("failed to restore checksum state: %w", err)
+	}
+	return h, nil
+}
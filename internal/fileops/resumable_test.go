@@ -0,0 +1,152 @@
+package fileops
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingReader returns data and then fails, like a dropped connection
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// startManager starts a manager that is stopped when the test ends
+func startManager(t *testing.T, m *Manager) {
+	t.Helper()
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Stop(context.Background()) })
+}
+
+// createUpload creates a resumable upload of content to dest
+func createUpload(t *testing.T, m *Manager, dest, content string) string {
+	t.Helper()
+	opts := NewUploadOptions(dest)
+	opts.Size = int64(len(content))
+	opts.SHA256 = sha256Hex(content)
+	session, err := m.CreateUpload(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.ID
+}
+
+func TestResumableUploadResumes(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	dest := filepath.Join(data, "backup.tar")
+	content := "hello resumable world"
+	id := createUpload(t, m, dest, content)
+
+	// The connection drops after the first part of the chunk
+	session, err := m.WriteUpload(context.Background(), id, 0, &failingReader{data: content[:8]})
+	if err == nil {
+		t.Fatal("WriteUpload() of a failing reader succeeded")
+	}
+	if session == nil || session.Offset != 8 {
+		t.Fatalf("session after the failed chunk = %+v, want offset 8", session)
+	}
+
+	var offsetErr *OffsetError
+	if _, err := m.WriteUpload(context.Background(), id, 0, strings.NewReader(content)); !errors.As(err, &offsetErr) || offsetErr.Offset != 8 {
+		t.Fatalf("WriteUpload() at offset 0 error = %v, want an OffsetError at 8", err)
+	}
+	if _, err := m.CompleteUpload(id, ""); !errors.Is(err, ErrUploadIncomplete) {
+		t.Errorf("CompleteUpload() of a partial upload error = %v, want ErrUploadIncomplete", err)
+	}
+
+	// The session survives a restart
+	restarted, err := New(m.config, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	startManager(t, restarted)
+	if session, err := restarted.GetUpload(id); err != nil || session.Offset != 8 {
+		t.Fatalf("restored session = %+v, %v, want offset 8", session, err)
+	}
+
+	if _, err := restarted.WriteUpload(context.Background(), id, 8, strings.NewReader(content[8:])); err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := restarted.CompleteUpload(id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Status != TransferStatusCompleted || transfer.Checksum != sha256Hex(content) {
+		t.Errorf("transfer = %+v", transfer)
+	}
+
+	if got, _ := os.ReadFile(dest); string(got) != content {
+		t.Errorf("uploaded file = %q, want %q", got, content)
+	}
+	if entries, _ := os.ReadDir(m.config.UploadsDir); len(entries) != 0 {
+		t.Errorf("uploads directory still holds %d files", len(entries))
+	}
+}
+
+func TestResumableUploadRejectsExtraData(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	id := createUpload(t, m, filepath.Join(data, "file"), "four")
+
+	_, err := m.WriteUpload(context.Background(), id, 0, strings.NewReader("four and more"))
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("WriteUpload() beyond the size error = %v, want ErrFileTooLarge", err)
+	}
+}
+
+func TestResumableUploadChecksumMismatch(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	dest := filepath.Join(data, "file")
+	id := createUpload(t, m, dest, "four")
+
+	if _, err := m.WriteUpload(context.Background(), id, 0, strings.NewReader("five")); err != nil {
+		t.Fatal(err)
+	}
+	var checksumErr *ChecksumError
+	if _, err := m.CompleteUpload(id, ""); !errors.As(err, &checksumErr) {
+		t.Fatalf("CompleteUpload() error = %v, want a ChecksumError", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("upload with a checksum mismatch was committed")
+	}
+	if _, err := m.GetUpload(id); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload() after a mismatch error = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestAbortUpload(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	id := createUpload(t, m, filepath.Join(data, "file"), "content")
+
+	if _, err := m.WriteUpload(context.Background(), id, 0, io.LimitReader(strings.NewReader("content"), 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AbortUpload(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.GetUpload(id); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("GetUpload() after abort error = %v, want ErrUploadNotFound", err)
+	}
+	if entries, _ := os.ReadDir(m.config.UploadsDir); len(entries) != 0 {
+		t.Errorf("uploads directory still holds %d files", len(entries))
+	}
+}
@@ -0,0 +1,785 @@
+package fileops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SyncEntryType is the kind of a synced directory entry
+type SyncEntryType string
+
+const (
+	SyncEntryFile    SyncEntryType = "file"
+	SyncEntryDir     SyncEntryType = "dir"
+	SyncEntrySymlink SyncEntryType = "symlink"
+)
+
+// SyncEntry describes one entry of a directory tree. Path is slash
+// separated and relative to the root of the tree.
+type SyncEntry struct {
+	Path    string        `json:"path"`
+	Type    SyncEntryType `json:"type"`
+	Size    int64         `json:"size,omitempty"`
+	Mode    os.FileMode   `json:"mode"`
+	ModTime time.Time     `json:"mod_time"`
+	Target  string        `json:"target,omitempty"` // symbolic link target
+}
+
+// SyncManifest lists the entries of a directory tree, sorted by path
+type SyncManifest struct {
+	Root    string      `json:"root"`
+	Entries []SyncEntry `json:"entries"`
+}
+
+// SyncOptions controls how a destination tree is made to match a source
+type SyncOptions struct {
+	Delete        bool `json:"delete"`         // remove destination entries missing from the source
+	PreserveMode  bool `json:"preserve_mode"`  // copy permission bits
+	PreserveTimes bool `json:"preserve_times"` // copy modification times
+}
+
+// SyncAction is a change made to a destination tree
+type SyncAction string
+
+const (
+	SyncActionDelete  SyncAction = "delete"
+	SyncActionMkdir   SyncAction = "mkdir"
+	SyncActionSymlink SyncAction = "symlink"
+	SyncActionCreate  SyncAction = "create"
+	SyncActionUpdate  SyncAction = "update"
+	SyncActionAttrs   SyncAction = "attrs" // mode and modification time only
+)
+
+// SyncChange is one planned change. Entry is the source entry, or the
+// removed destination entry for deletes.
+type SyncChange struct {
+	Action SyncAction `json:"action"`
+	Path   string     `json:"path"`
+	Entry  SyncEntry  `json:"entry"`
+}
+
+// NeedsData reports whether the change transfers file contents
+func (c SyncChange) NeedsData() bool {
+	return c.Action == SyncActionCreate || c.Action == SyncActionUpdate
+}
+
+// SyncPlan is the ordered list of changes that makes a destination tree
+// match a source: deletes, directories, links, file contents, then
+// attributes
+type SyncPlan struct {
+	Changes []SyncChange       `json:"changes"`
+	Summary map[SyncAction]int `json:"summary"`
+	Bytes   int64              `json:"bytes"` // size of the files to create or update
+}
+
+// SyncResult is the outcome of applying one change
+type SyncResult struct {
+	Action SyncAction `json:"action"`
+	Path   string     `json:"path"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// BuildManifest lists the tree at root without following symbolic links.
+// Entries the filters do not select, or for which skip returns true, are
+// left out; skipped directories are not descended into. Devices, sockets
+// and pipes are ignored. A missing root yields an empty manifest.
+func BuildManifest(root string, filters Filters, skip func(path string) bool) (*SyncManifest, error) {
+	manifest := &SyncManifest{Root: root, Entries: []SyncEntry{}}
+
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidPath, root)
+	}
+
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !filters.Selects(rel, info.IsDir()) || (skip != nil && skip(p)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		entry := SyncEntry{
+			Path:    rel,
+			Mode:    info.Mode().Perm(),
+			ModTime: info.ModTime(),
+		}
+		switch {
+		case info.Mode().IsRegular():
+			entry.Type = SyncEntryFile
+			entry.Size = info.Size()
+		case info.IsDir():
+			entry.Type = SyncEntryDir
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.Type = SyncEntrySymlink
+			if entry.Target, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+
+	return manifest, nil
+}
+
+// PlanSync compares a source and a destination manifest and returns the
+// changes that make the destination match. Files are considered unchanged
+// when their size and modification time (to the second) are equal.
+// Entries that change type are deleted and recreated; other extraneous
+// destination entries are only deleted with opts.Delete.
+func PlanSync(source, dest *SyncManifest, opts SyncOptions) *SyncPlan {
+	existing := make(map[string]SyncEntry, len(dest.Entries))
+	for _, entry := range dest.Entries {
+		existing[entry.Path] = entry
+	}
+	wanted := make(map[string]bool, len(source.Entries))
+
+	var deletes, mkdirs, links, files, attrs []SyncChange
+	for _, entry := range source.Entries {
+		wanted[entry.Path] = true
+		current, ok := existing[entry.Path]
+		if ok && current.Type != entry.Type {
+			deletes = append(deletes, SyncChange{Action: SyncActionDelete, Path: current.Path, Entry: current})
+			ok = false
+		}
+
+		switch entry.Type {
+		case SyncEntryDir:
+			if !ok {
+				mkdirs = append(mkdirs, SyncChange{Action: SyncActionMkdir, Path: entry.Path, Entry: entry})
+			} else if attrsDiffer(entry, current, opts) {
+				attrs = append(attrs, SyncChange{Action: SyncActionAttrs, Path: entry.Path, Entry: entry})
+			}
+		case SyncEntrySymlink:
+			if !ok || current.Target != entry.Target {
+				links = append(links, SyncChange{Action: SyncActionSymlink, Path: entry.Path, Entry: entry})
+			}
+		case SyncEntryFile:
+			switch {
+			case !ok:
+				files = append(files, SyncChange{Action: SyncActionCreate, Path: entry.Path, Entry: entry})
+			case current.Size != entry.Size || current.ModTime.Unix() != entry.ModTime.Unix():
+				files = append(files, SyncChange{Action: SyncActionUpdate, Path: entry.Path, Entry: entry})
+			case attrsDiffer(entry, current, opts):
+				attrs = append(attrs, SyncChange{Action: SyncActionAttrs, Path: entry.Path, Entry: entry})
+			}
+		}
+	}
+
+	if opts.Delete {
+		// Deleting a directory removes everything below it
+		var removed []string
+		for _, entry := range dest.Entries {
+			if wanted[entry.Path] || under(entry.Path, removed) {
+				continue
+			}
+			deletes = append(deletes, SyncChange{Action: SyncActionDelete, Path: entry.Path, Entry: entry})
+			if entry.Type == SyncEntryDir {
+				removed = append(removed, entry.Path)
+			}
+		}
+	}
+
+	// Changing a directory's contents changes its modification time, so
+	// directories are touched last
+	if opts.PreserveTimes {
+		touched := make(map[string]bool)
+		for _, list := range [][]SyncChange{deletes, mkdirs, links, files} {
+			for _, change := range list {
+				if change.Action == SyncActionMkdir {
+					touched[change.Path] = true
+				}
+				for dir := path.Dir(change.Path); dir != "."; dir = path.Dir(dir) {
+					touched[dir] = true
+				}
+			}
+		}
+		for _, change := range attrs {
+			delete(touched, change.Path)
+		}
+		for _, entry := range source.Entries {
+			if entry.Type == SyncEntryDir && touched[entry.Path] {
+				attrs = append(attrs, SyncChange{Action: SyncActionAttrs, Path: entry.Path, Entry: entry})
+			}
+		}
+	}
+
+	plan := &SyncPlan{Summary: make(map[SyncAction]int)}
+	for _, list := range [][]SyncChange{deletes, mkdirs, links, files, attrs} {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+		plan.Changes = append(plan.Changes, list...)
+	}
+	for _, change := range plan.Changes {
+		plan.Summary[change.Action]++
+		if change.NeedsData() {
+			plan.Bytes += change.Entry.Size
+		}
+	}
+	if plan.Changes == nil {
+		plan.Changes = []SyncChange{}
+	}
+
+	return plan
+}
+
+// attrsDiffer reports whether the preserved attributes of two entries differ
+func attrsDiffer(source, dest SyncEntry, opts SyncOptions) bool {
+	if opts.PreserveMode && source.Mode.Perm() != dest.Mode.Perm() {
+		return true
+	}
+	return opts.PreserveTimes && source.Type != SyncEntrySymlink && !source.ModTime.Equal(dest.ModTime)
+}
+
+// under reports whether rel is below any of the directories
+func under(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinSyncPath joins a relative path from a manifest to root. The path must
+// stay below root and no directory on the way may be a symbolic link, so
+// changes cannot be redirected outside the tree.
+func JoinSyncPath(root, rel string) (string, error) {
+	clean := path.Clean(rel)
+	if rel == "" || path.IsAbs(rel) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %q is not a relative path below the sync root", ErrInvalidPath, rel)
+	}
+
+	parts := strings.Split(clean, "/")
+	dir := root
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%w: %s is not a directory", ErrInvalidPath, dir)
+		}
+	}
+
+	return filepath.Join(root, filepath.FromSlash(clean)), nil
+}
+
+// ApplySyncChange applies a change that needs no file contents to the tree
+// at root. Create and update changes are applied with PatchFile.
+func ApplySyncChange(root string, change SyncChange, opts SyncOptions) error {
+	target, err := JoinSyncPath(root, change.Path)
+	if err != nil {
+		return err
+	}
+	entry := change.Entry
+
+	switch change.Action {
+	case SyncActionDelete:
+		return os.RemoveAll(target)
+
+	case SyncActionMkdir:
+		mode := os.FileMode(0755)
+		if opts.PreserveMode {
+			mode = entry.Mode.Perm()
+		}
+		if err := os.Mkdir(target, mode); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if info, err := os.Lstat(target); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%w: %s exists and is not a directory", ErrInvalidPath, target)
+		}
+		if opts.PreserveMode {
+			// Mkdir applies the umask
+			return os.Chmod(target, mode)
+		}
+		return nil
+
+	case SyncActionSymlink:
+		if info, err := os.Lstat(target); err == nil {
+			if info.IsDir() {
+				return fmt.Errorf("%w: %s exists and is a directory", ErrInvalidPath, target)
+			}
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(entry.Target, target)
+
+	case SyncActionAttrs:
+		info, err := os.Lstat(target)
+		if err != nil {
+			return err
+		}
+		// Chmod and Chtimes would follow the link
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if opts.PreserveMode {
+			if err := os.Chmod(target, entry.Mode.Perm()); err != nil {
+				return err
+			}
+		}
+		if opts.PreserveTimes {
+			return os.Chtimes(target, entry.ModTime, entry.ModTime)
+		}
+		return nil
+
+	case SyncActionCreate, SyncActionUpdate:
+		return fmt.Errorf("%s of %s needs file contents", change.Action, change.Path)
+
+	default:
+		return fmt.Errorf("unknown sync action: %s", change.Action)
+	}
+}
+
+// OpenSyncBasis opens the current version of a file in the tree at root,
+// the basis for a delta. It returns nil when the file does not exist or is
+// not a regular file, in which case the whole file is sent.
+func OpenSyncBasis(root, rel string) (*os.File, error) {
+	target, err := JoinSyncPath(root, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(target, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if os.IsNotExist(err) || isSymlinkErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// isSymlinkErr reports whether an O_NOFOLLOW open failed on a link
+func isSymlinkErr(err error) bool {
+	var pathErr *os.PathError
+	return err != nil && errors.As(err, &pathErr) && pathErr.Err == syscall.ELOOP
+}
+
+// SignatureOf returns the block signature of a basis file, or an empty
+// signature when there is none
+func SignatureOf(basis *os.File, blockSize int) (*FileSignature, error) {
+	if basis == nil {
+		if blockSize == 0 {
+			blockSize = MinBlockSize
+		}
+		return &FileSignature{BlockSize: blockSize, Blocks: []BlockSignature{}}, nil
+	}
+
+	info, err := basis.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := basis.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ComputeSignature(basis, info.Size(), blockSize)
+}
+
+// PatchFile rebuilds the file rel in the tree at root from a delta against
+// its current contents and atomically replaces it. The new file keeps the
+// mode of the file it replaces unless the source mode is preserved.
+func PatchFile(ctx context.Context, root, rel string, entry SyncEntry, delta io.Reader, opts SyncOptions, maxSize int64) (DeltaStats, error) {
+	var stats DeltaStats
+	target, err := JoinSyncPath(root, rel)
+	if err != nil {
+		return stats, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return stats, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	basis, err := OpenSyncBasis(root, rel)
+	if err != nil {
+		return stats, err
+	}
+	mode := os.FileMode(defaultUploadMode)
+	var basisReader io.ReaderAt
+	if basis != nil {
+		defer basis.Close()
+		basisReader = basis
+		if info, err := basis.Stat(); err == nil {
+			mode = info.Mode().Perm()
+		}
+	}
+	if opts.PreserveMode {
+		mode = entry.Mode.Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".sync-*")
+	if err != nil {
+		return stats, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the file has been renamed
+
+	stats, err = ApplyDelta(tmp, basisReader, &contextReader{ctx: ctx, r: delta}, maxSize)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if opts.PreserveTimes {
+		if err := os.Chtimes(tmpPath, entry.ModTime, entry.ModTime); err != nil {
+			return stats, fmt.Errorf("failed to set modification time: %w", err)
+		}
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		return stats, fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	return stats, syncDir(filepath.Dir(target))
+}
+
+// SyncManifest lists the tree at root as the source of a sync. Entries the
+// access policy denies reading are left out.
+func (m *Manager) SyncManifest(root string, filters Filters) (*SyncManifest, error) {
+	root, err := m.ResolvePath(AccessRead, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+
+	// An empty source would delete the whole destination
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", root, err)
+	}
+
+	return BuildManifest(root, filters, func(p string) bool {
+		return !m.policy.Allows(AccessRead, p)
+	})
+}
+
+// PlanPush plans the changes that make the tree at root match a client's
+// source manifest
+func (m *Manager) PlanPush(root string, source *SyncManifest, filters Filters, opts SyncOptions) (*SyncPlan, error) {
+	root, err := m.ResolvePath(AccessWrite, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+
+	dest, err := BuildManifest(root, filters, func(p string) bool {
+		return !m.policy.Allows(AccessWrite, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return PlanSync(source, dest, opts), nil
+}
+
+// SyncSignatures returns the block signatures of files in the tree at root.
+// Missing files have empty signatures.
+func (m *Manager) SyncSignatures(root string, paths []string, blockSize int) (map[string]*FileSignature, error) {
+	root, err := m.ResolvePath(AccessWrite, root)
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := make(map[string]*FileSignature, len(paths))
+	for _, rel := range paths {
+		target, err := JoinSyncPath(root, rel)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.checkSyncPath(AccessWrite, target); err != nil {
+			return nil, err
+		}
+
+		basis, err := OpenSyncBasis(root, rel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", target, err)
+		}
+		sig, err := SignatureOf(basis, blockSize)
+		if basis != nil {
+			basis.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", target, err)
+		}
+		signatures[rel] = sig
+	}
+
+	return signatures, nil
+}
+
+// SyncDelta writes the delta that turns a client's copy of rel, described
+// by sig, into the file in the tree at root
+func (m *Manager) SyncDelta(ctx context.Context, root, rel string, sig *FileSignature, w io.Writer) (DeltaStats, error) {
+	root, err := m.ResolvePath(AccessRead, root)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+	target, err := JoinSyncPath(root, rel)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+
+	file, err := m.policy.Open(target)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		return DeltaStats{}, fmt.Errorf("%w: %s is not a regular file", ErrInvalidPath, target)
+	}
+
+	return WriteDelta(w, sig, &contextReader{ctx: ctx, r: file})
+}
+
+// SyncPatch applies a delta from a client to the file rel in the tree at
+// root
+func (m *Manager) SyncPatch(ctx context.Context, root, rel string, entry SyncEntry, delta io.Reader, opts SyncOptions) (DeltaStats, error) {
+	root, err := m.ResolvePath(AccessWrite, root)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+	target, err := JoinSyncPath(root, rel)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+	if err := m.checkSyncPath(AccessWrite, target); err != nil {
+		return DeltaStats{}, err
+	}
+
+	stats, err := PatchFile(ctx, root, rel, entry, delta, opts, m.config.MaxFileSize)
+	if err != nil {
+		return stats, err
+	}
+
+	m.logger.WithFields(map[string]interface{}{
+		"path":    target,
+		"matched": stats.Matched,
+		"literal": stats.Literal,
+	}).Debug("Synced file")
+	return stats, nil
+}
+
+// ApplySync applies changes that need no file contents to the tree at root
+// and reports the outcome of each. Deletes need delete access, everything
+// else write access.
+func (m *Manager) ApplySync(ctx context.Context, root string, changes []SyncChange, opts SyncOptions) ([]SyncResult, error) {
+	root, err := m.ResolvePath(AccessWrite, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", root, err)
+	}
+
+	results := make([]SyncResult, 0, len(changes))
+	for _, change := range changes {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		result := SyncResult{Action: change.Action, Path: change.Path}
+		if err := m.applySyncChange(root, change, opts); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (m *Manager) applySyncChange(root string, change SyncChange, opts SyncOptions) error {
+	target, err := JoinSyncPath(root, change.Path)
+	if err != nil {
+		return err
+	}
+
+	access := AccessWrite
+	if change.Action == SyncActionDelete {
+		access = AccessDelete
+	}
+	if err := m.checkSyncPath(access, target); err != nil {
+		return err
+	}
+
+	return ApplySyncChange(root, change, opts)
+}
+
+// checkSyncPath checks a path joined to a resolved sync root against the
+// access policy. Deletes are resolved in full so allowed roots stay
+// protected; other changes only need to pass the deny patterns, since
+// JoinSyncPath already keeps them inside the root.
+func (m *Manager) checkSyncPath(access Access, target string) error {
+	if access == AccessDelete {
+		_, err := m.ResolvePath(access, target)
+		return err
+	}
+	if !m.policy.Allows(access, target) {
+		return m.policy.deny(access, target, target, "matches a deny pattern")
+	}
+	return nil
+}
+
+// handleSync makes the tree at dest_path match source_path on the agent.
+// metadata.delete removes extraneous entries, metadata.dry_run only returns
+// the plan. Modes and modification times are preserved.
+func (m *Manager) handleSync(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	filters := Filters{
+		Include: metadataStrings(op.Metadata, "include"),
+		Exclude: metadataStrings(op.Metadata, "exclude"),
+	}
+	opts := SyncOptions{PreserveMode: true, PreserveTimes: true}
+	opts.Delete, _ = op.Metadata["delete"].(bool)
+
+	source, err := m.SyncManifest(op.SourcePath, filters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source path: %w", err)
+	}
+	plan, err := m.PlanPush(op.DestPath, source, filters, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dest path: %w", err)
+	}
+	if dryRun, _ := op.Metadata["dry_run"].(bool); dryRun {
+		return map[string]interface{}{"plan": plan}, nil
+	}
+
+	var metadata, data, attrs []SyncChange
+	for _, change := range plan.Changes {
+		switch {
+		case change.NeedsData():
+			data = append(data, change)
+		case change.Action == SyncActionAttrs:
+			attrs = append(attrs, change)
+		default:
+			metadata = append(metadata, change)
+		}
+	}
+
+	results, err := m.ApplySync(ctx, op.DestPath, metadata, opts)
+	if err != nil {
+		return nil, err
+	}
+	var stats DeltaStats
+	for _, change := range data {
+		result := SyncResult{Action: change.Action, Path: change.Path}
+		fileStats, err := m.syncLocalFile(ctx, source.Root, op.DestPath, change, opts)
+		stats.Matched += fileStats.Matched
+		stats.Literal += fileStats.Literal
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	attrResults, err := m.ApplySync(ctx, op.DestPath, attrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, attrResults...)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	return map[string]interface{}{
+		"plan":    plan,
+		"results": results,
+		"matched": stats.Matched,
+		"literal": stats.Literal,
+		"failed":  failed,
+	}, nil
+}
+
+// syncLocalFile copies one file between two trees on the agent, reusing
+// the blocks the destination already has
+func (m *Manager) syncLocalFile(ctx context.Context, sourceRoot, destRoot string, change SyncChange, opts SyncOptions) (DeltaStats, error) {
+	sigs, err := m.SyncSignatures(destRoot, []string{change.Path}, 0)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := m.SyncDelta(ctx, sourceRoot, change.Path, sigs[change.Path], writer)
+		writer.CloseWithError(err)
+	}()
+	stats, err := m.SyncPatch(ctx, destRoot, change.Path, change.Entry, reader, opts)
+	reader.CloseWithError(err)
+	return stats, err
+}
+
+// metadataStrings returns a list of strings from operation metadata, given
+// as a JSON array or a comma separated string
+func metadataStrings(metadata map[string]interface{}, key string) []string {
+	switch value := metadata[key].(type) {
+	case []interface{}:
+		var result []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	case []string:
+		return value
+	case string:
+		var result []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package fileops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// transferChunkSize is the size of the chunks files are sent to the master in
+const transferChunkSize = 256 * 1024
+
+// ErrNoStreamer is returned for transfers to the master server when the
+// agent is not connected to one
+var ErrNoStreamer = errors.New("no connection to the master server")
+
+// Chunk is a piece of a file transferred between the agent and the master
+type Chunk struct {
+	TransferID string
+	Offset     int64
+	Data       []byte
+	Size       int64  // size of the whole file, 0 if unknown
+	Last       bool   // no data follows
+	SHA256     string // checksum of the whole file, sent with the last chunk
+}
+
+// ChunkReceipt is the master's account of a file it received
+type ChunkReceipt struct {
+	Received int64
+	Checksum string
+	Error    string
+}
+
+// ChunkStream carries the chunks of one file to the master server
+type ChunkStream interface {
+	// Send sends the next chunk
+	Send(chunk *Chunk) error
+	// Acknowledged returns how many bytes the master has confirmed
+	Acknowledged() int64
+	// Close waits for the master's receipt after the last chunk
+	Close() (*ChunkReceipt, error)
+}
+
+// Streamer opens chunk streams to the master server
+type Streamer interface {
+	OpenStream(ctx context.Context, transfer *Transfer) (ChunkStream, error)
+}
+
+// SetStreamer sets the connection files are sent to the master over
+func (m *Manager) SetStreamer(streamer Streamer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.streamer = streamer
+}
+
+// handleUpload starts a transfer of a file from the master server to
+// dest_path. The data arrives as chunks passed to ReceiveChunk and is kept
+// in a resumable upload session, so an interrupted transfer continues from
+// the returned offset. Setting metadata.resume to an earlier transfer ID
+// reports that transfer's offset instead of starting a new one.
+func (m *Manager) handleUpload(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	if id := metadataString(op.Metadata, "resume"); id != "" {
+		session, err := m.GetUpload(id)
+		if err != nil {
+			return nil, err
+		}
+		return transferReply(session), nil
+	}
+
+	opts := NewUploadOptions(op.DestPath)
+	opts.Overwrite = op.Overwrite
+	opts.Mode = op.Mode
+	opts.SHA256 = metadataString(op.Metadata, "sha256")
+	if size, ok := metadataInt(op.Metadata, "size"); ok {
+		opts.Size = size
+	}
+	if owner := metadataString(op.Metadata, "owner"); owner != "" {
+		uid, gid, err := ParseOwner(owner)
+		if err != nil {
+			return nil, err
+		}
+		opts.UID, opts.GID = uid, gid
+	}
+
+	session, err := m.CreateUpload(opts)
+	if err != nil {
+		return nil, err
+	}
+	return transferReply(session), nil
+}
+
+// ReceiveChunk writes a chunk of a transfer from the master server. The
+// chunk must start at the transfer's current offset. The last chunk
+// completes the transfer, verifying its checksum, and the completed
+// transfer is returned.
+func (m *Manager) ReceiveChunk(ctx context.Context, chunk *Chunk) (*UploadSession, *Transfer, error) {
+	session, err := m.WriteUpload(ctx, chunk.TransferID, chunk.Offset, bytes.NewReader(chunk.Data))
+	if err != nil {
+		return session, nil, err
+	}
+	if !chunk.Last {
+		return session, nil, nil
+	}
+
+	transfer, err := m.CompleteUpload(chunk.TransferID, chunk.SHA256)
+	return session, transfer, err
+}
+
+// handleDownload starts sending source_path to the master server. A
+// transfer resumes from metadata.offset, or from the offset the master
+// acknowledged for the transfer named in metadata.resume.
+func (m *Manager) handleDownload(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	m.mu.RLock()
+	streamer := m.streamer
+	m.mu.RUnlock()
+	if streamer == nil {
+		return nil, ErrNoStreamer
+	}
+
+	file, err := m.policy.Open(op.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", op.SourcePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidPath, op.SourcePath)
+	}
+
+	var offset int64
+	if id := metadataString(op.Metadata, "resume"); id != "" {
+		previous, err := m.GetTransfer(id)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		offset = previous.Acknowledged
+	} else if value, ok := metadataInt(op.Metadata, "offset"); ok {
+		offset = value
+	}
+	if offset < 0 || offset > info.Size() {
+		file.Close()
+		return nil, &OffsetError{Offset: offset}
+	}
+
+	transfer := &Transfer{
+		ID:           uuid.New().String(),
+		Type:         TransferTypeDownload,
+		Status:       TransferStatusPending,
+		SourcePath:   op.SourcePath,
+		Size:         info.Size(),
+		Transferred:  offset,
+		Acknowledged: offset,
+		StartedAt:    time.Now(),
+		Metadata:     op.Metadata,
+	}
+	if transfer.Size > 0 {
+		transfer.Progress = float64(offset) / float64(transfer.Size) * 100
+	}
+
+	// The transfer outlives the request that started it
+	transfer.ctx, transfer.cancel = context.WithCancel(m.ctx)
+
+	m.mu.Lock()
+	m.transfers[transfer.ID] = transfer
+	m.mu.Unlock()
+
+	go m.executeDownload(transfer, streamer, file, offset)
+
+	return map[string]interface{}{
+		"transfer_id": transfer.ID,
+		"status":      transfer.Status,
+		"size":        transfer.Size,
+		"offset":      offset,
+	}, nil
+}
+
+// executeDownload streams a file to the master server from offset. The
+// checksum covers the whole file and is compared with the one the master
+// computed.
+func (m *Manager) executeDownload(transfer *Transfer, streamer Streamer, file io.ReadCloser, offset int64) {
+	defer file.Close()
+	defer transfer.cancel()
+
+	m.mu.Lock()
+	transfer.Status = TransferStatusRunning
+	m.mu.Unlock()
+	m.publishProgress(transfer)
+
+	err := m.sendFile(transfer, streamer, file, offset)
+
+	m.mu.Lock()
+	transfer.CompletedAt = time.Now()
+	switch {
+	case err == nil:
+		transfer.Status = TransferStatusCompleted
+		transfer.Progress = 100
+	case transfer.ctx.Err() != nil:
+		transfer.Status = TransferStatusCancelled
+		transfer.Error = err.Error()
+	default:
+		transfer.Status = TransferStatusFailed
+		transfer.Error = err.Error()
+	}
+	m.mu.Unlock()
+	m.publishProgress(transfer)
+
+	logger := m.logger.WithFields(map[string]interface{}{
+		"transfer_id":  transfer.ID,
+		"source_path":  transfer.SourcePath,
+		"transferred":  transfer.Transferred,
+		"acknowledged": transfer.Acknowledged,
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Transfer to master failed")
+		return
+	}
+	logger.Info("Transfer to master completed")
+}
+
+// sendFile hashes the part of the file the master already has, then sends
+// the rest in chunks
+func (m *Manager) sendFile(transfer *Transfer, streamer Streamer, file io.Reader, offset int64) error {
+	ctx := transfer.ctx
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, &contextReader{ctx: ctx, r: file}, offset); err != nil {
+		return fmt.Errorf("failed to read %s: %w", transfer.SourcePath, err)
+	}
+
+	stream, err := streamer.OpenStream(ctx, transfer)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+
+	// Send exactly the size that was stat'ed in case the file changes
+	reader := io.LimitReader(&contextReader{ctx: ctx, r: file}, transfer.Size-offset)
+	buf := make([]byte, transferChunkSize)
+	published := time.Now()
+	position := offset
+	for {
+		n, readErr := io.ReadFull(reader, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read %s: %w", transfer.SourcePath, readErr)
+		}
+		hash.Write(buf[:n])
+
+		chunk := &Chunk{
+			TransferID: transfer.ID,
+			Offset:     position,
+			Data:       buf[:n],
+			Size:       transfer.Size,
+			Last:       position+int64(n) >= transfer.Size,
+		}
+		if chunk.Last {
+			chunk.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		}
+		if err := stream.Send(chunk); err != nil {
+			m.recordAcknowledged(transfer, stream.Acknowledged())
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to send chunk at offset %d: %w", position, err)
+		}
+		position += int64(n)
+
+		m.mu.Lock()
+		transfer.Transferred = position
+		if transfer.Size > 0 {
+			transfer.Progress = float64(position) / float64(transfer.Size) * 100
+		}
+		m.mu.Unlock()
+		m.recordAcknowledged(transfer, stream.Acknowledged())
+
+		if now := time.Now(); now.Sub(published) >= progressInterval {
+			published = now
+			m.publishProgress(transfer)
+		}
+
+		if chunk.Last {
+			break
+		}
+		if n == 0 {
+			return fmt.Errorf("%s shrank while it was sent", transfer.SourcePath)
+		}
+	}
+
+	transfer.Checksum = hex.EncodeToString(hash.Sum(nil))
+	receipt, err := stream.Close()
+	m.recordAcknowledged(transfer, stream.Acknowledged())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to complete transfer: %w", err)
+	}
+	m.recordAcknowledged(transfer, receipt.Received)
+
+	if receipt.Error != "" {
+		return fmt.Errorf("master rejected transfer: %s", receipt.Error)
+	}
+	if receipt.Checksum != "" && !strings.EqualFold(receipt.Checksum, transfer.Checksum) {
+		return &ChecksumError{Expected: transfer.Checksum, Actual: strings.ToLower(receipt.Checksum)}
+	}
+	return nil
+}
+
+// recordAcknowledged advances the offset the master confirmed
+func (m *Manager) recordAcknowledged(transfer *Transfer, offset int64) {
+	m.mu.Lock()
+	if offset > transfer.Acknowledged {
+		transfer.Acknowledged = offset
+	}
+	m.mu.Unlock()
+}
+
+// transferReply describes an upload session in an operation result
+func transferReply(session *UploadSession) map[string]interface{} {
+	return map[string]interface{}{
+		"transfer_id": session.ID,
+		"status":      session.Status,
+		"size":        session.Size,
+		"offset":      session.Offset,
+	}
+}
+
+// metadataString returns a string value from operation metadata
+func metadataString(metadata map[string]interface{}, key string) string {
+	value, _ := metadata[key].(string)
+	return value
+}
+
+// metadataInt returns an integer value from operation metadata, which may
+// have been decoded from JSON or given as a string
+func metadataInt(metadata map[string]interface{}, key string) (int64, bool) {
+	switch value := metadata[key].(type) {
+	case float64:
+		return int64(value), true
+	case int:
+		return int64(value), true
+	case int64:
+		return value, true
+	case string:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// metadataBool returns a boolean value from operation metadata, which may
+// have been decoded from JSON or given as a string
+func metadataBool(metadata map[string]interface{}, key string) bool {
+	switch value := metadata[key].(type) {
+	case bool:
+		return value
+	case string:
+		b, _ := strconv.ParseBool(value)
+		return b
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package fileops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// masterStub is a master server that keeps the file sent to it. Sends fail
+// once failAfter chunks were received, if it is set.
+type masterStub struct {
+	mu        sync.Mutex
+	received  []byte
+	chunks    []Chunk
+	failAfter int
+	checksum  string // reported instead of the received file's checksum
+}
+
+func (s *masterStub) OpenStream(ctx context.Context, transfer *Transfer) (ChunkStream, error) {
+	return &stubStream{master: s}, nil
+}
+
+type stubStream struct {
+	master       *masterStub
+	acknowledged int64
+}
+
+func (s *stubStream) Send(chunk *Chunk) error {
+	m := s.master
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failAfter > 0 && len(m.chunks) >= m.failAfter {
+		return errors.New("connection lost")
+	}
+	if chunk.Offset != int64(len(m.received)) {
+		return &OffsetError{Offset: int64(len(m.received))}
+	}
+
+	recorded := *chunk
+	recorded.Data = nil
+	m.chunks = append(m.chunks, recorded)
+	m.received = append(m.received, chunk.Data...)
+	s.acknowledged = int64(len(m.received))
+	return nil
+}
+
+func (s *stubStream) Acknowledged() int64 {
+	return s.acknowledged
+}
+
+func (s *stubStream) Close() (*ChunkReceipt, error) {
+	m := s.master
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	checksum := m.checksum
+	if checksum == "" {
+		sum := sha256.Sum256(m.received)
+		checksum = hex.EncodeToString(sum[:])
+	}
+	return &ChunkReceipt{Received: int64(len(m.received)), Checksum: checksum}, nil
+}
+
+// transferFile writes a file of size bytes to data for transfer tests
+func transferFile(t *testing.T, data string, size int) (string, []byte) {
+	t.Helper()
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+	path := filepath.Join(data, "dump.sql")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, content
+}
+
+// waitTransfer waits for a transfer to finish and returns a copy of it
+func waitTransfer(t *testing.T, m *Manager, id string) Transfer {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		transfer, err := m.GetTransfer(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.mu.RLock()
+		snapshot := *transfer
+		m.mu.RUnlock()
+		switch snapshot.Status {
+		case TransferStatusCompleted, TransferStatusFailed, TransferStatusCancelled:
+			return snapshot
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("transfer %s did not finish", id)
+	return Transfer{}
+}
+
+// sendToMaster starts a download operation and returns the transfer ID
+func sendToMaster(t *testing.T, m *Manager, path string, metadata map[string]interface{}) string {
+	t.Helper()
+	result, err := m.ExecuteOperation(context.Background(), &Operation{
+		Type:       OperationTypeDownload,
+		SourcePath: path,
+		Metadata:   metadata,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result["transfer_id"].(string)
+}
+
+func TestTransferToMaster(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	master := &masterStub{}
+	m.SetStreamer(master)
+	path, content := transferFile(t, data, 2*transferChunkSize+100)
+
+	transfer := waitTransfer(t, m, sendToMaster(t, m, path, nil))
+	if transfer.Status != TransferStatusCompleted || transfer.Acknowledged != int64(len(content)) {
+		t.Fatalf("transfer = %s with %d bytes acknowledged: %s", transfer.Status, transfer.Acknowledged, transfer.Error)
+	}
+	if !bytes.Equal(master.received, content) {
+		t.Error("master received different content")
+	}
+
+	if len(master.chunks) != 3 {
+		t.Fatalf("sent %d chunks, want 3", len(master.chunks))
+	}
+	last := master.chunks[2]
+	if !last.Last || last.SHA256 != transfer.Checksum || last.Size != int64(len(content)) {
+		t.Errorf("last chunk = %+v", last)
+	}
+	if master.chunks[0].Last || master.chunks[0].SHA256 != "" {
+		t.Errorf("first chunk = %+v", master.chunks[0])
+	}
+}
+
+func TestTransferToMasterResumes(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	master := &masterStub{failAfter: 1}
+	m.SetStreamer(master)
+	path, content := transferFile(t, data, 2*transferChunkSize+100)
+
+	failed := waitTransfer(t, m, sendToMaster(t, m, path, nil))
+	if failed.Status != TransferStatusFailed || failed.Acknowledged != transferChunkSize {
+		t.Fatalf("interrupted transfer = %s with %d bytes acknowledged", failed.Status, failed.Acknowledged)
+	}
+
+	master.failAfter = 0
+	resumed := waitTransfer(t, m, sendToMaster(t, m, path, map[string]interface{}{"resume": failed.ID}))
+	if resumed.Status != TransferStatusCompleted {
+		t.Fatalf("resumed transfer = %s: %s", resumed.Status, resumed.Error)
+	}
+	if first := master.chunks[1]; first.Offset != transferChunkSize {
+		t.Errorf("resumed transfer started at %d, want %d", first.Offset, transferChunkSize)
+	}
+	// The checksum covers the whole file, not only the part sent last
+	sum := sha256.Sum256(content)
+	if resumed.Checksum != hex.EncodeToString(sum[:]) || !bytes.Equal(master.received, content) {
+		t.Error("resumed transfer did not deliver the file")
+	}
+}
+
+func TestTransferToMasterRejected(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	path, _ := transferFile(t, data, 100)
+
+	if _, err := m.ExecuteOperation(context.Background(), &Operation{Type: OperationTypeDownload, SourcePath: path}); !errors.Is(err, ErrNoStreamer) {
+		t.Errorf("download without a master error = %v, want ErrNoStreamer", err)
+	}
+
+	m.SetStreamer(&masterStub{checksum: sha256Hex("something else")})
+	transfer := waitTransfer(t, m, sendToMaster(t, m, path, nil))
+	if transfer.Status != TransferStatusFailed {
+		t.Errorf("transfer with a checksum mismatch = %s", transfer.Status)
+	}
+
+	var offsetErr *OffsetError
+	_, err := m.ExecuteOperation(context.Background(), &Operation{
+		Type:       OperationTypeDownload,
+		SourcePath: path,
+		Metadata:   map[string]interface{}{"offset": "101"},
+	})
+	if !errors.As(err, &offsetErr) {
+		t.Errorf("download from beyond the end error = %v, want an OffsetError", err)
+	}
+}
+
+func TestReceiveChunks(t *testing.T) {
+	m, data := testManager(t, nil)
+	startManager(t, m)
+	dest := filepath.Join(data, "received")
+	content := "chunked content"
+
+	result, err := m.ExecuteOperation(context.Background(), &Operation{
+		Type:     OperationTypeUpload,
+		DestPath: dest,
+		Metadata: map[string]interface{}{"size": float64(len(content))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := result["transfer_id"].(string)
+
+	if _, _, err := m.ReceiveChunk(context.Background(), &Chunk{TransferID: id, Data: []byte(content[:7])}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A master reconnecting asks where to continue
+	result, err = m.ExecuteOperation(context.Background(), &Operation{
+		Type:     OperationTypeUpload,
+		Metadata: map[string]interface{}{"resume": id},
+	})
+	if err != nil || result["offset"] != int64(7) {
+		t.Fatalf("resume = %v, %v, want offset 7", result, err)
+	}
+
+	session, transfer, err := m.ReceiveChunk(context.Background(), &Chunk{
+		TransferID: id,
+		Offset:     7,
+		Data:       []byte(content[7:]),
+		Last:       true,
+		SHA256:     sha256Hex(content),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Offset != int64(len(content)) || transfer == nil || transfer.Status != TransferStatusCompleted {
+		t.Errorf("last chunk = %+v, %+v", session, transfer)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != content {
+		t.Errorf("received file = %q, want %q", got, content)
+	}
+}
+
+func TestMetadataInt(t *testing.T) {
+	metadata := map[string]interface{}{"json": 12.0, "int": 3, "string": "42", "bad": "x"}
+	for key, want := range map[string]int64{"json": 12, "int": 3, "string": 42} {
+		if got, ok := metadataInt(metadata, key); !ok || got != want {
+			t.Errorf("metadataInt(%q) = %d, %v, want %d", key, got, ok, want)
+		}
+	}
+	for _, key := range []string{"bad", "missing"} {
+		if _, ok := metadataInt(metadata, key); ok {
+			t.Errorf("metadataInt(%q) succeeded", key)
+		}
+	}
+}
@@ -0,0 +1,581 @@
+package fileops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrTrashNotFound is returned for unknown trash entries
+var ErrTrashNotFound = errors.New("trash entry not found")
+
+const (
+	// trashData is the name the deleted entry is kept under in its entry
+	// directory
+	trashData = "data"
+	// trashInfo is the name of an entry's metadata file
+	trashInfo = "info.json"
+	// trashRegistry lists the trash directories in use, below the data
+	// directory
+	trashRegistry = "trash-dirs.json"
+)
+
+// TrashEntry describes a deleted file or directory kept in the trash
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // where the entry was deleted from
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Size      int64     `json:"size"`
+	IsDir     bool      `json:"is_dir"`
+	TrashDir  string    `json:"trash_dir"`
+}
+
+// dir returns the directory holding the entry and its metadata
+func (e *TrashEntry) dir() string {
+	return filepath.Join(e.TrashDir, e.ID)
+}
+
+// Delete removes a file or directory. With the trash enabled it is moved
+// into the trash of its filesystem and the trash entry is returned, unless
+// permanent is set or the path is inside a trash directory already.
+func (m *Manager) Delete(path, actor string, permanent bool) (*TrashEntry, error) {
+	resolved, err := m.ResolvePath(AccessDelete, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %w", err)
+	}
+
+	return m.delete(resolved, info, actor, permanent)
+}
+
+// delete removes a resolved path or moves it to the trash
+func (m *Manager) delete(path string, info os.FileInfo, actor string, permanent bool) (*TrashEntry, error) {
+	if !m.config.Trash.Enabled || permanent || m.inTrash(path) {
+		if err := os.RemoveAll(path); err != nil {
+			return nil, fmt.Errorf("failed to delete: %w", err)
+		}
+		return nil, nil
+	}
+
+	trashDir, err := m.trashDirFor(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.ensureTrashDir(trashDir); err != nil {
+		return nil, err
+	}
+
+	entry := &TrashEntry{
+		ID:        uuid.New().String(),
+		Path:      path,
+		DeletedBy: actor,
+		DeletedAt: time.Now().UTC(),
+		IsDir:     info.IsDir(),
+		TrashDir:  trashDir,
+	}
+	if err := os.Mkdir(entry.dir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create trash entry: %w", err)
+	}
+
+	data := filepath.Join(entry.dir(), trashData)
+	if err := os.Rename(path, data); err != nil {
+		os.Remove(entry.dir())
+		return nil, fmt.Errorf("failed to move to trash: %w", err)
+	}
+	entry.Size = treeSize(data)
+
+	if err := writeTrashInfo(entry); err != nil {
+		// Without metadata the entry could never be restored
+		if restoreErr := os.Rename(data, path); restoreErr != nil {
+			m.logger.WithError(restoreErr).WithField("path", path).Error("Failed to move entry back from trash")
+		}
+		os.RemoveAll(entry.dir())
+		return nil, err
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"path":       path,
+		"trash_id":   entry.ID,
+		"deleted_by": actor,
+		"size":       entry.Size,
+	}).Info("Moved to trash")
+
+	return entry, nil
+}
+
+// ListTrash returns the entries of every trash directory, most recently
+// deleted first
+func (m *Manager) ListTrash() ([]*TrashEntry, error) {
+	entries := make([]*TrashEntry, 0)
+	for _, dir := range m.trashDirList() {
+		items, err := os.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				m.logger.WithError(err).WithField("trash_dir", dir).Warn("Failed to read trash directory")
+			}
+			continue
+		}
+
+		for _, item := range items {
+			if !item.IsDir() {
+				continue
+			}
+			entry, err := readTrashInfo(dir, item.Name())
+			if err != nil {
+				m.logger.WithError(err).WithField("trash_dir", dir).Debug("Skipping invalid trash entry")
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// GetTrash returns a trash entry by ID
+func (m *Manager) GetTrash(id string) (*TrashEntry, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTrashNotFound, id)
+	}
+
+	for _, dir := range m.trashDirList() {
+		entry, err := readTrashInfo(dir, id)
+		if err == nil {
+			return entry, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTrashNotFound, id)
+}
+
+// RestoreTrash moves a trash entry back to where it was deleted from, or
+// to dest if given. The destination must not exist and must be on the
+// trash directory's filesystem; missing parent directories are created.
+func (m *Manager) RestoreTrash(id, dest string) (*TrashEntry, string, error) {
+	entry, err := m.GetTrash(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if dest == "" {
+		dest = entry.Path
+	}
+	target, err := m.ResolvePath(AccessWrite, dest)
+	if err != nil {
+		return nil, "", err
+	}
+	if m.inTrash(target) {
+		return nil, "", fmt.Errorf("%w: cannot restore into a trash directory", ErrInvalidPath)
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrFileExists, dest)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	if err := os.Rename(filepath.Join(entry.dir(), trashData), target); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return nil, "", fmt.Errorf("%w: %s is not on the filesystem of %s", ErrInvalidPath, dest, entry.TrashDir)
+		}
+		return nil, "", fmt.Errorf("failed to restore from trash: %w", err)
+	}
+	if err := os.RemoveAll(entry.dir()); err != nil {
+		m.logger.WithError(err).WithField("trash_id", id).Warn("Failed to remove restored trash entry")
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"trash_id": id,
+		"path":     target,
+	}).Info("Restored from trash")
+
+	return entry, target, nil
+}
+
+// PurgeTrash permanently removes a trash entry. The delete access policy
+// of the path it was deleted from applies.
+func (m *Manager) PurgeTrash(id string) (*TrashEntry, error) {
+	entry, err := m.GetTrash(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.ResolvePath(AccessDelete, entry.Path); err != nil {
+		return nil, err
+	}
+
+	if err := m.purgeTrashEntry(entry, "purged"); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// EmptyTrash permanently removes every trash entry the delete access policy
+// allows and returns the entries removed
+func (m *Manager) EmptyTrash() ([]*TrashEntry, error) {
+	entries, err := m.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	purged := make([]*TrashEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !m.policy.Allows(AccessDelete, entry.Path) {
+			continue
+		}
+		if err := m.purgeTrashEntry(entry, "purged"); err != nil {
+			return purged, err
+		}
+		purged = append(purged, entry)
+	}
+	return purged, nil
+}
+
+// expireTrash purges trash entries older than the configured age, then the
+// oldest entries until the trash fits the configured size
+func (m *Manager) expireTrash() {
+	entries, err := m.ListTrash()
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	var total int64
+	kept := entries[:0]
+	for _, entry := range entries {
+		if m.config.Trash.MaxAge > 0 && time.Since(entry.DeletedAt) > m.config.Trash.MaxAge {
+			if err := m.purgeTrashEntry(entry, "expired"); err != nil {
+				m.logger.WithError(err).WithField("trash_id", entry.ID).Error("Failed to purge trash entry")
+			}
+			continue
+		}
+		kept = append(kept, entry)
+		total += entry.Size
+	}
+
+	// Entries are sorted newest first
+	for i := len(kept) - 1; i >= 0 && m.config.Trash.MaxSize > 0 && total > m.config.Trash.MaxSize; i-- {
+		if err := m.purgeTrashEntry(kept[i], "trash full"); err != nil {
+			m.logger.WithError(err).WithField("trash_id", kept[i].ID).Error("Failed to purge trash entry")
+			continue
+		}
+		total -= kept[i].Size
+	}
+}
+
+// purgeTrashEntry removes a trash entry and its data
+func (m *Manager) purgeTrashEntry(entry *TrashEntry, reason string) error {
+	if err := os.RemoveAll(entry.dir()); err != nil {
+		return fmt.Errorf("failed to purge trash entry: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"trash_id": entry.ID,
+		"path":     entry.Path,
+		"size":     entry.Size,
+		"reason":   reason,
+	}).Info("Purged trash entry")
+	return nil
+}
+
+// handleTrashList handles the trash_list operation
+func (m *Manager) handleTrashList(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	entries, err := m.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+
+	return map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+		"size":    size,
+	}, nil
+}
+
+// handleTrashRestore handles the trash_restore operation
+func (m *Manager) handleTrashRestore(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	id := metadataString(op.Metadata, "id")
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+
+	entry, target, err := m.RestoreTrash(id, op.DestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":          entry.ID,
+		"path":        entry.Path,
+		"restored_to": target,
+	}, nil
+}
+
+// handleTrashPurge handles the trash_purge operation, for one entry or
+// with metadata all for every entry
+func (m *Manager) handleTrashPurge(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	if metadataBool(op.Metadata, "all") {
+		purged, err := m.EmptyTrash()
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"purged": len(purged),
+		}, nil
+	}
+
+	id := metadataString(op.Metadata, "id")
+	if id == "" {
+		return nil, fmt.Errorf("id or all is required")
+	}
+	entry, err := m.PurgeTrash(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"id":     entry.ID,
+		"path":   entry.Path,
+		"purged": 1,
+	}, nil
+}
+
+// inTrash reports whether a path is inside a trash directory
+func (m *Manager) inTrash(path string) bool {
+	for _, part := range splitPath(path) {
+		if part == m.config.Trash.Dir {
+			return true
+		}
+	}
+	return false
+}
+
+// trashDirFor returns the trash directory for a path: the one at the top of
+// the path's filesystem within the delete root holding it, so moving to
+// the trash is a rename
+func (m *Manager) trashDirFor(path string) (string, error) {
+	root := m.policy.rootFor(AccessDelete, path)
+	parent := filepath.Dir(path)
+	if root == "" || !within(parent, root) {
+		return "", fmt.Errorf("%w: cannot move %s to the trash", ErrInvalidPath, path)
+	}
+
+	device, err := deviceOf(parent)
+	if err != nil {
+		return "", err
+	}
+
+	dir := parent
+	for dir != root {
+		up := filepath.Dir(dir)
+		if upDevice, err := deviceOf(up); err != nil || upDevice != device {
+			break
+		}
+		dir = up
+	}
+
+	return filepath.Join(dir, m.config.Trash.Dir), nil
+}
+
+// ensureTrashDir creates a trash directory and records it. An existing
+// trash directory must be a real directory, not a link leading elsewhere.
+func (m *Manager) ensureTrashDir(dir string) error {
+	if err := makeDir(dir, "trash"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.trashDirs[dir] {
+		return nil
+	}
+	m.trashDirs[dir] = true
+	return m.saveTrashDirsLocked()
+}
+
+// makeDir creates a private directory the agent keeps files in. An existing
+// one must be a real directory, not a link leading elsewhere.
+func makeDir(dir, kind string) error {
+	err := os.Mkdir(dir, 0700)
+	if err == nil || !os.IsExist(err) {
+		if err != nil {
+			return fmt.Errorf("failed to create %s directory: %w", kind, err)
+		}
+		return nil
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s directory: %w", kind, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s directory %s is not a directory", ErrInvalidPath, kind, dir)
+	}
+	return nil
+}
+
+// trashDirList returns the known trash directories
+func (m *Manager) trashDirList() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dirs := make([]string, 0, len(m.trashDirs))
+	for dir := range m.trashDirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// loadTrashDirs reads the trash directories recorded by earlier runs
+func (m *Manager) loadTrashDirs() {
+	data, err := os.ReadFile(filepath.Join(m.config.DataDir, trashRegistry))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.logger.WithError(err).Error("Failed to read trash directories")
+		}
+		return
+	}
+
+	var dirs []string
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		m.logger.WithError(err).Error("Failed to decode trash directories")
+		return
+	}
+
+	m.mu.Lock()
+	for _, dir := range dirs {
+		m.trashDirs[dir] = true
+	}
+	m.mu.Unlock()
+}
+
+// saveTrashDirsLocked records the trash directories. Must be called with
+// m.mu held.
+func (m *Manager) saveTrashDirsLocked() error {
+	dirs := make([]string, 0, len(m.trashDirs))
+	for dir := range m.trashDirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	data, err := json.Marshal(dirs)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(m.config.DataDir, trashRegistry), data); err != nil {
+		return fmt.Errorf("failed to save trash directories: %w", err)
+	}
+	return nil
+}
+
+// readTrashInfo reads the metadata of the trash entry id in dir
+func readTrashInfo(dir, id string) (*TrashEntry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, id, trashInfo))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("invalid trash metadata: %w", err)
+	}
+	if entry.ID != id {
+		return nil, fmt.Errorf("invalid trash metadata: id %q in %s", entry.ID, id)
+	}
+	// The entry may have been moved along with its trash directory
+	entry.TrashDir = dir
+	return &entry, nil
+}
+
+// writeTrashInfo writes the metadata of a trash entry
+func writeTrashInfo(entry *TrashEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(entry.dir(), trashInfo), data); err != nil {
+		return fmt.Errorf("failed to write trash metadata: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file with data through a synced temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// treeSize returns the total size of the regular files at or below path
+func treeSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// deviceOf returns the ID of the device holding path
+func deviceOf(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, nil
+	}
+	return uint64(stat.Dev), nil
+}
+
+// trashName reports whether name is a trash directory's name, which
+// cleanup of the temp directory must not descend into
+func (m *Manager) trashName(name string) bool {
+	return name == m.config.Trash.Dir
+}
@@ -0,0 +1,227 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// trashManager returns a started manager moving deletes to the trash
+func trashManager(t *testing.T, configure func(*config.StorageConfig)) (*Manager, string) {
+	t.Helper()
+	m, data := testManager(t, func(cfg *config.StorageConfig) {
+		cfg.Trash = config.TrashConfig{Enabled: true, Dir: ".ducla-trash"}
+		if configure != nil {
+			configure(cfg)
+		}
+	})
+	startManager(t, m)
+	return m, data
+}
+
+// writeFiles creates files with their content below dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	m, data := trashManager(t, nil)
+	site := filepath.Join(data, "site")
+	writeFiles(t, site, map[string]string{"index.html": "<html>", "css/main.css": "body {}"})
+
+	entry, err := m.Delete(site, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || !entry.IsDir || entry.Size != 13 || entry.DeletedBy != "alice" || entry.Path != site {
+		t.Fatalf("trash entry = %+v", entry)
+	}
+	if _, err := os.Stat(site); !os.IsNotExist(err) {
+		t.Fatal("deleted directory is still in place")
+	}
+
+	entries, err := m.ListTrash()
+	if err != nil || len(entries) != 1 || entries[0].ID != entry.ID {
+		t.Fatalf("ListTrash() = %v, %v", entries, err)
+	}
+
+	// A new file in its place blocks the restore
+	writeFiles(t, data, map[string]string{"site": "new"})
+	if _, _, err := m.RestoreTrash(entry.ID, ""); !errors.Is(err, ErrFileExists) {
+		t.Errorf("RestoreTrash() over an existing file error = %v, want ErrFileExists", err)
+	}
+	if err := os.Remove(site); err != nil {
+		t.Fatal(err)
+	}
+
+	_, target, err := m.RestoreTrash(entry.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != site {
+		t.Errorf("restored to %s, want %s", target, site)
+	}
+	if content, _ := os.ReadFile(filepath.Join(site, "css/main.css")); string(content) != "body {}" {
+		t.Errorf("restored file = %q", content)
+	}
+	if _, err := m.GetTrash(entry.ID); !errors.Is(err, ErrTrashNotFound) {
+		t.Errorf("GetTrash() of a restored entry error = %v, want ErrTrashNotFound", err)
+	}
+}
+
+func TestTrashRestoreElsewhere(t *testing.T) {
+	m, data := trashManager(t, nil)
+	path := filepath.Join(data, "app.yaml")
+	writeFiles(t, data, map[string]string{"app.yaml": "config"})
+
+	entry, err := m.Delete(path, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.RestoreTrash(entry.ID, filepath.Join(data, ".ducla-trash", "copy")); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("RestoreTrash() into the trash error = %v, want ErrInvalidPath", err)
+	}
+	if _, _, err := m.RestoreTrash(entry.ID, filepath.Join(filepath.Dir(data), "outside")); err == nil {
+		t.Error("RestoreTrash() outside the allowed roots succeeded")
+	}
+
+	dest := filepath.Join(data, "restored", "app.yaml")
+	if _, target, err := m.RestoreTrash(entry.ID, dest); err != nil || target != dest {
+		t.Fatalf("RestoreTrash() = %s, %v", target, err)
+	}
+	if content, _ := os.ReadFile(dest); string(content) != "config" {
+		t.Errorf("restored file = %q", content)
+	}
+}
+
+func TestTrashSurvivesRestart(t *testing.T) {
+	m, data := trashManager(t, nil)
+	path := filepath.Join(data, "sub", "file")
+	writeFiles(t, data, map[string]string{"sub/file": "x"})
+
+	entry, err := m.Delete(path, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := New(m.config, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	startManager(t, restarted)
+	if _, _, err := restarted.RestoreTrash(entry.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("file was not restored after a restart")
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	m, data := trashManager(t, nil)
+	writeFiles(t, data, map[string]string{"a": "a", "b.keep": "b", "c": "c"})
+
+	var ids []string
+	for _, name := range []string{"a", "b.keep", "c"} {
+		entry, err := m.Delete(filepath.Join(data, name), "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, entry.ID)
+	}
+
+	if _, err := m.PurgeTrash(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetTrash(ids[0]); !errors.Is(err, ErrTrashNotFound) {
+		t.Errorf("GetTrash() of a purged entry error = %v, want ErrTrashNotFound", err)
+	}
+	if _, err := m.PurgeTrash("not-a-uuid"); !errors.Is(err, ErrTrashNotFound) {
+		t.Errorf("PurgeTrash() of an unknown entry error = %v, want ErrTrashNotFound", err)
+	}
+
+	// Entries the delete policy protects are kept when the trash is emptied
+	cfg := m.config
+	cfg.Access.Delete.Deny = []string{"*.keep"}
+	restricted, err := New(cfg, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	startManager(t, restricted)
+
+	if _, err := restricted.PurgeTrash(ids[1]); err == nil {
+		t.Error("PurgeTrash() of a protected entry succeeded")
+	}
+	purged, err := restricted.EmptyTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 || purged[0].ID != ids[2] {
+		t.Errorf("EmptyTrash() purged %v, want only %s", purged, ids[2])
+	}
+	if entries, _ := restricted.ListTrash(); len(entries) != 1 || entries[0].ID != ids[1] {
+		t.Errorf("trash holds %v, want only the protected entry", entries)
+	}
+}
+
+func TestExpireTrash(t *testing.T) {
+	m, data := trashManager(t, func(cfg *config.StorageConfig) {
+		cfg.Trash.MaxSize = 25
+	})
+	files := []string{"oldest", "middle", "newest"}
+	for _, name := range files {
+		writeFiles(t, data, map[string]string{name: "ten bytes!"})
+		if _, err := m.Delete(filepath.Join(data, name), "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.expireTrash()
+	entries, err := m.ListTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Path != filepath.Join(data, "newest") || entries[1].Path != filepath.Join(data, "middle") {
+		t.Errorf("trash after expiry = %v, want the two newest entries", entries)
+	}
+
+	m.config.Trash.MaxAge = 1
+	m.expireTrash()
+	if entries, _ := m.ListTrash(); len(entries) != 0 {
+		t.Errorf("trash holds %d entries past their age", len(entries))
+	}
+}
+
+func TestDeletePermanent(t *testing.T) {
+	m, data := trashManager(t, nil)
+	writeFiles(t, data, map[string]string{"a": "a", "b": "b"})
+
+	if entry, err := m.Delete(filepath.Join(data, "a"), "", true); err != nil || entry != nil {
+		t.Errorf("permanent Delete() = %v, %v", entry, err)
+	}
+
+	entry, err := m.Delete(filepath.Join(data, "b"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deleting inside the trash removes for good
+	if moved, err := m.Delete(entry.dir(), "", false); err != nil || moved != nil {
+		t.Errorf("Delete() inside the trash = %v, %v", moved, err)
+	}
+	if entries, _ := m.ListTrash(); len(entries) != 0 {
+		t.Errorf("trash holds %d entries", len(entries))
+	}
+}
@@ -0,0 +1,401 @@
+package fileops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultUploadMode is used for new files when no mode is given
+const defaultUploadMode = 0644
+
+// progressInterval limits how often upload progress is published
+const progressInterval = time.Second
+
+var (
+	// ErrInvalidPath is returned for destination paths that cannot be used
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrFileExists is returned when the destination exists and overwrite is not set
+	ErrFileExists = errors.New("destination already exists")
+	// ErrFileTooLarge is returned when an upload exceeds the maximum file size
+	ErrFileTooLarge = errors.New("file exceeds maximum size")
+	// ErrSizeMismatch is returned when an upload does not have the declared size
+	ErrSizeMismatch = errors.New("size mismatch")
+)
+
+// ChecksumError is returned when the received data does not match the
+// checksum supplied by the client
+type ChecksumError struct {
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// UploadOptions describes where and how an uploaded file is stored
+type UploadOptions struct {
+	DestPath  string      `json:"dest_path"`
+	Overwrite bool        `json:"overwrite"`
+	Mode      os.FileMode `json:"mode"`   // defaults to 0644, or the mode of the replaced file
+	UID       int         `json:"uid"`    // -1 keeps the agent's user
+	GID       int         `json:"gid"`    // -1 keeps the agent's group
+	SHA256    string      `json:"sha256"` // expected checksum, verified before the file is committed
+	Size      int64       `json:"size"`   // expected size, 0 if unknown
+}
+
+// NewUploadOptions returns options for an upload to path that keep the
+// agent's ownership
+func NewUploadOptions(path string) UploadOptions {
+	return UploadOptions{
+		DestPath: path,
+		UID:      -1,
+		GID:      -1,
+	}
+}
+
+// Upload streams r into a temporary file in the temp directory, verifies it
+// and atomically moves it to the destination. The destination never holds a
+// partially written file. The returned transfer carries the computed
+// checksum and size.
+func (m *Manager) Upload(ctx context.Context, r io.Reader, opts UploadOptions) (*Transfer, error) {
+	if err := m.prepareUpload(&opts); err != nil {
+		return nil, err
+	}
+	dest := opts.DestPath
+
+	transfer := &Transfer{
+		ID:        uuid.New().String(),
+		Type:      TransferTypeUpload,
+		Status:    TransferStatusRunning,
+		DestPath:  dest,
+		Size:      opts.Size,
+		StartedAt: time.Now(),
+		Metadata:  make(map[string]interface{}),
+	}
+	transfer.ctx, transfer.cancel = context.WithCancel(ctx)
+	defer transfer.cancel()
+
+	m.mu.Lock()
+	m.transfers[transfer.ID] = transfer
+	m.mu.Unlock()
+	m.publishProgress(transfer)
+
+	err := m.receiveUpload(transfer, r, opts)
+	transfer.CompletedAt = time.Now()
+	switch {
+	case err == nil:
+		transfer.Status = TransferStatusCompleted
+		transfer.Size = transfer.Transferred
+		transfer.Progress = 100
+	case transfer.ctx.Err() != nil:
+		transfer.Status = TransferStatusCancelled
+		transfer.Error = err.Error()
+	default:
+		transfer.Status = TransferStatusFailed
+		transfer.Error = err.Error()
+	}
+	m.publishProgress(transfer)
+
+	logger := m.logger.WithFields(map[string]interface{}{
+		"transfer_id": transfer.ID,
+		"dest_path":   dest,
+		"size":        transfer.Transferred,
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Upload failed")
+		return transfer, err
+	}
+	logger.Info("Upload completed")
+
+	return transfer, nil
+}
+
+// prepareUpload checks the destination of an upload against the access
+// policy, resolves it and fills in the default mode
+func (m *Manager) prepareUpload(opts *UploadOptions) error {
+	dest, err := m.ResolvePath(AccessWrite, opts.DestPath)
+	if err != nil {
+		return err
+	}
+	opts.DestPath = dest
+
+	if info, err := os.Stat(opts.DestPath); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%w: %s is a directory", ErrInvalidPath, opts.DestPath)
+		}
+		if !opts.Overwrite {
+			return fmt.Errorf("%w: %s", ErrFileExists, opts.DestPath)
+		}
+		if opts.Mode == 0 {
+			opts.Mode = info.Mode().Perm()
+		}
+	}
+	if opts.Mode == 0 {
+		opts.Mode = defaultUploadMode
+	}
+	return nil
+}
+
+// receiveUpload writes the upload to a temporary file and commits it
+func (m *Manager) receiveUpload(transfer *Transfer, r io.Reader, opts UploadOptions) error {
+	if err := os.MkdirAll(m.config.TempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(m.config.TempDir, "upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op once the file has been committed
+
+	// Read one byte past the limit to detect oversized uploads
+	limit := m.config.MaxFileSize
+	reader := &contextReader{ctx: transfer.ctx, r: r}
+	var src io.Reader = reader
+	if limit > 0 {
+		src = io.LimitReader(reader, limit+1)
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), &progressReader{r: src, transfer: transfer, manager: m})
+	if err == nil && limit > 0 && written > limit {
+		err = fmt.Errorf("%w of %d bytes", ErrFileTooLarge, limit)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to receive upload: %w", err)
+	}
+	if opts.Size > 0 && written != opts.Size {
+		return fmt.Errorf("%w: expected %d bytes, received %d", ErrSizeMismatch, opts.Size, written)
+	}
+
+	transfer.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if opts.SHA256 != "" && !strings.EqualFold(opts.SHA256, transfer.Checksum) {
+		return &ChecksumError{Expected: strings.ToLower(opts.SHA256), Actual: transfer.Checksum}
+	}
+
+	if err := os.Chmod(tmpPath, opts.Mode); err != nil {
+		return fmt.Errorf("failed to set mode: %w", err)
+	}
+	if opts.UID >= 0 || opts.GID >= 0 {
+		if err := os.Chown(tmpPath, opts.UID, opts.GID); err != nil {
+			return fmt.Errorf("failed to set owner: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(transfer.DestPath), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	return m.commitVersioned(tmpPath, transfer.DestPath, opts.Overwrite)
+}
+
+// commitFile atomically moves a complete file to its destination. Without
+// overwrite the file is hard linked into place so an existing destination is
+// never replaced. When the temp directory is on another file system the file
+// is first copied next to the destination.
+func commitFile(src, dest string, overwrite bool) error {
+	err := linkOrRename(src, dest, overwrite)
+	if errors.Is(err, syscall.EXDEV) {
+		var staged string
+		if staged, err = stageBeside(src, dest); err != nil {
+			return err
+		}
+		defer os.Remove(staged)
+		err = linkOrRename(staged, dest, overwrite)
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%w: %s", ErrFileExists, dest)
+		}
+		return fmt.Errorf("failed to commit upload: %w", err)
+	}
+
+	return syncDir(filepath.Dir(dest))
+}
+
+func linkOrRename(src, dest string, overwrite bool) error {
+	if overwrite {
+		return os.Rename(src, dest)
+	}
+	if err := os.Link(src, dest); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// stageBeside copies src to a hidden temporary file in the destination's
+// directory, preserving its mode and ownership
+func stageBeside(src, dest string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			err = out.Chown(int(stat.Uid), int(stat.Gid))
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to stage upload: %w", err)
+	}
+
+	return out.Name(), nil
+}
+
+// syncDir flushes a directory so a rename into it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// ParseOwner parses an owner given as "user", "user:group", "uid" or
+// "uid:gid". Parts that are not given are returned as -1.
+func ParseOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner == "" {
+		return uid, gid, nil
+	}
+
+	name, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+
+	if name != "" {
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			u, lookupErr := user.Lookup(name)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("unknown user %q", name)
+			}
+			id, _ = strconv.Atoi(u.Uid)
+		}
+		uid = id
+	}
+
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(group)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("unknown group %q", group)
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		gid = id
+	}
+
+	return uid, gid, nil
+}
+
+// ParseMode parses an octal file mode such as "0640" or "4755". The setuid,
+// setgid and sticky bits map to their os.FileMode flags.
+func ParseMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value == 0 || value > 07777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+
+	parsed := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		parsed |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		parsed |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		parsed |= os.ModeSticky
+	}
+	return parsed, nil
+}
+
+// contextReader stops reading once its context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// progressReader counts bytes into a transfer and publishes its progress
+// at most once per progressInterval
+type progressReader struct {
+	r         io.Reader
+	transfer  *Transfer
+	manager   *Manager
+	published time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.transfer.Transferred += int64(n)
+	if p.transfer.Size > 0 {
+		p.transfer.Progress = float64(p.transfer.Transferred) / float64(p.transfer.Size) * 100
+		if p.transfer.Progress > 100 {
+			p.transfer.Progress = 100
+		}
+	}
+
+	if now := time.Now(); now.Sub(p.published) >= progressInterval {
+		p.published = now
+		p.manager.publishProgress(p.transfer)
+	}
+	return n, err
+}
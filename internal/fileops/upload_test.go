@@ -0,0 +1,214 @@
+package fileops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// testManager returns a manager allowed to access the returned data
+// directory. configure adjusts the storage config before it is created.
+func testManager(t *testing.T, configure func(*config.StorageConfig)) (*Manager, string) {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data")
+
+	rule := config.AccessRule{Roots: []string{data}}
+	cfg := config.StorageConfig{
+		DataDir:    data,
+		TempDir:    filepath.Join(dir, "tmp"),
+		UploadsDir: filepath.Join(dir, "uploads"),
+		Access:     config.AccessConfig{Read: rule, Write: rule, Delete: rule},
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	manager, err := New(cfg, nil, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, data
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// assertNoTempFiles fails if an upload left files in the temp directory
+func assertNoTempFiles(t *testing.T, m *Manager) {
+	t.Helper()
+	entries, err := os.ReadDir(m.config.TempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("temp file %s was left behind", entry.Name())
+	}
+}
+
+func TestUpload(t *testing.T) {
+	m, data := testManager(t, nil)
+	dest := filepath.Join(data, "conf", "app.yaml")
+
+	opts := NewUploadOptions(dest)
+	opts.SHA256 = strings.ToUpper(sha256Hex("key: value\n"))
+	transfer, err := m.Upload(context.Background(), strings.NewReader("key: value\n"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Status != TransferStatusCompleted || transfer.Size != 11 || transfer.Checksum != sha256Hex("key: value\n") {
+		t.Errorf("transfer = %+v", transfer)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != defaultUploadMode {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(defaultUploadMode))
+	}
+	assertNoTempFiles(t, m)
+}
+
+func TestUploadOverwrite(t *testing.T) {
+	m, data := testManager(t, nil)
+	dest := filepath.Join(data, "app.yaml")
+	if err := os.WriteFile(dest, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := m.Upload(context.Background(), strings.NewReader("new"), NewUploadOptions(dest))
+	if !errors.Is(err, ErrFileExists) {
+		t.Errorf("Upload() without overwrite error = %v, want ErrFileExists", err)
+	}
+
+	opts := NewUploadOptions(dest)
+	opts.Overwrite = true
+	if _, err := m.Upload(context.Background(), strings.NewReader("new"), opts); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(dest)
+	info, _ := os.Stat(dest)
+	if string(content) != "new" || info.Mode().Perm() != 0600 {
+		t.Errorf("replaced file = %q with mode %v, want \"new\" keeping 0600", content, info.Mode().Perm())
+	}
+
+	opts.Mode = 0640
+	if _, err := m.Upload(context.Background(), strings.NewReader("newer"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(dest); info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	m, data := testManager(t, func(cfg *config.StorageConfig) {
+		cfg.MaxFileSize = 8
+	})
+
+	tests := []struct {
+		name    string
+		content string
+		opts    func(*UploadOptions)
+		check   func(error) bool
+	}{
+		{
+			name:    "checksum mismatch",
+			content: "content",
+			opts:    func(o *UploadOptions) { o.SHA256 = sha256Hex("other") },
+			check: func(err error) bool {
+				var checksumErr *ChecksumError
+				return errors.As(err, &checksumErr) && checksumErr.Actual == sha256Hex("content")
+			},
+		},
+		{
+			name:    "size mismatch",
+			content: "content",
+			opts:    func(o *UploadOptions) { o.Size = 3 },
+			check:   func(err error) bool { return errors.Is(err, ErrSizeMismatch) },
+		},
+		{
+			name:    "too large",
+			content: "too much content",
+			check:   func(err error) bool { return errors.Is(err, ErrFileTooLarge) },
+		},
+		{
+			name:    "outside the allowed roots",
+			content: "content",
+			opts:    func(o *UploadOptions) { o.DestPath = filepath.Join(filepath.Dir(data), "outside") },
+			check:   func(err error) bool { return err != nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := NewUploadOptions(filepath.Join(data, "file"))
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+
+			_, err := m.Upload(context.Background(), strings.NewReader(tt.content), opts)
+			if !tt.check(err) {
+				t.Errorf("Upload() error = %v", err)
+			}
+			if _, err := os.Stat(opts.DestPath); !os.IsNotExist(err) {
+				t.Errorf("rejected upload created %s", opts.DestPath)
+			}
+			assertNoTempFiles(t, m)
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := map[string]os.FileMode{
+		"0640": 0640,
+		"755":  0755,
+		"4755": 0755 | os.ModeSetuid,
+		"1777": 0777 | os.ModeSticky,
+	}
+	for input, want := range tests {
+		if got, err := ParseMode(input); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "0", "rw-r--r--", "0999", "17777"} {
+		if _, err := ParseMode(input); err == nil {
+			t.Errorf("ParseMode(%q) succeeded", input)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		owner    string
+		uid, gid int
+	}{
+		{"", -1, -1},
+		{"1000", 1000, -1},
+		{"1000:50", 1000, 50},
+		{":50", -1, 50},
+		{"root:root", 0, 0},
+	}
+	for _, tt := range tests {
+		uid, gid, err := ParseOwner(tt.owner)
+		if err != nil || uid != tt.uid || gid != tt.gid {
+			t.Errorf("ParseOwner(%q) = %d, %d, %v, want %d, %d", tt.owner, uid, gid, err, tt.uid, tt.gid)
+		}
+	}
+	if _, _, err := ParseOwner("no-such-user-here"); err == nil {
+		t.Error("ParseOwner() accepted an unknown user")
+	}
+}
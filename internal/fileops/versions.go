@@ -0,0 +1,318 @@
+package fileops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrVersionNotFound is returned for unknown versions of a file
+var ErrVersionNotFound = errors.New("version not found")
+
+// versionTimeFormat names timestamped versions. It has a fixed width, so
+// the names sort by time.
+const versionTimeFormat = "20060102T150405.000000000Z"
+
+// Version is a previous content of a file, kept when the file was replaced
+type Version struct {
+	ID      string      `json:"id"`
+	Path    string      `json:"path"` // the file it is a version of
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"` // when this content was last modified
+}
+
+// ListVersions returns the kept versions of a file, newest first
+func (m *Manager) ListVersions(path string) ([]*Version, error) {
+	resolved, err := m.ResolvePath(AccessRead, path)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := m.versions(resolved)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+	return versions, nil
+}
+
+// RestoreVersion replaces a file with one of its versions, or writes the
+// version to dest if given. The content being replaced is kept as a new
+// version. A dest other than the file itself is only replaced with
+// overwrite set.
+func (m *Manager) RestoreVersion(path, id, dest string, overwrite bool) (*Version, string, error) {
+	resolved, err := m.ResolvePath(AccessRead, path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var version *Version
+	versions, err := m.versions(resolved)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, v := range versions {
+		if v.ID == id {
+			version = v
+		}
+	}
+	if version == nil {
+		return nil, "", fmt.Errorf("%w: %s of %s", ErrVersionNotFound, id, path)
+	}
+
+	if dest == "" {
+		dest = path
+	}
+	target, err := m.ResolvePath(AccessWrite, dest)
+	if err != nil {
+		return nil, "", err
+	}
+	if target == resolved {
+		overwrite = true
+	}
+	if m.inVersions(target) {
+		return nil, "", fmt.Errorf("%w: cannot restore into a versions directory", ErrInvalidPath)
+	}
+	if info, err := os.Lstat(target); err == nil {
+		if info.IsDir() {
+			return nil, "", fmt.Errorf("%w: %s is a directory", ErrInvalidPath, dest)
+		}
+		if !overwrite {
+			return nil, "", fmt.Errorf("%w: %s", ErrFileExists, dest)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	// The version is copied so it stays available
+	if _, err := m.copyFile(m.versionPath(resolved, id), target, overwrite); err != nil {
+		return nil, "", fmt.Errorf("failed to restore version: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"path":    resolved,
+		"version": id,
+		"dest":    target,
+	}).Info("Restored file version")
+
+	return version, target, nil
+}
+
+// commitVersioned moves a complete file to its destination like commitFile,
+// first keeping the content it replaces as a version
+func (m *Manager) commitVersioned(src, dest string, overwrite bool) error {
+	if overwrite {
+		if err := m.keepVersion(dest); err != nil {
+			return err
+		}
+	}
+	return commitFile(src, dest, overwrite)
+}
+
+// keepVersion keeps the current content of a regular file that is about to
+// be replaced, then drops the oldest versions beyond the retention count.
+// The content is hard linked where possible: replacing the file gives it a
+// new inode, so the link keeps the old content unchanged.
+func (m *Manager) keepVersion(path string) error {
+	if !m.config.Versions.Enabled || m.inVersions(path) {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	dir := m.versionDir(path)
+	if err := makeDir(filepath.Dir(dir), "versions"); err != nil {
+		return err
+	}
+	if err := makeDir(dir, "versions"); err != nil {
+		return err
+	}
+
+	versions, err := m.versions(path)
+	if err != nil {
+		return err
+	}
+
+	var id string
+	for attempt := 0; ; attempt++ {
+		id = m.nextVersionID(versions, attempt)
+		err = os.Link(path, filepath.Join(dir, id))
+		if err == nil || !os.IsExist(err) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil && !os.IsExist(err) {
+		err = copyContent(path, filepath.Join(dir, id), info.Mode())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to keep version of %s: %w", path, err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"path":    path,
+		"version": id,
+	}).Debug("Kept file version")
+
+	m.pruneVersions(path)
+	return nil
+}
+
+// pruneVersions removes the oldest versions of a file beyond the
+// retention count
+func (m *Manager) pruneVersions(path string) {
+	versions, err := m.versions(path)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < len(versions)-m.config.Versions.Keep; i++ {
+		if err := os.Remove(m.versionPath(path, versions[i].ID)); err != nil {
+			m.logger.WithError(err).WithField("path", path).Warn("Failed to remove old file version")
+		}
+	}
+}
+
+// versions returns the kept versions of a resolved path, oldest first
+func (m *Manager) versions(path string) ([]*Version, error) {
+	items, err := os.ReadDir(m.versionDir(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Version{}, nil
+		}
+		return nil, fmt.Errorf("failed to read versions: %w", err)
+	}
+
+	versions := make([]*Version, 0, len(items))
+	for _, item := range items {
+		if !item.Type().IsRegular() {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, &Version{
+			ID:      item.Name(),
+			Path:    path,
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		a, errA := strconv.Atoi(versions[i].ID)
+		b, errB := strconv.Atoi(versions[j].ID)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return versions[i].ID < versions[j].ID
+	})
+	return versions, nil
+}
+
+// nextVersionID names a new version. Numbered versions count up from the
+// highest kept; attempt skips names taken by a concurrent replacement.
+func (m *Manager) nextVersionID(versions []*Version, attempt int) string {
+	if m.config.Versions.Naming == "timestamped" {
+		return time.Now().UTC().Format(versionTimeFormat)
+	}
+
+	highest := 0
+	for _, v := range versions {
+		if n, err := strconv.Atoi(v.ID); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return strconv.Itoa(highest + 1 + attempt)
+}
+
+// versionDir returns the directory holding the versions of a path
+func (m *Manager) versionDir(path string) string {
+	return filepath.Join(filepath.Dir(path), m.config.Versions.Dir, filepath.Base(path))
+}
+
+// versionPath returns the file holding a version of a path
+func (m *Manager) versionPath(path, id string) string {
+	return filepath.Join(m.versionDir(path), filepath.Base(id))
+}
+
+// inVersions reports whether a path is inside a versions directory
+func (m *Manager) inVersions(path string) bool {
+	for _, part := range splitPath(path) {
+		if part == m.config.Versions.Dir {
+			return true
+		}
+	}
+	return false
+}
+
+// handleVersions handles the versions operation
+func (m *Manager) handleVersions(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	versions, err := m.ListVersions(op.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"path":     op.SourcePath,
+		"versions": versions,
+		"count":    len(versions),
+	}, nil
+}
+
+// handleRestoreVersion handles the restore_version operation
+func (m *Manager) handleRestoreVersion(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	id := metadataString(op.Metadata, "version")
+	if id == "" {
+		return nil, fmt.Errorf("version is required")
+	}
+
+	version, target, err := m.RestoreVersion(op.SourcePath, id, op.DestPath, op.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"path":        op.SourcePath,
+		"version":     version.ID,
+		"restored_to": target,
+	}, nil
+}
+
+// copyContent copies a file's content to a new file with the given mode
+func copyContent(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
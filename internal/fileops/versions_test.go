@@ -0,0 +1,200 @@
+package fileops
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// versionManager returns a manager keeping versions of replaced files
+func versionManager(t *testing.T, naming string, keep int) (*Manager, string) {
+	t.Helper()
+	return testManager(t, func(cfg *config.StorageConfig) {
+		cfg.Versions = config.VersionConfig{Enabled: true, Dir: ".ducla-versions", Naming: naming, Keep: keep}
+	})
+}
+
+// versionContents returns the IDs and contents of a file's versions,
+// newest first
+func versionContents(t *testing.T, m *Manager, path string) ([]string, []string) {
+	t.Helper()
+	versions, err := m.ListVersions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids, contents []string
+	for _, v := range versions {
+		content, err := os.ReadFile(m.versionPath(v.Path, v.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, v.ID)
+		contents = append(contents, string(content))
+	}
+	return ids, contents
+}
+
+// copyOver copies a file with content over path
+func copyOver(t *testing.T, m *Manager, path, content string, overwrite bool) error {
+	t.Helper()
+	src := filepath.Join(filepath.Dir(path), "incoming")
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := m.ExecuteOperation(context.Background(), &Operation{
+		Type:       OperationTypeCopy,
+		SourcePath: src,
+		DestPath:   path,
+		Overwrite:  overwrite,
+	})
+	return err
+}
+
+func TestReplaceKeepsVersions(t *testing.T) {
+	m, data := versionManager(t, "numbered", 2)
+	path := filepath.Join(data, "app.yaml")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyOver(t, m, path, "v2", false); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("copy without overwrite error = %v, want ErrFileExists", err)
+	}
+	if ids, _ := versionContents(t, m, path); len(ids) != 0 {
+		t.Errorf("refused copy kept versions %v", ids)
+	}
+
+	if err := copyOver(t, m, path, "v2", true); err != nil {
+		t.Fatal(err)
+	}
+	opts := NewUploadOptions(path)
+	opts.Overwrite = true
+	if _, err := m.Upload(context.Background(), strings.NewReader("v3"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyOver(t, m, path, "v4", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the two newest versions are kept
+	ids, contents := versionContents(t, m, path)
+	if strings.Join(ids, ",") != "3,2" || strings.Join(contents, ",") != "v3,v2" {
+		t.Errorf("versions = %v with %v, want 3,2 with v3,v2", ids, contents)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "v4" {
+		t.Errorf("file = %q, want v4", content)
+	}
+}
+
+func TestMoveOverwriteKeepsVersion(t *testing.T) {
+	m, data := versionManager(t, "numbered", 5)
+	path := filepath.Join(data, "app.yaml")
+	src := filepath.Join(data, "new.yaml")
+	for name, content := range map[string]string{path: "old", src: "new"} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	move := &Operation{Type: OperationTypeMove, SourcePath: src, DestPath: path}
+	if _, err := m.ExecuteOperation(context.Background(), move); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("move without overwrite error = %v, want ErrFileExists", err)
+	}
+	move.Overwrite = true
+	if _, err := m.ExecuteOperation(context.Background(), move); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, contents := versionContents(t, m, path); len(contents) != 1 || contents[0] != "old" {
+		t.Errorf("versions = %v, want the old content", contents)
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	m, data := versionManager(t, "numbered", 5)
+	path := filepath.Join(data, "app.yaml")
+	if err := os.WriteFile(path, []byte("good"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyOver(t, m, path, "broken", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restoring keeps the content it replaces, and the version itself
+	if _, target, err := m.RestoreVersion(path, "1", "", false); err != nil || target != path {
+		t.Fatalf("RestoreVersion() = %s, %v", target, err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "good" {
+		t.Errorf("file = %q, want the restored content", content)
+	}
+	if _, contents := versionContents(t, m, path); strings.Join(contents, ",") != "broken,good" {
+		t.Errorf("versions = %v, want broken,good", contents)
+	}
+
+	other := filepath.Join(data, "other.yaml")
+	if err := os.WriteFile(other, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.RestoreVersion(path, "1", other, false); !errors.Is(err, ErrFileExists) {
+		t.Errorf("RestoreVersion() over another file error = %v, want ErrFileExists", err)
+	}
+	if _, _, err := m.RestoreVersion(path, "1", other, true); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(other); string(content) != "good" {
+		t.Errorf("restored copy = %q, want good", content)
+	}
+
+	if _, _, err := m.RestoreVersion(path, "9", "", false); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("RestoreVersion() of an unknown version error = %v, want ErrVersionNotFound", err)
+	}
+	into := filepath.Join(data, ".ducla-versions", "copy")
+	if _, _, err := m.RestoreVersion(path, "1", into, false); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("RestoreVersion() into the versions directory error = %v, want ErrInvalidPath", err)
+	}
+}
+
+func TestTimestampedVersions(t *testing.T) {
+	m, data := versionManager(t, "timestamped", 5)
+	path := filepath.Join(data, "app.yaml")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"v2", "v3"} {
+		if err := copyOver(t, m, path, content, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, contents := versionContents(t, m, path)
+	if strings.Join(contents, ",") != "v2,v1" {
+		t.Fatalf("versions = %v, want v2,v1", contents)
+	}
+	for _, id := range ids {
+		if _, err := time.Parse(versionTimeFormat, id); err != nil {
+			t.Errorf("version %q is not a timestamp: %v", id, err)
+		}
+	}
+}
+
+func TestVersionsDisabled(t *testing.T) {
+	m, data := testManager(t, func(cfg *config.StorageConfig) {
+		cfg.Versions = config.VersionConfig{Dir: ".ducla-versions", Keep: 5}
+	})
+	path := filepath.Join(data, "app.yaml")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyOver(t, m, path, "v2", true); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := versionContents(t, m, path); len(ids) != 0 {
+		t.Errorf("versions %v kept while disabled", ids)
+	}
+}
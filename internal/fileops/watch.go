@@ -0,0 +1,572 @@
+package fileops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// WatchOp is the kind of change a watch reports
+type WatchOp string
+
+const (
+	WatchOpCreate   WatchOp = "create"
+	WatchOpModify   WatchOp = "modify"
+	WatchOpDelete   WatchOp = "delete"
+	WatchOpRename   WatchOp = "rename"
+	WatchOpOverflow WatchOp = "overflow" // changes were lost, rescan the path
+	WatchOpClosed   WatchOp = "closed"   // the watch ended, no events follow
+)
+
+var (
+	// ErrWatchNotFound is returned for unknown watch IDs
+	ErrWatchNotFound = errors.New("watch not found")
+	// ErrWatchLimit is returned when a watch would exceed the configured limits
+	ErrWatchLimit = errors.New("watch limit reached")
+	// ErrWatchUnsupported is returned where the platform cannot watch files
+	ErrWatchUnsupported = errors.New("file watches are not supported on this platform")
+)
+
+const (
+	// maxPendingChanges bounds the changes a watch holds back while
+	// debouncing; beyond it they are reported straight away
+	maxPendingChanges = 4096
+	// maxDebounceDelay caps, in debounce periods, how long a path that keeps
+	// changing is held back
+	maxDebounceDelay = 10
+)
+
+// WatchOptions selects the changes a watch reports
+type WatchOptions struct {
+	Recursive bool
+	Filters   Filters
+}
+
+// Watch reports changes to a file, or to the entries of a directory, as
+// FileChanged events. Changes to the same path are debounced: a path is
+// reported once it has been quiet for the configured period, with the net
+// effect of everything that happened to it meanwhile.
+type Watch struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	Recursive   bool      `json:"recursive"`
+	Filters               // selects entries by path relative to Path
+	CreatedAt   time.Time `json:"created_at"`
+	Directories int       `json:"directories"` // directories holding a kernel watch
+	Events      int64     `json:"events"`      // changes reported
+
+	manager *Manager
+	root    string // resolved directory the kernel watch starts at
+	file    string // base name when a single file is watched
+	backend watchBackend
+	stopped bool // guarded by manager.mu
+
+	mu        sync.Mutex
+	pending   map[string]*pendingChange // by resolved path, nil once stopped
+	timer     *time.Timer
+	scheduled bool
+}
+
+// watchBackend is the platform's kernel watch behind a Watch
+type watchBackend interface {
+	run() // reports changes until closed
+	close() error
+}
+
+// pendingChange is a change held back until its path is quiet
+type pendingChange struct {
+	op      WatchOp
+	oldPath string
+	isDir   bool
+	first   time.Time
+	last    time.Time
+}
+
+// AddWatch starts watching path. A directory reports changes to its entries,
+// and with opts.Recursive to everything below it; a file reports changes to
+// itself, including being replaced by a rename.
+func (m *Manager) AddWatch(path string, opts WatchOptions) (*Watch, error) {
+	if err := opts.Filters.Validate(); err != nil {
+		return nil, err
+	}
+
+	resolved, err := m.ResolvePath(AccessRead, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat path: %w", err)
+	}
+
+	w := &Watch{
+		ID:        uuid.New().String(),
+		Path:      filepath.Clean(path),
+		Recursive: opts.Recursive && info.IsDir(),
+		Filters:   opts.Filters,
+		CreatedAt: time.Now(),
+		manager:   m,
+		root:      resolved,
+		pending:   make(map[string]*pendingChange),
+	}
+	if !info.IsDir() {
+		// Watch the parent so replacing the file by a rename is seen
+		w.root, w.file = filepath.Dir(resolved), filepath.Base(resolved)
+	}
+
+	if err := m.checkWatchCount(); err != nil {
+		return nil, err
+	}
+
+	backend, err := newWatchBackend(w)
+	if err != nil {
+		m.releaseWatch(w)
+		return nil, err
+	}
+	w.backend = backend
+	w.timer = time.AfterFunc(time.Hour, w.flush)
+	w.timer.Stop()
+
+	m.mu.Lock()
+	if len(m.watches) >= m.config.Watch.MaxWatches {
+		m.mu.Unlock()
+		backend.close()
+		m.releaseWatch(w)
+		return nil, fmt.Errorf("%w: %d watches active", ErrWatchLimit, m.config.Watch.MaxWatches)
+	}
+	m.watches[w.ID] = w
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		backend.run()
+	}()
+
+	m.logger.WithFields(logrus.Fields{
+		"watch_id":    w.ID,
+		"path":        w.Path,
+		"recursive":   w.Recursive,
+		"directories": w.Directories,
+	}).Info("File watch started")
+
+	return w.snapshot(), nil
+}
+
+// RemoveWatch stops a watch
+func (m *Manager) RemoveWatch(id string) error {
+	m.mu.RLock()
+	w, ok := m.watches[id]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWatchNotFound, id)
+	}
+
+	w.stop("watch removed")
+	return nil
+}
+
+// GetWatch returns a watch by ID
+func (m *Manager) GetWatch(id string) (*Watch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	w, ok := m.watches[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWatchNotFound, id)
+	}
+	return w.snapshotLocked(), nil
+}
+
+// ListWatches returns the active watches, oldest first
+func (m *Manager) ListWatches() []*Watch {
+	m.mu.RLock()
+	watches := make([]*Watch, 0, len(m.watches))
+	for _, w := range m.watches {
+		watches = append(watches, w.snapshotLocked())
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(watches, func(i, j int) bool {
+		return watches[i].CreatedAt.Before(watches[j].CreatedAt)
+	})
+	return watches
+}
+
+// stopWatches stops every watch when the manager stops
+func (m *Manager) stopWatches() {
+	m.mu.RLock()
+	watches := make([]*Watch, 0, len(m.watches))
+	for _, w := range m.watches {
+		watches = append(watches, w)
+	}
+	m.mu.RUnlock()
+
+	for _, w := range watches {
+		w.stop("agent stopping")
+	}
+}
+
+// checkWatchCount fails if no further watch may be added
+func (m *Manager) checkWatchCount() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.watches) >= m.config.Watch.MaxWatches {
+		return fmt.Errorf("%w: %d watches active", ErrWatchLimit, m.config.Watch.MaxWatches)
+	}
+	return nil
+}
+
+// acquireWatchDir reserves one of the directories the limits allow a watch
+// to hold a kernel watch on
+func (m *Manager) acquireWatchDir(w *Watch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w.stopped {
+		return fmt.Errorf("watch %s stopped", w.ID)
+	}
+	if m.watchDirs >= m.config.Watch.MaxDirectories {
+		return fmt.Errorf("%w: %d directories watched", ErrWatchLimit, m.config.Watch.MaxDirectories)
+	}
+	m.watchDirs++
+	w.Directories++
+	return nil
+}
+
+// releaseWatchDir returns a directory reserved by acquireWatchDir
+func (m *Manager) releaseWatchDir(w *Watch) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !w.stopped {
+		m.watchDirs--
+		w.Directories--
+	}
+}
+
+// releaseWatch removes a watch and the directories it holds, and reports
+// whether it was still active
+func (m *Manager) releaseWatch(w *Watch) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w.stopped {
+		return false
+	}
+	w.stopped = true
+	m.watchDirs -= w.Directories
+	delete(m.watches, w.ID)
+	return true
+}
+
+// handleWatch handles the watch operation
+func (m *Manager) handleWatch(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	w, err := m.AddWatch(op.SourcePath, WatchOptions{
+		Recursive: op.Recursive,
+		Filters: Filters{
+			Include: metadataStrings(op.Metadata, "include"),
+			Exclude: metadataStrings(op.Metadata, "exclude"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"watch_id":    w.ID,
+		"path":        w.Path,
+		"recursive":   w.Recursive,
+		"directories": w.Directories,
+	}, nil
+}
+
+// handleUnwatch handles the unwatch operation
+func (m *Manager) handleUnwatch(ctx context.Context, op *Operation) (map[string]interface{}, error) {
+	id := metadataString(op.Metadata, "watch_id")
+	if id == "" {
+		return nil, fmt.Errorf("watch_id is required")
+	}
+	if err := m.RemoveWatch(id); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"watch_id": id,
+	}, nil
+}
+
+// snapshot returns a copy of the watch's exported state
+func (w *Watch) snapshot() *Watch {
+	w.manager.mu.RLock()
+	defer w.manager.mu.RUnlock()
+	return w.snapshotLocked()
+}
+
+// snapshotLocked is snapshot with the manager's lock held
+func (w *Watch) snapshotLocked() *Watch {
+	return &Watch{
+		ID:          w.ID,
+		Path:        w.Path,
+		Recursive:   w.Recursive,
+		Filters:     w.Filters,
+		CreatedAt:   w.CreatedAt,
+		Directories: w.Directories,
+		Events:      atomic.LoadInt64(&w.Events),
+	}
+}
+
+// stop ends the watch, discarding changes not yet reported
+func (w *Watch) stop(reason string) {
+	if !w.manager.releaseWatch(w) {
+		return
+	}
+
+	w.mu.Lock()
+	w.pending = nil
+	w.timer.Stop()
+	w.mu.Unlock()
+
+	if err := w.backend.close(); err != nil {
+		w.manager.logger.WithError(err).WithField("watch_id", w.ID).Warn("Failed to close file watch")
+	}
+
+	w.publish(WatchOpClosed, "", "", false, reason)
+	w.manager.logger.WithFields(logrus.Fields{
+		"watch_id": w.ID,
+		"path":     w.Path,
+		"reason":   reason,
+	}).Info("File watch stopped")
+}
+
+// rootGone ends the watch once the watched directory has been removed or
+// moved, reporting what is pending first
+func (w *Watch) rootGone(reason string) {
+	w.mu.Lock()
+	if w.pending == nil {
+		w.mu.Unlock()
+		return
+	}
+	w.flushLocked(true)
+	if w.file == "" {
+		w.publish(WatchOpDelete, w.root, "", true, "")
+	}
+	w.mu.Unlock()
+
+	w.stop(reason)
+}
+
+// selects reports whether a change to path is reported
+func (w *Watch) selects(path string) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+	if w.file != "" {
+		return rel == w.file
+	}
+	return w.Filters.Selects(filepath.ToSlash(rel), false) && w.manager.policy.Allows(AccessRead, path)
+}
+
+// descends reports whether the directory at path is watched by a
+// recursive watch
+func (w *Watch) descends(path string) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return w.Filters.Selects(filepath.ToSlash(rel), true) && w.manager.policy.Allows(AccessRead, path)
+}
+
+// report records a change seen by the backend if the watch selects it. A
+// rename with only one side selected is a delete or a create.
+func (w *Watch) report(op WatchOp, path, oldPath string, isDir bool) {
+	selected := w.selects(path)
+	if op == WatchOpRename {
+		from := w.selects(oldPath)
+		switch {
+		case !from && !selected:
+			return
+		case !from:
+			op, oldPath = WatchOpCreate, ""
+		case !selected:
+			op, path, oldPath = WatchOpDelete, oldPath, ""
+		}
+	} else if !selected {
+		return
+	}
+
+	w.record(op, path, oldPath, isDir)
+}
+
+// overflow reports that changes were lost
+func (w *Watch) overflow(message string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending != nil {
+		w.publish(WatchOpOverflow, "", "", false, message)
+	}
+}
+
+// record merges a change into the pending change of its path
+func (w *Watch) record(op WatchOp, path, oldPath string, isDir bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending == nil {
+		return
+	}
+
+	p := w.pending[path]
+	switch op {
+	case WatchOpCreate:
+		if p != nil && p.op == WatchOpDelete {
+			op = WatchOpModify // replaced
+		}
+	case WatchOpModify:
+		if p != nil {
+			op, oldPath = p.op, p.oldPath
+		}
+	case WatchOpDelete:
+		if p != nil {
+			switch p.op {
+			case WatchOpCreate:
+				delete(w.pending, path)
+				return
+			case WatchOpRename:
+				delete(w.pending, path)
+				path, p = p.oldPath, nil
+			}
+		}
+	case WatchOpRename:
+		if from := w.pending[oldPath]; from != nil {
+			delete(w.pending, oldPath)
+			switch from.op {
+			case WatchOpCreate:
+				op, oldPath = WatchOpCreate, ""
+			case WatchOpRename:
+				oldPath = from.oldPath
+			}
+		}
+		if oldPath == path {
+			op, oldPath = WatchOpModify, ""
+		}
+	}
+
+	if len(w.pending) >= maxPendingChanges {
+		w.flushLocked(true)
+		p = nil
+	}
+
+	now := time.Now()
+	if p == nil {
+		p = &pendingChange{first: now}
+		w.pending[path] = p
+	}
+	p.op, p.oldPath, p.isDir, p.last = op, oldPath, isDir, now
+
+	if !w.scheduled {
+		w.scheduled = true
+		w.timer.Reset(w.manager.config.Watch.Debounce)
+	}
+}
+
+// flush reports the pending changes whose paths have been quiet for the
+// debounce period
+func (w *Watch) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending == nil {
+		return
+	}
+
+	next := w.flushLocked(false)
+	if next > 0 {
+		w.timer.Reset(next)
+	} else {
+		w.scheduled = false
+	}
+}
+
+// flushLocked reports the pending changes that are due, or all of them, in
+// the order they first happened. It returns how long until the next
+// remaining change is due.
+func (w *Watch) flushLocked(all bool) time.Duration {
+	debounce := w.manager.config.Watch.Debounce
+	now := time.Now()
+
+	var due []string
+	var next time.Duration
+	for path, p := range w.pending {
+		at := p.last.Add(debounce)
+		if limit := p.first.Add(maxDebounceDelay * debounce); limit.Before(at) {
+			at = limit
+		}
+		if all || !at.After(now) {
+			due = append(due, path)
+		} else if wait := at.Sub(now); next == 0 || wait < next {
+			next = wait
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		a, b := w.pending[due[i]], w.pending[due[j]]
+		if !a.first.Equal(b.first) {
+			return a.first.Before(b.first)
+		}
+		return due[i] < due[j]
+	})
+	for _, path := range due {
+		p := w.pending[path]
+		delete(w.pending, path)
+		w.publish(p.op, path, p.oldPath, p.isDir, "")
+	}
+
+	return next
+}
+
+// publish publishes a change, translating resolved paths back to the path
+// the watch was created with
+func (w *Watch) publish(op WatchOp, path, oldPath string, isDir bool, message string) {
+	if op != WatchOpClosed && op != WatchOpOverflow {
+		atomic.AddInt64(&w.Events, 1)
+	}
+
+	w.manager.events.Publish(events.FileChanged{
+		WatchID: w.ID,
+		Op:      string(op),
+		Path:    w.external(path),
+		OldPath: w.external(oldPath),
+		IsDir:   isDir,
+		Message: message,
+	})
+}
+
+// external maps a resolved path below the watch's root to the watched path
+func (w *Watch) external(path string) string {
+	if path == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil {
+		return path
+	}
+	if w.file != "" {
+		return filepath.Join(filepath.Dir(w.Path), rel)
+	}
+	return filepath.Join(w.Path, rel)
+}
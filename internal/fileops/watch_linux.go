@@ -0,0 +1,324 @@
+//go:build linux
+// +build linux
+
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the kernel events a watched directory reports
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// errWatchFull stops walking a tree once the directory limit is reached
+var errWatchFull = errors.New("watch full")
+
+// inotifyWatcher watches the directories of one Watch with its own inotify
+// instance. Only run touches the directory maps once it has started.
+type inotifyWatcher struct {
+	watch *Watch
+	file  *os.File
+	conn  syscall.RawConn
+	dirs  map[int]string // directory by watch descriptor
+	wds   map[string]int // watch descriptor by directory
+	full  bool           // the directory limit has been reported
+}
+
+// movedFrom is the first half of a rename
+type movedFrom struct {
+	path  string
+	isDir bool
+}
+
+// newWatchBackend places kernel watches on the directories a watch covers
+func newWatchBackend(w *Watch) (watchBackend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		if err == unix.EMFILE {
+			return nil, fmt.Errorf("%w: no inotify instances left", ErrWatchLimit)
+		}
+		return nil, fmt.Errorf("failed to create inotify instance: %w", err)
+	}
+
+	// A non-blocking descriptor is served by the runtime poller, so closing
+	// the file interrupts a pending read
+	file := os.NewFile(uintptr(fd), "inotify")
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	iw := &inotifyWatcher{
+		watch: w,
+		file:  file,
+		conn:  conn,
+		dirs:  make(map[int]string),
+		wds:   make(map[string]int),
+	}
+
+	if w.Recursive {
+		err = iw.addTree(w.root, false)
+	} else {
+		err = iw.addDir(w.root)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return iw, nil
+}
+
+// run reads kernel events until the watcher is closed
+func (iw *inotifyWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := iw.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				iw.watch.manager.logger.WithError(err).WithField("watch_id", iw.watch.ID).Error("Failed to read file watch events")
+				iw.watch.stop(fmt.Sprintf("failed to read events: %v", err))
+			}
+			return
+		}
+		iw.handle(buf[:n])
+	}
+}
+
+// close releases the inotify instance and with it every kernel watch
+func (iw *inotifyWatcher) close() error {
+	return iw.file.Close()
+}
+
+// handle processes one read's worth of events. The halves of a rename
+// arrive together, so a move whose destination is not among them left the
+// watched tree.
+func (iw *inotifyWatcher) handle(buf []byte) {
+	moves := make(map[uint32]movedFrom)
+
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		start := offset + unix.SizeofInotifyEvent
+		offset = start + int(raw.Len)
+		if offset > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[start:offset]), "\x00")
+
+		iw.event(int(raw.Wd), raw.Mask, raw.Cookie, name, moves)
+	}
+
+	for _, from := range moves {
+		iw.watch.report(WatchOpDelete, from.path, "", from.isDir)
+		if from.isDir {
+			iw.removeTree(from.path)
+		}
+	}
+}
+
+// event processes a single kernel event
+func (iw *inotifyWatcher) event(wd int, mask, cookie uint32, name string, moves map[uint32]movedFrom) {
+	w := iw.watch
+
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.overflow("kernel event queue overflowed")
+		return
+	}
+
+	dir, ok := iw.dirs[wd]
+	if !ok {
+		return
+	}
+
+	switch {
+	case mask&unix.IN_IGNORED != 0:
+		iw.forget(wd)
+		if dir == w.root {
+			w.rootGone("watched directory was removed")
+		}
+		return
+	case mask&unix.IN_DELETE_SELF != 0:
+		return // followed by IN_IGNORED
+	case mask&unix.IN_MOVE_SELF != 0:
+		if dir == w.root {
+			w.rootGone("watched directory was moved")
+		}
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	isDir := mask&unix.IN_ISDIR != 0
+
+	switch {
+	case mask&unix.IN_CREATE != 0:
+		w.report(WatchOpCreate, path, "", isDir)
+		if isDir {
+			iw.addNewDir(path)
+		}
+	case mask&(unix.IN_MODIFY|unix.IN_ATTRIB) != 0:
+		w.report(WatchOpModify, path, "", isDir)
+	case mask&unix.IN_DELETE != 0:
+		w.report(WatchOpDelete, path, "", isDir)
+	case mask&unix.IN_MOVED_FROM != 0:
+		moves[cookie] = movedFrom{path: path, isDir: isDir}
+	case mask&unix.IN_MOVED_TO != 0:
+		from, paired := moves[cookie]
+		if !paired {
+			w.report(WatchOpCreate, path, "", isDir)
+			if isDir {
+				iw.addNewDir(path)
+			}
+			return
+		}
+
+		delete(moves, cookie)
+		w.report(WatchOpRename, path, from.path, isDir)
+		if isDir {
+			iw.moveTree(from.path, path)
+			if _, watched := iw.wds[path]; !watched {
+				iw.addNewDir(path)
+			}
+		}
+	}
+}
+
+// addNewDir watches a directory that appeared in a recursive watch. Entries
+// created in it before its kernel watch was placed are reported as created.
+func (iw *inotifyWatcher) addNewDir(path string) {
+	if iw.watch.Recursive && iw.watch.descends(path) {
+		iw.addTree(path, true)
+	}
+}
+
+// addTree watches dir and the directories below it, optionally reporting
+// every entry found as created. Directories the watch does not descend
+// into and symbolic links are skipped.
+func (iw *inotifyWatcher) addTree(dir string, report bool) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && !report {
+				return err
+			}
+			return nil // removed while walking
+		}
+
+		if path != dir {
+			if report {
+				iw.watch.report(WatchOpCreate, path, "", d.IsDir())
+			}
+			if d.IsDir() && !iw.watch.descends(path) {
+				return fs.SkipDir
+			}
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		if err := iw.addDir(path); err != nil {
+			switch {
+			case errors.Is(err, ErrWatchLimit):
+				if !report {
+					return err
+				}
+				if !iw.full {
+					iw.full = true
+					iw.watch.overflow(err.Error())
+				}
+				return errWatchFull
+			case path == dir && !report:
+				return err
+			}
+			iw.watch.manager.logger.WithError(err).WithField("watch_id", iw.watch.ID).Debug("Skipping directory in file watch")
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if errors.Is(err, errWatchFull) {
+		return nil
+	}
+	return err
+}
+
+// addDir places a kernel watch on a directory
+func (iw *inotifyWatcher) addDir(dir string) error {
+	if _, ok := iw.wds[dir]; ok {
+		return nil
+	}
+	if err := iw.watch.manager.acquireWatchDir(iw.watch); err != nil {
+		return err
+	}
+
+	var wd int
+	var err error
+	if ctlErr := iw.conn.Control(func(fd uintptr) {
+		wd, err = unix.InotifyAddWatch(int(fd), dir, inotifyMask)
+	}); ctlErr != nil {
+		err = ctlErr
+	}
+	if err != nil {
+		iw.watch.manager.releaseWatchDir(iw.watch)
+		if err == unix.ENOSPC {
+			return fmt.Errorf("%w: system limit on inotify watches reached", ErrWatchLimit)
+		}
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+
+	if old, ok := iw.dirs[wd]; ok {
+		// The directory was already watched under a previous path
+		delete(iw.wds, old)
+		iw.watch.manager.releaseWatchDir(iw.watch)
+	}
+	iw.dirs[wd] = dir
+	iw.wds[dir] = wd
+	return nil
+}
+
+// forget drops a kernel watch the kernel has removed
+func (iw *inotifyWatcher) forget(wd int) {
+	dir, ok := iw.dirs[wd]
+	if !ok {
+		return
+	}
+	delete(iw.dirs, wd)
+	delete(iw.wds, dir)
+	iw.watch.manager.releaseWatchDir(iw.watch)
+}
+
+// moveTree updates the paths of the directories below a renamed directory
+func (iw *inotifyWatcher) moveTree(from, to string) {
+	for wd, dir := range iw.dirs {
+		if dir != from && !strings.HasPrefix(dir, from+string(filepath.Separator)) {
+			continue
+		}
+		moved := to + strings.TrimPrefix(dir, from)
+		delete(iw.wds, dir)
+		iw.dirs[wd] = moved
+		iw.wds[moved] = wd
+	}
+}
+
+// removeTree removes the kernel watches below a directory that left the
+// watched tree
+func (iw *inotifyWatcher) removeTree(root string) {
+	for wd, dir := range iw.dirs {
+		if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			continue
+		}
+		iw.conn.Control(func(fd uintptr) {
+			unix.InotifyRmWatch(int(fd), uint32(wd))
+		})
+		iw.forget(wd)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchRecursive(t *testing.T) {
+	m, data, sub := watchManager(t, 30*time.Millisecond)
+	startManager(t, m)
+
+	watch, err := m.AddWatch(data, WatchOptions{Recursive: true, Filters: Filters{Exclude: []string{"*.tmp"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written in several steps, reported once
+	path := filepath.Join(data, "app.conf")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		file.WriteString("line\n")
+	}
+	file.Close()
+	if got := nextChange(t, sub); got.Op != "create" || got.Path != path || got.WatchID != watch.ID {
+		t.Errorf("change = %+v, want the create of %s", got, path)
+	}
+
+	// New directories are watched too
+	logs := filepath.Join(data, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextChange(t, sub); got.Op != "create" || got.Path != logs || !got.IsDir {
+		t.Errorf("change = %+v, want the create of directory %s", got, logs)
+	}
+	nested := filepath.Join(logs, "today.log")
+	if err := os.WriteFile(nested, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextChange(t, sub); got.Op != "create" || got.Path != nested {
+		t.Errorf("change = %+v, want the create of %s", got, nested)
+	}
+
+	// Excluded entries are not reported
+	if err := os.WriteFile(filepath.Join(data, "scratch.tmp"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assertNoChange(t, sub, 150*time.Millisecond)
+
+	renamed := filepath.Join(data, "app.conf.bak")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextChange(t, sub); got.Op != "rename" || got.Path != renamed || got.OldPath != path {
+		t.Errorf("change = %+v, want the rename of %s", got, path)
+	}
+
+	if err := m.RemoveWatch(watch.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextChange(t, sub); got.Op != "closed" {
+		t.Errorf("change = %+v, want the watch closing", got)
+	}
+	if len(m.ListWatches()) != 0 {
+		t.Error("removed watch is still listed")
+	}
+}
+
+func TestWatchFileReplaced(t *testing.T) {
+	m, data, sub := watchManager(t, 30*time.Millisecond)
+	startManager(t, m)
+	path := filepath.Join(data, "app.yaml")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "other"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.AddWatch(path, WatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Editors save by renaming a new file over the old one
+	temp := filepath.Join(data, ".app.yaml.swp")
+	if err := os.WriteFile(temp, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "other"), []byte("y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := nextChange(t, sub); got.Path != path || got.OldPath != "" {
+		t.Errorf("change = %+v, want a change of %s only", got, path)
+	}
+	assertNoChange(t, sub, 150*time.Millisecond)
+}
+
+func TestWatchLimits(t *testing.T) {
+	m, data, _ := watchManager(t, 30*time.Millisecond)
+	startManager(t, m)
+	for i := 0; i < 4; i++ {
+		if _, err := m.AddWatch(data, WatchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.AddWatch(data, WatchOptions{}); !errors.Is(err, ErrWatchLimit) {
+		t.Errorf("AddWatch() beyond the limit error = %v, want ErrWatchLimit", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package fileops
+
+// newWatchBackend fails: file watches rely on inotify
+func newWatchBackend(w *Watch) (watchBackend, error) {
+	return nil, ErrWatchUnsupported
+}
@@ -0,0 +1,161 @@
+package fileops
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
+)
+
+// watchManager returns a manager publishing to a bus and a subscription to
+// its file change events
+func watchManager(t *testing.T, debounce time.Duration) (*Manager, string, *events.Subscription) {
+	t.Helper()
+	m, data := testManager(t, func(cfg *config.StorageConfig) {
+		cfg.Watch = config.WatchConfig{MaxWatches: 4, MaxDirectories: 16, Debounce: debounce}
+	})
+
+	bus := events.NewBus(1000, testLogger())
+	t.Cleanup(bus.Close)
+	m.events = bus
+	return m, data, bus.Subscribe(1000, events.TypeFileChanged)
+}
+
+// debouncedWatch returns a watch on root that changes are recorded on
+// directly, without a kernel watch
+func debouncedWatch(m *Manager, root string) *Watch {
+	w := &Watch{ID: "watch", Path: root, manager: m, root: root, pending: make(map[string]*pendingChange)}
+	w.timer = time.AfterFunc(time.Hour, w.flush)
+	w.timer.Stop()
+	return w
+}
+
+// nextChange waits for the next file change event
+func nextChange(t *testing.T, sub *events.Subscription) events.FileChanged {
+	t.Helper()
+	select {
+	case envelope := <-sub.Events():
+		return envelope.Event.(events.FileChanged)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no file change reported")
+		return events.FileChanged{}
+	}
+}
+
+// assertNoChange fails if a file change is reported within wait
+func assertNoChange(t *testing.T, sub *events.Subscription, wait time.Duration) {
+	t.Helper()
+	select {
+	case envelope := <-sub.Events():
+		t.Errorf("unexpected change %+v", envelope.Event)
+	case <-time.After(wait):
+	}
+}
+
+func TestWatchDebounceMergesChanges(t *testing.T) {
+	m, data, sub := watchManager(t, 50*time.Millisecond)
+	w := debouncedWatch(m, data)
+	path := func(name string) string { return filepath.Join(data, name) }
+
+	w.record(WatchOpCreate, path("written"), "", false)
+	w.record(WatchOpModify, path("written"), "", false)
+	w.record(WatchOpModify, path("written"), "", false)
+
+	w.record(WatchOpCreate, path("temporary"), "", false)
+	w.record(WatchOpDelete, path("temporary"), "", false)
+
+	w.record(WatchOpDelete, path("replaced"), "", false)
+	w.record(WatchOpCreate, path("replaced"), "", false)
+
+	w.record(WatchOpRename, path("b"), path("a"), false)
+	w.record(WatchOpRename, path("c"), path("b"), false)
+
+	w.record(WatchOpCreate, path("draft"), "", false)
+	w.record(WatchOpRename, path("final"), path("draft"), false)
+
+	w.record(WatchOpRename, path("moved"), path("back"), false)
+	w.record(WatchOpRename, path("back"), path("moved"), false)
+
+	want := []events.FileChanged{
+		{WatchID: "watch", Op: "create", Path: path("written")},
+		{WatchID: "watch", Op: "modify", Path: path("replaced")},
+		{WatchID: "watch", Op: "rename", Path: path("c"), OldPath: path("a")},
+		{WatchID: "watch", Op: "create", Path: path("final")},
+		{WatchID: "watch", Op: "modify", Path: path("back")},
+	}
+	for _, expected := range want {
+		if got := nextChange(t, sub); got != expected {
+			t.Errorf("change = %+v, want %+v", got, expected)
+		}
+	}
+	assertNoChange(t, sub, 150*time.Millisecond)
+}
+
+func TestWatchDebounceWaitsForQuiet(t *testing.T) {
+	m, data, sub := watchManager(t, 100*time.Millisecond)
+	w := debouncedWatch(m, data)
+	path := filepath.Join(data, "app.log")
+
+	w.record(WatchOpModify, path, "", false)
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		w.record(WatchOpModify, path, "", false)
+	}
+	// The last change was 50ms ago, less than the debounce period
+	assertNoChange(t, sub, 20*time.Millisecond)
+
+	if got := nextChange(t, sub); got.Op != "modify" || got.Path != path {
+		t.Errorf("change = %+v", got)
+	}
+	assertNoChange(t, sub, 200*time.Millisecond)
+}
+
+func TestWatchDebounceDelayCapped(t *testing.T) {
+	m, data, sub := watchManager(t, 20*time.Millisecond)
+	w := debouncedWatch(m, data)
+	path := filepath.Join(data, "busy.log")
+
+	// A path that never stays quiet is still reported every few periods
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for time.Since(start) < time.Second {
+			w.record(WatchOpModify, path, "", false)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	nextChange(t, sub)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("first change reported after %s, want within %s", elapsed, maxDebounceDelay*20*time.Millisecond)
+	}
+	<-done
+}
+
+func TestWatchStopDiscardsPending(t *testing.T) {
+	m, data, sub := watchManager(t, time.Hour)
+	w := debouncedWatch(m, data)
+	w.backend = closedBackend{}
+	m.watches[w.ID] = w
+
+	w.record(WatchOpCreate, filepath.Join(data, "file"), "", false)
+	w.stop("test")
+
+	if got := nextChange(t, sub); got.Op != "closed" || got.Message != "test" {
+		t.Errorf("change = %+v, want the watch closing", got)
+	}
+	if _, err := m.GetWatch(w.ID); err == nil {
+		t.Error("stopped watch is still listed")
+	}
+	w.record(WatchOpCreate, filepath.Join(data, "late"), "", false)
+	assertNoChange(t, sub, 50*time.Millisecond)
+}
+
+// closedBackend is a kernel watch that has nothing to close
+type closedBackend struct{}
+
+func (closedBackend) run()         {}
+func (closedBackend) close() error { return nil }
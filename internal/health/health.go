@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/events"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,6 +16,7 @@ import (
 type Checker struct {
 	config config.HealthConfig
 	logger *logrus.Logger
+	events *events.Bus
 
 	// Health checks
 	mu     sync.RWMutex
@@ -80,10 +82,11 @@ type HealthStatus struct {
 type CheckFunc func(ctx context.Context) error
 
 // New creates a new health checker
-func New(cfg config.HealthConfig, logger *logrus.Logger) (*Checker, error) {
+func New(cfg config.HealthConfig, bus *events.Bus, logger *logrus.Logger) (*Checker, error) {
 	checker := &Checker{
 		config: cfg,
 		logger: logger,
+		events: bus,
 		checks: make(map[string]*Check),
 		status: HealthStatus{
 			Status:   CheckStatusUnknown,
@@ -189,6 +192,38 @@ func (c *Checker) GetStatus() map[string]interface{} {
 	}
 }
 
+// HostLoad is a snapshot of host resource usage taken from the latest checks
+type HostLoad struct {
+	CPULoadPerCore float64   `json:"cpu_load_per_core"`
+	MemoryPercent  float64   `json:"memory_percent"`
+	DiskPercent    float64   `json:"disk_percent"`
+	CheckedAt      time.Time `json:"checked_at"`
+}
+
+// GetHostLoad returns host load as measured by the most recent CPU, memory
+// and disk checks. It returns false until the checks have run at least once.
+func (c *Checker) GetHostLoad() (HostLoad, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.status.Timestamp.IsZero() {
+		return HostLoad{}, false
+	}
+
+	load := HostLoad{CheckedAt: c.status.Timestamp}
+	if check, ok := c.checks["cpu"]; ok {
+		load.CPULoadPerCore, _ = check.Metadata["load_per_cpu"].(float64)
+	}
+	if check, ok := c.checks["memory"]; ok {
+		load.MemoryPercent, _ = check.Metadata["used_percent"].(float64)
+	}
+	if check, ok := c.checks["disk"]; ok {
+		load.DiskPercent, _ = check.Metadata["used_percent"].(float64)
+	}
+
+	return load, true
+}
+
 // IsHealthy returns whether the system is healthy
 func (c *Checker) IsHealthy() bool {
 	c.mu.RLock()
@@ -235,6 +270,7 @@ func (c *Checker) performChecks() {
 	// Execute each check
 	for name, check := range c.checks {
 		checkStart := time.Now()
+		previous := check.Status
 		
 		// Perform the check based on type
 		err := c.executeCheck(check)
@@ -252,6 +288,15 @@ func (c *Checker) performChecks() {
 			check.Message = "Check passed"
 		}
 
+		if check.Status != previous {
+			c.events.Publish(events.HealthChanged{
+				Check:    name,
+				Previous: string(previous),
+				Current:  string(check.Status),
+				Message:  check.Message,
+			})
+		}
+
 		// Update summary
 		summary["total"]++
 		switch check.Status {
@@ -278,6 +323,13 @@ func (c *Checker) performChecks() {
 		overallStatus = CheckStatusUnknown
 	}
 
+	if overallStatus != c.status.Status {
+		c.events.Publish(events.HealthChanged{
+			Previous: string(c.status.Status),
+			Current:  string(overallStatus),
+		})
+	}
+
 	c.status.Status = overallStatus
 	c.status.Timestamp = time.Now()
 	c.status.Summary = summary
@@ -0,0 +1,58 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// helperArg marks a re-exec of the agent binary as the sandbox helper
+const helperArg = "__ducla-sandbox"
+
+// helperExitCode is returned by the helper when the sandbox cannot be set up
+const helperExitCode = 126
+
+// spec is passed from the agent to the sandbox helper
+type spec struct {
+	Profile config.SandboxProfile `json:"profile"`
+	Command string                `json:"command"`
+	Args    []string              `json:"args"`
+}
+
+// IsHelper reports whether the current process was started as the sandbox helper
+func IsHelper() bool {
+	return len(os.Args) > 2 && os.Args[1] == helperArg
+}
+
+// RunHelper sets up the sandbox inside the new namespaces and replaces the
+// process with the task command. It only returns on failure, by exiting.
+func RunHelper() {
+	var s spec
+	if err := json.Unmarshal([]byte(os.Args[2]), &s); err != nil {
+		fail(fmt.Errorf("invalid sandbox spec: %w", err))
+	}
+
+	fail(runHelper(&s))
+}
+
+// fail reports a helper error on stderr and exits
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(helperExitCode)
+}
+
+// encodeSpec builds the helper arguments for a command
+func encodeSpec(profile config.SandboxProfile, command string, args []string) ([]string, error) {
+	data, err := json.Marshal(spec{
+		Profile: profile,
+		Command: command,
+		Args:    args,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sandbox spec: %w", err)
+	}
+
+	return []string{helperArg, string(data)}, nil
+}
@@ -0,0 +1,135 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// Command returns a command that runs name with args inside the namespaces
+// of the profile. The agent binary is re-executed as a helper that finishes
+// the setup from inside the namespaces before executing the command.
+func Command(ctx context.Context, profile config.SandboxProfile, name string, args ...string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate agent binary: %w", err)
+	}
+
+	helperArgs, err := encodeSpec(profile, name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, self, helperArgs...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: cloneFlags(profile),
+		Pdeathsig:  syscall.SIGKILL,
+	}
+
+	return cmd, nil
+}
+
+// cloneFlags returns the namespaces to create for a profile
+func cloneFlags(profile config.SandboxProfile) uintptr {
+	var flags uintptr
+	if profile.MountNamespace {
+		flags |= syscall.CLONE_NEWNS
+	}
+	if profile.PIDNamespace {
+		flags |= syscall.CLONE_NEWPID
+	}
+	if profile.NetworkNamespace {
+		flags |= syscall.CLONE_NEWNET
+	}
+	if profile.IPCNamespace {
+		flags |= syscall.CLONE_NEWIPC
+	}
+	if profile.UTSNamespace {
+		flags |= syscall.CLONE_NEWUTS
+	}
+	return flags
+}
+
+// runHelper configures the sandbox and executes the command
+func runHelper(s *spec) error {
+	// Thread-scoped settings (no_new_privs, seccomp) must be applied on the
+	// thread that calls exec
+	runtime.LockOSThread()
+
+	profile := s.Profile
+
+	if profile.MountNamespace {
+		if err := setupMounts(profile); err != nil {
+			return err
+		}
+	}
+
+	if profile.UTSNamespace {
+		hostname := profile.Hostname
+		if hostname == "" {
+			hostname = "sandbox"
+		}
+		if err := unix.Sethostname([]byte(hostname)); err != nil {
+			return fmt.Errorf("failed to set hostname: %w", err)
+		}
+	}
+
+	path, err := exec.LookPath(s.Command)
+	if err != nil {
+		return fmt.Errorf("command not found: %w", err)
+	}
+
+	if profile.Seccomp.Enabled {
+		if err := installSeccomp(profile.Seccomp); err != nil {
+			return err
+		}
+	}
+
+	argv := append([]string{s.Command}, s.Args...)
+	if err := unix.Exec(path, argv, os.Environ()); err != nil {
+		return fmt.Errorf("failed to execute %s: %w", s.Command, err)
+	}
+
+	return nil
+}
+
+// setupMounts applies the mount settings of a profile inside the new mount namespace
+func setupMounts(profile config.SandboxProfile) error {
+	// Keep mount changes from propagating back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	for _, path := range profile.ReadOnlyPaths {
+		if err := unix.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind mount %s: %w", path, err)
+		}
+		if err := unix.Mount("", path, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("failed to remount %s read-only: %w", path, err)
+		}
+	}
+
+	if profile.PrivateTmp {
+		if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("failed to mount private /tmp: %w", err)
+		}
+	}
+
+	// Show only the processes of the new PID namespace
+	if profile.PIDNamespace {
+		if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+			return fmt.Errorf("failed to mount /proc: %w", err)
+		}
+	}
+
+	return nil
+}
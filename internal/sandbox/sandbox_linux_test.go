@@ -0,0 +1,111 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// runSandboxed runs a shell command inside a profile and returns its output
+func runSandboxed(t *testing.T, profile config.SandboxProfile, script string) (string, error) {
+	t.Helper()
+	cmd, err := Command(context.Background(), profile, "sh", "-c", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(output)), err
+}
+
+func TestValidateSeccomp(t *testing.T) {
+	if err := ValidateSeccomp(config.SeccompConfig{DenySyscalls: []string{"ptrace", "kill"}}); err != nil {
+		t.Errorf("ValidateSeccomp() error = %v", err)
+	}
+	if err := ValidateSeccomp(config.SeccompConfig{DenySyscalls: []string{"open"}}); err == nil {
+		t.Error("ValidateSeccomp() accepted an unsupported syscall")
+	}
+}
+
+func TestBuildFilter(t *testing.T) {
+	if _, err := auditArch(); err != nil {
+		t.Skip(err)
+	}
+
+	header := 5 // load arch, check it, kill, load nr, allow
+	if runtime.GOARCH == "amd64" {
+		header += 2
+	}
+
+	filter, err := buildFilter(config.SeccompConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := header + 2*len(defaultDenySyscalls); len(filter) != want {
+		t.Errorf("default filter has %d instructions, want %d", len(filter), want)
+	}
+
+	filter, err = buildFilter(config.SeccompConfig{DenySyscalls: []string{"kill"}, Action: "kill"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) != header+2 {
+		t.Fatalf("filter has %d instructions, want %d", len(filter), header+2)
+	}
+	deny := filter[len(filter)-3:]
+	if deny[0].K != syscallNumbers["kill"] || deny[1].K != seccompRetKillProcess {
+		t.Errorf("deny rule = %+v, want kill -> SECCOMP_RET_KILL_PROCESS", deny[:2])
+	}
+}
+
+func TestCloneFlags(t *testing.T) {
+	flags := cloneFlags(config.SandboxProfile{MountNamespace: true, NetworkNamespace: true})
+	if flags != syscall.CLONE_NEWNS|syscall.CLONE_NEWNET {
+		t.Errorf("cloneFlags() = %#x", flags)
+	}
+	if flags := cloneFlags(config.SandboxProfile{}); flags != 0 {
+		t.Errorf("cloneFlags() of an empty profile = %#x, want 0", flags)
+	}
+}
+
+func TestSeccompDeniesSyscalls(t *testing.T) {
+	if _, err := auditArch(); err != nil {
+		t.Skip(err)
+	}
+
+	script := `kill -0 $$ 2>/dev/null && echo allowed || echo denied`
+	output, err := runSandboxed(t, config.SandboxProfile{}, script)
+	if err != nil || output != "allowed" {
+		t.Fatalf("unfiltered output = %q, %v, want allowed", output, err)
+	}
+
+	profile := config.SandboxProfile{Seccomp: config.SeccompConfig{Enabled: true, DenySyscalls: []string{"kill"}}}
+	output, err = runSandboxed(t, profile, script)
+	if err != nil || output != "denied" {
+		t.Errorf("filtered output = %q, %v, want denied", output, err)
+	}
+}
+
+func TestUTSNamespace(t *testing.T) {
+	profile := config.SandboxProfile{UTSNamespace: true, Hostname: "task-box"}
+	output, err := runSandboxed(t, profile, "hostname")
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == helperExitCode || errors.Is(err, syscall.EPERM) {
+		t.Skipf("namespaces are not available: %s", output)
+	}
+	if err != nil {
+		t.Fatalf("hostname failed: %v: %s", err, output)
+	}
+	if output != "task-box" {
+		t.Errorf("hostname = %q, want task-box", output)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// Command is not supported outside Linux
+func Command(ctx context.Context, profile config.SandboxProfile, name string, args ...string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("sandbox profiles are not supported on %s", runtime.GOOS)
+}
+
+// ValidateSeccomp accepts any config outside Linux, where sandboxes cannot run
+func ValidateSeccomp(cfg config.SeccompConfig) error {
+	return nil
+}
+
+func runHelper(s *spec) error {
+	return fmt.Errorf("sandbox profiles are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,42 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+)
+
+// TestMain lets the test binary act as the sandbox helper, as the agent does
+func TestMain(m *testing.M) {
+	if IsHelper() {
+		RunHelper()
+	}
+	os.Exit(m.Run())
+}
+
+func TestEncodeSpec(t *testing.T) {
+	profile := config.SandboxProfile{
+		NetworkNamespace: true,
+		ReadOnlyPaths:    []string{"/etc"},
+		Seccomp:          config.SeccompConfig{Enabled: true, DenySyscalls: []string{"ptrace"}},
+	}
+	args, err := encodeSpec(profile, "ls", []string{"-l", "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != helperArg {
+		t.Fatalf("helper args = %v", args)
+	}
+
+	var s spec
+	if err := json.Unmarshal([]byte(args[1]), &s); err != nil {
+		t.Fatal(err)
+	}
+	want := spec{Profile: profile, Command: "ls", Args: []string{"-l", "/"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("decoded spec = %+v, want %+v", s, want)
+	}
+}
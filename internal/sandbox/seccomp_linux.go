@@ -0,0 +1,174 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/duclacloud/DUCLA-CLOUD-AGENT/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// Seccomp return actions, see seccomp(2)
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	x32SyscallBit = 0x40000000
+)
+
+// defaultDenySyscalls are denied when a profile enables seccomp without a list
+var defaultDenySyscalls = []string{
+	"mount", "umount2", "pivot_root", "chroot", "setns", "unshare",
+	"reboot", "kexec_load", "init_module", "finit_module", "delete_module",
+	"swapon", "swapoff", "acct", "settimeofday", "clock_settime",
+	"sethostname", "setdomainname", "ptrace", "process_vm_readv",
+	"process_vm_writev", "bpf", "perf_event_open", "keyctl", "add_key",
+	"request_key", "open_by_handle_at", "userfaultfd",
+}
+
+// syscallNumbers maps the syscall names accepted in profiles to numbers
+var syscallNumbers = map[string]uint32{
+	"mount":             unix.SYS_MOUNT,
+	"umount2":           unix.SYS_UMOUNT2,
+	"pivot_root":        unix.SYS_PIVOT_ROOT,
+	"chroot":            unix.SYS_CHROOT,
+	"setns":             unix.SYS_SETNS,
+	"unshare":           unix.SYS_UNSHARE,
+	"reboot":            unix.SYS_REBOOT,
+	"kexec_load":        unix.SYS_KEXEC_LOAD,
+	"init_module":       unix.SYS_INIT_MODULE,
+	"finit_module":      unix.SYS_FINIT_MODULE,
+	"delete_module":     unix.SYS_DELETE_MODULE,
+	"swapon":            unix.SYS_SWAPON,
+	"swapoff":           unix.SYS_SWAPOFF,
+	"acct":              unix.SYS_ACCT,
+	"settimeofday":      unix.SYS_SETTIMEOFDAY,
+	"clock_settime":     unix.SYS_CLOCK_SETTIME,
+	"sethostname":       unix.SYS_SETHOSTNAME,
+	"setdomainname":     unix.SYS_SETDOMAINNAME,
+	"ptrace":            unix.SYS_PTRACE,
+	"process_vm_readv":  unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV,
+	"bpf":               unix.SYS_BPF,
+	"perf_event_open":   unix.SYS_PERF_EVENT_OPEN,
+	"keyctl":            unix.SYS_KEYCTL,
+	"add_key":           unix.SYS_ADD_KEY,
+	"request_key":       unix.SYS_REQUEST_KEY,
+	"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT,
+	"userfaultfd":       unix.SYS_USERFAULTFD,
+	"kill":              unix.SYS_KILL,
+	"socket":            unix.SYS_SOCKET,
+	"connect":           unix.SYS_CONNECT,
+	"bind":              unix.SYS_BIND,
+	"listen":            unix.SYS_LISTEN,
+	"setuid":            unix.SYS_SETUID,
+	"setgid":            unix.SYS_SETGID,
+	"fchownat":          unix.SYS_FCHOWNAT,
+	"personality":       unix.SYS_PERSONALITY,
+}
+
+// ValidateSeccomp checks that every syscall in a seccomp config is known
+func ValidateSeccomp(cfg config.SeccompConfig) error {
+	for _, name := range cfg.DenySyscalls {
+		if _, ok := syscallNumbers[name]; !ok {
+			return fmt.Errorf("unsupported syscall in seccomp filter: %s", name)
+		}
+	}
+	return nil
+}
+
+// installSeccomp loads a filter denying the configured syscalls into the
+// calling thread. no_new_privs is set first, as required for unprivileged use.
+func installSeccomp(cfg config.SeccompConfig) error {
+	if err := ValidateSeccomp(cfg); err != nil {
+		return err
+	}
+
+	filter, err := buildFilter(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("failed to install seccomp filter: %w", err)
+	}
+
+	return nil
+}
+
+// buildFilter compiles the deny list into a classic BPF program
+func buildFilter(cfg config.SeccompConfig) ([]unix.SockFilter, error) {
+	arch, err := auditArch()
+	if err != nil {
+		return nil, err
+	}
+
+	deny := cfg.DenySyscalls
+	if len(deny) == 0 {
+		deny = defaultDenySyscalls
+	}
+
+	action := uint32(seccompRetErrno | uint32(unix.EPERM))
+	if cfg.Action == "kill" {
+		action = seccompRetKillProcess
+	}
+
+	// struct seccomp_data: nr at offset 0, arch at offset 4
+	filter := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0),
+	}
+
+	// Reject the x32 ABI on amd64, its syscall numbers would bypass the deny list
+	if runtime.GOARCH == "amd64" {
+		filter = append(filter,
+			bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+		)
+	}
+
+	for _, name := range deny {
+		filter = append(filter,
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, syscallNumbers[name], 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, action),
+		)
+	}
+
+	filter = append(filter, bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow))
+	return filter, nil
+}
+
+// auditArch returns the seccomp architecture identifier of this build
+func auditArch() (uint32, error) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return unix.AUDIT_ARCH_X86_64, nil
+	case "arm64":
+		return unix.AUDIT_ARCH_AARCH64, nil
+	default:
+		return 0, fmt.Errorf("seccomp filters are not supported on %s", runtime.GOARCH)
+	}
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
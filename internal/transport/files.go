@@ -0,0 +1,365 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// fileStreamWindow bounds the bytes a WebSocket file stream sends ahead of
+// the master's acknowledgements
+const fileStreamWindow = 8 * 1024 * 1024
+
+// Metadata keys describing the chunk in a file_chunk message
+const (
+	chunkKeyFileID    = "file_id"
+	chunkKeyFilename  = "filename"
+	chunkKeyOffset    = "offset"
+	chunkKeyTotalSize = "total_size"
+	chunkKeyLast      = "last"
+)
+
+// NewChunkMessage wraps a file chunk in a file_chunk message. The chunk's
+// position is carried in the metadata and its data in the payload.
+func NewChunkMessage(chunk *FileChunk) *Message {
+	metadata := make(map[string]string, len(chunk.Metadata)+5)
+	for key, value := range chunk.Metadata {
+		metadata[key] = value
+	}
+	metadata[chunkKeyFileID] = chunk.FileId
+	metadata[chunkKeyOffset] = strconv.FormatInt(chunk.Offset, 10)
+	metadata[chunkKeyTotalSize] = strconv.FormatInt(chunk.TotalSize, 10)
+	metadata[chunkKeyLast] = strconv.FormatBool(chunk.IsLast)
+	if chunk.Filename != "" {
+		metadata[chunkKeyFilename] = chunk.Filename
+	}
+
+	return &Message{
+		Type:     MessageTypeFileChunk,
+		Data:     map[string]interface{}{},
+		Metadata: metadata,
+		Payload:  chunk.Data,
+	}
+}
+
+// ParseChunkMessage extracts the file chunk from a file_chunk message
+func ParseChunkMessage(message *Message) (*FileChunk, error) {
+	if message.Type != MessageTypeFileChunk {
+		return nil, fmt.Errorf("not a file chunk: %s", message.Type)
+	}
+
+	chunk := &FileChunk{
+		FileId:   message.Metadata[chunkKeyFileID],
+		Filename: message.Metadata[chunkKeyFilename],
+		Data:     message.Payload,
+		Metadata: make(map[string]string),
+	}
+	if chunk.FileId == "" {
+		return nil, fmt.Errorf("file chunk has no %s", chunkKeyFileID)
+	}
+
+	var err error
+	if chunk.Offset, err = strconv.ParseInt(message.Metadata[chunkKeyOffset], 10, 64); err != nil || chunk.Offset < 0 {
+		return nil, fmt.Errorf("invalid chunk offset %q", message.Metadata[chunkKeyOffset])
+	}
+	if size, ok := message.Metadata[chunkKeyTotalSize]; ok {
+		if chunk.TotalSize, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid chunk total size %q", size)
+		}
+	}
+	if last, ok := message.Metadata[chunkKeyLast]; ok {
+		if chunk.IsLast, err = strconv.ParseBool(last); err != nil {
+			return nil, fmt.Errorf("invalid chunk last flag %q", last)
+		}
+	}
+
+	for key, value := range message.Metadata {
+		switch key {
+		case chunkKeyFileID, chunkKeyFilename, chunkKeyOffset, chunkKeyTotalSize, chunkKeyLast:
+		default:
+			chunk.Metadata[key] = value
+		}
+	}
+
+	return chunk, nil
+}
+
+// encodeBinaryFrame encodes a message with a payload as a WebSocket binary
+// frame: a 4 byte big endian header length, the JSON message, then the
+// payload
+func encodeBinaryFrame(message *Message) ([]byte, error) {
+	header, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 4+len(header)+len(message.Payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(header)))
+	copy(frame[4:], header)
+	copy(frame[4+len(header):], message.Payload)
+	return frame, nil
+}
+
+// decodeBinaryFrame decodes a WebSocket binary frame
+func decodeBinaryFrame(frame []byte) (*Message, error) {
+	if len(frame) < 4 {
+		return nil, fmt.Errorf("binary frame too short")
+	}
+	size := binary.BigEndian.Uint32(frame)
+	if uint64(size) > uint64(len(frame)-4) {
+		return nil, fmt.Errorf("binary frame header exceeds frame")
+	}
+
+	var message Message
+	if err := json.Unmarshal(frame[4:4+size], &message); err != nil {
+		return nil, err
+	}
+	message.Payload = frame[4+size:]
+	return &message, nil
+}
+
+// grpcFileStream sends a file over the TransferFile client stream. The
+// master only reports what it received once the stream is closed.
+type grpcFileStream struct {
+	stream       AgentService_TransferFileClient
+	acknowledged int64
+}
+
+// OpenFileStream opens a TransferFile stream for one file
+func (t *GRPCTransport) OpenFileStream(ctx context.Context, fileID string) (FileStream, error) {
+	t.mu.RLock()
+	client := t.client
+	connected := t.connected
+	t.mu.RUnlock()
+
+	if !connected || client == nil {
+		return nil, &TransportError{
+			Code:    ErrCodeDisconnected,
+			Message: "Not connected to master server",
+		}
+	}
+
+	stream, err := client.TransferFile(t.authContext(ctx))
+	if err != nil {
+		return nil, &TransportError{
+			Code:    ErrCodeSendFailed,
+			Message: "Failed to open file stream",
+			Err:     err,
+		}
+	}
+	return &grpcFileStream{stream: stream}, nil
+}
+
+func (s *grpcFileStream) Send(chunk *FileChunk) error {
+	if err := s.stream.Send(chunk); err != nil {
+		return &TransportError{
+			Code:    ErrCodeSendFailed,
+			Message: "Failed to send file chunk",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+func (s *grpcFileStream) Acknowledged() int64 {
+	return s.acknowledged
+}
+
+func (s *grpcFileStream) CloseAndRecv() (*FileTransferResponse, error) {
+	response, err := s.stream.CloseAndRecv()
+	if err != nil {
+		return nil, &TransportError{
+			Code:    ErrCodeReceiveFailed,
+			Message: "Failed to receive file transfer response",
+			Err:     err,
+		}
+	}
+	s.acknowledged = response.BytesTransferred
+	return response, nil
+}
+
+// wsFileStream sends a file as binary frames. The master acknowledges
+// chunks with file_chunk_ack messages and reports the outcome with a
+// file_transfer_result message.
+type wsFileStream struct {
+	transport *WebSocketTransport
+	fileID    string
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	sent         int64
+	acknowledged int64
+	result       *FileTransferResponse
+	err          error
+	done         chan struct{}
+	finished     bool
+}
+
+// OpenFileStream opens a stream of binary frames for one file
+func (t *WebSocketTransport) OpenFileStream(ctx context.Context, fileID string) (FileStream, error) {
+	if !t.IsConnected() {
+		return nil, &TransportError{
+			Code:    ErrCodeDisconnected,
+			Message: "Not connected to master server",
+		}
+	}
+
+	stream := &wsFileStream{
+		transport: t,
+		fileID:    fileID,
+		done:      make(chan struct{}),
+	}
+	stream.cond = sync.NewCond(&stream.mu)
+
+	t.streamsMu.Lock()
+	if _, exists := t.streams[fileID]; exists {
+		t.streamsMu.Unlock()
+		return nil, fmt.Errorf("file stream %s is already open", fileID)
+	}
+	t.streams[fileID] = stream
+	t.streamsMu.Unlock()
+
+	// Wake a sender waiting for acknowledgements when the stream is cancelled
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.finish(nil, ctx.Err())
+			t.SendMessage(&Message{
+				Type: MessageTypeFileTransferCancel,
+				Data: map[string]interface{}{"transfer_id": fileID},
+			})
+		case <-stream.done:
+		}
+	}()
+
+	return stream, nil
+}
+
+func (s *wsFileStream) Send(chunk *FileChunk) error {
+	s.mu.Lock()
+	for !s.finished && s.sent-s.acknowledged > fileStreamWindow {
+		s.cond.Wait()
+	}
+	if s.finished {
+		err := s.err
+		s.mu.Unlock()
+		if err == nil {
+			err = fmt.Errorf("file stream %s is closed", s.fileID)
+		}
+		return err
+	}
+	s.mu.Unlock()
+
+	// The message is queued, so it must not share the caller's buffer
+	copied := *chunk
+	copied.Data = append([]byte{}, chunk.Data...)
+	if err := s.transport.SendMessage(NewChunkMessage(&copied)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.sent = chunk.Offset + int64(len(chunk.Data))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *wsFileStream) Acknowledged() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acknowledged
+}
+
+func (s *wsFileStream) CloseAndRecv() (*FileTransferResponse, error) {
+	defer s.transport.closeStream(s.fileID)
+
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result, s.err
+}
+
+// ack records the offset the master confirmed
+func (s *wsFileStream) ack(offset int64) {
+	s.mu.Lock()
+	if offset > s.acknowledged {
+		s.acknowledged = offset
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// finish ends the stream with the master's result or an error
+func (s *wsFileStream) finish(result *FileTransferResponse, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.result = result
+	s.err = err
+	if result != nil && result.BytesTransferred > s.acknowledged {
+		s.acknowledged = result.BytesTransferred
+	}
+	close(s.done)
+	s.cond.Broadcast()
+}
+
+// dispatchStreamMessage routes acknowledgements and results to the open
+// file stream they belong to. It reports whether the message was consumed.
+func (t *WebSocketTransport) dispatchStreamMessage(message *Message) bool {
+	if message.Type != MessageTypeFileChunkAck && message.Type != MessageTypeFileTransferResult {
+		return false
+	}
+
+	fileID, _ := message.Data["transfer_id"].(string)
+	t.streamsMu.Lock()
+	stream, ok := t.streams[fileID]
+	t.streamsMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	offset, _ := message.Data["offset"].(float64)
+	if message.Type == MessageTypeFileChunkAck {
+		stream.ack(int64(offset))
+		return true
+	}
+
+	result := &FileTransferResponse{FileId: fileID}
+	result.Status, _ = message.Data["status"].(string)
+	result.Checksum, _ = message.Data["checksum"].(string)
+	result.Error, _ = message.Data["error"].(string)
+	if received, ok := message.Data["bytes_transferred"].(float64); ok {
+		result.BytesTransferred = int64(received)
+	}
+	stream.finish(result, nil)
+	return true
+}
+
+// closeStream forgets a finished file stream
+func (t *WebSocketTransport) closeStream(fileID string) {
+	t.streamsMu.Lock()
+	delete(t.streams, fileID)
+	t.streamsMu.Unlock()
+}
+
+// failStreams ends all open file streams when the connection is lost
+func (t *WebSocketTransport) failStreams() {
+	t.streamsMu.Lock()
+	streams := make([]*wsFileStream, 0, len(t.streams))
+	for _, stream := range t.streams {
+		streams = append(streams, stream)
+	}
+	t.streamsMu.Unlock()
+
+	for _, stream := range streams {
+		stream.finish(nil, &TransportError{
+			Code:    ErrCodeDisconnected,
+			Message: "Disconnected from master server",
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestChunkMessageRoundTrip(t *testing.T) {
+	chunk := &FileChunk{
+		FileId:    "transfer-1",
+		Filename:  "dump.sql",
+		Offset:    262144,
+		Data:      []byte{0, 1, 2, 255},
+		TotalSize: 600000,
+		IsLast:    true,
+		Metadata:  map[string]string{"sha256": "abc"},
+	}
+
+	frame, err := encodeBinaryFrame(NewChunkMessage(chunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := decodeBinaryFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseChunkMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, chunk) {
+		t.Errorf("parsed chunk = %+v, want %+v", parsed, chunk)
+	}
+}
+
+func TestParseChunkMessageInvalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"no file ID":      {"offset": "0"},
+		"negative offset": {"file_id": "f", "offset": "-1"},
+		"bad size":        {"file_id": "f", "offset": "0", "total_size": "big"},
+		"bad last flag":   {"file_id": "f", "offset": "0", "last": "maybe"},
+	}
+	for name, metadata := range tests {
+		if _, err := ParseChunkMessage(&Message{Type: MessageTypeFileChunk, Metadata: metadata}); err == nil {
+			t.Errorf("%s: ParseChunkMessage() succeeded", name)
+		}
+	}
+
+	for _, frame := range [][]byte{{0, 0}, {0, 0, 0, 99, '{'}, {0, 0, 0, 1, '{'}} {
+		if _, err := decodeBinaryFrame(frame); err == nil {
+			t.Errorf("decodeBinaryFrame(%v) succeeded", frame)
+		}
+	}
+}
+
+// openTestStream registers a file stream on a disconnected transport
+func openTestStream(t *testing.T, fileID string) (*WebSocketTransport, *wsFileStream) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	transport, err := NewWebSocketTransport(TransportConfig{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := &wsFileStream{transport: transport, fileID: fileID, done: make(chan struct{})}
+	stream.cond = sync.NewCond(&stream.mu)
+	transport.streams[fileID] = stream
+	return transport, stream
+}
+
+func TestFileStreamAcknowledgements(t *testing.T) {
+	transport, stream := openTestStream(t, "transfer-1")
+
+	ack := &Message{Type: MessageTypeFileChunkAck, Data: map[string]interface{}{"transfer_id": "transfer-1", "offset": 1024.0}}
+	if !transport.dispatchStreamMessage(ack) || stream.Acknowledged() != 1024 {
+		t.Fatalf("acknowledged = %d after an ack of 1024", stream.Acknowledged())
+	}
+	other := &Message{Type: MessageTypeFileChunkAck, Data: map[string]interface{}{"transfer_id": "other"}}
+	if transport.dispatchStreamMessage(other) {
+		t.Error("ack of an unknown transfer was consumed")
+	}
+
+	result := &Message{Type: MessageTypeFileTransferResult, Data: map[string]interface{}{
+		"transfer_id":       "transfer-1",
+		"status":            "completed",
+		"checksum":          "abc",
+		"bytes_transferred": 2048.0,
+	}}
+	if !transport.dispatchStreamMessage(result) {
+		t.Fatal("transfer result was not consumed")
+	}
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "completed" || response.Checksum != "abc" || response.BytesTransferred != 2048 {
+		t.Errorf("response = %+v", response)
+	}
+	if _, open := transport.streams["transfer-1"]; open {
+		t.Error("closed stream is still registered")
+	}
+
+	// A finished stream refuses further chunks without sending them
+	if err := stream.Send(&FileChunk{FileId: "transfer-1", Data: bytes.Repeat([]byte("x"), 8)}); err == nil {
+		t.Error("Send() on a finished stream succeeded")
+	}
+}
+
+func TestFailStreams(t *testing.T) {
+	transport, stream := openTestStream(t, "transfer-1")
+
+	transport.failStreams()
+	if _, err := stream.CloseAndRecv(); err == nil {
+		t.Error("stream of a lost connection completed")
+	}
+}
@@ -124,15 +124,8 @@ func (t *GRPCTransport) Connect(ctx context.Context) error {
 	// Create client
 	client := NewAgentServiceClient(conn)
 
-	// Setup authentication metadata
-	md := metadata.New(map[string]string{
-		"authorization": "Bearer " + t.config.Token,
-		"user-agent":    "Ducla-Cloud-Agent/1.0.0",
-	})
-	authCtx := metadata.NewOutgoingContext(ctx, md)
-
 	// Create bidirectional stream
-	stream, err := client.Stream(authCtx)
+	stream, err := client.Stream(t.authContext(ctx))
 	if err != nil {
 		conn.Close()
 		if status.Code(err) == codes.Unauthenticated {
@@ -171,6 +164,15 @@ func (t *GRPCTransport) Connect(ctx context.Context) error {
 	return nil
 }
 
+// authContext adds the authentication metadata to a call's context
+func (t *GRPCTransport) authContext(ctx context.Context) context.Context {
+	md := metadata.New(map[string]string{
+		"authorization": "Bearer " + t.config.Token,
+		"user-agent":    "Ducla-Cloud-Agent/1.0.0",
+	})
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
 // Disconnect closes the gRPC connection
 func (t *GRPCTransport) Disconnect() error {
 	t.mu.Lock()
@@ -354,8 +356,13 @@ func (t *GRPCTransport) sendMessage(message *Message) error {
 		}
 	}
 
-	// Convert Message to gRPC message
-	data, err := json.Marshal(message.Data)
+	// Convert Message to gRPC message. File chunks carry their payload as
+	// the data and describe it in the metadata.
+	data := message.Payload
+	var err error
+	if message.Type != MessageTypeFileChunk {
+		data, err = json.Marshal(message.Data)
+	}
 	if err != nil {
 		return &TransportError{
 			Code:    ErrCodeInvalidMessage,
@@ -409,7 +416,13 @@ func (t *GRPCTransport) receiveMessage() (*Message, error) {
 
 	// Convert gRPC message to Message
 	var data map[string]interface{}
-	if err := json.Unmarshal(grpcMessage.Data, &data); err != nil {
+	var payload []byte
+	if MessageType(grpcMessage.Type) == MessageTypeFileChunk {
+		payload = grpcMessage.Data
+		if payload == nil {
+			payload = []byte{}
+		}
+	} else if err := json.Unmarshal(grpcMessage.Data, &data); err != nil {
 		return nil, &TransportError{
 			Code:    ErrCodeInvalidMessage,
 			Message: "Failed to unmarshal message data",
@@ -425,6 +438,7 @@ func (t *GRPCTransport) receiveMessage() (*Message, error) {
 		ReplyTo:   grpcMessage.ReplyTo,
 		Data:      data,
 		Metadata:  grpcMessage.Metadata,
+		Payload:   payload,
 	}
 
 	return message, nil
@@ -12,6 +12,7 @@ const (
 	MessageTypeHeartbeat           MessageType = "heartbeat"
 	MessageTypeTask                MessageType = "task"
 	MessageTypeTaskResult          MessageType = "task_result"
+	MessageTypeTaskProgress        MessageType = "task_progress"
 	MessageTypeFileOperation       MessageType = "file_operation"
 	MessageTypeFileOperationResult MessageType = "file_operation_result"
 	MessageTypeHealthCheck         MessageType = "health_check"
@@ -21,6 +22,11 @@ const (
 	MessageTypeError               MessageType = "error"
 	MessageTypeLog                 MessageType = "log"
 	MessageTypeMetrics             MessageType = "metrics"
+	MessageTypeFileChunk           MessageType = "file_chunk"
+	MessageTypeFileChunkAck        MessageType = "file_chunk_ack"
+	MessageTypeFileTransferResult  MessageType = "file_transfer_result"
+	MessageTypeFileTransferCancel  MessageType = "file_transfer_cancel"
+	MessageTypeFileEvent           MessageType = "file_event"
 )
 
 // Message represents a message exchanged between agent and master
@@ -32,6 +38,9 @@ type Message struct {
 	ReplyTo   string                 `json:"reply_to,omitempty"`
 	Data      map[string]interface{} `json:"data"`
 	Metadata  map[string]string      `json:"metadata,omitempty"`
+
+	// Payload carries the binary data of file_chunk messages
+	Payload []byte `json:"-"`
 }
 
 // Transport defines the interface for communication with master server
@@ -55,6 +64,26 @@ type Transport interface {
 	GetConnectionInfo() ConnectionInfo
 }
 
+// FileStreamer is implemented by transports that can stream files to the
+// master server
+type FileStreamer interface {
+	// OpenFileStream opens a stream for one file. Cancelling ctx aborts it.
+	OpenFileStream(ctx context.Context, fileID string) (FileStream, error)
+}
+
+// FileStream sends the chunks of one file to the master server
+type FileStream interface {
+	// Send sends the next chunk
+	Send(chunk *FileChunk) error
+
+	// Acknowledged returns the offset up to which the master confirmed
+	// receiving the file
+	Acknowledged() int64
+
+	// CloseAndRecv waits for the master's receipt after the last chunk
+	CloseAndRecv() (*FileTransferResponse, error)
+}
+
 // ConnectionInfo contains information about the connection
 type ConnectionInfo struct {
 	Connected      bool      `json:"connected"`
@@ -30,6 +30,10 @@ type WebSocketTransport struct {
 	sendChan    chan *Message
 	receiveChan chan *Message
 	errorChan   chan error
+
+	// Outgoing file streams by file ID
+	streamsMu sync.Mutex
+	streams   map[string]*wsFileStream
 	
 	// Lifecycle management
 	ctx    context.Context
@@ -45,6 +49,7 @@ func NewWebSocketTransport(config TransportConfig, logger *logrus.Logger) (*WebS
 		sendChan:    make(chan *Message, 100),
 		receiveChan: make(chan *Message, 100),
 		errorChan:   make(chan error, 10),
+		streams:     make(map[string]*wsFileStream),
 		connInfo: ConnectionInfo{
 			MasterURL: config.URL,
 			Protocol:  "websocket",
@@ -152,6 +157,9 @@ func (t *WebSocketTransport) Disconnect() error {
 	// Wait for goroutines to finish
 	t.wg.Wait()
 
+	// File streams cannot continue on a new connection
+	t.failStreams()
+
 	t.connected = false
 	t.connInfo.Connected = false
 	t.connInfo.DisconnectedAt = time.Now()
@@ -282,6 +290,10 @@ func (t *WebSocketTransport) receiveLoop() {
 			t.connInfo.MessagesRecv++
 			t.mu.Unlock()
 
+			if t.dispatchStreamMessage(message) {
+				continue
+			}
+
 			select {
 			case t.receiveChan <- message:
 			case <-t.ctx.Done():
@@ -323,7 +335,16 @@ func (t *WebSocketTransport) sendMessage(message *Message) error {
 		}
 	}
 
-	data, err := json.Marshal(message)
+	// Messages with a payload are sent as binary frames
+	frameType := websocket.TextMessage
+	var data []byte
+	var err error
+	if message.Payload != nil {
+		frameType = websocket.BinaryMessage
+		data, err = encodeBinaryFrame(message)
+	} else {
+		data, err = json.Marshal(message)
+	}
 	if err != nil {
 		return &TransportError{
 			Code:    ErrCodeInvalidMessage,
@@ -333,7 +354,7 @@ func (t *WebSocketTransport) sendMessage(message *Message) error {
 	}
 
 	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	if err := conn.WriteMessage(frameType, data); err != nil {
 		return &TransportError{
 			Code:    ErrCodeSendFailed,
 			Message: "Failed to write message",
@@ -367,6 +388,18 @@ func (t *WebSocketTransport) receiveMessage() (*Message, error) {
 		}
 	}
 
+	if messageType == websocket.BinaryMessage {
+		message, err := decodeBinaryFrame(data)
+		if err != nil {
+			return nil, &TransportError{
+				Code:    ErrCodeInvalidMessage,
+				Message: "Failed to decode binary frame",
+				Err:     err,
+			}
+		}
+		return message, nil
+	}
+
 	if messageType != websocket.TextMessage {
 		return nil, &TransportError{
 			Code:    ErrCodeInvalidMessage,